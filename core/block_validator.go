@@ -85,13 +85,23 @@ func (v *BlockValidator) ValidateBody(block *types.Block) error {
 			return err
 		}
 		if hash := types.CalcUncleHash(block.Uncles()); hash != header.UncleHash() {
-			return fmt.Errorf("uncle root hash mismatch: have %x, want %x", hash, header.UncleHash())
+			return fmt.Errorf("%w: have %x, want %x", ErrUncleRootMismatch, hash, header.UncleHash())
+		}
+		// An empty body always derives the empty root, so skip hashing it
+		if block.EmptyBody() {
+			if !header.EmptyTxs() {
+				return fmt.Errorf("%w: have %x, want %x", ErrTxRootMismatch, types.EmptyRootHash, header.TxHash())
+			}
+			if !header.EmptyEtxs() {
+				return fmt.Errorf("%w: have %x, want %x", ErrEtxRootMismatch, types.EmptyRootHash, header.EtxHash())
+			}
+			return nil
 		}
 		if hash := types.DeriveSha(block.Transactions(), trie.NewStackTrie(nil)); hash != header.TxHash() {
-			return fmt.Errorf("transaction root hash mismatch: have %x, want %x", hash, header.TxHash())
+			return fmt.Errorf("%w: have %x, want %x", ErrTxRootMismatch, hash, header.TxHash())
 		}
 		if hash := types.DeriveSha(block.ExtTransactions(), trie.NewStackTrie(nil)); hash != header.EtxHash() {
-			return fmt.Errorf("external transaction root hash mismatch: have %x, want %x", hash, header.EtxHash())
+			return fmt.Errorf("%w: have %x, want %x", ErrEtxRootMismatch, hash, header.EtxHash())
 		}
 	}
 	return nil
@@ -130,6 +130,10 @@ func (bc *BodyDb) ProcessingState() bool {
 func (bc *BodyDb) WriteBlock(block *types.Block) {
 	// add the block to the cache as well
 	bc.blockCache.Add(block.Hash(), block)
+	// overwrite any body previously cached for the hash, the encoded body is
+	// dropped and read again from the database when needed
+	bc.bodyCache.Add(block.Hash(), block.Body())
+	bc.bodyRLPCache.Remove(block.Hash())
 	rawdb.WriteBlock(bc.db, block)
 }
 
@@ -9,6 +9,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/dominant-strategies/go-quai/common"
@@ -49,12 +50,28 @@ const (
 	c_appendQueuePrintSize                     = 10
 	c_badSyncTargetsSize                       = 20 // List of bad sync target hashes
 	c_badSyncTargetCheckTime                   = 15 * time.Minute
-	c_normalListBackoffThreshold               = 5 // Max multiple on the c_normalListProcCounter
+	c_normalListBackoffThreshold               = 5                // Max multiple on the c_normalListProcCounter
+	c_appendQueueOverflowFactor                = 10               // Divisor of c_maxAppendQueue, above which the append queue is reported as overflowing
+	c_appendQueueMaxRetries                    = 3000             // Number of times a block is retried before it is dropped from the append queue regardless of its number
+	c_appendQueueMaxBackoff                    = 30 * time.Second // Maximum delay between two append attempts of the same block
+	c_headStallCheckPeriod                     = time.Minute      // Time between two checks of the head advance
+	c_pendingPrunePeriod                       = 10 * time.Minute // Time between two prunings of the pending data on disk
 )
 
 type blockNumberAndRetryCounter struct {
-	number uint64
-	retry  uint64
+	number    uint64
+	retry     uint64
+	nextRetry time.Time // Earliest time at which the block is attempted again
+}
+
+// FutureHeaderInfo describes a block waiting in the append queue and what it
+// is waiting on.
+type FutureHeaderInfo struct {
+	Hash       common.Hash
+	Number     uint64
+	ParentHash common.Hash
+	Retries    uint64
+	Reason     string
 }
 
 type Core struct {
@@ -75,7 +92,12 @@ type Core struct {
 
 	normalListBackoff uint64 // normalListBackoff is the multiple on c_normalListProcCounter which delays the proc on normal list
 
-	quit chan struct{} // core quit channel
+	appendQueueProcessing int32 // 1 while a procAppendQueue pass is running, 0 otherwise
+
+	maxAppendQueue int // Maximum number of future headers held in the append queue
+
+	quit    chan struct{} // core quit channel
+	stopped int32         // 1 once Stop or StopContext has been called, 0 otherwise
 }
 
 func NewCore(db ethdb.Database, config *Config, isLocalBlock func(block *types.Header) bool, txConfig *TxPoolConfig, txLookupLimit *uint64, chainConfig *params.ChainConfig, slicesRunning []common.Location, domClientUrl string, subClientUrls []string, engine consensus.Engine, cacheConfig *CacheConfig, vmConfig vm.Config, genesis *Genesis) (*Core, error) {
@@ -90,12 +112,17 @@ func NewCore(db ethdb.Database, config *Config, isLocalBlock func(block *types.H
 		quit:              make(chan struct{}),
 		procCounter:       0,
 		normalListBackoff: 1,
+		maxAppendQueue:    config.MaxAppendQueue,
+	}
+	// A zero limit keeps the default instead of disabling the append queue
+	if c.maxAppendQueue <= 0 {
+		c.maxAppendQueue = c_maxAppendQueue
 	}
 
 	// Initialize the sync target to current header parent entropy
 	c.syncTarget = c.CurrentHeader()
 
-	appendQueue, _ := lru.New(c_maxAppendQueue)
+	appendQueue, _ := lru.New(c.maxAppendQueue)
 	c.appendQueue = appendQueue
 
 	proccesingCache, _ := lru.NewWithExpire(c_processingCache, time.Second*60)
@@ -104,9 +131,13 @@ func NewCore(db ethdb.Database, config *Config, isLocalBlock func(block *types.H
 	badSyncTargetsCache, _ := lru.New(c_badSyncTargetsSize)
 	c.badSyncTargets = badSyncTargetsCache
 
+	c.loadAppendQueue()
+
 	go c.updateAppendQueue()
 	go c.startStatsTimer()
 	go c.checkSyncTarget()
+	go c.checkHeadStalled()
+	go c.prunePendingDataLoop()
 	return c, nil
 }
 
@@ -116,6 +147,13 @@ func NewCore(db ethdb.Database, config *Config, isLocalBlock func(block *types.H
 // cached), and an error.
 func (c *Core) InsertChain(blocks types.Blocks) (int, error) {
 	nodeCtx := common.NodeLocation.Context()
+	// Verify the seals of the whole batch in parallel, so that the appends
+	// below only check the memoized results.
+	headers := make([]*types.Header, len(blocks))
+	for i, block := range blocks {
+		headers[i] = block.Header()
+	}
+	c.sl.PreverifySeals(headers)
 	for idx, block := range blocks {
 		// Only attempt to append a block, if it is not coincident with our dominant
 		// chain. If it is dom coincident, then the dom chain node in our slice needs
@@ -132,7 +170,7 @@ func (c *Core) InsertChain(blocks types.Blocks) (int, error) {
 				log.Info("Already processing block:", "Number:", block.Header().NumberArray(), "Hash:", block.Hash())
 				return idx, errors.New("Already in process of appending this block")
 			}
-			newPendingEtxs, _, _, err := c.sl.Append(block.Header(), types.EmptyHeader(), common.Hash{}, false, nil)
+			newPendingEtxs, _, _, err := c.sl.Append(context.Background(), block.Header(), types.EmptyHeader(), common.Hash{}, false, nil)
 			c.processingCache.Remove(block.Hash())
 			if err == nil {
 				// If we have a dom, send the dom any pending ETXs which will become
@@ -141,34 +179,39 @@ func (c *Core) InsertChain(blocks types.Blocks) (int, error) {
 				// of ETXs produced by subordinate chain(s) will become referencable.
 				if nodeCtx > common.PRIME_CTX {
 					pendingEtx := types.PendingEtxs{block.Header(), newPendingEtxs}
-					// Only send the pending Etxs to dom if valid, because in the case of running a slice, for the zones that the node doesn't run, it cannot have the etxs generated
+					// Only send the pending Etxs to dom if valid, because in the case of running a slice, for the zones that the node doesn't run, it cannot have the etxs generated.
+					// The send is queued, so that an unreachable dom does not hold up the appends while it is retried.
 					if pendingEtx.IsValid(trie.NewStackTrie(nil)) {
-						if err := c.SendPendingEtxsToDom(pendingEtx); err != nil {
-							log.Error("failed to send ETXs to domclient", "block: ", block.Hash(), "err", err)
+						ctx, cancel := context.WithTimeout(context.Background(), c_pEtxQueueTimeout)
+						if err := c.sl.queuePendingEtxsToDom(ctx, pendingEtx); err != nil {
+							log.Warn("Failed to queue the pending etxs for the dom, the dom has to request them", "hash", block.Hash(), "err", err)
 						}
+						cancel()
 					}
 				}
 				c.removeFromAppendQueue(block)
-			} else if err.Error() == consensus.ErrFutureBlock.Error() ||
-				err.Error() == ErrBodyNotFound.Error() ||
-				err.Error() == ErrPendingEtxNotFound.Error() ||
-				err.Error() == consensus.ErrPrunedAncestor.Error() ||
-				err.Error() == consensus.ErrUnknownAncestor.Error() ||
-				err.Error() == ErrSubNotSyncedToDom.Error() ||
-				err.Error() == ErrDomClientNotUp.Error() {
+			} else if IsAppendError(err, consensus.ErrFutureBlock) ||
+				IsAppendError(err, ErrBodyNotFound) ||
+				IsAppendError(err, ErrPendingEtxNotFound) ||
+				IsAppendError(err, consensus.ErrPrunedAncestor) ||
+				IsAppendError(err, consensus.ErrUnknownAncestor) ||
+				IsAppendError(err, ErrSubNotSyncedToDom) ||
+				IsAppendError(err, ErrDomClientNotUp) ||
+				IsAppendError(err, ErrAppendTimeout) ||
+				IsAppendError(err, ErrInitializing) {
 				if c.sl.CurrentInfo(block.Header()) {
 					log.Info("Cannot append yet.", "loc", common.NodeLocation.Name(), "number", block.Header().NumberArray(), "hash", block.Hash(), "err", err)
 				} else {
 					log.Debug("Cannot append yet.", "loc", common.NodeLocation.Name(), "number", block.Header().NumberArray(), "hash", block.Hash(), "err", err)
 				}
-				if err.Error() == ErrSubNotSyncedToDom.Error() ||
-					err.Error() == ErrPendingEtxNotFound.Error() {
-					if nodeCtx != common.ZONE_CTX && c.sl.subClients[block.Location().SubIndex()] != nil {
-						c.sl.subClients[block.Location().SubIndex()].DownloadBlocksInManifest(context.Background(), block.Hash(), block.SubManifest(), block.ParentEntropy())
+				if IsAppendError(err, ErrSubNotSyncedToDom) ||
+					IsAppendError(err, ErrPendingEtxNotFound) {
+					if nodeCtx != common.ZONE_CTX && c.sl.getSubClient(block.Location().SubIndex()) != nil {
+						c.sl.getSubClient(block.Location().SubIndex()).DownloadBlocksInManifest(context.Background(), block.Hash(), block.SubManifest(), block.ParentEntropy())
 					}
 				}
 				return idx, ErrPendingBlock
-			} else if err.Error() != ErrKnownBlock.Error() {
+			} else if !IsAppendError(err, ErrKnownBlock) {
 				log.Info("Append failed.", "hash", block.Hash(), "err", err)
 			}
 			if err != nil && strings.Contains(err.Error(), "connection refused") {
@@ -181,8 +224,15 @@ func (c *Core) InsertChain(blocks types.Blocks) (int, error) {
 	return len(blocks), nil
 }
 
-// procAppendQueue sorts the append queue and attempts to append
+// procAppendQueue sorts the append queue and attempts to append. Only one pass
+// runs at a time, a call made while a pass is running returns immediately since
+// the running pass already covers the queue.
 func (c *Core) procAppendQueue() {
+	if !atomic.CompareAndSwapInt32(&c.appendQueueProcessing, 0, 1) {
+		return
+	}
+	defer atomic.StoreInt32(&c.appendQueueProcessing, 0)
+
 	nodeCtx := common.NodeLocation.Context()
 
 	maxFutureBlocks := c_maxFutureBlocksPrime
@@ -225,7 +275,7 @@ func (c *Core) procAppendQueue() {
 	}
 
 	normalListProcCounter := c.normalListBackoff * c_normalListProcCounter
-	if len(c.appendQueue.Keys()) < c_appendQueueThreshold || c.procCounter%int(normalListProcCounter) == 0 {
+	if c.appendQueue.Len() < c_appendQueueThreshold || c.procCounter%int(normalListProcCounter) == 0 {
 		c.procCounter = 0
 		c.serviceBlocks(hashNumberList)
 		if len(hashNumberList) > 0 {
@@ -236,19 +286,38 @@ func (c *Core) procAppendQueue() {
 }
 
 func (c *Core) serviceBlocks(hashNumberList []types.HashAndNumber) {
+	// Sort by number, and blocks at the same number by descending entropy, so
+	// that the heavier sibling is appended first. Blocks whose entropy cannot be
+	// computed come after the others and ties are broken by hash, so that the
+	// order is deterministic.
+	entropies := make(map[common.Hash]*big.Int)
+	entropy := func(hash common.Hash) *big.Int {
+		if s, ok := entropies[hash]; ok {
+			return s
+		}
+		var s *big.Int
+		if block := c.GetBlockOrCandidateByHash(hash); block != nil {
+			s = c.engine.TotalLogS(block.Header())
+		}
+		entropies[hash] = s
+		return s
+	}
 	sort.Slice(hashNumberList, func(i, j int) bool {
-		return hashNumberList[i].Number < hashNumberList[j].Number
+		a, b := hashNumberList[i], hashNumberList[j]
+		if a.Number != b.Number {
+			return a.Number < b.Number
+		}
+		aS, bS := entropy(a.Hash), entropy(b.Hash)
+		if (aS == nil) != (bS == nil) {
+			return aS != nil
+		}
+		if aS != nil && aS.Cmp(bS) != 0 {
+			return aS.Cmp(bS) > 0
+		}
+		return lowerHash(a.Hash, b.Hash)
 	})
 
-	var retryThreshold uint64
-	switch common.NodeLocation.Context() {
-	case common.PRIME_CTX:
-		retryThreshold = c_primeRetryThreshold
-	case common.REGION_CTX:
-		retryThreshold = c_regionRetryThreshold
-	case common.ZONE_CTX:
-		retryThreshold = c_zoneRetryThreshold
-	}
+	settings := c.sl.currentSettings()
 
 	// Attempt to service the sorted list
 	for i, hashAndNumber := range hashNumberList {
@@ -257,10 +326,20 @@ func (c *Core) serviceBlocks(hashNumberList []types.HashAndNumber) {
 			var numberAndRetryCounter blockNumberAndRetryCounter
 			if value, exist := c.appendQueue.Peek(block.Hash()); exist {
 				numberAndRetryCounter = value.(blockNumberAndRetryCounter)
+				// Skip the block until its backoff has elapsed
+				if time.Now().Before(numberAndRetryCounter.nextRetry) {
+					continue
+				}
 				numberAndRetryCounter.retry += 1
-				if numberAndRetryCounter.retry > retryThreshold && numberAndRetryCounter.number+c_appendQueueRemoveThreshold < c.CurrentHeader().NumberU64() {
+				if numberAndRetryCounter.retry > settings.appendQueueMaxRetries {
+					log.Warn("Dropping block from the append queue", "hash", block.Hash(), "number", block.Header().NumberArray(), "retries", numberAndRetryCounter.retry, "reason", "max retries reached")
+					c.appendQueue.Remove(block.Hash())
+					continue
+				}
+				if numberAndRetryCounter.retry > settings.appendQueueRetryThreshold && numberAndRetryCounter.number+c_appendQueueRemoveThreshold < c.CurrentHeader().NumberU64() {
 					c.appendQueue.Remove(block.Hash())
 				} else {
+					numberAndRetryCounter.nextRetry = time.Now().Add(appendQueueBackoff(numberAndRetryCounter.retry))
 					c.appendQueue.Add(block.Hash(), numberAndRetryCounter)
 				}
 			}
@@ -275,14 +354,14 @@ func (c *Core) serviceBlocks(hashNumberList []types.HashAndNumber) {
 				nodeCtx := common.NodeLocation.Context()
 				if parentHeaderOrder < nodeCtx && c.GetHeaderByHash(parentBlock.Hash()) == nil {
 					log.Info("Requesting the dom to get the block if it doesnt have and try to append", "Hash", parentBlock.Hash(), "Order", parentHeaderOrder)
-					if c.sl.domClient != nil {
+					if c.sl.getDomClient() != nil {
 						// send a signal to the required dom to fetch the block if it doesnt have it, or its not in its appendqueue
-						go c.sl.domClient.RequestDomToAppendOrFetch(context.Background(), parentBlock.Hash(), parentBlock.ParentEntropy(), parentHeaderOrder)
+						go c.sl.getDomClient().RequestDomToAppendOrFetch(context.Background(), parentBlock.Hash(), parentBlock.ParentEntropy(), parentHeaderOrder)
 					}
 				}
 				c.addToQueueIfNotAppended(parentBlock)
 				_, err = c.InsertChain([]*types.Block{block})
-				if err != nil && err.Error() == ErrPendingBlock.Error() {
+				if err != nil && IsAppendError(err, ErrPendingBlock) {
 					// Best check here would be to check the first hash in each Fork, until we do that
 					// checking the first item in the sorted hashNumberList will do
 					if i == 0 && c.normalListBackoff < c_normalListBackoffThreshold {
@@ -300,6 +379,36 @@ func (c *Core) serviceBlocks(hashNumberList []types.HashAndNumber) {
 	}
 }
 
+// defaultAppendQueueRetryThreshold returns the number of retries after which a
+// block behind the head is dropped from the append queue of the given context
+func defaultAppendQueueRetryThreshold(nodeCtx int) uint64 {
+	switch nodeCtx {
+	case common.PRIME_CTX:
+		return c_primeRetryThreshold
+	case common.REGION_CTX:
+		return c_regionRetryThreshold
+	default:
+		return c_zoneRetryThreshold
+	}
+}
+
+// appendQueueBackoff returns the delay before the next append attempt of a
+// block, doubling with every retry past the priority threshold
+func appendQueueBackoff(retry uint64) time.Duration {
+	if retry < c_appendQueueRetryPriorityThreshold {
+		return 0
+	}
+	shift := retry - c_appendQueueRetryPriorityThreshold
+	if shift >= 16 {
+		return c_appendQueueMaxBackoff
+	}
+	backoff := time.Duration(1<<shift) * c_appendQueueRetryPeriod * time.Second
+	if backoff > c_appendQueueMaxBackoff {
+		return c_appendQueueMaxBackoff
+	}
+	return backoff
+}
+
 func (c *Core) RequestDomToAppendOrFetch(hash common.Hash, entropy *big.Int, order int) {
 	// TODO: optimize to check if the block is in the appendqueue or already
 	// appended to reduce the network bandwidth utilization
@@ -318,8 +427,8 @@ func (c *Core) RequestDomToAppendOrFetch(hash common.Hash, entropy *big.Int, ord
 		}
 	} else if nodeCtx == common.REGION_CTX {
 		if order < nodeCtx { // Prime block
-			if c.sl.domClient != nil {
-				go c.sl.domClient.RequestDomToAppendOrFetch(context.Background(), hash, entropy, order)
+			if c.sl.getDomClient() != nil {
+				go c.sl.getDomClient().RequestDomToAppendOrFetch(context.Background(), hash, entropy, order)
 			}
 		}
 		_, exists := c.appendQueue.Get(hash)
@@ -346,7 +455,7 @@ func (c *Core) addToQueueIfNotAppended(block *types.Block) {
 
 // SetSyncTarget sets the sync target entropy based on the prime blocks
 func (c *Core) SetSyncTarget(header *types.Header) {
-	if c.sl.subClients == nil || header.Hash() == c.sl.config.GenesisHash {
+	if c.sl.getSubClients() == nil || header.Hash() == c.sl.config.GenesisHash {
 		return
 	}
 
@@ -360,8 +469,8 @@ func (c *Core) SetSyncTarget(header *types.Header) {
 	// Set Sync Target for subs
 	if nodeCtx != common.ZONE_CTX {
 		if header != nil {
-			if c.sl.subClients[header.Location().SubIndex()] != nil {
-				c.sl.subClients[header.Location().SubIndex()].SetSyncTarget(context.Background(), header)
+			if c.sl.getSubClient(header.Location().SubIndex()) != nil {
+				c.sl.getSubClient(header.Location().SubIndex()).SetSyncTarget(context.Background(), header)
 			}
 		}
 	}
@@ -391,12 +500,67 @@ func (c *Core) addToAppendQueue(block *types.Block) error {
 	if err != nil {
 		return err
 	}
+	if err := checkFutureTime(block.Time(), time.Now().Unix(), c.sl.currentSettings().maxFutureTime); err != nil {
+		return err
+	}
 	if order == nodeCtx {
-		c.appendQueue.ContainsOrAdd(block.Hash(), blockNumberAndRetryCounter{block.NumberU64(), 0})
+		if contains, _ := c.appendQueue.ContainsOrAdd(block.Hash(), blockNumberAndRetryCounter{number: block.NumberU64()}); !contains {
+			appendFutureCounter.Inc(1)
+		}
 	}
 	return nil
 }
 
+// checkFutureTime checks that the timestamp is plausible and at most
+// maxFutureTime seconds ahead of now. The distance into the future is compared
+// instead of now+maxFutureTime, so that the check cannot overflow, and a pre
+// epoch clock is treated as the epoch.
+func checkFutureTime(timestamp uint64, now int64, maxFutureTime uint64) error {
+	if timestamp == 0 {
+		return ErrImplausibleTimestamp
+	}
+	if now < 0 {
+		now = 0
+	}
+	if timestamp > uint64(now) && timestamp-uint64(now) > maxFutureTime {
+		return consensus.ErrFutureBlock
+	}
+	return nil
+}
+
+// loadAppendQueue restores the append queue stored on the last stop. Blocks
+// which are no longer in the database, are already appended or have fallen
+// too far behind the current header are dropped.
+func (c *Core) loadAppendQueue() {
+	hashes := rawdb.ReadAppendQueue(c.sl.sliceDb)
+	if hashes == nil {
+		return
+	}
+	currentNumber := c.CurrentHeader().NumberU64()
+	for _, hash := range hashes {
+		block := c.GetBlockOrCandidateByHash(hash)
+		if block == nil || c.GetHeaderByHash(hash) != nil {
+			continue
+		}
+		if block.NumberU64()+c_appendQueueRemoveThreshold < currentNumber {
+			continue
+		}
+		c.addToAppendQueue(block)
+	}
+	rawdb.DeleteAppendQueue(c.sl.sliceDb)
+	log.Info("Loaded append queue", "stored", len(hashes), "loaded", c.appendQueue.Len())
+}
+
+// storeAppendQueue writes the hashes of the blocks in the append queue to the
+// database, so that they are not lost across a restart
+func (c *Core) storeAppendQueue() {
+	hashes := make([]common.Hash, 0, c.appendQueue.Len())
+	for _, key := range c.appendQueue.Keys() {
+		hashes = append(hashes, key.(common.Hash))
+	}
+	rawdb.WriteAppendQueue(c.sl.sliceDb, hashes)
+}
+
 // removeFromAppendQueue removes a block from the append queue
 func (c *Core) removeFromAppendQueue(block *types.Block) {
 	c.appendQueue.Remove(block.Hash())
@@ -416,6 +580,77 @@ func (c *Core) updateAppendQueue() {
 	}
 }
 
+// prunePendingDataLoop periodically prunes the pending data of the slice which
+// is below the retention window. The pending etxs referenced by the manifests
+// of the blocks waiting in the append queue are kept, as those blocks still
+// need them to be appended.
+func (c *Core) prunePendingDataLoop() {
+	pruneTimer := time.NewTicker(c_pendingPrunePeriod)
+	defer pruneTimer.Stop()
+	for {
+		select {
+		case <-pruneTimer.C:
+			keep := make(map[common.Hash]struct{})
+			for _, key := range c.appendQueue.Keys() {
+				block := c.GetBlockOrCandidateByHash(key.(common.Hash))
+				if block == nil {
+					continue
+				}
+				for _, hash := range block.SubManifest() {
+					keep[hash] = struct{}{}
+				}
+			}
+			c.sl.PrunePendingData(keep)
+		case <-c.quit:
+			return
+		}
+	}
+}
+
+// checkHeadStalled watches the head and attempts a recovery once it has not
+// advanced for the head stall timeout of the slice. The recovery is attempted
+// at most once per timeout, as long as the head keeps advancing nothing is
+// done. A head still at the genesis has not started syncing and is never
+// considered stalled. The timeout is read on every check, so that a change of
+// the setting applies to the running watchdog.
+func (c *Core) checkHeadStalled() {
+	stallTimer := time.NewTimer(headStallCheckPeriod(c.sl.currentSettings().headStallTimeout))
+	defer stallTimer.Stop()
+	lastHead := c.CurrentHeader().Hash()
+	lastAdvance := time.Now()
+	for {
+		select {
+		case <-stallTimer.C:
+			headStallTimeout := c.sl.currentSettings().headStallTimeout
+			stallTimer.Reset(headStallCheckPeriod(headStallTimeout))
+			head := c.CurrentHeader().Hash()
+			if head != lastHead || head == c.sl.config.GenesisHash {
+				lastHead = head
+				lastAdvance = time.Now()
+				continue
+			}
+			stalledFor := time.Since(lastAdvance)
+			if stalledFor < headStallTimeout {
+				continue
+			}
+			c.sl.recoverStalledHead(stalledFor)
+			c.procAppendQueue()
+			lastAdvance = time.Now()
+		case <-c.quit:
+			return
+		}
+	}
+}
+
+// headStallCheckPeriod returns the time between two checks of the head
+// advance, short enough for a stall to be noticed soon after the timeout.
+func headStallCheckPeriod(headStallTimeout time.Duration) time.Duration {
+	if period := headStallTimeout / 2; period < c_headStallCheckPeriod {
+		return period
+	}
+	return c_headStallCheckPeriod
+}
+
 func (c *Core) checkSyncTarget() {
 	badSyncTimer := time.NewTicker(c_badSyncTargetCheckTime)
 	defer badSyncTimer.Stop()
@@ -451,7 +686,7 @@ func (c *Core) startStatsTimer() {
 
 // printStats displays stats on syncing, latestHeight, etc.
 func (c *Core) printStats() {
-	log.Info("Blocks waiting to be appended", "loc", common.NodeLocation.Name(), "len(appendQueue)", len(c.appendQueue.Keys()))
+	log.Info("Blocks waiting to be appended", "loc", common.NodeLocation.Name(), "len(appendQueue)", c.appendQueue.Len())
 
 	// Print hashes & heights of all queue entries.
 	for _, hash := range c.appendQueue.Keys()[:math.Min(len(c.appendQueue.Keys()), c_appendQueuePrintSize)] {
@@ -465,6 +700,53 @@ func (c *Core) printStats() {
 
 }
 
+// FutureHeaders returns a snapshot of the blocks waiting in the append queue
+// along with the reason they cannot be appended yet.
+func (c *Core) FutureHeaders() []FutureHeaderInfo {
+	infos := make([]FutureHeaderInfo, 0, c.appendQueue.Len())
+	for _, hash := range c.appendQueue.Keys() {
+		value, exist := c.appendQueue.Peek(hash)
+		if !exist {
+			continue
+		}
+		numberAndRetryCounter := value.(blockNumberAndRetryCounter)
+		info := FutureHeaderInfo{
+			Hash:    hash.(common.Hash),
+			Number:  numberAndRetryCounter.number,
+			Retries: numberAndRetryCounter.retry,
+		}
+		header := c.GetHeaderOrCandidateByHash(info.Hash)
+		if header == nil {
+			info.Reason = "block not found in the database"
+			infos = append(infos, info)
+			continue
+		}
+		info.ParentHash = header.ParentHash()
+		switch {
+		case c.sl.IsBlockHashABadHash(info.ParentHash):
+			info.Reason = "parent is a bad block"
+		case c.GetHeaderByHash(info.ParentHash) != nil:
+			info.Reason = "parent appended, waiting on block data"
+		case c.GetHeaderOrCandidateByHash(info.ParentHash) != nil:
+			info.Reason = "parent not yet appended"
+		default:
+			info.Reason = "parent not yet arrived"
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+// FutureHeaderCount returns the number of blocks waiting in the append queue
+func (c *Core) FutureHeaderCount() int {
+	return c.appendQueue.Len()
+}
+
+// PendingEtxsCount returns the number of pending etxs sets held in the cache
+func (c *Core) PendingEtxsCount() int {
+	return c.sl.PendingEtxsCount()
+}
+
 func (c *Core) BadHashExistsInChain() bool {
 	nodeCtx := common.NodeLocation.Context()
 	// Lookup the bad hashes list to see if we have it in the database
@@ -487,10 +769,56 @@ func (c *Core) BadHashExistsInChain() bool {
 	return false
 }
 
+// Healthy reports the health of the slice and additionally checks that the
+// append queue is not overflowing with blocks that cannot be appended.
+func (c *Core) Healthy() (bool, []string) {
+	_, reasons := c.sl.Healthy()
+	if c.appendQueue.Len() > c.maxAppendQueue/c_appendQueueOverflowFactor {
+		reasons = append(reasons, "future headers overflowing")
+	}
+	return len(reasons) == 0, reasons
+}
+
 func (c *Core) SubscribeMissingBlockEvent(ch chan<- types.BlockRequest) event.Subscription {
 	return c.sl.SubscribeMissingBlockEvent(ch)
 }
 
+// MinerWorkDiff compares the selected pending header with the miners' active work.
+func (c *Core) MinerWorkDiff() (WorkDiff, error) {
+	return c.sl.MinerWorkDiff()
+}
+
+// SetForkChoice replaces the fork choice rule of the slice, nil restores the default.
+func (c *Core) SetForkChoice(forkChoice ForkChoice) {
+	c.sl.SetForkChoice(forkChoice)
+}
+
+// UpdateConfig applies the patch to the runtime settings of the slice.
+func (c *Core) UpdateConfig(patch ConfigPatch) error {
+	return c.sl.UpdateConfig(patch)
+}
+
+// SubscribeTxConfirmation notifies the given channel once the transaction has
+// confirmations canonical blocks on top of it, and again if it is reorged out.
+func (c *Core) SubscribeTxConfirmation(txHash common.Hash, confirmations int, ch chan<- TxConfirmation) (event.Subscription, error) {
+	return c.sl.SubscribeTxConfirmation(txHash, confirmations, ch)
+}
+
+// SubscribeHeadStalledEvent registers a subscription of HeadStalledEvent.
+func (c *Core) SubscribeHeadStalledEvent(ch chan<- HeadStalledEvent) event.Subscription {
+	return c.sl.SubscribeHeadStalledEvent(ch)
+}
+
+// SubscribePendingHeaderForLocation registers a subscription to the pending headers relayed to the given location.
+func (c *Core) SubscribePendingHeaderForLocation(location common.Location, ch chan<- *types.Header) (event.Subscription, error) {
+	return c.sl.SubscribePendingHeaderForLocation(location, ch)
+}
+
+// SubscribePendingEtxsEvent registers a subscription of PendingEtxsEvent.
+func (c *Core) SubscribePendingEtxsEvent(ch chan<- PendingEtxsEvent) event.Subscription {
+	return c.sl.SubscribePendingEtxsEvent(ch)
+}
+
 // InsertChainWithoutSealVerification works exactly the same
 // except for seal verification, seal verification is omitted
 func (c *Core) InsertChainWithoutSealVerification(block *types.Block) (int, error) {
@@ -519,11 +847,32 @@ func (c *Core) TxPool() *TxPool {
 	return c.sl.txPool
 }
 
-func (c *Core) Stop() {
-	// Delete the append queue
+func (c *Core) Stop() error {
+	if !atomic.CompareAndSwapInt32(&c.stopped, 0, 1) {
+		return nil
+	}
+	// Store and delete the append queue
+	c.storeAppendQueue()
 	c.appendQueue.Purge()
 	close(c.quit)
-	c.sl.Stop()
+	return c.sl.Stop()
+}
+
+// StopContext stops the core like Stop, letting the appends in flight finish
+// until ctx is done before the append queue and the slice state are persisted.
+// The appends still in flight once ctx is done are cancelled. The blocks added
+// to the append queue by the appends in flight are stored along with the rest
+// of the queue.
+func (c *Core) StopContext(ctx context.Context) error {
+	if !atomic.CompareAndSwapInt32(&c.stopped, 0, 1) {
+		return nil
+	}
+	drainErr := c.sl.drainAppends(ctx)
+	// Store and delete the append queue
+	c.storeAppendQueue()
+	c.appendQueue.Purge()
+	close(c.quit)
+	return errors.Join(drainErr, c.sl.Stop())
 }
 
 //---------------//
@@ -554,8 +903,8 @@ func (c *Core) WriteBlock(block *types.Block) {
 			c.addToAppendQueue(block)
 			// If a dom block comes in and we havent appended it yet
 		} else if order < nodeCtx && c.GetHeaderByHash(block.Hash()) == nil {
-			if c.sl.domClient != nil {
-				go c.sl.domClient.RequestDomToAppendOrFetch(context.Background(), block.Hash(), block.ParentEntropy(), order)
+			if c.sl.getDomClient() != nil {
+				go c.sl.getDomClient().RequestDomToAppendOrFetch(context.Background(), block.Hash(), block.ParentEntropy(), order)
 			}
 		}
 	}
@@ -570,10 +919,21 @@ func (c *Core) WriteBlock(block *types.Block) {
 	}
 }
 
-func (c *Core) Append(header *types.Header, manifest types.BlockManifest, domPendingHeader *types.Header, domTerminus common.Hash, domOrigin bool, newInboundEtxs types.Transactions) (types.Transactions, bool, bool, error) {
-	newPendingEtxs, subReorg, setHead, err := c.sl.Append(header, domPendingHeader, domTerminus, domOrigin, newInboundEtxs)
+// AppendContiguous appends a run of consecutive headers of this chain
+func (c *Core) AppendContiguous(ctx context.Context, headers []*types.Header) ([]types.Transactions, error) {
+	return c.sl.AppendContiguous(ctx, headers)
+}
+
+// AppendBlock appends a block which is already fully held by the caller,
+// without reconstructing it from the body in the db
+func (c *Core) AppendBlock(ctx context.Context, block *types.Block, domPendingHeader *types.Header, domTerminus common.Hash, domOrigin bool, newInboundEtxs types.Transactions) (types.Transactions, bool, bool, error) {
+	return c.sl.AppendBlock(ctx, block, domPendingHeader, domTerminus, domOrigin, newInboundEtxs)
+}
+
+func (c *Core) Append(ctx context.Context, header *types.Header, manifest types.BlockManifest, domPendingHeader *types.Header, domTerminus common.Hash, domOrigin bool, newInboundEtxs types.Transactions) (types.Transactions, bool, bool, error) {
+	newPendingEtxs, subReorg, setHead, err := c.sl.Append(ctx, header, domPendingHeader, domTerminus, domOrigin, newInboundEtxs)
 	if err != nil {
-		if err.Error() == ErrBodyNotFound.Error() || err.Error() == consensus.ErrUnknownAncestor.Error() || err.Error() == ErrSubNotSyncedToDom.Error() {
+		if IsAppendError(err, ErrBodyNotFound) || IsAppendError(err, consensus.ErrUnknownAncestor) || IsAppendError(err, ErrSubNotSyncedToDom) || IsAppendError(err, ErrAppendTimeout) {
 			// Fetch the blocks for each hash in the manifest
 			block := c.GetBlockOrCandidateByHash(header.Hash())
 			if block == nil {
@@ -614,8 +974,8 @@ func (c *Core) DownloadBlocksInManifest(blockHash common.Hash, manifest types.Bl
 		block := c.GetBlockOrCandidateByHash(blockHash)
 		if block != nil {
 			// If a prime block comes in
-			if c.sl.subClients[block.Location().SubIndex()] != nil {
-				c.sl.subClients[block.Location().SubIndex()].DownloadBlocksInManifest(context.Background(), block.Hash(), block.SubManifest(), block.ParentEntropy())
+			if c.sl.getSubClient(block.Location().SubIndex()) != nil {
+				c.sl.getSubClient(block.Location().SubIndex()).DownloadBlocksInManifest(context.Background(), block.Hash(), block.SubManifest(), block.ParentEntropy())
 			}
 		}
 	}
@@ -630,18 +990,74 @@ func (c *Core) SubRelayPendingHeader(slPendingHeader types.PendingHeader, newEnt
 	c.sl.SubRelayPendingHeader(slPendingHeader, newEntropy, location, subReorg, order)
 }
 
+func (c *Core) SubRelayPendingHeaderDiff(diff types.PendingHeaderDiff, termini types.Termini, newEntropy *big.Int, location common.Location, subReorg bool, order int) error {
+	return c.sl.SubRelayPendingHeaderDiff(diff, termini, newEntropy, location, subReorg, order)
+}
+
 func (c *Core) UpdateDom(oldTerminus common.Hash, pendingHeader types.PendingHeader, location common.Location) {
 	c.sl.UpdateDom(oldTerminus, pendingHeader, location)
 }
 
-func (c *Core) NewGenesisPendigHeader(pendingHeader *types.Header) {
-	c.sl.NewGenesisPendingHeader(pendingHeader)
+func (c *Core) NewGenesisPendigHeader(ctx context.Context, pendingHeader *types.Header) {
+	c.sl.NewGenesisPendingHeader(ctx, pendingHeader)
+}
+
+func (c *Core) BlockEtxs(hash common.Hash) (types.Transactions, error) {
+	return c.sl.BlockEtxs(hash)
+}
+
+func (c *Core) PreverifySeals(headers []*types.Header) {
+	c.sl.PreverifySeals(headers)
+}
+
+func (c *Core) AppendOrGet(ctx context.Context, header *types.Header, domPendingHeader *types.Header, domTerminus common.Hash, domOrigin bool, newInboundEtxs types.Transactions) (types.Transactions, bool, bool, error) {
+	return c.sl.AppendOrGet(ctx, header, domPendingHeader, domTerminus, domOrigin, newInboundEtxs)
+}
+
+func (c *Core) AppendWithResult(ctx context.Context, header *types.Header, domPendingHeader *types.Header, domTerminus common.Hash, domOrigin bool, newInboundEtxs types.Transactions) (*AppendResult, error) {
+	return c.sl.AppendWithResult(ctx, header, domPendingHeader, domTerminus, domOrigin, newInboundEtxs)
+}
+
+func (c *Core) ReorgTo(hash common.Hash) error {
+	return c.sl.ReorgTo(hash)
+}
+
+func (c *Core) ResetTxPool() error {
+	return c.sl.ResetTxPool()
+}
+
+func (c *Core) Confirmations(hash common.Hash) (int, error) {
+	return c.sl.Confirmations(hash)
+}
+
+func (c *Core) ForEachCanonicalBlock(from, to uint64, fn func(*types.Block) error) error {
+	return c.sl.ForEachCanonicalBlock(from, to, fn)
 }
 
 func (c *Core) GetPendingHeader() (*types.Header, error) {
 	return c.sl.GetPendingHeader()
 }
 
+func (c *Core) GetPendingHeaderByTerminus(terminus common.Hash) (types.PendingHeader, error) {
+	return c.sl.GetPendingHeaderByTerminus(terminus)
+}
+
+func (c *Core) PendingHeadersByCoinbase(addr common.Address) []types.PendingHeader {
+	return c.sl.PendingHeadersByCoinbase(addr)
+}
+
+func (c *Core) StalePendingHeaders() []common.Hash {
+	return c.sl.StalePendingHeaders()
+}
+
+func (c *Core) SuggestGasPrice(ctx int) (*big.Int, error) {
+	return c.sl.SuggestGasPrice(ctx)
+}
+
+func (c *Core) HeadAndPending() (*types.Header, types.Termini, types.PendingHeader, error) {
+	return c.sl.HeadAndPending()
+}
+
 func (c *Core) GetManifest(blockHash common.Hash) (types.BlockManifest, error) {
 	return c.sl.GetManifest(blockHash)
 }
@@ -650,8 +1066,16 @@ func (c *Core) GetSubManifest(slice common.Location, blockHash common.Hash) (typ
 	return c.sl.GetSubManifest(slice, blockHash)
 }
 
+func (c *Core) GetRollup(blockHash common.Hash) (types.Transactions, error) {
+	return c.sl.GetRollup(blockHash)
+}
+
 func (c *Core) GetPendingEtxs(hash common.Hash) *types.PendingEtxs {
-	return rawdb.ReadPendingEtxs(c.sl.sliceDb, hash)
+	pendingEtxs, err := c.sl.GetPendingEtxs(hash)
+	if err != nil {
+		return nil
+	}
+	return &pendingEtxs
 }
 
 func (c *Core) GetPendingEtxsRollup(hash common.Hash) *types.PendingEtxsRollup {
@@ -666,12 +1090,16 @@ func (c *Core) GetPendingEtxsFromSub(hash common.Hash, location common.Location)
 	return c.sl.GetPendingEtxsFromSub(hash, location)
 }
 
+func (c *Core) CheckRollupAvailability(manifest types.BlockManifest) ([]common.Hash, error) {
+	return c.sl.CheckRollupAvailability(manifest)
+}
+
 func (c *Core) HasPendingEtxs(hash common.Hash) bool {
 	return c.GetPendingEtxs(hash) != nil
 }
 
-func (c *Core) SendPendingEtxsToDom(pEtxs types.PendingEtxs) error {
-	return c.sl.SendPendingEtxsToDom(pEtxs)
+func (c *Core) SendPendingEtxsToDom(ctx context.Context, pEtxs types.PendingEtxs) error {
+	return c.sl.SendPendingEtxsToDom(ctx, pEtxs)
 }
 
 func (c *Core) AddPendingEtxs(pEtxs types.PendingEtxs) error {
@@ -833,7 +1261,7 @@ func (c *Core) Genesis() *types.Block {
 
 // SubscribeChainHeadEvent registers a subscription of ChainHeadEvent.
 func (c *Core) SubscribeChainHeadEvent(ch chan<- ChainHeadEvent) event.Subscription {
-	return c.sl.hc.SubscribeChainHeadEvent(ch)
+	return c.sl.SubscribeChainHeadEvent(ch)
 }
 
 // GetBody retrieves a block body (transactions and uncles) from the database by
@@ -853,9 +1281,14 @@ func (c *Core) GetTerminiByHash(hash common.Hash) *types.Termini {
 	return c.sl.hc.GetTerminiByHash(hash)
 }
 
+// GetTermini retrieves the validated termini stored for a given header hash
+func (c *Core) GetTermini(hash common.Hash) (types.Termini, error) {
+	return c.sl.GetTermini(hash)
+}
+
 // SubscribeChainSideEvent registers a subscription of ChainSideEvent.
 func (c *Core) SubscribeChainSideEvent(ch chan<- ChainSideEvent) event.Subscription {
-	return c.sl.hc.SubscribeChainSideEvent(ch)
+	return c.sl.SubscribeChainSideEvent(ch)
 }
 
 //--------------------//
@@ -47,6 +47,9 @@ var (
 	// ErrDomClientNotUp is returned when block is trying to be appended when domClient is not up.
 	ErrDomClientNotUp = errors.New("dom client is not online")
 
+	// ErrPendingEtxsNotSent is returned when the pending etxs could not be sent to the dom after retrying
+	ErrPendingEtxsNotSent = errors.New("pending etxs not sent to dom")
+
 	// ErrBadSubManifest is returned when a block's subordinate manifest does not match the subordinate manifest hash
 	ErrBadSubManifest = errors.New("subordinate manifest is incorrect")
 
@@ -71,10 +74,104 @@ var (
 	// ErrBadBlockHash is returned when block being appended is in the badBlockHashes list
 	ErrBadBlockHash = errors.New("block hash exists in bad block hashes list")
 
+	// ErrAppendReentry is returned when a block re-enters Append on a slice which is already appending it
+	ErrAppendReentry = errors.New("block is already being appended by this slice")
+
+	// ErrCanonicalHashNotFound is returned when there is no canonical hash for the requested height
+	ErrCanonicalHashNotFound = errors.New("canonical hash not found")
+
+	// ErrPendingHeaderNilBaseFee is returned when a combined pending header does not have a base fee
+	ErrPendingHeaderNilBaseFee = errors.New("pending header has nil base fee")
+
+	// ErrSliceClosed is returned when a slice method is called after the slice has been stopped
+	ErrSliceClosed = errors.New("slice is closed")
+
+	// ErrShuttingDown is returned when a block is appended after the graceful shutdown of the slice has begun
+	ErrShuttingDown = errors.New("slice is shutting down")
+
+	// ErrInvalidConfirmations is returned when a confirmation subscription is requested for a negative number of blocks
+	ErrInvalidConfirmations = errors.New("confirmations must not be negative")
+
+	// ErrImmutableConfig is returned when a config patch changes a value which cannot be changed on a running slice
+	ErrImmutableConfig = errors.New("config value cannot be changed at runtime")
+
+	// ErrInvalidConfigPatch is returned when a config patch sets a value out of its valid range
+	ErrInvalidConfigPatch = errors.New("invalid config patch value")
+
+	// ErrAppendTimeout is returned when a subordinate or dominant request made during an append exceeds the append timeout
+	ErrAppendTimeout = errors.New("append request to hierarchy timed out")
+
+	// ErrInitializing is returned when a block is appended while the slice is still initializing the genesis knot
+	ErrInitializing = errors.New("slice is initializing")
+
+	// ErrCyclicReference is returned when the termini of a block do not match the terminus given by the dom
+	ErrCyclicReference = errors.New("termini do not match, block rejected due to cyclic reference")
+
+	// ErrNoMinerWork is returned when no pending header has been delivered to the miners yet
+	ErrNoMinerWork = errors.New("no pending header delivered to the miners")
+
+	// ErrReorgTooDeep is returned when a block would reorganize more canonical blocks than the maximum reorg depth
+	ErrReorgTooDeep = errors.New("reorg exceeds the maximum reorg depth")
+
+	// ErrLocationNotRelayed is returned when subscribing to the pending headers of a location this slice does not relay to
+	ErrLocationNotRelayed = errors.New("pending headers are not relayed to this location")
+
+	// ErrTerminiNotFound is returned when the termini of a header are not in the database
+	ErrTerminiNotFound = errors.New("termini not found")
+
+	// ErrImplausibleTimestamp is returned when a block with a zero timestamp is added to the append queue
+	ErrImplausibleTimestamp = errors.New("implausible block timestamp")
+
+	// ErrMissingEntropy is returned when the fork choice is given a candidate without total entropy
+	ErrMissingEntropy = errors.New("candidate total entropy is missing")
+
+	// ErrRollupHashMismatch is returned when the etx rollup collected for a coincident block does not hash to its declared etx rollup hash
+	ErrRollupHashMismatch = errors.New("sub rollup does not match sub rollup hash")
+
+	// ErrManifestTooLarge is returned when the sub manifest of a block holds more hashes than the maximum manifest size
+	ErrManifestTooLarge = errors.New("sub manifest too large")
+
+	// ErrNonContiguousHeaders is returned when a run of headers to append is not a chain of consecutive headers
+	ErrNonContiguousHeaders = errors.New("headers are not contiguous")
+
+	// ErrBlockNotFound is returned when the requested block is not known
+	ErrBlockNotFound = errors.New("block not found")
+
+	// ErrNotCanonical is returned when the requested block is known but is not on the canonical chain
+	ErrNotCanonical = errors.New("block is not canonical")
+
+	// ErrTxPoolNotRunning is returned when a tx pool operation is requested from a slice which does not run a tx pool
+	ErrTxPoolNotRunning = errors.New("tx pool is not running")
+
+	// ErrInvalidContext is returned when a request is made for a context which does not exist or is not served by this slice
+	ErrInvalidContext = errors.New("invalid context")
+
 	// ErrPendingHeaderNotInCache is returned when a coord gives an update but the slice has not yet created the referenced ph
 	ErrPendingHeaderNotInCache = errors.New("no pending header found in cache")
+
+	// ErrNoMinerWorker is returned when a pending header is requested but no miner worker is running
+	ErrNoMinerWorker = errors.New("no miner worker running")
+
+	// ErrTxRootMismatch is returned when the transactions of a block body do not derive to the header transaction root
+	ErrTxRootMismatch = errors.New("transaction root hash mismatch")
+
+	// ErrEtxRootMismatch is returned when the external transactions of a block body do not derive to the header etx root
+	ErrEtxRootMismatch = errors.New("external transaction root hash mismatch")
+
+	// ErrUncleRootMismatch is returned when the uncles of a block body do not hash to the header uncle hash
+	ErrUncleRootMismatch = errors.New("uncle root hash mismatch")
 )
 
+// IsAppendError reports whether err is the target error. Errors returned by a
+// dom or sub over rpc lose their identity and only keep their message, so they
+// are matched on their message as well.
+func IsAppendError(err error, target error) bool {
+	if err == nil {
+		return false
+	}
+	return errors.Is(err, target) || err.Error() == target.Error()
+}
+
 // List of evm-call-message pre-checking errors. All state transition messages will
 // be pre-checked before execution. If any invalidation detected, the corresponding
 // error should be returned which is defined here.
@@ -17,6 +17,8 @@
 package core
 
 import (
+	"time"
+
 	"github.com/dominant-strategies/go-quai/common"
 	"github.com/dominant-strategies/go-quai/core/types"
 )
@@ -41,3 +43,30 @@ type ChainSideEvent struct {
 }
 
 type ChainHeadEvent struct{ Block *types.Block }
+
+// PendingEtxsEvent is posted when a new set of pending ETXs has been stored.
+type PendingEtxsEvent struct {
+	Header common.Hash
+	Count  int
+}
+
+// HeadStalledEvent is posted when the head has not advanced for longer than the
+// head stall timeout, before the recovery is attempted.
+type HeadStalledEvent struct {
+	Head       *types.Header
+	StalledFor time.Duration
+}
+
+// TxConfirmation is sent to transaction confirmation subscribers when the
+// transaction reaches the requested depth on the canonical chain, or when a
+// previously confirmed transaction is dropped by a reorg.
+type TxConfirmation struct {
+	TxHash      common.Hash
+	BlockHash   common.Hash
+	BlockNumber uint64
+	// Confirmations is the number of canonical blocks on top of the block
+	// including the transaction, as returned by Slice.Confirmations. It is zero
+	// when that block is the head.
+	Confirmations uint64
+	Dropped       bool
+}
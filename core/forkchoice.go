@@ -0,0 +1,127 @@
+package core
+
+import (
+	"bytes"
+	"math/big"
+
+	"github.com/dominant-strategies/go-quai/common"
+	"github.com/dominant-strategies/go-quai/core/types"
+	"github.com/dominant-strategies/go-quai/log"
+)
+
+// EqualTdPolicy selects how the slice resolves two heads carrying the same
+// total entropy.
+type EqualTdPolicy uint8
+
+const (
+	// EqualTdPreferLowestHash picks the head with the lowest hash, so that every
+	// node on the network converges on the same head.
+	EqualTdPreferLowestHash EqualTdPolicy = iota
+	// EqualTdPreferFirstSeen keeps the current head, which is the one this node
+	// saw first. Nodes may end up on different heads until the next block.
+	EqualTdPreferFirstSeen
+	// EqualTdPreferLocal picks the head mined by the local miner if there is
+	// one, otherwise it falls back to the lowest hash.
+	EqualTdPreferLocal
+)
+
+// String implements the stringer interface.
+func (p EqualTdPolicy) String() string {
+	switch p {
+	case EqualTdPreferLowestHash:
+		return "lowest-hash"
+	case EqualTdPreferFirstSeen:
+		return "first-seen"
+	case EqualTdPreferLocal:
+		return "local"
+	default:
+		return "unknown"
+	}
+}
+
+// ForkChoice decides whether the slice should switch its head from the current
+// header to a candidate header, given the total entropy of both.
+type ForkChoice interface {
+	ShouldReorg(current, candidate *types.Header, currentTd, candidateTd *big.Int) (bool, error)
+}
+
+// hlcrForkChoice is the default fork choice of the slice, which runs the
+// heaviest logarithmic chain rule.
+type hlcrForkChoice struct {
+	sl *Slice
+}
+
+// ShouldReorg implements ForkChoice.
+func (f *hlcrForkChoice) ShouldReorg(current, candidate *types.Header, currentTd, candidateTd *big.Int) (bool, error) {
+	return f.sl.hlcr(candidate, current, candidateTd, currentTd)
+}
+
+// SetForkChoice replaces the fork choice used by Append to select the head.
+// Passing nil restores the default heaviest logarithmic chain rule.
+func (sl *Slice) SetForkChoice(forkChoice ForkChoice) {
+	sl.settingsMu.Lock()
+	defer sl.settingsMu.Unlock()
+	if forkChoice == nil {
+		forkChoice = &hlcrForkChoice{sl: sl}
+	}
+	sl.forkChoice = forkChoice
+}
+
+// currentForkChoice returns the fork choice used by Append
+func (sl *Slice) currentForkChoice() ForkChoice {
+	sl.settingsMu.RLock()
+	defer sl.settingsMu.RUnlock()
+	return sl.forkChoice
+}
+
+// hlcr runs the heaviest logarithmic chain rule between the extern header and
+// the current head and returns true if the extern header should become the
+// new head. Ties in total entropy are resolved with the configured EqualTdPolicy.
+// Without the entropy of the current head there is nothing to keep it on, so
+// the extern header is taken, whereas a missing extern entropy is an error.
+func (sl *Slice) hlcr(externHeader *types.Header, currentHeader *types.Header, externS *big.Int, currentS *big.Int) (bool, error) {
+	if externS == nil {
+		return false, ErrMissingEntropy
+	}
+	if currentS == nil {
+		log.Warn("HLCR current head entropy is missing, taking the extern header", "current", currentHeader.Hash(), "extern", externHeader.Hash())
+		return true, nil
+	}
+	if cmp := externS.Cmp(currentS); cmp != 0 {
+		return cmp > 0, nil
+	}
+	if externHeader.Hash() == currentHeader.Hash() {
+		return false, nil
+	}
+	policy := sl.currentSettings().equalTdPolicy
+	reorg := sl.equalTdTieBreak(policy, externHeader, currentHeader)
+	equalEntropyTieBreakCounter.Inc(1)
+	log.Debug("HLCR equal entropy tie break", "policy", policy, "extern", externHeader.Hash(), "current", currentHeader.Hash(), "reorg", reorg)
+	return reorg, nil
+}
+
+// equalTdTieBreak decides between two headers of equal total entropy
+func (sl *Slice) equalTdTieBreak(policy EqualTdPolicy, externHeader *types.Header, currentHeader *types.Header) bool {
+	switch policy {
+	case EqualTdPreferFirstSeen:
+		return false
+	case EqualTdPreferLocal:
+		if sl.isLocalBlock != nil {
+			externLocal, currentLocal := sl.isLocalBlock(externHeader), sl.isLocalBlock(currentHeader)
+			if externLocal != currentLocal {
+				return externLocal
+			}
+		}
+	}
+	return lowerHash(externHeader.Hash(), currentHeader.Hash())
+}
+
+// lowerHash returns true if the extern hash is lexicographically lower than
+// the current hash. It is the deterministic tie breaker used when two heads
+// carry the same entropy, so every node reaches the same decision regardless
+// of the order in which it received the blocks. The whole hash is compared, so
+// two different blocks never tie and the order is total without any further
+// tie breaker. Equal hashes are the same block, which hlcr keeps as the head.
+func lowerHash(externHash common.Hash, currentHash common.Hash) bool {
+	return bytes.Compare(externHash[:], currentHash[:]) < 0
+}
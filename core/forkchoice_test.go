@@ -0,0 +1,166 @@
+// Copyright 2014 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/big"
+	"sync"
+	"testing"
+
+	"github.com/dominant-strategies/go-quai/common"
+	"github.com/dominant-strategies/go-quai/core/types"
+)
+
+func TestHlcrEqualEntropy(t *testing.T) {
+	low, high := newTestHeader(nil, 1, 0), newTestHeader(nil, 1, 1)
+	if bytes.Compare(low.Hash().Bytes(), high.Hash().Bytes()) > 0 {
+		low, high = high, low
+	}
+	isLocal := func(local ...*types.Header) func(*types.Header) bool {
+		return func(header *types.Header) bool {
+			for _, l := range local {
+				if l.Hash() == header.Hash() {
+					return true
+				}
+			}
+			return false
+		}
+	}
+
+	tests := []struct {
+		name            string
+		policy          EqualTdPolicy
+		isLocalBlock    func(*types.Header) bool
+		extern, current *types.Header
+		want            bool
+	}{
+		{"lowest hash, lower extern", EqualTdPreferLowestHash, nil, low, high, true},
+		{"lowest hash, higher extern", EqualTdPreferLowestHash, nil, high, low, false},
+		{"first seen, lower extern", EqualTdPreferFirstSeen, nil, low, high, false},
+		{"first seen, higher extern", EqualTdPreferFirstSeen, nil, high, low, false},
+		{"local, higher extern is local", EqualTdPreferLocal, isLocal(high), high, low, true},
+		{"local, current is local", EqualTdPreferLocal, isLocal(high), low, high, false},
+		{"local, neither is local", EqualTdPreferLocal, isLocal(), low, high, true},
+		{"local, both are local", EqualTdPreferLocal, isLocal(low, high), high, low, false},
+		{"local, without a local check", EqualTdPreferLocal, nil, low, high, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sl, _ := newTestSlice()
+			sl.settings.equalTdPolicy = tt.policy
+			sl.isLocalBlock = tt.isLocalBlock
+			reorg, err := sl.hlcr(tt.extern, tt.current, big.NewInt(10), big.NewInt(10))
+			if err != nil {
+				t.Fatalf("hlcr failed: %v", err)
+			}
+			if reorg != tt.want {
+				t.Errorf("reorg mismatch: have %v, want %v", reorg, tt.want)
+			}
+		})
+	}
+}
+
+func TestHlcrMissingEntropy(t *testing.T) {
+	extern, current := newTestHeader(nil, 1, 0), newTestHeader(nil, 1, 1)
+
+	tests := []struct {
+		name              string
+		externS, currentS *big.Int
+		want              bool
+		err               error
+	}{
+		{"missing extern entropy", nil, big.NewInt(10), false, ErrMissingEntropy},
+		{"missing both entropies", nil, nil, false, ErrMissingEntropy},
+		{"missing current entropy", big.NewInt(10), nil, true, nil},
+		{"heavier extern", big.NewInt(11), big.NewInt(10), true, nil},
+		{"lighter extern", big.NewInt(9), big.NewInt(10), false, nil},
+		{"equal entropy", big.NewInt(10), big.NewInt(10), lowerHash(extern.Hash(), current.Hash()), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sl, _ := newTestSlice()
+			reorg, err := sl.hlcr(extern, current, tt.externS, tt.currentS)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error mismatch: have %v, want %v", err, tt.err)
+			}
+			if reorg != tt.want {
+				t.Errorf("reorg mismatch: have %v, want %v", reorg, tt.want)
+			}
+		})
+	}
+}
+
+// alwaysReorg is a fork choice switching to every candidate it is asked about
+type alwaysReorg struct {
+	mu         sync.Mutex
+	candidates []common.Hash
+}
+
+func (f *alwaysReorg) ShouldReorg(current, candidate *types.Header, currentTd, candidateTd *big.Int) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.candidates = append(f.candidates, candidate.Hash())
+	return true, nil
+}
+
+func TestSetForkChoice(t *testing.T) {
+	defer func(location common.Location) { common.NodeLocation = location }(common.NodeLocation)
+	common.NodeLocation = common.Location{0, 0}
+
+	tc := newTestSubChain(t, nil, nil)
+	head := tc.appendChain(t, tc.genesis.Header(), 2, 0)[1]
+
+	// A lighter block on a side chain only becomes the head through the
+	// custom fork choice
+	forkChoice := &alwaysReorg{}
+	tc.sl.SetForkChoice(forkChoice)
+	side := tc.newBlock(tc.genesis.Header(), 1, 1)
+	if _, _, setHead, err := tc.appendBlock(context.Background(), side); err != nil || !setHead {
+		t.Fatalf("side block append: have %v, %v, want true, nil", setHead, err)
+	}
+	if have := tc.sl.hc.CurrentHeader().Hash(); have != side.Hash() {
+		t.Fatalf("head mismatch: have %x, want %x", have, side.Hash())
+	}
+	if len(forkChoice.candidates) != 1 || forkChoice.candidates[0] != side.Hash() {
+		t.Fatalf("fork choice candidates mismatch: have %x, want [%x]", forkChoice.candidates, side.Hash())
+	}
+
+	// Restoring the default brings back the heaviest chain rule, so a block
+	// lighter than the head is not taken
+	tc.sl.SetForkChoice(nil)
+	if _, ok := tc.sl.currentForkChoice().(*hlcrForkChoice); !ok {
+		t.Fatalf("fork choice not restored: have %T", tc.sl.currentForkChoice())
+	}
+	light := tc.newBlock(tc.genesis.Header(), 0, 2)
+	if _, _, setHead, err := tc.appendBlock(context.Background(), light); err != nil || setHead {
+		t.Fatalf("light block append: have %v, %v, want false, nil", setHead, err)
+	}
+	if have := tc.sl.hc.CurrentHeader().Hash(); have != side.Hash() {
+		t.Fatalf("head mismatch: have %x, want %x", have, side.Hash())
+	}
+	// A heavier block does move the head back
+	heavy := tc.newBlock(head, 1, 0)
+	if _, _, setHead, err := tc.appendBlock(context.Background(), heavy); err != nil || !setHead {
+		t.Fatalf("heavy block append: have %v, %v, want true, nil", setHead, err)
+	}
+	if len(forkChoice.candidates) != 1 {
+		t.Errorf("replaced fork choice still consulted: %d candidates", len(forkChoice.candidates))
+	}
+}
@@ -65,6 +65,9 @@ type HeaderChain struct {
 	blooms            *lru.Cache
 	subRollupCache    *lru.Cache
 
+	stagedTerminiMu sync.RWMutex
+	stagedTermini   map[common.Hash]types.Termini // Termini written to an append batch which is not committed yet
+
 	wg            sync.WaitGroup // chain processing wait group for shutting down
 	running       int32          // 0 if chain is running, 1 when stopped
 	procInterrupt int32          // interrupt signaler for block processing
@@ -134,10 +137,24 @@ func NewHeaderChain(db ethdb.Database, engine consensus.Engine, pEtxsRollupFetch
 }
 
 // CollectSubRollup collects the rollup of ETXs emitted from the subordinate
-// chain in the slice which emitted the given block.
+// chain in the slice which emitted the given block. The result is memoized by
+// block hash until the pending etxs it was collected from are removed.
 func (hc *HeaderChain) CollectSubRollup(b *types.Block) (types.Transactions, error) {
 	nodeCtx := common.NodeLocation.Context()
 	subRollup := types.Transactions{}
+	var sources []common.Hash
+	if nodeCtx < common.ZONE_CTX && b.EmptyBody() {
+		// Nothing to collect from an empty sub manifest, the rollup is known to be empty
+		if nodeCtx == common.REGION_CTX && b.EtxRollupHash() != types.EmptyRootHash {
+			return nil, fmt.Errorf("%w: have %x, want %x", ErrRollupHashMismatch, types.EmptyRootHash, b.EtxRollupHash())
+		}
+		return subRollup, nil
+	}
+	// A rollup is only cached once it is complete and, in region, matches the
+	// rollup hash of the block
+	if cached, exists := hc.subRollupCache.Get(b.Hash()); exists && cached != nil {
+		return cached.(cachedSubRollup).etxs, nil
+	}
 	if nodeCtx < common.ZONE_CTX {
 		// Since in prime the pending etxs are stored in 2 parts, pendingEtxsRollup
 		// consists of region header and its sub manifests
@@ -155,6 +172,7 @@ func (hc *HeaderChain) CollectSubRollup(b *types.Block) (types.Transactions, err
 							return nil, ErrPendingEtxNotFound
 						}
 						subRollup = append(subRollup, pendingEtxs.Etxs...)
+						sources = append(sources, pEtxHash)
 					}
 				} else {
 					// Try to get the pending etx from the Regions
@@ -170,18 +188,53 @@ func (hc *HeaderChain) CollectSubRollup(b *types.Block) (types.Transactions, err
 					return nil, ErrPendingEtxNotFound
 				}
 				subRollup = append(subRollup, pendingEtxs.Etxs...)
+				sources = append(sources, hash)
 			}
 		}
 		// Rolluphash is specifically for zone rollup, which can only be validated by region
 		if nodeCtx == common.REGION_CTX {
-			if subRollupHash := types.DeriveSha(subRollup, trie.NewStackTrie(nil)); subRollupHash != b.EtxRollupHash() {
-				return nil, errors.New("sub rollup does not match sub rollup hash")
+			if subRollupHash := types.EtxRollupHash(subRollup, trie.NewStackTrie(nil)); subRollupHash != b.EtxRollupHash() {
+				log.Warn("Collected sub rollup does not match the declared rollup hash", "hash", b.Hash(), "have", subRollupHash, "want", b.EtxRollupHash())
+				return nil, fmt.Errorf("%w: have %x, want %x", ErrRollupHashMismatch, subRollupHash, b.EtxRollupHash())
 			}
 		}
+		hc.subRollupCache.Add(b.Hash(), cachedSubRollup{etxs: subRollup, sources: sources})
 	}
 	return subRollup, nil
 }
 
+// cachedSubRollup is a sub rollup memoized by CollectSubRollup, along with the
+// hashes of the pending etxs it was collected from.
+type cachedSubRollup struct {
+	etxs    types.Transactions
+	sources []common.Hash
+}
+
+// invalidateSubRollups evicts the memoized sub rollups collected from any of
+// the given pending etxs. The rollups are cached by the hash of the dom block,
+// so every cached entry is checked against the pending etxs it was collected
+// from. It returns the number of rollups evicted.
+func (hc *HeaderChain) invalidateSubRollups(pEtxHashes map[common.Hash]struct{}) int {
+	if len(pEtxHashes) == 0 {
+		return 0
+	}
+	evicted := 0
+	for _, key := range hc.subRollupCache.Keys() {
+		cached, exists := hc.subRollupCache.Peek(key)
+		if !exists || cached == nil {
+			continue
+		}
+		for _, source := range cached.(cachedSubRollup).sources {
+			if _, removed := pEtxHashes[source]; removed {
+				hc.subRollupCache.Remove(key)
+				evicted++
+				break
+			}
+		}
+	}
+	return evicted
+}
+
 // GetPendingEtxs gets the pendingEtxs form the
 func (hc *HeaderChain) GetPendingEtxs(hash common.Hash) (*types.PendingEtxs, error) {
 	var pendingEtxs types.PendingEtxs
@@ -316,25 +369,49 @@ func (hc *HeaderChain) AppendBlock(block *types.Block, newInboundEtxs types.Tran
 	return nil
 }
 
+// txLookupIndexed reports whether the tx lookup entries of the block at the
+// given number are kept with the head at headNumber. Only a slice processing
+// state indexes transactions, a zero txLookupLimit keeps every block indexed.
+func (hc *HeaderChain) txLookupIndexed(number uint64, headNumber uint64) bool {
+	if hc.bc.processor == nil {
+		return false
+	}
+	limit := hc.bc.processor.txLookupLimit
+	return limit == 0 || number+limit > headNumber
+}
+
 // SetCurrentHeader sets the current header based on the POEM choice
 func (hc *HeaderChain) SetCurrentHeader(head *types.Header) error {
+	batch := hc.headerDb.NewBatch()
+	return hc.setCurrentHeader(batch, head, nil, batch.Write)
+}
+
+// setCurrentHeader writes the head marker, the canonical hashes and the tx
+// lookup entries of the new head to the batch, and moves the in-memory head
+// once commit has written the batch. Nothing is changed if commit fails. The
+// head block is used for its tx lookup entries if it is not readable before
+// commit.
+func (hc *HeaderChain) setCurrentHeader(batch ethdb.Batch, head *types.Header, headBlock *types.Block, commit func() error) error {
 	hc.headermu.Lock()
 	defer hc.headermu.Unlock()
 
 	prevHeader := hc.CurrentHeader()
-	// if trying to set the same header, escape
+	// if trying to set the same header, only the batch is committed
 	if prevHeader.Hash() == head.Hash() {
-		return nil
+		return commit()
 	}
 
 	// write the head block hash to the db
-	rawdb.WriteHeadBlockHash(hc.headerDb, head.Hash())
+	rawdb.WriteHeadBlockHash(batch, head.Hash())
 	log.Info("Setting the current header", "Hash", head.Hash(), "Number", head.NumberArray())
-	hc.currentHeader.Store(head)
 
 	// If head is the normal extension of canonical head, we can return by just wiring the canonical hash.
 	if prevHeader.Hash() == head.ParentHash() {
-		rawdb.WriteCanonicalHash(hc.headerDb, head.Hash(), head.NumberU64())
+		rawdb.WriteCanonicalHash(batch, head.Hash(), head.NumberU64())
+		if err := commit(); err != nil {
+			return err
+		}
+		hc.currentHeader.Store(head)
 		return nil
 	}
 
@@ -358,11 +435,23 @@ func (hc *HeaderChain) SetCurrentHeader(head *types.Header) error {
 		}
 	}
 
+	// The canonical hashes and the tx lookup entries are switched in the same
+	// batch as the head, so that lookups never point into the dropped blocks.
+	// Only the blocks which reorg out or in are reindexed, and only those
+	// inside the tx lookup limit of the new head.
+	var droppedTxs []common.Hash
 	for {
 		if prevHeader.Hash() == commonHeader.Hash() {
 			break
 		}
-		rawdb.DeleteCanonicalHash(hc.headerDb, prevHeader.NumberU64())
+		rawdb.DeleteCanonicalHash(batch, prevHeader.NumberU64())
+		if hc.txLookupIndexed(prevHeader.NumberU64(), head.NumberU64()) {
+			if block := hc.GetBlock(prevHeader.Hash(), prevHeader.NumberU64()); block != nil {
+				for _, tx := range block.Transactions() {
+					droppedTxs = append(droppedTxs, tx.Hash())
+				}
+			}
+		}
 		prevHeader = hc.GetHeader(prevHeader.ParentHash(), prevHeader.NumberU64()-1)
 
 		// genesis check to not delete the genesis block
@@ -370,10 +459,34 @@ func (hc *HeaderChain) SetCurrentHeader(head *types.Header) error {
 			break
 		}
 	}
+	rawdb.DeleteTxLookupEntries(batch, droppedTxs)
 
 	// Run through the hash stack to update canonicalHash and forward state processor
+	var reindexedTxs []common.Hash
 	for i := len(hashStack) - 1; i >= 0; i-- {
-		rawdb.WriteCanonicalHash(hc.headerDb, hashStack[i].Hash(), hashStack[i].NumberU64())
+		rawdb.WriteCanonicalHash(batch, hashStack[i].Hash(), hashStack[i].NumberU64())
+		if !hc.txLookupIndexed(hashStack[i].NumberU64(), head.NumberU64()) {
+			continue
+		}
+		block := hc.GetBlock(hashStack[i].Hash(), hashStack[i].NumberU64())
+		if block == nil && headBlock != nil && headBlock.Hash() == hashStack[i].Hash() {
+			block = headBlock
+		}
+		if block != nil {
+			rawdb.WriteTxLookupEntriesByBlock(batch, block)
+			for _, tx := range block.Transactions() {
+				reindexedTxs = append(reindexedTxs, tx.Hash())
+			}
+		}
+	}
+	if err := commit(); err != nil {
+		return err
+	}
+	hc.currentHeader.Store(head)
+	if hc.bc.processor != nil {
+		for _, hash := range append(droppedTxs, reindexedTxs...) {
+			hc.bc.processor.txLookupCache.Remove(hash)
+		}
 	}
 
 	return nil
@@ -453,7 +566,11 @@ func (hc *HeaderChain) findCommonAncestor(header *types.Header) *types.Header {
 
 func (hc *HeaderChain) AddPendingEtxs(pEtxs types.PendingEtxs) error {
 	if !pEtxs.IsValid(trie.NewStackTrie(nil)) {
-		log.Info("PendingEtx is not valid")
+		// The etxs do not match the etx hash committed to in the header, they are
+		// never written so that they cannot shadow the valid set for this block
+		if pEtxs.Header != nil && pEtxs.Etxs != nil {
+			log.Warn("Rejected pending etxs not matching the header etx hash", "block", pEtxs.Header.Hash(), "have", types.DeriveSha(pEtxs.Etxs, trie.NewStackTrie(nil)), "want", pEtxs.Header.EtxHash())
+		}
 		return ErrPendingEtxNotValid
 	}
 	log.Debug("Received pending ETXs", "block: ", pEtxs.Header.Hash())
@@ -563,10 +680,35 @@ func (hc *HeaderChain) GetBlockNumber(hash common.Hash) *uint64 {
 }
 
 func (hc *HeaderChain) GetTerminiByHash(hash common.Hash) *types.Termini {
+	hc.stagedTerminiMu.RLock()
+	staged, exists := hc.stagedTermini[hash]
+	hc.stagedTerminiMu.RUnlock()
+	if exists {
+		termini := types.CopyTermini(staged)
+		return &termini
+	}
 	termini := rawdb.ReadTermini(hc.headerDb, hash)
 	return termini
 }
 
+// stageTermini makes termini which are written to a batch that is not committed
+// yet visible to GetTerminiByHash.
+func (hc *HeaderChain) stageTermini(hash common.Hash, termini types.Termini) {
+	hc.stagedTerminiMu.Lock()
+	defer hc.stagedTerminiMu.Unlock()
+	if hc.stagedTermini == nil {
+		hc.stagedTermini = make(map[common.Hash]types.Termini)
+	}
+	hc.stagedTermini[hash] = termini
+}
+
+// clearStagedTermini drops all the staged termini once their batch is committed
+func (hc *HeaderChain) clearStagedTermini() {
+	hc.stagedTerminiMu.Lock()
+	defer hc.stagedTerminiMu.Unlock()
+	hc.stagedTermini = nil
+}
+
 // GetBlockHashesFromHash retrieves a number of block hashes starting at a given
 // hash, fetching towards the genesis block.
 func (hc *HeaderChain) GetBlockHashesFromHash(hash common.Hash, max uint64) []common.Hash {
@@ -744,6 +886,32 @@ func (hc *HeaderChain) GetCanonicalHash(number uint64) common.Hash {
 	return hash
 }
 
+// RetentionDepth translates a retention period into a block depth, i.e. the
+// number of canonical blocks behind the current header whose timestamp is
+// within the retention period of the current header. The timestamps are
+// searched directly so that variable block times are accounted for.
+func (hc *HeaderChain) RetentionDepth(retention time.Duration) uint64 {
+	current := hc.CurrentHeader()
+	retentionSecs := uint64(retention / time.Second)
+	if retentionSecs >= current.Time() {
+		return current.NumberU64()
+	}
+	cutoff := current.Time() - retentionSecs
+
+	// Binary search the lowest canonical block which is not older than the cutoff
+	low, high := uint64(0), current.NumberU64()
+	for low < high {
+		mid := low + (high-low)/2
+		header := hc.GetHeaderByNumber(mid)
+		if header == nil || header.Time() < cutoff {
+			low = mid + 1
+		} else {
+			high = mid
+		}
+	}
+	return current.NumberU64() - low
+}
+
 // CurrentHeader retrieves the current head header of the canonical chain. The
 // header is retrieved from the HeaderChain's internal cache.
 func (hc *HeaderChain) CurrentHeader() *types.Header {
@@ -907,7 +1075,17 @@ func (hc *HeaderChain) GetBody(hash common.Hash) *types.Body {
 	if number == nil {
 		return nil
 	}
-	body := rawdb.ReadBody(hc.headerDb, hash, *number)
+	return hc.GetBodyByNumber(hash, *number)
+}
+
+// GetBodyByNumber retrieves a block body from the database by hash and number,
+// caching it if found. Unlike GetBody it does not need the header to be known,
+// so it can be used while the block is being appended.
+func (hc *HeaderChain) GetBodyByNumber(hash common.Hash, number uint64) *types.Body {
+	if cached, ok := hc.bc.bodyCache.Get(hash); ok {
+		return cached.(*types.Body)
+	}
+	body := rawdb.ReadBody(hc.headerDb, hash, number)
 	if body == nil {
 		return nil
 	}
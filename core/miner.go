@@ -41,6 +41,7 @@ type Miner struct {
 	engine   consensus.Engine
 	startCh  chan common.Address
 	stopCh   chan struct{}
+	exitCh   chan struct{} // Closed once the update loop has stopped the worker
 }
 
 func New(hc *HeaderChain, txPool *TxPool, config *Config, db ethdb.Database, chainConfig *params.ChainConfig, engine consensus.Engine, isLocalBlock func(block *types.Header) bool, processingState bool) *Miner {
@@ -49,6 +50,7 @@ func New(hc *HeaderChain, txPool *TxPool, config *Config, db ethdb.Database, cha
 		engine:   engine,
 		startCh:  make(chan common.Address),
 		stopCh:   make(chan struct{}),
+		exitCh:   make(chan struct{}),
 		worker:   newWorker(config, chainConfig, db, engine, hc, txPool, isLocalBlock, true, processingState),
 		coinbase: config.Etherbase,
 	}
@@ -65,6 +67,7 @@ func New(hc *HeaderChain, txPool *TxPool, config *Config, db ethdb.Database, cha
 // the loop is exited. This to prevent a major security vuln where external parties can DOS you with blocks
 // and halt your mining operation for as long as the DOS continues.
 func (miner *Miner) update() {
+	defer close(miner.exitCh)
 	canStart := true
 	for {
 		select {
@@ -85,8 +88,10 @@ func (miner *Miner) Start(coinbase common.Address) {
 	miner.startCh <- coinbase
 }
 
+// Stop stops the worker and waits for its background threads to exit
 func (miner *Miner) Stop() {
 	miner.stopCh <- struct{}{}
+	<-miner.exitCh
 }
 
 func (miner *Miner) Mining() bool {
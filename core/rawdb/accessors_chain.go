@@ -516,10 +516,15 @@ func ReadTermini(db ethdb.Reader, hash common.Hash) *types.Termini {
 	if err := rlp.DecodeBytes(data, &termini); err != nil {
 		return nil
 	}
+	if err := termini.Validate(); err != nil {
+		log.Error("Malformed termini in database", "hash", hash, "err", err)
+		return nil
+	}
 	return &termini
 }
 
-// WriteTermini writes the heads hashes of the blockchain.
+// WriteTermini writes the heads hashes of the blockchain. The termini are not
+// validated, the caller is expected to pass termini which passed Validate.
 func WriteTermini(db ethdb.KeyValueWriter, index common.Hash, hashes types.Termini) {
 	key := terminiKey(index)
 	data, err := rlp.EncodeToBytes(hashes)
@@ -557,7 +562,8 @@ func ReadPendingHeader(db ethdb.Reader, hash common.Hash) *types.PendingHeader {
 	return pendingHeader
 }
 
-// WritePendingHeader writes the pending header of the terminus hash.
+// WritePendingHeader writes the pending header of the terminus hash, and
+// indexes it by the number of the pending header.
 func WritePendingHeader(db ethdb.KeyValueWriter, hash common.Hash, pendingHeader types.PendingHeader) {
 	key := pendingHeaderKey(hash)
 
@@ -570,6 +576,51 @@ func WritePendingHeader(db ethdb.KeyValueWriter, hash common.Hash, pendingHeader
 	if err := db.Put(key, data); err != nil {
 		log.Fatal("Failed to store header", "err", err)
 	}
+	if pendingHeader.Header() != nil {
+		if err := db.Put(pendingHeaderNumberKey(pendingHeader.Header().NumberU64(), hash), nil); err != nil {
+			log.Fatal("Failed to store pending header number index", "err", err)
+		}
+	}
+}
+
+// ReadPendingHeaderNumbersBelow retrieves the terminus hashes indexed for the
+// pending headers numbered below the limit, along with the indexed numbers. A
+// terminus is indexed again every time its pending header is written, so the
+// stored pending header may have a higher number than the index entry.
+func ReadPendingHeaderNumbersBelow(db ethdb.Iteratee, limit uint64) ([]uint64, []common.Hash) {
+	return readNumberIndexBelow(db, pendingHeaderNumberPrefix, limit)
+}
+
+// DeletePendingHeaderNumber deletes an entry of the pending header number index.
+func DeletePendingHeaderNumber(db ethdb.KeyValueWriter, number uint64, hash common.Hash) {
+	if err := db.Delete(pendingHeaderNumberKey(number, hash)); err != nil {
+		log.Fatal("Failed to delete pending header number index", "err", err)
+	}
+}
+
+// readNumberIndexBelow retrieves the numbers and hashes of the keys made of the
+// prefix followed by a number below the limit and a hash, in number order.
+func readNumberIndexBelow(db ethdb.Iteratee, prefix []byte, limit uint64) ([]uint64, []common.Hash) {
+	it := db.NewIterator(prefix, nil)
+	defer it.Release()
+
+	var (
+		numbers []uint64
+		hashes  []common.Hash
+	)
+	for it.Next() {
+		key := it.Key()
+		if len(key) != len(prefix)+8+common.HashLength {
+			continue
+		}
+		number := binary.BigEndian.Uint64(key[len(prefix) : len(prefix)+8])
+		if number >= limit {
+			break
+		}
+		numbers = append(numbers, number)
+		hashes = append(hashes, common.BytesToHash(key[len(prefix)+8:]))
+	}
+	return numbers, hashes
 }
 
 // DeletePendingHeader deletes the pending header stored for the header hash.
@@ -1146,6 +1197,22 @@ func WritePendingEtxs(db ethdb.KeyValueWriter, pendingEtxs types.PendingEtxs) {
 		log.Fatal("Failed to RLP encode pending etxs", "err", err)
 	}
 	WritePendingEtxsRLP(db, pendingEtxs.Header.Hash(), data)
+	if err := db.Put(pendingEtxsNumberKey(pendingEtxs.Header.NumberU64(), pendingEtxs.Header.Hash()), nil); err != nil {
+		log.Fatal("Failed to store pending etxs number index", "err", err)
+	}
+}
+
+// ReadPendingEtxsNumbersBelow retrieves the hashes of the blocks numbered
+// below the limit which have pending ETXs stored, along with their numbers.
+func ReadPendingEtxsNumbersBelow(db ethdb.Iteratee, limit uint64) ([]uint64, []common.Hash) {
+	return readNumberIndexBelow(db, pendingEtxsNumberPrefix, limit)
+}
+
+// DeletePendingEtxsNumber deletes an entry of the pending etxs number index.
+func DeletePendingEtxsNumber(db ethdb.KeyValueWriter, number uint64, hash common.Hash) {
+	if err := db.Delete(pendingEtxsNumberKey(number, hash)); err != nil {
+		log.Fatal("Failed to delete pending etxs number index", "err", err)
+	}
 }
 
 // DeletePendingEtxs removes all pending ETX data associated with a block.
@@ -1301,6 +1368,39 @@ func DeleteBadHashesList(db ethdb.KeyValueWriter) {
 	}
 }
 
+// ReadAppendQueue retreives the hashes of the blocks which were waiting in the
+// append queue when the node was stopped
+func ReadAppendQueue(db ethdb.Reader) []common.Hash {
+	data, _ := db.Get(appendQueueKey)
+	if len(data) == 0 {
+		return nil
+	}
+	hashes := []common.Hash{}
+	if err := rlp.Decode(bytes.NewReader(data), &hashes); err != nil {
+		log.Error("Invalid appendQueue rlp")
+		return nil
+	}
+	return hashes
+}
+
+// WriteAppendQueue stores the hashes of the blocks waiting in the append queue
+func WriteAppendQueue(db ethdb.KeyValueWriter, hashes []common.Hash) {
+	data, err := rlp.EncodeToBytes(hashes)
+	if err != nil {
+		log.Fatal("Failed to RLP encode appendQueue", "err", err)
+	}
+	if err := db.Put(appendQueueKey, data); err != nil {
+		log.Fatal("Failed to store appendQueue", "err", err)
+	}
+}
+
+// DeleteAppendQueue removes the appendQueue from the database
+func DeleteAppendQueue(db ethdb.KeyValueWriter) {
+	if err := db.Delete(appendQueueKey); err != nil {
+		log.Fatal("Failed to delete appendQueue", "err", err)
+	}
+}
+
 // WriteInboundEtxs stores the inbound etxs for a given dom block hashes
 func WriteInboundEtxs(db ethdb.KeyValueWriter, hash common.Hash, inboundEtxs types.Transactions) {
 	data, err := rlp.EncodeToBytes(inboundEtxs)
@@ -1333,3 +1433,35 @@ func DeleteInboundEtxs(db ethdb.KeyValueWriter, hash common.Hash) {
 		log.Fatal("Failed to delete inbound etxs", "err", err)
 	}
 }
+
+// WriteEtxRollup stores the rollup of etxs collected from the sub for a given dom block hash
+func WriteEtxRollup(db ethdb.KeyValueWriter, hash common.Hash, etxRollup types.Transactions) {
+	data, err := rlp.EncodeToBytes(etxRollup)
+	if err != nil {
+		log.Fatal("Failed to RLP encode etx rollup", "err", err)
+	}
+	if err := db.Put(etxRollupKey(hash), data); err != nil {
+		log.Fatal("Failed to store etx rollup", "err", err)
+	}
+}
+
+// ReadEtxRollup reads the etx rollup from the database
+func ReadEtxRollup(db ethdb.Reader, hash common.Hash) types.Transactions {
+	data, _ := db.Get(etxRollupKey(hash))
+	if len(data) == 0 {
+		return nil
+	}
+	etxRollup := types.Transactions{}
+	if err := rlp.Decode(bytes.NewReader(data), &etxRollup); err != nil {
+		log.Error("Invalid etx rollup on read", "hash", hash, "err", err)
+		return nil
+	}
+	return etxRollup
+}
+
+// DeleteEtxRollup deletes the etx rollup from the database
+func DeleteEtxRollup(db ethdb.KeyValueWriter, hash common.Hash) {
+	if err := db.Delete(etxRollupKey(hash)); err != nil {
+		log.Fatal("Failed to delete etx rollup", "err", err)
+	}
+}
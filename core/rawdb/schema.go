@@ -95,7 +95,12 @@ var (
 	phBodyPrefix        = []byte("pc")    // phBodyPrefix + hash -> []common.Hash + Td
 	terminiPrefix       = []byte("tk")    //terminiPrefix + hash -> []common.Hash
 	badHashesListPrefix = []byte("bh")
+	appendQueueKey      = []byte("aq") // appendQueueKey -> []common.Hash of the blocks waiting in the append queue
 	inboundEtxsPrefix   = []byte("ie") // inboundEtxsPrefix + hash -> types.Transactions
+	etxRollupPrefix     = []byte("er") // etxRollupPrefix + hash -> types.Transactions rolled up by the block
+
+	pendingEtxsNumberPrefix   = []byte("ne") // pendingEtxsNumberPrefix + num (uint64 big endian) + hash -> nil, index of the pending etxs by block number
+	pendingHeaderNumberPrefix = []byte("nh") // pendingHeaderNumberPrefix + num (uint64 big endian) + hash -> nil, index of the pending headers by number
 
 	blockBodyPrefix         = []byte("b")  // blockBodyPrefix + num (uint64 big endian) + hash -> block body
 	blockReceiptsPrefix     = []byte("r")  // blockReceiptsPrefix + num (uint64 big endian) + hash -> block receipts
@@ -187,6 +192,11 @@ func pendingHeaderKey(hash common.Hash) []byte {
 	return append(pendingHeaderPrefix, hash.Bytes()...)
 }
 
+// pendingHeaderNumberKey = pendingHeaderNumberPrefix + num (uint64 big endian) + hash
+func pendingHeaderNumberKey(number uint64, hash common.Hash) []byte {
+	return append(append(pendingHeaderNumberPrefix, encodeBlockNumber(number)...), hash.Bytes()...)
+}
+
 // pbBodyKey = pbBodyPrefix + hash
 func pbBodyKey(hash common.Hash) []byte {
 	return append(pbBodyPrefix, hash.Bytes()...)
@@ -291,6 +301,11 @@ func pendingEtxsKey(hash common.Hash) []byte {
 	return append(pendingEtxsPrefix, hash.Bytes()...)
 }
 
+// pendingEtxsNumberKey = pendingEtxsNumberPrefix + num (uint64 big endian) + hash
+func pendingEtxsNumberKey(number uint64, hash common.Hash) []byte {
+	return append(append(pendingEtxsNumberPrefix, encodeBlockNumber(number)...), hash.Bytes()...)
+}
+
 // pendingEtxsRollupKey = pendingEtxsRollupPrefix + hash
 func pendingEtxsRollupKey(hash common.Hash) []byte {
 	return append(pendingEtxsRollupPrefix, hash.Bytes()...)
@@ -308,3 +323,7 @@ func bloomKey(hash common.Hash) []byte {
 func inboundEtxsKey(hash common.Hash) []byte {
 	return append(inboundEtxsPrefix, hash.Bytes()...)
 }
+
+func etxRollupKey(hash common.Hash) []byte {
+	return append(etxRollupPrefix, hash.Bytes()...)
+}
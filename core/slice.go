@@ -5,9 +5,16 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"math"
 	"math/big"
 	"math/rand"
+	"net"
+	"runtime"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/dominant-strategies/go-quai/common"
@@ -19,8 +26,10 @@ import (
 	"github.com/dominant-strategies/go-quai/ethdb"
 	"github.com/dominant-strategies/go-quai/event"
 	"github.com/dominant-strategies/go-quai/log"
+	"github.com/dominant-strategies/go-quai/metrics"
 	"github.com/dominant-strategies/go-quai/params"
 	"github.com/dominant-strategies/go-quai/quaiclient"
+	"github.com/dominant-strategies/go-quai/rpc"
 	"github.com/dominant-strategies/go-quai/trie"
 	lru "github.com/hashicorp/golang-lru"
 )
@@ -32,17 +41,69 @@ const (
 	c_maxBloomFilters                 = 1024
 	c_pendingHeaderChacheBufferFactor = 2
 	pendingHeaderGCTime               = 5
-	c_terminusIndex                   = 3
+	c_terminusIndex                   = common.HierarchyDepth
 	c_startingPrintLimit              = 10
 	c_regionRelayProc                 = 3
 	c_primeRelayProc                  = 10
 	c_asyncPhUpdateChanSize           = 10
 	c_phCacheSize                     = 500
-	c_pEtxRetryThreshold              = 100 // Number of pEtxNotFound return on a dom block before asking for pEtx/Rollup from sub
-	c_currentStateComputeWindow       = 20  // Number of blocks around the current header the state generation is always done
-	c_inboundEtxCacheSize             = 10  // Number of inboundEtxs to keep in cache so that, we don't recompute it every time dom is processed
+	c_pEtxRetryThreshold              = 100                    // Number of pEtxNotFound return on a dom block before asking for pEtx/Rollup from sub
+	c_currentStateComputeWindow       = 20                     // Number of blocks around the current header the state generation is always done
+	c_inboundEtxCacheSize             = 10                     // Number of inboundEtxs to keep in cache so that, we don't recompute it every time dom is processed
+	c_manifestCacheSize               = 1024                   // Default number of decoded manifests kept in memory
+	c_maxManifestSize                 = 4096                   // Default maximum number of hashes in the sub manifest of an appended block
+	c_pendingRetention                = 10000                  // Default number of blocks below the head for which the pending etxs and pending headers are kept on disk
+	c_headStalledThreshold            = 10 * time.Minute       // Default time since the current head was produced after which the slice is reported unhealthy
+	c_locationPhChanSize              = 10                     // Number of pending headers buffered for each location subscriber
+	c_reconnectBackoff                = time.Second            // Default delay before the first reconnection attempt to a dom or sub
+	c_reconnectMaxBackoff             = time.Minute            // Default maximum delay between two reconnection attempts
+	c_parentGraceWindow               = 200 * time.Millisecond // Default time an append waits for an unknown parent before giving up
+	c_dialTimeout                     = 10 * time.Second       // Default time after which dialing a dom or sub is abandoned
+	c_gasPriceBlocks                  = 20                     // Number of recent canonical blocks sampled for the suggested gas tip
+	c_defaultGasTip                   = params.GWei            // Suggested gas tip when the recent blocks have no transactions
+	c_parentGracePollPeriod           = 20 * time.Millisecond  // Period at which the termini of the awaited parent are checked
+	c_sealCacheSize                   = 4096                   // Number of pre-verified seals kept in memory
+	c_cyclicCheckDepth                = 16                     // Default number of dom terminus links walked back by pcrc to detect a cycle
+	c_pEtxSendAttempts                = 4                      // Number of attempts made to send a set of pending etxs to the dom
+	c_pEtxSendBackoff                 = 250 * time.Millisecond // Delay before the first resend of pending etxs to the dom, doubled on every failed attempt
+	c_pendingHeaderGCWindow           = 500                    // Default number of blocks behind the head after which a phCache entry is collected
+	c_pEtxSendQueueSize               = 64                     // Number of sets of pending etxs waiting to be sent to the dom
+	c_pEtxSendTimeout                 = 10 * time.Second       // Time after which a single send of pending etxs to the dom is abandoned
+	c_pEtxQueueTimeout                = 5 * time.Second        // Time a new set of pending etxs waits for room in the full send queue
 )
 
+var (
+	appendTimer                 = metrics.NewRegisteredTimer("slice/append", nil)
+	appendSuccessCounter        = metrics.NewRegisteredCounter("slice/append/success", nil)
+	appendDuplicateCounter      = metrics.NewRegisteredCounter("slice/append/duplicate", nil)
+	appendCyclicCounter         = metrics.NewRegisteredCounter("slice/append/cyclic", nil)
+	appendFutureCounter         = metrics.NewRegisteredCounter("slice/append/future", nil)
+	appendSubNotSyncedCounter   = metrics.NewRegisteredCounter("slice/append/subnotsynced", nil)
+	reorgCounter                = metrics.NewRegisteredCounter("slice/reorg", nil)
+	equalEntropyTieBreakCounter = metrics.NewRegisteredCounter("slice/hlcr/tiebreak", nil)
+	phCacheSizeGauge            = metrics.NewRegisteredGauge("slice/phcache/size", nil)
+
+	pEtxSendSuccessCounter = metrics.NewRegisteredCounter("slice/pendingetxs/send/success", nil)
+	pEtxSendFailureCounter = metrics.NewRegisteredCounter("slice/pendingetxs/send/failure", nil)
+	pEtxSendRetryCounter   = metrics.NewRegisteredCounter("slice/pendingetxs/send/retry", nil)
+	pEtxDuplicateCounter   = metrics.NewRegisteredCounter("slice/pendingetxs/duplicate", nil)
+
+	constructBodyMissingCounter         = metrics.NewRegisteredCounter("slice/construct/bodymissing", nil)
+	constructTxRootMismatchCounter      = metrics.NewRegisteredCounter("slice/construct/txroot", nil)
+	constructEtxRootMismatchCounter     = metrics.NewRegisteredCounter("slice/construct/etxroot", nil)
+	constructUncleRootMismatchCounter   = metrics.NewRegisteredCounter("slice/construct/uncleroot", nil)
+	constructSubManifestMismatchCounter = metrics.NewRegisteredCounter("slice/construct/submanifest", nil)
+	constructOtherFailureCounter        = metrics.NewRegisteredCounter("slice/construct/other", nil)
+)
+
+// locationPhSub is a subscription to the pending headers relayed to a single
+// location. Pending headers are buffered in ch so that a slow subscriber does
+// not block the relay.
+type locationPhSub struct {
+	location common.Location
+	ch       chan *types.Header
+}
+
 type pEtxRetry struct {
 	hash    common.Hash
 	retries uint64
@@ -58,41 +119,123 @@ type Slice struct {
 	config  *params.ChainConfig
 	engine  consensus.Engine
 
-	quit chan struct{} // slice quit channel
-
-	domClient  *quaiclient.Client
-	subClients []*quaiclient.Client
+	quit         chan struct{} // slice quit channel
+	closed       int32         // 1 once Stop has been called, 0 otherwise
+	initializing int32         // 1 while init is setting up the genesis knot, 0 otherwise
+
+	shutdownMu    sync.Mutex
+	shuttingDown  bool                          // Set once the shutdown has begun, new appends are rejected afterwards
+	appendWg      sync.WaitGroup                // In-flight appends, drained by StopContext and Stop
+	appendCancels map[uint64]context.CancelFunc // Cancels the contexts of the in-flight appends
+	appendSeq     uint64                        // Key of the next in-flight append in appendCancels
+
+	backgroundCtx    context.Context    // Parent context of the requests to the dom and the subs which outlive their caller, cancelled by Stop
+	backgroundCancel context.CancelFunc // Cancels backgroundCtx
+	relayWg          sync.WaitGroup     // Relays to the subs and updates of the dom in flight, waited for by Stop
+
+	clientsMu     sync.RWMutex // Guards domClient and subClients, which are replaced by the reconnections
+	domClient     *quaiclient.Client
+	subClients    []*quaiclient.Client
+	domClientUrl  string
+	subClientUrls []string
+
+	domReconnecting     int32                           // 1 while the dom client is being reconnected, 0 otherwise
+	subReconnecting     [common.NumRegionsInPrime]int32 // 1 while the sub client at the index is being reconnected, 0 otherwise
+	subUnreachable      [common.NumRegionsInPrime]int32 // 1 if the last request to the sub at the index failed, 0 otherwise
+	reconnectBackoff    time.Duration                   // Delay before the first reconnection attempt, doubled on every failed attempt
+	reconnectMaxBackoff time.Duration                   // Maximum delay between two reconnection attempts
+
+	parentGraceWindow time.Duration // Time an append waits for an unknown parent to be appended, negative disables it
+	dialTimeout       time.Duration // Time after which dialing a dom or sub is abandoned
+	maxManifestSize   int           // Maximum number of hashes in the sub manifest of an appended block
+	pendingRetention  uint64        // Number of blocks below the head for which the pending etxs and pending headers are kept on disk
+	retentionPeriod   time.Duration // Period before the head for which the pending etxs and pending headers are kept on disk, overrides pendingRetention when set
+	phGCWindow        uint64        // Number of blocks behind the head after which a phCache entry is collected
+	phGCIntervalCh    chan struct{} // Signals the gc loop that the phCache collection interval changed
+	cyclicCheckDepth  int           // Number of dom terminus links walked back by pcrc, zero or less disables the walk
 
 	wg                    sync.WaitGroup
 	scope                 event.SubscriptionScope
 	pendingEtxsFeed       event.Feed
 	pendingEtxsRollupFeed event.Feed
 	missingBlockFeed      event.Feed
+	pendingEtxsEventFeed  event.Feed
+	headStalledFeed       event.Feed
+
+	locationPhSubsMu sync.RWMutex
+	locationPhSubs   map[*locationPhSub]struct{} // Subscribers of the pending headers relayed to a single location
 
 	pEtxRetryCache *lru.Cache
 	asyncPhCh      chan *types.Header
+	minerPh        atomic.Value // Last types.PendingHeader delivered to the miners
 	asyncPhSub     event.Subscription
 
+	pEtxSendCh chan pEtxSend // Pending etxs waiting to be sent to the dom, outside of the append path
+
 	bestPhKey        common.Hash
 	phCache          *lru.Cache
 	inboundEtxsCache *lru.Cache
+	manifestCache    *lru.Cache // Decoded manifests by block hash, a manifest never changes for a given hash
+	sealCache        *lru.Cache // Pre-verified seals by block hash
+	sealWorkers      int        // Number of seals verified concurrently by PreverifySeals
 
 	validator Validator // Block and state validator interface
 	phCacheMu sync.RWMutex
 	reorgMu   sync.RWMutex
 
 	badHashesCache map[common.Hash]bool
+
+	domUnreachable int32                          // 1 if the last request to the dom failed, 0 otherwise
+	isLocalBlock   func(block *types.Header) bool // Reports whether a block was mined by the local miner
+
+	settingsMu sync.RWMutex
+	settings   sliceSettings // Config values which can be updated at runtime
+	forkChoice ForkChoice    // Rule used by Append to decide whether a block becomes the head
+
+	appendBatchMu     sync.Mutex
+	appendBatch       ethdb.Batch // Writes of appends which are not committed yet
+	appendBatchCount  int         // Number of appends accumulated in appendBatch
+	appendBatchBlocks int         // Number of appends after which appendBatch is committed
+	appendBatchBytes  int         // Size in bytes after which appendBatch is committed
+
+	appendMu    sync.Mutex // Serializes the local state changes of the appends
+	appendingMu sync.Mutex
+	appending   map[common.Hash]int // Block hashes currently being appended by the sub, used to detect re-entry
+
+	phRecovering int32 // 1 while GetPendingHeader regenerates a missing best pending header, 0 otherwise
 }
 
 func NewSlice(db ethdb.Database, config *Config, txConfig *TxPoolConfig, txLookupLimit *uint64, isLocalBlock func(block *types.Header) bool, chainConfig *params.ChainConfig, slicesRunning []common.Location, domClientUrl string, subClientUrls []string, engine consensus.Engine, cacheConfig *CacheConfig, vmConfig vm.Config, genesis *Genesis) (*Slice, error) {
 	nodeCtx := common.NodeLocation.Context()
 	sl := &Slice{
-		config:         chainConfig,
-		engine:         engine,
-		sliceDb:        db,
-		quit:           make(chan struct{}),
-		badHashesCache: make(map[common.Hash]bool),
-	}
+		config:            chainConfig,
+		engine:            engine,
+		sliceDb:           db,
+		quit:              make(chan struct{}),
+		pEtxSendCh:        make(chan pEtxSend, c_pEtxSendQueueSize),
+		phGCIntervalCh:    make(chan struct{}, 1),
+		badHashesCache:    make(map[common.Hash]bool),
+		appending:         make(map[common.Hash]int),
+		isLocalBlock:      isLocalBlock,
+		appendBatchBlocks: config.AppendBatchBlocks,
+		appendBatchBytes:  config.AppendBatchBytes,
+		settings: sliceSettings{
+			equalTdPolicy:             config.EqualTdPolicy,
+			domRequiredForCoincident:  config.DomRequiredForCoincident,
+			relayPendingHeaderDiffs:   config.RelayPendingHeaderDiffs,
+			verifyTermini:             config.VerifyTermini,
+			retryPersistOnStop:        config.RetryPersistOnStop,
+			pEtxRetryThreshold:        c_pEtxRetryThreshold,
+			appendTimeout:             config.AppendTimeout,
+			maxReorgDepth:             config.MaxReorgDepth,
+			headStallTimeout:          config.HeadStallTimeout,
+			phGCInterval:              config.PendingHeaderGCInterval,
+			maxFutureTime:             config.MaxFutureTime,
+			appendQueueMaxRetries:     config.AppendQueueMaxRetries,
+			appendQueueRetryThreshold: config.AppendQueueRetryThreshold,
+		},
+	}
+	sl.backgroundCtx, sl.backgroundCancel = context.WithCancel(context.Background())
 
 	var err error
 	sl.hc, err = NewHeaderChain(db, engine, sl.GetPEtxRollupAfterRetryThreshold, sl.GetPEtxAfterRetryThreshold, chainConfig, cacheConfig, txLookupLimit, vmConfig, slicesRunning)
@@ -101,6 +244,7 @@ func NewSlice(db ethdb.Database, config *Config, txConfig *TxPoolConfig, txLooku
 	}
 
 	sl.validator = NewBlockValidator(chainConfig, sl.hc, engine)
+	sl.forkChoice = &hlcrForkChoice{sl: sl}
 
 	// tx pool is only used in zone
 	if nodeCtx == common.ZONE_CTX && sl.ProcessingState() {
@@ -109,22 +253,111 @@ func NewSlice(db ethdb.Database, config *Config, txConfig *TxPoolConfig, txLooku
 	}
 	sl.miner = New(sl.hc, sl.txPool, config, db, chainConfig, engine, isLocalBlock, sl.ProcessingState())
 
-	sl.phCache, _ = lru.New(c_phCacheSize)
+	phCacheSize := config.PhCacheSize
+	if phCacheSize <= 0 {
+		phCacheSize = c_phCacheSize
+	}
+	sl.phCache, _ = lru.New(phCacheSize)
 
 	sl.pEtxRetryCache, _ = lru.New(c_pEtxRetryThreshold)
 
 	sl.inboundEtxsCache, _ = lru.New(c_inboundEtxCacheSize)
 
-	// only set the subClients if the chain is not Zone
+	manifestCacheSize := config.ManifestCacheSize
+	if manifestCacheSize <= 0 {
+		manifestCacheSize = c_manifestCacheSize
+	}
+	sl.manifestCache, _ = lru.New(manifestCacheSize)
+
+	sl.sealCache, _ = lru.New(c_sealCacheSize)
+	sl.sealWorkers = config.SealVerifyWorkers
+	if sl.sealWorkers <= 0 {
+		sl.sealWorkers = runtime.NumCPU()
+	}
+
+	sl.locationPhSubs = make(map[*locationPhSub]struct{})
+
+	sl.domClientUrl = domClientUrl
+	sl.subClientUrls = subClientUrls
+	sl.reconnectBackoff = config.ReconnectBackoff
+	if sl.reconnectBackoff <= 0 {
+		sl.reconnectBackoff = c_reconnectBackoff
+	}
+	sl.reconnectMaxBackoff = config.ReconnectMaxBackoff
+	if sl.reconnectMaxBackoff < sl.reconnectBackoff {
+		sl.reconnectMaxBackoff = c_reconnectMaxBackoff
+	}
+	sl.parentGraceWindow = config.ParentGraceWindow
+	if sl.parentGraceWindow == 0 {
+		sl.parentGraceWindow = c_parentGraceWindow
+	}
+	sl.dialTimeout = config.DialTimeout
+	if sl.dialTimeout <= 0 {
+		sl.dialTimeout = c_dialTimeout
+	}
+	sl.maxManifestSize = config.MaxManifestSize
+	if sl.maxManifestSize <= 0 {
+		sl.maxManifestSize = c_maxManifestSize
+	}
+	sl.pendingRetention = config.PendingRetention
+	if sl.pendingRetention == 0 {
+		sl.pendingRetention = c_pendingRetention
+	}
+	sl.retentionPeriod = config.PendingRetentionPeriod
+	sl.phGCWindow = config.PendingHeaderGCWindow
+	if sl.phGCWindow == 0 {
+		sl.phGCWindow = c_pendingHeaderGCWindow
+	}
+	if sl.settings.headStallTimeout <= 0 {
+		sl.settings.headStallTimeout = c_headStalledThreshold
+	}
+	if sl.settings.phGCInterval <= 0 {
+		sl.settings.phGCInterval = pendingHeaderGCTime * time.Minute
+	}
+	if sl.settings.maxFutureTime == 0 {
+		sl.settings.maxFutureTime = c_maxFutureTime
+	}
+	if sl.settings.appendQueueMaxRetries == 0 {
+		sl.settings.appendQueueMaxRetries = c_appendQueueMaxRetries
+	}
+	if sl.settings.appendQueueRetryThreshold == 0 {
+		sl.settings.appendQueueRetryThreshold = defaultAppendQueueRetryThreshold(nodeCtx)
+	}
+	sl.cyclicCheckDepth = config.CyclicCheckDepth
+	if sl.cyclicCheckDepth == 0 {
+		sl.cyclicCheckDepth = c_cyclicCheckDepth
+	}
+
+	// only set the subClients if the chain is not Zone. A sub which cannot be
+	// reached is left nil and reconnected in the background, so that a single
+	// unreachable sub does not keep the node from starting.
 	sl.subClients = make([]*quaiclient.Client, 3)
 	if nodeCtx != common.ZONE_CTX {
-		sl.subClients = makeSubClients(subClientUrls)
+		var subErrs []error
+		sl.subClients, subErrs = makeSubClients(subClientUrls, sl.dialTimeout)
+		for i, err := range subErrs {
+			if err != nil {
+				log.Warn("Starting without the subordinate go-quai client", "index", i, "err", err)
+				index := i
+				sl.reconnect(&sl.subReconnecting[index], "subordinate", func() error { return sl.dialSubClient(index) })
+			}
+		}
 	}
 
-	// only set domClient if the chain is not Prime.
+	// only set domClient if the chain is not Prime. The dom is required, but it
+	// may come up after this node, so a failed dial is retried in the background.
 	if nodeCtx != common.PRIME_CTX {
+		if domClientUrl == "" {
+			return nil, errors.New("dom client url is empty")
+		}
 		go func() {
-			sl.domClient = makeDomClient(domClientUrl)
+			domClient, err := makeDomClient(domClientUrl, sl.dialTimeout)
+			if err != nil {
+				log.Warn("Starting without the dominant go-quai client", "err", err)
+				sl.reconnect(&sl.domReconnecting, "dominant", sl.dialDomClient)
+				return
+			}
+			sl.setDomClient(domClient)
 		}()
 	}
 
@@ -137,6 +370,12 @@ func NewSlice(db ethdb.Database, config *Config, txConfig *TxPoolConfig, txLooku
 	if nodeCtx == common.ZONE_CTX && sl.ProcessingState() {
 		go sl.asyncPendingHeaderLoop()
 	}
+	if nodeCtx != common.PRIME_CTX {
+		sl.wg.Add(1)
+		go sl.pEtxSendLoop()
+	}
+	sl.wg.Add(1)
+	go sl.gcPendingHeadersLoop()
 
 	return sl, nil
 }
@@ -144,13 +383,136 @@ func NewSlice(db ethdb.Database, config *Config, txConfig *TxPoolConfig, txLooku
 // Append takes a proposed header and constructs a local block and attempts to hierarchically append it to the block graph.
 // If this is called from a dominant context a domTerminus must be provided else a common.Hash{} should be used and domOrigin should be set to true.
 // Return of this function is the Etxs generated in the Zone Block, subReorg bool that tells dom if should be mined on, setHead bool that determines if we should set the block as the current head and the error
-func (sl *Slice) Append(header *types.Header, domPendingHeader *types.Header, domTerminus common.Hash, domOrigin bool, newInboundEtxs types.Transactions) (types.Transactions, bool, bool, error) {
+// If ctx is cancelled before the block is handed to the sub, nothing is written and ctx.Err() is returned. After that point
+// the append is completed regardless of ctx.
+func (sl *Slice) Append(ctx context.Context, header *types.Header, domPendingHeader *types.Header, domTerminus common.Hash, domOrigin bool, newInboundEtxs types.Transactions) (types.Transactions, bool, bool, error) {
+	return sl.appendBlock(ctx, header, nil, domPendingHeader, domTerminus, domOrigin, newInboundEtxs, nil)
+}
+
+// AppendResult holds what an append produced, so that block indexers do not
+// have to read the block back after the append, racing against pruning.
+type AppendResult struct {
+	Block       *types.Block       // Block constructed and appended, nil for the genesis block
+	PendingEtxs types.Transactions // Pending etxs, as returned by Append
+	Receipts    types.Receipts     // Receipts of the block, only set in a zone processing state
+	Entropy     *big.Int           // Total entropy of the block, weighed by the fork choice
+	SubReorg    bool               // Whether the dom should mine on the block
+	SetHead     bool               // Whether the block was set as the current head
+}
+
+// AppendWithResult appends the header the same way as Append, and returns the
+// constructed block, its receipts and its total entropy along with the values
+// returned by Append.
+func (sl *Slice) AppendWithResult(ctx context.Context, header *types.Header, domPendingHeader *types.Header, domTerminus common.Hash, domOrigin bool, newInboundEtxs types.Transactions) (*AppendResult, error) {
+	result := new(AppendResult)
+	if _, _, _, err := sl.appendBlock(ctx, header, nil, domPendingHeader, domTerminus, domOrigin, newInboundEtxs, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// AppendBlock appends a block whose body the caller already holds, the same way
+// as Append but without reading the body back from the db. The body is still
+// checked against the header roots, and is written along with the append.
+func (sl *Slice) AppendBlock(ctx context.Context, block *types.Block, domPendingHeader *types.Header, domTerminus common.Hash, domOrigin bool, newInboundEtxs types.Transactions) (types.Transactions, bool, bool, error) {
+	return sl.appendBlock(ctx, block.Header(), block, domPendingHeader, domTerminus, domOrigin, newInboundEtxs, nil)
+}
+
+// AppendOrGet appends the header the same way as Append, unless the block has
+// already been appended. A known block returns ErrKnownBlock along with the
+// pending etxs it produced, read back from storage, so that it can be told
+// apart from a new append which produced no pending etxs. The pending etxs of
+// a known block are nil if they are no longer stored.
+func (sl *Slice) AppendOrGet(ctx context.Context, header *types.Header, domPendingHeader *types.Header, domTerminus common.Hash, domOrigin bool, newInboundEtxs types.Transactions) (types.Transactions, bool, bool, error) {
+	if sl.hc.HasHeader(header.Hash(), header.NumberU64()) && sl.hc.GetTerminiByHash(header.Hash()) != nil {
+		appendDuplicateCounter.Inc(1)
+		return sl.appendedPendingEtxs(header.Hash()), false, false, ErrKnownBlock
+	}
+	return sl.Append(ctx, header, domPendingHeader, domTerminus, domOrigin, newInboundEtxs)
+}
+
+// appendedPendingEtxs returns the pending etxs the append of the block with
+// the given hash returned: its own etxs in a zone, the etxs rolled up from the
+// sub otherwise.
+func (sl *Slice) appendedPendingEtxs(hash common.Hash) types.Transactions {
+	if common.NodeLocation.Context() == common.ZONE_CTX {
+		etxs, err := sl.BlockEtxs(hash)
+		if err != nil {
+			return nil
+		}
+		return etxs
+	}
+	pEtxs, err := sl.hc.GetPendingEtxs(hash)
+	if err != nil {
+		return nil
+	}
+	return pEtxs.Etxs
+}
+
+// AppendContiguous appends a run of consecutive headers of this chain, as
+// received during sync. The run is checked to be contiguous before anything is
+// appended, then each header is appended in turn through Append, so every
+// append commits its own batch as configured for single appends. The pending
+// etxs of the appended headers are returned in order, and on the first failure
+// the appends stop, so the number of pending etxs returned is the index of the
+// header which failed.
+func (sl *Slice) AppendContiguous(ctx context.Context, headers []*types.Header) ([]types.Transactions, error) {
+	for i := 1; i < len(headers); i++ {
+		if headers[i].ParentHash() != headers[i-1].Hash() || headers[i].NumberU64() != headers[i-1].NumberU64()+1 {
+			return nil, fmt.Errorf("%w: header %d (%x) does not follow header %d (%x)", ErrNonContiguousHeaders, i, headers[i].Hash(), i-1, headers[i-1].Hash())
+		}
+	}
+	pendingEtxs := make([]types.Transactions, 0, len(headers))
+	for i, header := range headers {
+		newPendingEtxs, _, _, err := sl.Append(ctx, header, types.EmptyHeader(), common.Hash{}, false, nil)
+		if err != nil {
+			return pendingEtxs, fmt.Errorf("append of header %d (%x) failed: %w", i, header.Hash(), err)
+		}
+		pendingEtxs = append(pendingEtxs, newPendingEtxs)
+	}
+	return pendingEtxs, nil
+}
+
+// appendBlock implements Append, AppendBlock and AppendWithResult. The block is
+// constructed from the body in the db if localBlock is nil. If result is not
+// nil it is filled once the append has succeeded.
+func (sl *Slice) appendBlock(ctx context.Context, header *types.Header, localBlock *types.Block, domPendingHeader *types.Header, domTerminus common.Hash, domOrigin bool, newInboundEtxs types.Transactions, result *AppendResult) (types.Transactions, bool, bool, error) {
 	start := time.Now()
 
+	if sl.isClosed() {
+		return nil, false, false, ErrSliceClosed
+	}
+	ctx, endAppend, ok := sl.beginInFlightAppend(ctx)
+	if !ok {
+		return nil, false, false, ErrShuttingDown
+	}
+	defer endAppend()
+
+	// Appends are not accepted until the genesis knot has been fully set up
+	if atomic.LoadInt32(&sl.initializing) == 1 {
+		return nil, false, false, ErrInitializing
+	}
+
 	if header.Hash() == sl.config.GenesisHash {
 		return nil, false, false, nil
 	}
 
+	// Guard against the same block re-entering this slice through a misconfigured
+	// sub client, which would otherwise recurse forever. The block can only
+	// re-enter while it is being appended by the sub, other appends of the same
+	// block wait on the append lock instead.
+	if sl.isAppending(header.Hash()) {
+		log.Warn("Append re-entered for block already being appended", "hash", header.Hash())
+		return nil, false, false, ErrAppendReentry
+	}
+
+	// The parent may be committed by a concurrent append, give it a moment to
+	// land before the block is sent back to the append queue. This has to be
+	// done before taking the append lock, which the parent append needs.
+	if !sl.waitForParent(ctx, header) {
+		log.Debug("Parent not appended within the grace window", "hash", header.Hash(), "parent hash", header.ParentHash(), "window", sl.parentGraceWindow)
+	}
+
 	// Only print in Info level if block is c_startingPrintLimit behind or less
 	if sl.CurrentInfo(header) {
 		log.Info("Starting slice append", "hash", header.Hash(), "number", header.NumberArray(), "location", header.Location(), "parent hash", header.ParentHash())
@@ -167,19 +529,28 @@ func (sl *Slice) Append(header *types.Header, domPendingHeader *types.Header, do
 
 	nodeCtx := common.NodeLocation.Context()
 	location := header.Location()
+	if err := sl.applyPreverifiedSeal(header); err != nil {
+		return nil, false, false, err
+	}
 	_, order, err := sl.engine.CalcOrder(header)
 	if err != nil {
 		return nil, false, false, err
 	}
+	// In strict mode a coincident block is only appended if the pending ETXs it
+	// produces can be propagated to the dom
+	if sl.currentSettings().domRequiredForCoincident && nodeCtx != common.PRIME_CTX && (domOrigin || order < nodeCtx) && !sl.domReachable() {
+		return nil, false, false, ErrDomClientNotUp
+	}
 	// Don't append the block which already exists in the database.
 	if sl.hc.HasHeader(header.Hash(), header.NumberU64()) && (sl.hc.GetTerminiByHash(header.Hash()) != nil) {
 		log.Debug("Block has already been appended: ", "Hash: ", header.Hash())
+		appendDuplicateCounter.Inc(1)
 		return nil, false, false, nil
 	}
 	time1 := common.PrettyDuration(time.Since(start))
 	// This is to prevent a crash when we try to insert blocks before domClient is on.
 	// Ideally this check should not exist here and should be fixed before we start the slice.
-	if sl.domClient == nil && nodeCtx != common.PRIME_CTX {
+	if sl.getDomClient() == nil && nodeCtx != common.PRIME_CTX {
 		return nil, false, false, ErrDomClientNotUp
 	}
 
@@ -200,9 +571,31 @@ func (sl *Slice) Append(header *types.Header, domPendingHeader *types.Header, do
 	}
 
 	time3 := common.PrettyDuration(time.Since(start))
-	// Construct the block locally
-	block, err := sl.ConstructLocalBlock(header)
-	if err != nil {
+	// Construct the block locally, unless the caller gave it
+	var block *types.Block
+	if localBlock != nil {
+		if err := sl.validator.ValidateBody(localBlock); err != nil {
+			return nil, false, false, err
+		}
+		block = localBlock
+		rawdb.WriteBody(batch, block.Hash(), block.NumberU64(), block.Body())
+	} else {
+		block, err = sl.ConstructLocalBlock(header)
+		if err != nil {
+			return nil, false, false, err
+		}
+	}
+	// Bound the sub manifest before any work is done per manifest entry
+	if len(block.SubManifest()) > sl.maxManifestSize {
+		return nil, false, false, fmt.Errorf("%w: %d hashes, max %d", ErrManifestTooLarge, len(block.SubManifest()), sl.maxManifestSize)
+	}
+	// Cache the order so that the pending header generation reuses the same classification
+	block.SetOrder(order)
+	// Refuse a block forking off deeper than the configured maximum reorg depth.
+	// The head only moves forward, so such a block can never become the head.
+	// It is rejected before the sub appends it and before the phCache is
+	// updated, so that neither moves onto a block this slice refuses.
+	if err := sl.checkReorgDepth(block.Header()); err != nil {
 		return nil, false, false, err
 	}
 	time4 := common.PrettyDuration(time.Since(start))
@@ -210,7 +603,7 @@ func (sl *Slice) Append(header *types.Header, domPendingHeader *types.Header, do
 	var pendingHeaderWithTermini types.PendingHeader
 	if nodeCtx != common.ZONE_CTX {
 		// Upate the local pending header
-		pendingHeaderWithTermini, err = sl.generateSlicePendingHeader(block, newTermini, domPendingHeader, domOrigin, true, false)
+		pendingHeaderWithTermini, err = sl.generateSlicePendingHeader(ctx, block, newTermini, domPendingHeader, domOrigin, true, false)
 		if err != nil {
 			return nil, false, false, err
 		}
@@ -221,32 +614,51 @@ func (sl *Slice) Append(header *types.Header, domPendingHeader *types.Header, do
 	// list of confirmed ETXs using the subordinate manifest In either case, if
 	// we are a dominant node, we need to collect the ETX rollup from our sub.
 	if !domOrigin && nodeCtx != common.ZONE_CTX {
+		var subRollup types.Transactions
 		cachedInboundEtxs, exists := sl.inboundEtxsCache.Get(block.Hash())
 		if exists && cachedInboundEtxs != nil {
 			newInboundEtxs = cachedInboundEtxs.(types.Transactions)
+			subRollup, err = sl.hc.CollectSubRollup(block)
 		} else {
-			newInboundEtxs, _, err = sl.CollectNewlyConfirmedEtxs(block, block.Location())
-			if err != nil {
-				log.Trace("Error collecting newly confirmed etxs: ", "err", err)
-				// Keeping track of the number of times pending etx fails and if it crossed the retry threshold
-				// ask the sub for the pending etx/rollup data
-				val, exist := sl.pEtxRetryCache.Get(block.Hash())
-				var retry uint64
-				if exist {
-					pEtxCurrent, ok := val.(pEtxRetry)
-					if ok {
-						retry = pEtxCurrent.retries + 1
-					}
+			newInboundEtxs, subRollup, err = sl.CollectNewlyConfirmedEtxs(block, block.Location())
+		}
+		if err != nil {
+			// A rollup which does not match the block is not going to be
+			// fixed by retrying, reject the block
+			if errors.Is(err, ErrRollupHashMismatch) {
+				return nil, false, false, err
+			}
+			log.Trace("Error collecting newly confirmed etxs: ", "err", err)
+			// Keeping track of the number of times pending etx fails and if it crossed the retry threshold
+			// ask the sub for the pending etx/rollup data
+			val, exist := sl.pEtxRetryCache.Get(block.Hash())
+			var retry uint64
+			if exist {
+				pEtxCurrent, ok := val.(pEtxRetry)
+				if ok {
+					retry = pEtxCurrent.retries + 1
 				}
-				pEtxNew := pEtxRetry{hash: block.Hash(), retries: retry}
-				sl.pEtxRetryCache.Add(block.Hash(), pEtxNew)
-				return nil, false, false, ErrSubNotSyncedToDom
 			}
-			sl.inboundEtxsCache.Add(block.Hash(), newInboundEtxs)
+			pEtxNew := pEtxRetry{hash: block.Hash(), retries: retry}
+			sl.pEtxRetryCache.Add(block.Hash(), pEtxNew)
+			return nil, false, false, ErrSubNotSyncedToDom
 		}
+		sl.inboundEtxsCache.Add(block.Hash(), newInboundEtxs)
+		// Store the rollup this block committed to, so it can be audited later.
+		// It is written as collected, the rollup cache may have evicted it since.
+		rawdb.WriteEtxRollup(batch, block.Hash(), subRollup)
 	}
 	time5 := common.PrettyDuration(time.Since(start))
 
+	// This is the last point at which the append of a dom block stops if the
+	// caller gave up. Once the sub has the block the local writes are completed
+	// regardless of ctx, so that the sub is never left with a block its dom does
+	// not have. A zone has no sub, its pending header generation below still
+	// stops on ctx before anything is written.
+	if err := ctx.Err(); err != nil {
+		return nil, false, false, err
+	}
+
 	time6 := common.PrettyDuration(time.Since(start))
 	var subPendingEtxs types.Transactions
 	var subReorg bool
@@ -257,9 +669,18 @@ func (sl *Slice) Append(header *types.Header, domPendingHeader *types.Header, do
 	// Call my sub to append the block, and collect the rolled up ETXs from that sub
 	if nodeCtx != common.ZONE_CTX {
 		// How to get the sub pending etxs if not running the full node?.
-		if sl.subClients[location.SubIndex()] != nil {
-			subPendingEtxs, subReorg, setHead, err = sl.subClients[location.SubIndex()].Append(context.Background(), header, block.SubManifest(), pendingHeaderWithTermini.Header(), domTerminus, true, newInboundEtxs)
+		if sl.getSubClient(location.SubIndex()) != nil {
+			subCtx, cancel := sl.hierarchyRequestContext(ctx)
+			sl.beginAppend(header.Hash())
+			subPendingEtxs, subReorg, setHead, err = sl.getSubClient(location.SubIndex()).Append(subCtx, header, block.SubManifest(), pendingHeaderWithTermini.Header(), domTerminus, true, newInboundEtxs)
+			sl.endAppend(header.Hash())
+			cancel()
+			sl.recordSubResult(location.SubIndex(), err)
 			if err != nil {
+				if ctx.Err() == nil && subCtx.Err() == context.DeadlineExceeded {
+					log.Warn("Sub append timed out", "hash", block.Hash(), "location", location, "timeout", sl.currentSettings().appendTimeout)
+					return nil, false, false, ErrAppendTimeout
+				}
 				return nil, false, false, err
 			}
 			time6_1 = common.PrettyDuration(time.Since(start))
@@ -280,24 +701,25 @@ func (sl *Slice) Append(header *types.Header, domPendingHeader *types.Header, do
 
 	time7 := common.PrettyDuration(time.Since(start))
 
+	// The local state changes of the appends are applied one at a time, so that
+	// concurrent appends of sibling blocks cannot interleave their db writes,
+	// head updates and phCache updates. The lock is only taken after the sub
+	// append, so that the levels of the hierarchy do not wait on each other's
+	// network round trips.
+	sl.appendMu.Lock()
+	defer sl.appendMu.Unlock()
+
 	sl.phCacheMu.Lock()
 	defer sl.phCacheMu.Unlock()
 
 	var time8, time9 common.PrettyDuration
 	var bestPh types.PendingHeader
-	var exist bool
 	if nodeCtx == common.ZONE_CTX {
-		bestPh, exist = sl.readPhCache(sl.bestPhKey)
-		if !exist {
-			sl.WriteBestPhKey(sl.config.GenesisHash)
-			sl.writePhCache(block.Hash(), pendingHeaderWithTermini)
-			bestPh = types.EmptyPendingHeader()
-			log.Error("BestPh Key does not exist for", "key", sl.bestPhKey)
-		}
+		bestPh = sl.readZoneBestPh(block, pendingHeaderWithTermini)
 
 		time8 = common.PrettyDuration(time.Since(start))
 
-		tempPendingHeader, err := sl.generateSlicePendingHeader(block, newTermini, domPendingHeader, domOrigin, false, false)
+		tempPendingHeader, err := sl.generateSlicePendingHeader(ctx, block, newTermini, domPendingHeader, domOrigin, false, false)
 		if err != nil {
 			return nil, false, false, err
 		}
@@ -307,10 +729,14 @@ func (sl *Slice) Append(header *types.Header, domPendingHeader *types.Header, do
 		if order < nodeCtx {
 			// Store the inbound etxs for dom blocks that did not get picked and use
 			// it in the future if dom switch happens
-			rawdb.WriteInboundEtxs(sl.sliceDb, block.Hash(), newInboundEtxs)
+			rawdb.WriteInboundEtxs(batch, block.Hash(), newInboundEtxs)
 		}
 
-		setHead = sl.poem(sl.engine.TotalLogS(block.Header()), sl.engine.TotalLogS(sl.hc.CurrentHeader()))
+		currentHeader := sl.hc.CurrentHeader()
+		setHead, err = sl.currentForkChoice().ShouldReorg(currentHeader, block.Header(), sl.engine.TotalLogS(currentHeader), sl.engine.TotalLogS(block.Header()))
+		if err != nil {
+			return nil, false, false, err
+		}
 
 		if subReorg || (sl.hc.CurrentHeader().NumberU64() < block.NumberU64()+c_currentStateComputeWindow) {
 			err := sl.hc.SetCurrentState(block.Header())
@@ -320,13 +746,33 @@ func (sl *Slice) Append(header *types.Header, domPendingHeader *types.Header, do
 			}
 		}
 		// Upate the local pending header
-		pendingHeaderWithTermini, err = sl.generateSlicePendingHeader(block, newTermini, domPendingHeader, domOrigin, subReorg, false)
+		pendingHeaderWithTermini, err = sl.generateSlicePendingHeader(ctx, block, newTermini, domPendingHeader, domOrigin, subReorg, false)
 		if err != nil {
 			return nil, false, false, err
 		}
 
 	}
 	time9 = common.PrettyDuration(time.Since(start))
+
+	// Append has succeeded write the batch. If the block becomes the head, the
+	// head is moved in the same batch, so that a failure to set it drops the
+	// whole append instead of leaving part of it behind.
+	if setHead {
+		reorg := block.ParentHash() != sl.hc.CurrentHeader().Hash()
+		err := sl.hc.setCurrentHeader(batch, block.Header(), block, func() error {
+			return sl.commitAppendBatch(batch, block.Hash(), newTermini, true)
+		})
+		if err != nil {
+			log.Error("Failed to set the current header", "hash", block.Hash(), "err", err)
+			return nil, false, false, err
+		}
+		if reorg {
+			reorgCounter.Inc(1)
+		}
+	} else if err := sl.commitAppendBatch(batch, block.Hash(), newTermini, false); err != nil {
+		return nil, false, false, err
+	}
+
 	sl.updatePhCache(pendingHeaderWithTermini, true, nil, subReorg, common.NodeLocation)
 
 	var updateDom bool
@@ -339,23 +785,19 @@ func (sl *Slice) Append(header *types.Header, domPendingHeader *types.Header, do
 		block.SetAppendTime(time.Duration(time9))
 	}
 
-	// Append has succeeded write the batch
-	if err := batch.Write(); err != nil {
-		return nil, false, false, err
-	}
-
-	if setHead {
-		sl.hc.SetCurrentHeader(block.Header())
-	}
-
 	if subReorg {
 		sl.hc.chainHeadFeed.Send(ChainHeadEvent{Block: block})
 	}
+	if !setHead {
+		sl.hc.chainSideFeed.Send(ChainSideEvent{Block: block})
+	}
 
 	// Relay the new pendingHeader
 	sl.relayPh(block, pendingHeaderWithTermini, domOrigin, block.Location(), subReorg)
 
 	time10 := common.PrettyDuration(time.Since(start))
+	appendTimer.UpdateSince(start)
+	appendSuccessCounter.Inc(1)
 	log.Info("Times during append:", "t0_1", time0_1, "t0_2", time0_2, "t1:", time1, "t2:", time2, "t3:", time3, "t4:", time4, "t5:", time5, "t6:", time6, "t7:", time7, "t8:", time8, "t9:", time9, "t10:", time10)
 	log.Debug("Times during sub append:", "t6_1:", time6_1, "t6_2:", time6_2, "t6_3:", time6_3)
 	log.Info("Appended new block", "number", block.Header().NumberArray(), "hash", block.Hash(),
@@ -369,21 +811,251 @@ func (sl *Slice) Append(header *types.Header, domPendingHeader *types.Header, do
 	if nodeCtx == common.ZONE_CTX {
 		if updateDom {
 			log.Info("Append updateDom", "oldTermini():", bestPh.Termini().DomTerminus(), "newTermini():", pendingHeaderWithTermini.Termini().DomTerminus(), "location:", common.NodeLocation)
-			if sl.domClient != nil {
-				go sl.domClient.UpdateDom(context.Background(), bestPh.Termini().DomTerminus(), pendingHeaderWithTermini, common.NodeLocation)
-			}
+			// The update outlives the append, so it is not bound to ctx
+			sl.updateDomInBackground(bestPh.Termini().DomTerminus(), pendingHeaderWithTermini, common.NodeLocation)
 		}
+		sl.fillAppendResult(result, block, block.ExtTransactions(), subReorg, setHead)
 		return block.ExtTransactions(), subReorg, setHead, nil
 	} else {
+		sl.fillAppendResult(result, block, subPendingEtxs, subReorg, setHead)
 		return subPendingEtxs, subReorg, setHead, nil
 	}
 }
 
+// fillAppendResult sets the result of an append, if one was asked for. The
+// receipts are read while the append lock is still held, before the block can
+// be pruned.
+func (sl *Slice) fillAppendResult(result *AppendResult, block *types.Block, pendingEtxs types.Transactions, subReorg bool, setHead bool) {
+	if result == nil {
+		return
+	}
+	result.Block = block
+	result.PendingEtxs = pendingEtxs
+	result.Entropy = sl.engine.TotalLogS(block.Header())
+	result.SubReorg = subReorg
+	result.SetHead = setHead
+	if common.NodeLocation.Context() == common.ZONE_CTX && sl.ProcessingState() {
+		result.Receipts = sl.hc.bc.processor.GetReceiptsByHash(block.Hash())
+	}
+}
+
+// checkReorgDepth returns ErrReorgTooDeep if setting the header as the head
+// would reorganize more canonical blocks than the configured maximum. The depth
+// is measured from the current head to its common ancestor with the header.
+func (sl *Slice) checkReorgDepth(header *types.Header) error {
+	maxReorgDepth := sl.currentSettings().maxReorgDepth
+	if maxReorgDepth == 0 {
+		return nil
+	}
+	currentHeader := sl.hc.CurrentHeader()
+	if currentHeader.Hash() == header.ParentHash() {
+		return nil
+	}
+	commonHeader := sl.hc.findCommonAncestor(header)
+	if commonHeader == nil {
+		return nil
+	}
+	if depth := currentHeader.NumberU64() - commonHeader.NumberU64(); depth > maxReorgDepth {
+		log.Warn("Rejecting reorg deeper than the maximum reorg depth", "hash", header.Hash(), "current", currentHeader.Hash(), "common", commonHeader.Hash(), "depth", depth, "max", maxReorgDepth)
+		return ErrReorgTooDeep
+	}
+	return nil
+}
+
+// commitAppendBatch commits the batch of an append. If append batching is
+// configured, the batch is only accumulated and the accumulated writes are
+// committed once AppendBatchBlocks blocks or AppendBatchBytes bytes have been
+// appended, when the slice is stopped, or along with a batch which moves the
+// head. The head marker and the canonical hashes are therefore never persisted
+// ahead of the blocks they point to. The termini of the accumulated blocks
+// stay visible through the headerchain in the meantime. On a crash, the
+// accumulated writes are lost and the blocks are appended again after restart.
+func (sl *Slice) commitAppendBatch(batch ethdb.Batch, hash common.Hash, termini types.Termini, movesHead bool) error {
+	if sl.appendBatchBlocks <= 1 && sl.appendBatchBytes <= 0 {
+		return batch.Write()
+	}
+	sl.appendBatchMu.Lock()
+	defer sl.appendBatchMu.Unlock()
+
+	if sl.appendBatch == nil {
+		sl.appendBatch = sl.sliceDb.NewBatch()
+	}
+	if err := batch.Replay(sl.appendBatch); err != nil {
+		return err
+	}
+	sl.hc.stageTermini(hash, termini)
+	sl.appendBatchCount++
+	if movesHead || (sl.appendBatchBlocks > 0 && sl.appendBatchCount >= sl.appendBatchBlocks) || (sl.appendBatchBytes > 0 && sl.appendBatch.ValueSize() >= sl.appendBatchBytes) {
+		return sl.flushAppendBatchLocked()
+	}
+	return nil
+}
+
+// flushAppendBatch commits the writes accumulated by commitAppendBatch
+func (sl *Slice) flushAppendBatch() error {
+	sl.appendBatchMu.Lock()
+	defer sl.appendBatchMu.Unlock()
+	return sl.flushAppendBatchLocked()
+}
+
+func (sl *Slice) flushAppendBatchLocked() error {
+	if sl.appendBatch == nil || sl.appendBatchCount == 0 {
+		return nil
+	}
+	if err := sl.appendBatch.Write(); err != nil {
+		return err
+	}
+	log.Debug("Committed append batch", "blocks", sl.appendBatchCount, "size", sl.appendBatch.ValueSize())
+	sl.appendBatch.Reset()
+	sl.appendBatchCount = 0
+	sl.hc.clearStagedTermini()
+	return nil
+}
+
+// waitForParent waits up to the parent grace window for the termini of the
+// parent of the header to be written, and returns whether they are known. The
+// chain head events wake it up as soon as a new head is appended, the polling
+// covers parents which are appended without becoming the head.
+func (sl *Slice) waitForParent(ctx context.Context, header *types.Header) bool {
+	if sl.hc.GetTerminiByHash(header.ParentHash()) != nil {
+		return true
+	}
+	if sl.parentGraceWindow <= 0 {
+		return false
+	}
+	heads := make(chan ChainHeadEvent, 1)
+	sub := sl.hc.SubscribeChainHeadEvent(heads)
+	defer sub.Unsubscribe()
+
+	timeout := time.NewTimer(sl.parentGraceWindow)
+	defer timeout.Stop()
+	poll := time.NewTicker(c_parentGracePollPeriod)
+	defer poll.Stop()
+	for {
+		select {
+		case <-heads:
+		case <-poll.C:
+		case <-timeout.C:
+			return sl.hc.GetTerminiByHash(header.ParentHash()) != nil
+		case <-ctx.Done():
+			return false
+		case <-sl.quit:
+			return false
+		}
+		if sl.hc.GetTerminiByHash(header.ParentHash()) != nil {
+			return true
+		}
+	}
+}
+
+// sealResult is the outcome of a seal verification done ahead of the append.
+// The hash of a header does not cover its mix hash, so the result only applies
+// to a header carrying the same mix hash.
+type sealResult struct {
+	mixHash   common.Hash
+	powDigest interface{} // Computed pow digest, nil if the engine did not record it
+	powHash   interface{} // Computed pow hash, nil if the engine did not record it
+	err       error
+}
+
+// PreverifySeals verifies the seals of the given headers concurrently, using
+// up to sealWorkers goroutines, and memoizes the results by block hash. It
+// returns once all the headers are verified. A later Append of one of the
+// headers reuses the result instead of recomputing the proof of work.
+func (sl *Slice) PreverifySeals(headers []*types.Header) {
+	var (
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, sl.sealWorkers)
+	)
+	for _, header := range headers {
+		if header == nil || sl.sealCache.Contains(header.Hash()) {
+			continue
+		}
+		select {
+		case sem <- struct{}{}:
+		case <-sl.quit:
+			wg.Wait()
+			return
+		}
+		wg.Add(1)
+		go func(header *types.Header) {
+			defer func() { <-sem; wg.Done() }()
+			_, err := sl.engine.VerifySeal(header)
+			sl.sealCache.Add(header.Hash(), sealResult{
+				mixHash:   header.MixHash(),
+				powDigest: header.PowDigest.Load(),
+				powHash:   header.PowHash.Load(),
+				err:       err,
+			})
+		}(header)
+	}
+	wg.Wait()
+}
+
+// applyPreverifiedSeal looks up the seal result of the header recorded by
+// PreverifySeals. A failed verification is returned as is, a successful one
+// loads the computed pow values into the header, so that the engine checks
+// them against the header without recomputing the proof of work.
+func (sl *Slice) applyPreverifiedSeal(header *types.Header) error {
+	cached, ok := sl.sealCache.Get(header.Hash())
+	if !ok {
+		return nil
+	}
+	result := cached.(sealResult)
+	if result.mixHash != header.MixHash() {
+		return nil
+	}
+	if result.err != nil {
+		return result.err
+	}
+	if result.powDigest != nil && result.powHash != nil {
+		header.PowDigest.Store(result.powDigest)
+		header.PowHash.Store(result.powHash)
+	}
+	return nil
+}
+
+// beginAppend marks the given hash as being appended by the sub
+func (sl *Slice) beginAppend(hash common.Hash) {
+	sl.appendingMu.Lock()
+	defer sl.appendingMu.Unlock()
+	sl.appending[hash]++
+}
+
+// endAppend clears one in progress marker for the given hash
+func (sl *Slice) endAppend(hash common.Hash) {
+	sl.appendingMu.Lock()
+	defer sl.appendingMu.Unlock()
+	if sl.appending[hash] <= 1 {
+		delete(sl.appending, hash)
+	} else {
+		sl.appending[hash]--
+	}
+}
+
+// isAppending reports whether the given hash is being appended by the sub
+func (sl *Slice) isAppending(hash common.Hash) bool {
+	sl.appendingMu.Lock()
+	defer sl.appendingMu.Unlock()
+	return sl.appending[hash] > 0
+}
+
 func (sl *Slice) miningStrategy(bestPh types.PendingHeader, pendingHeader types.PendingHeader) bool {
 	if bestPh.Header() == nil { // This is the case where we try to append the block before we have not initialized the bestPh
 		return true
 	}
-	subReorg := sl.poem(sl.engine.TotalLogPhS(pendingHeader.Header()), sl.engine.TotalLogPhS(bestPh.Header()))
+	externS, currentS := sl.engine.TotalLogPhS(pendingHeader.Header()), sl.engine.TotalLogPhS(bestPh.Header())
+	// Break ties on the parents with the equal entropy policy of the fork
+	// choice, so that the miner builds on the head hlcr would pick
+	nodeCtx := common.NodeLocation.Context()
+	externParent, currentParent := pendingHeader.Header().ParentHash(nodeCtx), bestPh.Header().ParentHash(nodeCtx)
+	if externS != nil && currentS != nil && externS.Cmp(currentS) == 0 && externParent != currentParent {
+		externParentHeader, currentParentHeader := sl.hc.GetHeaderByHash(externParent), sl.hc.GetHeaderByHash(currentParent)
+		if externParentHeader != nil && currentParentHeader != nil {
+			return sl.equalTdTieBreak(sl.currentSettings().equalTdPolicy, externParentHeader, currentParentHeader)
+		}
+	}
+	subReorg := sl.poem(externS, currentS)
 	return subReorg
 }
 
@@ -399,24 +1071,29 @@ func (sl *Slice) relayPh(block *types.Block, pendingHeaderWithTermini types.Pend
 		// Send an empty header to miner
 		bestPh, exists := sl.readPhCache(sl.bestPhKey)
 		if exists {
-			bestPh.Header().SetLocation(common.NodeLocation)
-			sl.miner.worker.pendingHeaderFeed.Send(bestPh.Header())
+			if !sl.hasMinerWorker() {
+				log.Debug("No miner worker running, skipping pending header relay to the miner", "best ph key", sl.bestPhKey)
+				return
+			}
+			sl.sendPendingHeaderToMiner(bestPh)
 			return
 		} else {
 			log.Warn("Pending Header for Best ph key does not exist", "best ph key", sl.bestPhKey)
 		}
 	} else if !domOrigin && subReorg {
-		for _, i := range sl.randomRelayArray() {
-			if sl.subClients[i] != nil {
-				sl.subClients[i].SubRelayPendingHeader(context.Background(), pendingHeaderWithTermini, pendingHeaderWithTermini.Header().ParentEntropy(), location, subReorg, nodeCtx)
-			}
-		}
+		newEntropy := pendingHeaderWithTermini.Header().ParentEntropy()
+		sl.relayToSubs(func(ctx context.Context, i int) {
+			sl.relayPendingHeaderToSub(ctx, i, pendingHeaderWithTermini, newEntropy, location, subReorg, nodeCtx)
+		})
 	}
 }
 
 // If a zone changes its best ph key on a dom block, it sends a signal to the
 // dom and we can relay that information to the coords, to build on the right dom header
 func (sl *Slice) UpdateDom(oldTerminus common.Hash, pendingHeader types.PendingHeader, location common.Location) {
+	if sl.isClosed() {
+		return
+	}
 	nodeCtx := common.NodeLocation.Context()
 	sl.phCacheMu.Lock()
 	defer sl.phCacheMu.Unlock()
@@ -444,12 +1121,10 @@ func (sl *Slice) UpdateDom(oldTerminus common.Hash, pendingHeader types.PendingH
 		sl.WriteBestPhKey(newDomTerminus)
 		newPh, exists := sl.readPhCache(newDomTerminus)
 		if exists {
-			for _, i := range sl.randomRelayArray() {
-				if sl.subClients[i] != nil {
-					log.Info("SubRelay in UpdateDom", "parent Hash:", newPh.Header().ParentHash(), "Number", newPh.Header().NumberArray(), "newTermini:", newPh.Termini().SubTerminiAtIndex(i))
-					sl.subClients[i].SubRelayPendingHeader(context.Background(), newPh, pendingHeader.Header().ParentEntropy(common.ZONE_CTX), common.Location{}, true, nodeCtx)
-				}
-			}
+			sl.relayToSubs(func(ctx context.Context, i int) {
+				log.Info("SubRelay in UpdateDom", "parent Hash:", newPh.Header().ParentHash(), "Number", newPh.Header().NumberArray(), "newTermini:", newPh.Termini().SubTerminiAtIndex(i))
+				sl.getSubClient(i).SubRelayPendingHeader(ctx, newPh, pendingHeader.Header().ParentEntropy(common.ZONE_CTX), common.Location{}, true, nodeCtx)
+			})
 		} else {
 			log.Warn("Update Dom:", "phCache at newTerminus does not exist:", newDomTerminus)
 		}
@@ -457,19 +1132,17 @@ func (sl *Slice) UpdateDom(oldTerminus common.Hash, pendingHeader types.PendingH
 	} else {
 		// need to update dom
 		log.Info("UpdateDom needs to updateDom", "oldDomTermini:", oldDomTerminus, "newDomTermini:", newPh.Termini(), "location:", location)
-		if sl.domClient != nil {
-			go sl.domClient.UpdateDom(context.Background(), oldDomTerminus, types.NewPendingHeader(pendingHeader.Header(), newPh.Termini()), location)
+		if sl.getDomClient() != nil {
+			sl.updateDomInBackground(oldDomTerminus, types.NewPendingHeader(pendingHeader.Header(), newPh.Termini()), location)
 		} else {
 			// Can update
 			sl.WriteBestPhKey(newDomTerminus)
 			newPh, exists := sl.readPhCache(newDomTerminus)
 			if exists {
-				for _, i := range sl.randomRelayArray() {
-					if sl.subClients[i] != nil {
-						log.Info("SubRelay in UpdateDom:", "Parent Hash:", newPh.Header().ParentHash(), "Number", newPh.Header().NumberArray(), "NewTermini:", newPh.Termini().SubTerminiAtIndex(i))
-						sl.subClients[i].SubRelayPendingHeader(context.Background(), newPh, pendingHeader.Header().ParentEntropy(common.ZONE_CTX), common.Location{}, true, nodeCtx)
-					}
-				}
+				sl.relayToSubs(func(ctx context.Context, i int) {
+					log.Info("SubRelay in UpdateDom:", "Parent Hash:", newPh.Header().ParentHash(), "Number", newPh.Header().NumberArray(), "NewTermini:", newPh.Termini().SubTerminiAtIndex(i))
+					sl.getSubClient(i).SubRelayPendingHeader(ctx, newPh, pendingHeader.Header().ParentEntropy(common.ZONE_CTX), common.Location{}, true, nodeCtx)
+				})
 			} else {
 				log.Warn("Update Dom:", "phCache at newTerminus does not exist:", newDomTerminus)
 			}
@@ -478,6 +1151,159 @@ func (sl *Slice) UpdateDom(oldTerminus common.Hash, pendingHeader types.PendingH
 	}
 }
 
+// updateDomInBackground sends the pending header update to the dom, if it is
+// connected, without waiting for it. The update is bounded by the hierarchy
+// request timeout and cancelled when the slice is stopped, which waits for it
+// along with the relays to the subs.
+func (sl *Slice) updateDomInBackground(oldTerminus common.Hash, pendingHeader types.PendingHeader, location common.Location) {
+	domClient := sl.getDomClient()
+	if domClient == nil || sl.backgroundCtx.Err() != nil {
+		return
+	}
+	ctx, cancel := sl.hierarchyRequestContext(sl.backgroundCtx)
+	sl.relayWg.Add(1)
+	go func() {
+		defer sl.relayWg.Done()
+		defer cancel()
+		domClient.UpdateDom(ctx, oldTerminus, pendingHeader, location)
+	}()
+}
+
+// sendPendingHeaderToMiner delivers the pending header to the miners and
+// records it as the active work of the miners.
+func (sl *Slice) sendPendingHeaderToMiner(pendingHeader types.PendingHeader) {
+	pendingHeader.Header().SetLocation(common.NodeLocation)
+	sl.minerPh.Store(pendingHeader)
+	sl.miner.worker.pendingHeaderFeed.Send(pendingHeader.Header())
+	sl.sendPendingHeaderToLocation(common.NodeLocation, pendingHeader.Header())
+}
+
+// sendPendingHeaderToLocation delivers the pending header to the subscribers of
+// the given location. A subscriber whose buffer is full misses the pending
+// header instead of blocking the relay.
+func (sl *Slice) sendPendingHeaderToLocation(location common.Location, header *types.Header) {
+	sl.locationPhSubsMu.RLock()
+	defer sl.locationPhSubsMu.RUnlock()
+	for sub := range sl.locationPhSubs {
+		if !sub.location.Equal(location) {
+			continue
+		}
+		select {
+		case sub.ch <- types.CopyHeader(header):
+		default:
+			log.Debug("Location pending header subscriber is full, dropping pending header", "location", location.Name(), "number", header.NumberArray())
+		}
+	}
+}
+
+// SubscribePendingHeaderForLocation registers a subscription to the pending
+// headers relayed to the given location. In a zone only the zone itself can be
+// subscribed to, in prime and regions the locations of the subordinates.
+func (sl *Slice) SubscribePendingHeaderForLocation(location common.Location, ch chan<- *types.Header) (event.Subscription, error) {
+	if sl.isClosed() {
+		return nil, ErrSliceClosed
+	}
+	nodeCtx := common.NodeLocation.Context()
+	if nodeCtx == common.ZONE_CTX {
+		if !location.Equal(common.NodeLocation) {
+			return nil, ErrLocationNotRelayed
+		}
+	} else if len(location) != len(common.NodeLocation)+1 || !location.InSameSliceAs(common.NodeLocation) {
+		return nil, ErrLocationNotRelayed
+	}
+
+	sub := &locationPhSub{
+		location: common.Location(common.CopyBytes(location)),
+		ch:       make(chan *types.Header, c_locationPhChanSize),
+	}
+	sl.locationPhSubsMu.Lock()
+	sl.locationPhSubs[sub] = struct{}{}
+	sl.locationPhSubsMu.Unlock()
+
+	return sl.scope.Track(event.NewSubscription(func(quit <-chan struct{}) error {
+		defer func() {
+			sl.locationPhSubsMu.Lock()
+			delete(sl.locationPhSubs, sub)
+			sl.locationPhSubsMu.Unlock()
+		}()
+		for {
+			select {
+			case header := <-sub.ch:
+				select {
+				case ch <- header:
+				case <-quit:
+					return nil
+				}
+			case <-quit:
+				return nil
+			}
+		}
+	})), nil
+}
+
+// WorkDiff describes how the pending header selected by the slice differs
+// from the pending header the miners are working on.
+type WorkDiff struct {
+	Selected  *types.Header // Best pending header in the phCache
+	Delivered *types.Header // Last pending header delivered to the miners
+	Number    bool          // True if the numbers differ
+	Parent    bool          // True if the parent hashes differ
+	Terminus  bool          // True if the dom termini differ
+}
+
+// Diverged returns true if the miners are not working on the selected pending header
+func (d WorkDiff) Diverged() bool {
+	return d.Number || d.Parent || d.Terminus
+}
+
+// MinerWorkDiff compares the best pending header in the phCache with the last
+// pending header delivered to the miners. A divergence means the miners are not
+// working on the selected pending header, i.e. the delivery failed, whereas a
+// stale header without divergence points at the selection.
+func (sl *Slice) MinerWorkDiff() (WorkDiff, error) {
+	bestPh, exists := sl.readBestPh()
+	if !exists {
+		return WorkDiff{}, ErrPendingHeaderNotInCache
+	}
+	minerPh, ok := sl.minerPh.Load().(types.PendingHeader)
+	if !ok {
+		return WorkDiff{}, ErrNoMinerWork
+	}
+	nodeCtx := common.NodeLocation.Context()
+	return WorkDiff{
+		Selected:  bestPh.Header(),
+		Delivered: minerPh.Header(),
+		Number:    bestPh.Header().NumberU64(nodeCtx) != minerPh.Header().NumberU64(nodeCtx),
+		Parent:    bestPh.Header().ParentHash(nodeCtx) != minerPh.Header().ParentHash(nodeCtx),
+		Terminus:  bestPh.Termini().DomTerminus() != minerPh.Termini().DomTerminus(),
+	}, nil
+}
+
+// relayToSubs starts relay in the background for every connected sub, in a
+// random order, and returns without waiting for them. A slow sub holds up
+// neither the caller nor the relays to the other subs, and each relay is
+// bounded by the hierarchy request timeout through its context, which is also
+// cancelled when the slice is stopped. The arguments of the relay must be
+// derived before calling relayToSubs, the caller may have released the locks
+// they were read under by the time the relay runs.
+func (sl *Slice) relayToSubs(relay func(ctx context.Context, index int)) {
+	if sl.backgroundCtx.Err() != nil {
+		return
+	}
+	for _, i := range sl.randomRelayArray() {
+		if sl.getSubClient(i) == nil {
+			continue
+		}
+		sl.relayWg.Add(1)
+		go func(index int) {
+			defer sl.relayWg.Done()
+			ctx, cancel := sl.hierarchyRequestContext(sl.backgroundCtx)
+			defer cancel()
+			relay(ctx, index)
+		}(i)
+	}
+}
+
 func (sl *Slice) randomRelayArray() [3]int {
 	rand.Seed(time.Now().UnixNano())
 	nums := [3]int{0, 1, 2}
@@ -488,8 +1314,33 @@ func (sl *Slice) randomRelayArray() [3]int {
 	return nums
 }
 
+// readZoneBestPh reads the best pending header during the append of the block
+// in a zone. If it does not exist the best ph key is reset to the genesis and
+// an empty pending header is returned, so that the block is mined on. It must
+// be called with phCacheMu held.
+func (sl *Slice) readZoneBestPh(block *types.Block, pendingHeaderWithTermini types.PendingHeader) types.PendingHeader {
+	bestPh, exist := sl.readPhCache(sl.bestPhKey)
+	if exist {
+		return bestPh
+	}
+	sl.WriteBestPhKey(sl.config.GenesisHash)
+	sl.writePhCache(block.Hash(), pendingHeaderWithTermini)
+	log.Error("BestPh Key does not exist for", "key", sl.bestPhKey)
+	return types.EmptyPendingHeader()
+}
+
+// hasMinerWorker reports whether a miner worker is running to generate the
+// pending headers.
+func (sl *Slice) hasMinerWorker() bool {
+	return sl.miner != nil && sl.miner.worker != nil
+}
+
 // asyncPendingHeaderLoop waits for the pendingheader updates from the worker and updates the phCache
 func (sl *Slice) asyncPendingHeaderLoop() {
+	if !sl.hasMinerWorker() {
+		log.Warn("No miner worker running, not subscribing to the async pending header updates")
+		return
+	}
 
 	// Subscribe to the AsyncPh updates from the worker
 	sl.asyncPhCh = make(chan *types.Header, c_asyncPhUpdateChanSize)
@@ -501,10 +1352,9 @@ func (sl *Slice) asyncPendingHeaderLoop() {
 			sl.phCacheMu.Lock()
 			sl.updatePhCache(types.PendingHeader{}, true, asyncPh, true, common.NodeLocation)
 			sl.phCacheMu.Unlock()
-			bestPh, exists := sl.readPhCache(sl.bestPhKey)
+			bestPh, exists := sl.readBestPh()
 			if exists {
-				bestPh.Header().SetLocation(common.NodeLocation)
-				sl.miner.worker.pendingHeaderFeed.Send(bestPh.Header())
+				sl.sendPendingHeaderToMiner(bestPh)
 			}
 		case <-sl.asyncPhSub.Err():
 			return
@@ -515,6 +1365,58 @@ func (sl *Slice) asyncPendingHeaderLoop() {
 	}
 }
 
+// gcPendingHeadersLoop periodically collects the phCache entries which are
+// too far behind the head to be mined on. The ticker is reset whenever the
+// collection interval is changed through UpdateConfig.
+func (sl *Slice) gcPendingHeadersLoop() {
+	defer sl.wg.Done()
+	gcTimer := time.NewTicker(sl.currentSettings().phGCInterval)
+	defer gcTimer.Stop()
+	for {
+		select {
+		case <-gcTimer.C:
+			sl.gcPendingHeaders()
+		case <-sl.phGCIntervalCh:
+			gcTimer.Reset(sl.currentSettings().phGCInterval)
+		case <-sl.quit:
+			return
+		}
+	}
+}
+
+// gcPendingHeaders removes from the phCache the pending headers more than the
+// gc window behind the current head and returns the number of entries removed.
+// The entry at the best ph key is never removed, so that the head entry stays
+// in the cache on a chain whose head is not advancing. The pending headers are
+// kept on disk, where they are pruned by PrunePendingData.
+func (sl *Slice) gcPendingHeaders() int {
+	sl.phCacheMu.Lock()
+	defer sl.phCacheMu.Unlock()
+
+	headNumber := sl.hc.CurrentHeader().NumberU64()
+	removed := 0
+	for _, key := range sl.phCache.Keys() {
+		if key == sl.bestPhKey {
+			continue
+		}
+		value, exists := sl.phCache.Peek(key)
+		if !exists {
+			continue
+		}
+		ph, ok := value.(types.PendingHeader)
+		if !ok || ph.Header() == nil || ph.Header().NumberU64()+sl.phGCWindow >= headNumber {
+			continue
+		}
+		sl.phCache.Remove(key)
+		removed++
+	}
+	if removed > 0 {
+		phCacheSizeGauge.Update(int64(sl.phCache.Len()))
+		log.Debug("Collected stale pending headers", "removed", removed, "window", sl.phGCWindow)
+	}
+	return removed
+}
+
 // Read the phCache
 func (sl *Slice) readPhCache(hash common.Hash) (types.PendingHeader, bool) {
 	if ph, exists := sl.phCache.Get(hash); exists {
@@ -528,7 +1430,7 @@ func (sl *Slice) readPhCache(hash common.Hash) (types.PendingHeader, bool) {
 	} else {
 		ph := rawdb.ReadPendingHeader(sl.sliceDb, hash)
 		if ph != nil {
-			sl.phCache.Add(hash, ph)
+			sl.addPhCache(hash, *ph)
 			return *types.CopyPendingHeader(ph), true
 		} else {
 			return types.PendingHeader{}, false
@@ -537,13 +1439,33 @@ func (sl *Slice) readPhCache(hash common.Hash) (types.PendingHeader, bool) {
 	return types.PendingHeader{}, false
 }
 
-// Write the phCache
-func (sl *Slice) writePhCache(hash common.Hash, pendingHeader types.PendingHeader) {
-	sl.phCache.Add(hash, pendingHeader)
-	rawdb.WritePendingHeader(sl.sliceDb, hash, pendingHeader)
+// readBestPh reads the pending header at the best ph key from the phCache. It
+// takes the phCacheMu read lock and must not be called with phCacheMu held.
+func (sl *Slice) readBestPh() (types.PendingHeader, bool) {
+	sl.phCacheMu.RLock()
+	defer sl.phCacheMu.RUnlock()
+	return sl.readPhCache(sl.bestPhKey)
 }
 
-// WriteBestPhKey writes the sl.bestPhKey
+// addPhCache adds the pending header of the terminus to the phCache. The entry
+// at the best ph key is refreshed first, so that it is never the least recently
+// used entry evicted by the add. It must be called with phCacheMu held.
+func (sl *Slice) addPhCache(terminus common.Hash, pendingHeader types.PendingHeader) {
+	sl.phCache.Get(sl.bestPhKey)
+	sl.phCache.Add(terminus, pendingHeader)
+	phCacheSizeGauge.Update(int64(sl.phCache.Len()))
+}
+
+// writePhCache adds the pending header of the terminus to the phCache and
+// writes it to the db, from which readPhCache loads it back once evicted. It
+// must be called with phCacheMu held.
+func (sl *Slice) writePhCache(terminus common.Hash, pendingHeader types.PendingHeader) {
+	sl.addPhCache(terminus, pendingHeader)
+	rawdb.WritePendingHeader(sl.sliceDb, terminus, pendingHeader)
+}
+
+// WriteBestPhKey writes the sl.bestPhKey. It must be called with phCacheMu
+// held, since readPhCache reads the best ph key.
 func (sl *Slice) WriteBestPhKey(hash common.Hash) {
 	sl.bestPhKey = hash
 	// write the ph head hash to the db.
@@ -551,13 +1473,16 @@ func (sl *Slice) WriteBestPhKey(hash common.Hash) {
 }
 
 // Generate a slice pending header
-func (sl *Slice) generateSlicePendingHeader(block *types.Block, newTermini types.Termini, domPendingHeader *types.Header, domOrigin bool, subReorg bool, fill bool) (types.PendingHeader, error) {
+func (sl *Slice) generateSlicePendingHeader(ctx context.Context, block *types.Block, newTermini types.Termini, domPendingHeader *types.Header, domOrigin bool, subReorg bool, fill bool) (types.PendingHeader, error) {
+	if !sl.hasMinerWorker() {
+		return types.PendingHeader{}, ErrNoMinerWorker
+	}
 	nodeCtx := common.NodeLocation.Context()
 	var localPendingHeader *types.Header
 	var err error
 	if subReorg {
 		// Upate the local pending header
-		localPendingHeader, err = sl.miner.worker.GeneratePendingHeader(block, fill)
+		localPendingHeader, err = sl.miner.worker.GeneratePendingHeader(ctx, block, fill)
 		if err != nil {
 			return types.PendingHeader{}, err
 		}
@@ -582,6 +1507,9 @@ func (sl *Slice) generateSlicePendingHeader(block *types.Block, newTermini types
 
 	// Combine subordinates pending header with local pending header
 	pendingHeaderWithTermini := sl.computePendingHeader(types.NewPendingHeader(localPendingHeader, newTermini), domPendingHeader, domOrigin)
+	if err := checkPendingHeaderBaseFee(pendingHeaderWithTermini.Header()); err != nil {
+		return types.PendingHeader{}, err
+	}
 	pendingHeaderWithTermini.Header().SetLocation(block.Header().Location())
 
 	return pendingHeaderWithTermini, nil
@@ -594,16 +1522,9 @@ func (sl *Slice) CollectNewlyConfirmedEtxs(block *types.Block, location common.L
 	subRollup := types.Transactions{}
 	var err error
 	if nodeCtx < common.ZONE_CTX {
-		rollup, exists := sl.hc.subRollupCache.Get(block.Hash())
-		if exists && rollup != nil {
-			subRollup = rollup.(types.Transactions)
-			log.Info("Found the rollup in cache", "Hash", block.Hash(), "len", len(subRollup))
-		} else {
-			subRollup, err = sl.hc.CollectSubRollup(block)
-			if err != nil {
-				return nil, nil, err
-			}
-			sl.hc.subRollupCache.Add(block.Hash(), subRollup)
+		subRollup, err = sl.hc.CollectSubRollup(block)
+		if err != nil {
+			return nil, nil, err
 		}
 	}
 
@@ -660,7 +1581,9 @@ func (sl *Slice) pcrc(batch ethdb.Batch, header *types.Header, domTerminus commo
 	log.Debug("PCRC:", "Parent Hash:", header.ParentHash(), "Number", header.Number, "Location:", header.Location())
 	termini := sl.hc.GetTerminiByHash(header.ParentHash())
 
-	if !termini.IsValid() {
+	if err := termini.Validate(); err != nil {
+		log.Debug("PCRC parent termini unavailable", "parent", header.ParentHash(), "err", err)
+		appendSubNotSyncedCounter.Inc(1)
 		return common.Hash{}, types.EmptyTermini(), ErrSubNotSyncedToDom
 	}
 
@@ -675,17 +1598,28 @@ func (sl *Slice) pcrc(batch ethdb.Batch, header *types.Header, domTerminus commo
 		newTermini.SetDomTerminiAtIndex(header.Hash(), location.DomIndex())
 	} else {
 		newTermini.SetDomTerminiAtIndex(termini.DomTerminus(), location.DomIndex())
+		if sl.currentSettings().verifyTermini && sl.hc.GetHeaderByHash(termini.DomTerminus()) == nil {
+			log.Warn("PCRC inherited terminus references an unknown header", "hash", header.Hash(), "parent", header.ParentHash(), "terminus", termini.DomTerminus())
+		}
 	}
 
 	// Check for a graph cyclic reference
 	if domOrigin {
 		if termini.DomTerminus() != domTerminus {
 			log.Warn("Cyclic Block:", "block number", header.NumberArray(), "hash", header.Hash(), "terminus", domTerminus, "termini", termini.DomTerminus())
-			return common.Hash{}, types.EmptyTermini(), errors.New("termini do not match, block rejected due to cyclic reference")
+			appendCyclicCounter.Inc(1)
+			return common.Hash{}, types.EmptyTermini(), ErrCyclicReference
 		}
 	}
+	if err := sl.checkTerminusChain(header, termini.DomTerminus()); err != nil {
+		appendCyclicCounter.Inc(1)
+		return common.Hash{}, types.EmptyTermini(), err
+	}
 
 	//Save the termini
+	if err := newTermini.Validate(); err != nil {
+		return common.Hash{}, types.EmptyTermini(), err
+	}
 	rawdb.WriteTermini(batch, header.Hash(), newTermini)
 
 	if nodeCtx == common.ZONE_CTX {
@@ -695,8 +1629,65 @@ func (sl *Slice) pcrc(batch ethdb.Batch, header *types.Header, domTerminus commo
 	return termini.SubTerminiAtIndex(location.SubIndex()), newTermini, nil
 }
 
-// POEM compares externS to the currentHead S and returns true if externS is greater
+// checkTerminusChain walks back up to cyclicCheckDepth links of the chain of
+// dom termini starting at the given terminus, each link going to the dom
+// terminus of the parent of the previous terminus. Every terminus on the way
+// has to be strictly lower than the previous one and different from the header,
+// otherwise the termini form a cycle and ErrCyclicReference is returned. The
+// walk stops early at the genesis or at a terminus which is not known yet.
+func (sl *Slice) checkTerminusChain(header *types.Header, terminus common.Hash) error {
+	hash := header.Hash()
+	number := header.NumberU64()
+	for i := 0; i < sl.cyclicCheckDepth; i++ {
+		if terminus == hash {
+			log.Warn("Cyclic terminus chain", "hash", header.Hash(), "terminus", terminus, "depth", i)
+			return fmt.Errorf("%w: terminus %x references itself after %d links", ErrCyclicReference, terminus, i)
+		}
+		if terminus == sl.config.GenesisHash {
+			return nil
+		}
+		terminusHeader := sl.hc.GetHeaderByHash(terminus)
+		if terminusHeader == nil {
+			return nil
+		}
+		// The dom terminus of a parent is the parent itself or one of its
+		// ancestors
+		if terminusHeader.NumberU64() >= number {
+			log.Warn("Terminus chain does not go back", "hash", header.Hash(), "terminus", terminus, "number", terminusHeader.NumberU64(), "previous number", number)
+			return fmt.Errorf("%w: terminus %x at %d does not precede %x at %d", ErrCyclicReference, terminus, terminusHeader.NumberU64(), hash, number)
+		}
+		parentTermini := sl.hc.GetTerminiByHash(terminusHeader.ParentHash())
+		if parentTermini == nil {
+			return nil
+		}
+		hash, number = terminus, terminusHeader.NumberU64()
+		terminus = parentTermini.DomTerminus()
+	}
+	return nil
+}
+
+// makeGenesisTermini returns the termini of the genesis block, in which every
+// terminus is the genesis itself
+func makeGenesisTermini(genesisHash common.Hash) types.Termini {
+	var termini [common.HierarchyDepth]common.Hash
+	for i := range termini {
+		termini[i] = genesisHash
+	}
+	return types.NewTermini(termini, termini)
+}
+
+// POEM compares externS to the currentHead S and returns true if externS is greater.
+// Like hlcr, a missing current entropy loses the comparison and a missing
+// extern entropy never wins it.
 func (sl *Slice) poem(externS *big.Int, currentS *big.Int) bool {
+	if externS == nil {
+		log.Warn("POEM extern entropy is missing, keeping the current head")
+		return false
+	}
+	if currentS == nil {
+		log.Warn("POEM current entropy is missing, taking the extern head")
+		return true
+	}
 	log.Debug("POEM:", "currentS:", common.BigBitsToBits(currentS), "externS:", common.BigBitsToBits(externS))
 	reorg := currentS.Cmp(externS) <= 0
 	return reorg
@@ -704,41 +1695,544 @@ func (sl *Slice) poem(externS *big.Int, currentS *big.Int) bool {
 
 // GetPendingHeader is used by the miner to request the current pending header
 func (sl *Slice) GetPendingHeader() (*types.Header, error) {
-	if ph, exists := sl.readPhCache(sl.bestPhKey); exists {
+	if ph, exists := sl.readBestPh(); exists {
 		return ph.Header(), nil
-	} else {
-		return nil, errors.New("empty pending header")
 	}
+	// The best pending header can go missing after a reorg, regenerate it on
+	// the current head so that the next call finds it. Concurrent calls do not
+	// regenerate it again.
+	if atomic.CompareAndSwapInt32(&sl.phRecovering, 0, 1) {
+		log.Warn("Best pending header missing, regenerating it on the current head", "best ph key", sl.bestPhKey)
+		sl.regeneratePendingHeader()
+		atomic.StoreInt32(&sl.phRecovering, 0)
+	}
+	return nil, errors.New("empty pending header")
+}
+
+// GetPendingHeaderByTerminus returns a copy of the pending header stored in the
+// phCache under the given terminus, or on disk if it was evicted. It is meant
+// for inspecting the pending header propagation, so unlike readPhCache it
+// neither updates the recency of the phCache nor loads evicted entries back.
+func (sl *Slice) GetPendingHeaderByTerminus(terminus common.Hash) (types.PendingHeader, error) {
+	sl.phCacheMu.RLock()
+	defer sl.phCacheMu.RUnlock()
+	if value, exists := sl.phCache.Peek(terminus); exists {
+		if ph, ok := value.(types.PendingHeader); ok && ph.Header() != nil {
+			return *types.CopyPendingHeader(&ph), nil
+		}
+	} else if ph := rawdb.ReadPendingHeader(sl.sliceDb, terminus); ph != nil && ph.Header() != nil {
+		return *ph, nil
+	}
+	return types.PendingHeader{}, fmt.Errorf("%w: terminus %x", ErrPendingHeaderNotInCache, terminus)
+}
+
+// PendingHeadersByCoinbase returns the pending headers in the phCache whose
+// coinbase is the given address
+func (sl *Slice) PendingHeadersByCoinbase(addr common.Address) []types.PendingHeader {
+	sl.phCacheMu.RLock()
+	defer sl.phCacheMu.RUnlock()
+
+	pendingHeaders := []types.PendingHeader{}
+	for _, key := range sl.phCache.Keys() {
+		value, exists := sl.phCache.Peek(key)
+		if !exists {
+			continue
+		}
+		ph, ok := value.(types.PendingHeader)
+		if !ok || ph.Header() == nil {
+			continue
+		}
+		if ph.Header().Coinbase().Equal(addr) {
+			pendingHeaders = append(pendingHeaders, *types.CopyPendingHeader(&ph))
+		}
+	}
+	return pendingHeaders
+}
+
+// pruneHorizon returns the number of the lowest block whose pending data is
+// kept on disk. With a retention period configured, it is the lowest canonical
+// block which is not older than the period before the current head, otherwise
+// the block pendingRetention blocks below the current head.
+func (sl *Slice) pruneHorizon() uint64 {
+	headNumber := sl.hc.CurrentHeader().NumberU64()
+	depth := sl.pendingRetention
+	if sl.retentionPeriod > 0 {
+		depth = sl.hc.RetentionDepth(sl.retentionPeriod)
+	}
+	if headNumber <= depth {
+		return 0
+	}
+	return headNumber - depth
+}
+
+// PrunePendingData deletes the pending etxs and the pending headers stored on
+// disk for the blocks below the retention window of the current head. Only the
+// entries below the window are read, through their number index.
+// The pending etxs of the given hashes, which may still be referenced by a
+// manifest which is not appended yet, are kept regardless of their height, as
+// are the pending headers still in the phCache. It returns the number of
+// pending etxs and pending headers deleted.
+func (sl *Slice) PrunePendingData(keep map[common.Hash]struct{}) (int, int) {
+	horizon := sl.pruneHorizon()
+	if horizon == 0 {
+		return 0, 0
+	}
+
+	batch := sl.sliceDb.NewBatch()
+	prunedEtxs := make(map[common.Hash]struct{})
+	numbers, hashes := rawdb.ReadPendingEtxsNumbersBelow(sl.sliceDb, horizon)
+	for i, hash := range hashes {
+		if _, exists := keep[hash]; exists {
+			continue
+		}
+		rawdb.DeletePendingEtxs(batch, hash)
+		rawdb.DeletePendingEtxsNumber(batch, numbers[i], hash)
+		sl.hc.pendingEtxs.Remove(hash)
+		prunedEtxs[hash] = struct{}{}
+	}
+	// Evict the memoized rollups collected from the pruned pending etxs
+	sl.hc.invalidateSubRollups(prunedEtxs)
+
+	sl.phCacheMu.RLock()
+	prunedPhs := make(map[common.Hash]struct{})
+	numbers, hashes = rawdb.ReadPendingHeaderNumbersBelow(sl.sliceDb, horizon)
+	for i, hash := range hashes {
+		if hash == sl.bestPhKey || sl.phCache.Contains(hash) {
+			continue
+		}
+		rawdb.DeletePendingHeaderNumber(batch, numbers[i], hash)
+		if _, exists := prunedPhs[hash]; exists {
+			continue
+		}
+		// The terminus may have been indexed again since with a newer pending
+		// header, which is kept
+		ph := rawdb.ReadPendingHeader(sl.sliceDb, hash)
+		if ph == nil || ph.Header() == nil || ph.Header().NumberU64() >= horizon {
+			continue
+		}
+		rawdb.DeletePendingHeader(batch, hash)
+		prunedPhs[hash] = struct{}{}
+	}
+	sl.phCacheMu.RUnlock()
+
+	if err := batch.Write(); err != nil {
+		log.Error("Failed to prune the pending data", "err", err)
+		return 0, 0
+	}
+	if len(prunedEtxs) > 0 || len(prunedPhs) > 0 {
+		log.Info("Pruned pending data", "pending etxs", len(prunedEtxs), "pending headers", len(prunedPhs), "below", horizon)
+	}
+	return len(prunedEtxs), len(prunedPhs)
+}
+
+// StalePendingHeaders returns the keys of the phCache entries whose pending
+// header is not built on a canonical ancestor of the current head, which can
+// happen to the entries left behind by a reorg. The phCache is not modified.
+func (sl *Slice) StalePendingHeaders() []common.Hash {
+	sl.phCacheMu.RLock()
+	defer sl.phCacheMu.RUnlock()
+
+	headNumber := sl.hc.CurrentHeader().NumberU64()
+	stale := []common.Hash{}
+	for _, key := range sl.phCache.Keys() {
+		value, exists := sl.phCache.Peek(key)
+		if !exists {
+			continue
+		}
+		ph, ok := value.(types.PendingHeader)
+		if !ok || ph.Header() == nil || ph.Header().NumberU64() == 0 {
+			continue
+		}
+		parentNumber := ph.Header().NumberU64() - 1
+		if parentNumber > headNumber || rawdb.ReadCanonicalHash(sl.sliceDb, parentNumber) != ph.Header().ParentHash() {
+			stale = append(stale, key.(common.Hash))
+		}
+	}
+	return stale
+}
+
+// SuggestGasPrice returns a gas price for a transaction to be included in the
+// chain of the given context. It is the base fee of the best pending header
+// plus the median of the lowest tip paid in each of the recent blocks. Only the
+// chain of this slice has a pending header, so any other context is rejected.
+func (sl *Slice) SuggestGasPrice(ctx int) (*big.Int, error) {
+	if ctx < common.PRIME_CTX || ctx >= common.HierarchyDepth {
+		return nil, ErrInvalidContext
+	}
+	if ctx != common.NodeLocation.Context() {
+		return nil, fmt.Errorf("%w: slice serves context %d, not %d", ErrInvalidContext, common.NodeLocation.Context(), ctx)
+	}
+	ph, exists := sl.readBestPh()
+	if !exists {
+		return nil, ErrPendingHeaderNotInCache
+	}
+	baseFee := ph.Header().BaseFee()
+	if baseFee == nil {
+		return nil, ErrPendingHeaderNilBaseFee
+	}
+	return new(big.Int).Add(baseFee, sl.suggestGasTip()), nil
+}
+
+// suggestGasTip returns the median of the lowest effective tip of the recent
+// canonical blocks, ignoring the blocks without transactions
+func (sl *Slice) suggestGasTip() *big.Int {
+	tips := make([]*big.Int, 0, c_gasPriceBlocks)
+	header := sl.hc.CurrentHeader()
+	for i := 0; i < c_gasPriceBlocks && header != nil && header.NumberU64() > 0; i++ {
+		block := sl.hc.GetBlock(header.Hash(), header.NumberU64())
+		if block == nil {
+			break
+		}
+		var lowest *big.Int
+		for _, tx := range block.Transactions() {
+			tip, err := tx.EffectiveGasTip(header.BaseFee())
+			if err != nil {
+				continue
+			}
+			if lowest == nil || tip.Cmp(lowest) < 0 {
+				lowest = tip
+			}
+		}
+		if lowest != nil {
+			tips = append(tips, lowest)
+		}
+		header = sl.hc.GetHeader(header.ParentHash(), header.NumberU64()-1)
+	}
+	if len(tips) == 0 {
+		return big.NewInt(c_defaultGasTip)
+	}
+	sort.Slice(tips, func(i, j int) bool { return tips[i].Cmp(tips[j]) < 0 })
+	return new(big.Int).Set(tips[len(tips)/2])
+}
+
+// HeadAndPending returns the current head, its termini and the best pending
+// header from a single snapshot. Appends are held off while the snapshot is
+// taken, so that the three are never from different heads.
+func (sl *Slice) HeadAndPending() (*types.Header, types.Termini, types.PendingHeader, error) {
+	sl.appendMu.Lock()
+	defer sl.appendMu.Unlock()
+	sl.phCacheMu.RLock()
+	defer sl.phCacheMu.RUnlock()
+
+	head := sl.hc.CurrentHeader()
+	termini := sl.hc.GetTerminiByHash(head.Hash())
+	if termini == nil {
+		return nil, types.Termini{}, types.PendingHeader{}, ErrTerminiNotFound
+	}
+	pending, exists := sl.readPhCache(sl.bestPhKey)
+	if !exists {
+		return nil, types.Termini{}, types.PendingHeader{}, ErrPendingHeaderNotInCache
+	}
+	return types.CopyHeader(head), *termini, pending, nil
+}
+
+// CanonicalHash returns the canonical hash at the given height directly from
+// the canonical hash index, without loading the header.
+func (sl *Slice) CanonicalHash(number uint64) (common.Hash, error) {
+	if number > sl.hc.CurrentHeader().NumberU64() {
+		return common.Hash{}, ErrCanonicalHashNotFound
+	}
+	hash := rawdb.ReadCanonicalHash(sl.sliceDb, number)
+	if hash == (common.Hash{}) {
+		return common.Hash{}, ErrCanonicalHashNotFound
+	}
+	return hash, nil
+}
+
+// BlockEtxs returns the ETXs emitted by the block with the given hash itself,
+// as opposed to the rollup of its sub. The ETXs are checked against the ETX
+// hash of the block header.
+func (sl *Slice) BlockEtxs(hash common.Hash) (types.Transactions, error) {
+	block := sl.hc.GetBlockByHash(hash)
+	if block == nil {
+		return nil, ErrBlockNotFound
+	}
+	etxs := block.ExtTransactions()
+	if etxHash := types.DeriveSha(etxs, trie.NewStackTrie(nil)); etxHash != block.EtxHash() {
+		return nil, fmt.Errorf("%w: have %x, want %x", ErrEtxRootMismatch, etxHash, block.EtxHash())
+	}
+	return etxs, nil
+}
+
+// Confirmations returns the number of canonical blocks on top of the block with
+// the given hash, zero if it is the current head. A known block which is not
+// on the canonical chain returns -1 and ErrNotCanonical, an unknown block
+// returns -1 and ErrBlockNotFound.
+func (sl *Slice) Confirmations(hash common.Hash) (int, error) {
+	header := sl.hc.GetHeaderByHash(hash)
+	if header == nil {
+		return -1, ErrBlockNotFound
+	}
+	head := sl.hc.CurrentHeader()
+	number := header.NumberU64()
+	if number > head.NumberU64() || rawdb.ReadCanonicalHash(sl.sliceDb, number) != hash {
+		return -1, ErrNotCanonical
+	}
+	return int(head.NumberU64() - number), nil
+}
+
+// ForEachCanonicalBlock calls fn with each canonical block from number from to
+// number to, both included, in ascending order. The blocks are reconstructed
+// and their bodies checked like in an append. The iteration stops at the first
+// error, whether it comes from fn or from a block which cannot be read.
+func (sl *Slice) ForEachCanonicalBlock(from, to uint64, fn func(*types.Block) error) error {
+	for number := from; number <= to; number++ {
+		if sl.isClosed() {
+			return ErrSliceClosed
+		}
+		hash, err := sl.CanonicalHash(number)
+		if err != nil {
+			return err
+		}
+		header := sl.hc.GetHeader(hash, number)
+		if header == nil {
+			return fmt.Errorf("canonical header %d not found", number)
+		}
+		block, err := sl.ConstructLocalBlock(header)
+		if err != nil {
+			return err
+		}
+		if err := fn(block); err != nil {
+			return err
+		}
+		if number == math.MaxUint64 {
+			break
+		}
+	}
+	return nil
 }
 
 // GetManifest gathers the manifest of ancestor block hashes since the last
 // coincident block.
 func (sl *Slice) GetManifest(blockHash common.Hash) (types.BlockManifest, error) {
+	if cached, exists := sl.manifestCache.Get(blockHash); exists {
+		return copyManifest(cached.(types.BlockManifest)), nil
+	}
 	manifest := rawdb.ReadManifest(sl.sliceDb, blockHash)
 	if manifest != nil {
+		sl.manifestCache.Add(blockHash, copyManifest(manifest))
 		return manifest, nil
 	}
 	return nil, errors.New("manifest not found in the disk")
 }
 
+// GetTermini returns the validated termini of the block with the given hash
+func (sl *Slice) GetTermini(hash common.Hash) (types.Termini, error) {
+	termini := sl.hc.GetTerminiByHash(hash)
+	if termini == nil {
+		return types.Termini{}, ErrTerminiNotFound
+	}
+	if err := termini.Validate(); err != nil {
+		return types.Termini{}, err
+	}
+	return *termini, nil
+}
+
+// copyManifest returns a copy of the manifest, so that the cached manifests are
+// never shared with the callers
+func copyManifest(manifest types.BlockManifest) types.BlockManifest {
+	manifestCopy := make(types.BlockManifest, len(manifest))
+	copy(manifestCopy, manifest)
+	return manifestCopy
+}
+
+// GetRollup returns the ETXs rolled up from the sub by the given block, as
+// stored when the block was appended.
+func (sl *Slice) GetRollup(blockHash common.Hash) (types.Transactions, error) {
+	etxRollup := rawdb.ReadEtxRollup(sl.sliceDb, blockHash)
+	if etxRollup != nil {
+		return etxRollup, nil
+	}
+	return nil, errors.New("etx rollup not found in the disk")
+}
+
 // GetSubManifest gets the block manifest from the subordinate node which
 // produced this block
 func (sl *Slice) GetSubManifest(slice common.Location, blockHash common.Hash) (types.BlockManifest, error) {
 	subIdx := slice.SubIndex()
-	if sl.subClients[subIdx] == nil {
+	if sl.getSubClient(subIdx) == nil {
 		return nil, errors.New("missing requested subordinate node")
 	}
-	return sl.subClients[subIdx].GetManifest(context.Background(), blockHash)
+	return sl.getSubClient(subIdx).GetManifest(context.Background(), blockHash)
+}
+
+// SendPendingEtxsToDom shares a set of pending ETXs with your dom, so he can reference them when a coincident block is found.
+// The send goes through the queue of pEtxSendLoop, which retries failed sends with a backoff, up to c_pEtxSendAttempts
+// attempts. Once it gives up ErrPendingEtxsNotSent is returned so that the caller can requeue the pending ETXs. The
+// attempts stop when ctx is done.
+func (sl *Slice) SendPendingEtxsToDom(ctx context.Context, pEtxs types.PendingEtxs) error {
+	if common.NodeLocation.Context() == common.PRIME_CTX {
+		return fmt.Errorf("%w: %v", ErrPendingEtxsNotSent, ErrDomClientNotUp)
+	}
+	result := make(chan error, 1)
+	if err := sl.enqueuePendingEtxs(ctx, pEtxSend{ctx: ctx, pEtxs: pEtxs, attempt: 1, backoff: c_pEtxSendBackoff, result: result}); err != nil {
+		return err
+	}
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("%w: %v", ErrPendingEtxsNotSent, ctx.Err())
+	case <-sl.quit:
+		return fmt.Errorf("%w: %v", ErrPendingEtxsNotSent, ErrSliceClosed)
+	}
+}
+
+// sendPendingEtxsOnce makes a single attempt to send the pending etxs to the
+// dom, and reports whether a failed attempt is worth retrying.
+func (sl *Slice) sendPendingEtxsOnce(ctx context.Context, pEtxs types.PendingEtxs) (bool, error) {
+	var err error
+	if sl.getDomClient() == nil {
+		err = ErrDomClientNotUp
+	} else {
+		// A hung dom must not hold up the sends queued behind this one
+		ctx, cancel := context.WithTimeout(ctx, c_pEtxSendTimeout)
+		err = sl.getDomClient().SendPendingEtxsToDom(ctx, pEtxs)
+		cancel()
+		sl.recordDomResult(err)
+	}
+	if err == nil {
+		pEtxSendSuccessCounter.Inc(1)
+		return false, nil
+	}
+	// An error returned by the dom itself means the pending etxs were
+	// rejected, sending them again would not change the outcome
+	var rpcErr rpc.Error
+	return !errors.As(err, &rpcErr), err
+}
+
+// pEtxSend is a set of pending etxs queued to be sent to the dom, along with
+// the attempt it is at and the delay before its next attempt.
+type pEtxSend struct {
+	ctx     context.Context // Context of the sender, the attempts stop once it is done
+	pEtxs   types.PendingEtxs
+	attempt int
+	backoff time.Duration
+	due     time.Time  // Time of the next attempt of a failed send
+	result  chan error // Receives the outcome of the send if set, buffered so the loop never blocks on it
+}
+
+// done reports the outcome of the send to the waiting caller, if any
+func (send pEtxSend) done(err error) {
+	if send.result != nil {
+		send.result <- err
+	}
+}
+
+// queuePendingEtxsToDom hands the pending etxs to pEtxSendLoop, so that the
+// retries of the send never hold up the caller. If the send queue stays full
+// until ctx is done, the pending etxs are not queued and ErrPendingEtxsNotSent
+// is returned instead of dropping them silently.
+func (sl *Slice) queuePendingEtxsToDom(ctx context.Context, pEtxs types.PendingEtxs) error {
+	return sl.enqueuePendingEtxs(ctx, pEtxSend{ctx: sl.backgroundCtx, pEtxs: pEtxs, attempt: 1, backoff: c_pEtxSendBackoff})
+}
+
+// enqueuePendingEtxs waits for room in the send queue of pEtxSendLoop
+func (sl *Slice) enqueuePendingEtxs(ctx context.Context, send pEtxSend) error {
+	select {
+	case sl.pEtxSendCh <- send:
+		return nil
+	case <-ctx.Done():
+		pEtxSendFailureCounter.Inc(1)
+		return fmt.Errorf("%w: send queue full: %v", ErrPendingEtxsNotSent, ctx.Err())
+	case <-sl.quit:
+		return fmt.Errorf("%w: %v", ErrPendingEtxsNotSent, ErrSliceClosed)
+	}
+}
+
+// pEtxSendLoop sends the queued pending etxs to the dom one attempt at a time.
+// A failed attempt is held back until its backoff has elapsed, so that the
+// other pending etxs are sent in the meantime instead of waiting behind it. At
+// most c_pEtxSendQueueSize sends are held back, and the ones still waiting when
+// the slice stops are reported as not sent.
+func (sl *Slice) pEtxSendLoop() {
+	defer sl.wg.Done()
+	var retries []pEtxSend
+	for {
+		// Wait for the earliest held back send along with the queue
+		var (
+			retryTimer *time.Timer
+			retryC     <-chan time.Time
+			next       int
+		)
+		if len(retries) > 0 {
+			for i := range retries {
+				if retries[i].due.Before(retries[next].due) {
+					next = i
+				}
+			}
+			retryTimer = time.NewTimer(time.Until(retries[next].due))
+			retryC = retryTimer.C
+		}
+		select {
+		case send := <-sl.pEtxSendCh:
+			retries = sl.attemptPendingEtxsSend(send, retries)
+		case <-retryC:
+			send := retries[next]
+			retries = append(retries[:next], retries[next+1:]...)
+			retries = sl.attemptPendingEtxsSend(send, retries)
+		case <-sl.quit:
+			for _, send := range retries {
+				send.done(fmt.Errorf("%w: %v", ErrPendingEtxsNotSent, ErrSliceClosed))
+			}
+			return
+		}
+		if retryTimer != nil {
+			retryTimer.Stop()
+		}
+	}
+}
+
+// attemptPendingEtxsSend makes one attempt of the send. A failed attempt worth
+// retrying is added to the held back retries, unless it was the last attempt,
+// the sender is gone or too many sends are held back already, in which case it
+// is given up on.
+func (sl *Slice) attemptPendingEtxsSend(send pEtxSend, retries []pEtxSend) []pEtxSend {
+	if err := send.ctx.Err(); err != nil {
+		pEtxSendFailureCounter.Inc(1)
+		send.done(fmt.Errorf("%w: %v", ErrPendingEtxsNotSent, err))
+		return retries
+	}
+	retry, err := sl.sendPendingEtxsOnce(send.ctx, send.pEtxs)
+	if err == nil {
+		send.done(nil)
+		return retries
+	}
+	if !retry || send.attempt >= c_pEtxSendAttempts || len(retries) >= c_pEtxSendQueueSize {
+		pEtxSendFailureCounter.Inc(1)
+		log.Warn("Failed to send pending etxs to dom", "hash", send.pEtxs.Header.Hash(), "attempts", send.attempt, "err", err)
+		send.done(fmt.Errorf("%w: %v", ErrPendingEtxsNotSent, err))
+		return retries
+	}
+	log.Debug("Failed to send pending etxs to dom, retrying", "hash", send.pEtxs.Header.Hash(), "attempt", send.attempt, "err", err)
+	pEtxSendRetryCounter.Inc(1)
+	send.due = time.Now().Add(send.backoff)
+	send.attempt++
+	send.backoff *= 2
+	return append(retries, send)
 }
 
-// SendPendingEtxsToDom shares a set of pending ETXs with your dom, so he can reference them when a coincident block is found
-func (sl *Slice) SendPendingEtxsToDom(pEtxs types.PendingEtxs) error {
-	return sl.domClient.SendPendingEtxsToDom(context.Background(), pEtxs)
+// domReachable returns true if the dom client is up and the last request made
+// to the dom succeeded
+func (sl *Slice) domReachable() bool {
+	return sl.getDomClient() != nil && atomic.LoadInt32(&sl.domUnreachable) == 0
+}
+
+// recordDomResult keeps track of whether the last request to the dom failed
+func (sl *Slice) recordDomResult(err error) {
+	if err != nil {
+		atomic.StoreInt32(&sl.domUnreachable, 1)
+		if isConnectionError(err) && sl.domClientUrl != "" {
+			sl.reconnect(&sl.domReconnecting, "dominant", sl.dialDomClient)
+		}
+	} else {
+		atomic.StoreInt32(&sl.domUnreachable, 0)
+	}
 }
 
 func (sl *Slice) GetPEtxRollupAfterRetryThreshold(blockHash common.Hash, hash common.Hash, location common.Location) (types.PendingEtxsRollup, error) {
 	pEtx, exists := sl.pEtxRetryCache.Get(blockHash)
-	if !exists || pEtx.(pEtxRetry).retries < c_pEtxRetryThreshold {
+	if !exists || pEtx.(pEtxRetry).retries < sl.currentSettings().pEtxRetryThreshold {
 		return types.PendingEtxsRollup{}, ErrPendingEtxNotFound
 	}
 	return sl.GetPendingEtxsRollupFromSub(hash, location)
@@ -748,8 +2242,9 @@ func (sl *Slice) GetPEtxRollupAfterRetryThreshold(blockHash common.Hash, hash co
 func (sl *Slice) GetPendingEtxsRollupFromSub(hash common.Hash, location common.Location) (types.PendingEtxsRollup, error) {
 	nodeCtx := common.NodeLocation.Context()
 	if nodeCtx == common.PRIME_CTX {
-		if sl.subClients[location.SubIndex()] != nil {
-			pEtxRollup, err := sl.subClients[location.SubIndex()].GetPendingEtxsRollupFromSub(context.Background(), hash, location)
+		if sl.getSubClient(location.SubIndex()) != nil {
+			pEtxRollup, err := sl.getSubClient(location.SubIndex()).GetPendingEtxsRollupFromSub(context.Background(), hash, location)
+			sl.recordSubResult(location.SubIndex(), err)
 			if err != nil {
 				return types.PendingEtxsRollup{}, err
 			} else {
@@ -768,18 +2263,68 @@ func (sl *Slice) GetPendingEtxsRollupFromSub(hash common.Hash, location common.L
 
 func (sl *Slice) GetPEtxAfterRetryThreshold(blockHash common.Hash, hash common.Hash, location common.Location) (types.PendingEtxs, error) {
 	pEtx, exists := sl.pEtxRetryCache.Get(blockHash)
-	if !exists || pEtx.(pEtxRetry).retries < c_pEtxRetryThreshold {
+	if !exists || pEtx.(pEtxRetry).retries < sl.currentSettings().pEtxRetryThreshold {
 		return types.PendingEtxs{}, ErrPendingEtxNotFound
 	}
-	return sl.GetPendingEtxsFromSub(hash, location)
+	return sl.GetPendingEtxsFromSub(hash, location)
+}
+
+// GetPendingEtxs returns the pending etxs stored for the given block hash,
+// looking in the pending etxs cache first and then in the database. If neither
+// has them, ErrPendingEtxNotFound is returned.
+func (sl *Slice) GetPendingEtxs(hash common.Hash) (types.PendingEtxs, error) {
+	pendingEtxs, err := sl.hc.GetPendingEtxs(hash)
+	if err != nil {
+		return types.PendingEtxs{}, err
+	}
+	return *pendingEtxs, nil
+}
+
+// PendingEtxsCount returns the number of pending etxs sets held in the cache
+func (sl *Slice) PendingEtxsCount() int {
+	return sl.hc.pendingEtxs.Len()
+}
+
+// CheckRollupAvailability returns the hashes in the manifest whose pending etxs
+// are not available locally, so that they can be fetched before a block with
+// this manifest is committed to. In prime a hash whose pending etxs rollup is
+// missing is reported as missing, as are the hashes of the rollup manifest
+// which are missing.
+func (sl *Slice) CheckRollupAvailability(manifest types.BlockManifest) ([]common.Hash, error) {
+	if sl.isClosed() {
+		return nil, ErrSliceClosed
+	}
+	nodeCtx := common.NodeLocation.Context()
+	missing := []common.Hash{}
+	if nodeCtx == common.ZONE_CTX {
+		return missing, nil
+	}
+	for _, hash := range manifest {
+		if nodeCtx == common.PRIME_CTX {
+			pEtxRollup, err := sl.hc.GetPendingEtxsRollup(hash)
+			if err != nil {
+				missing = append(missing, hash)
+				continue
+			}
+			for _, pEtxHash := range pEtxRollup.Manifest {
+				if _, err := sl.hc.GetPendingEtxs(pEtxHash); err != nil {
+					missing = append(missing, pEtxHash)
+				}
+			}
+		} else if _, err := sl.hc.GetPendingEtxs(hash); err != nil {
+			missing = append(missing, hash)
+		}
+	}
+	return missing, nil
 }
 
 // GetPendingEtxsFromSub gets the pending etxs from the appropriate prime
 func (sl *Slice) GetPendingEtxsFromSub(hash common.Hash, location common.Location) (types.PendingEtxs, error) {
 	nodeCtx := common.NodeLocation.Context()
 	if nodeCtx != common.ZONE_CTX {
-		if sl.subClients[location.SubIndex()] != nil {
-			pEtx, err := sl.subClients[location.SubIndex()].GetPendingEtxsFromSub(context.Background(), hash, location)
+		if sl.getSubClient(location.SubIndex()) != nil {
+			pEtx, err := sl.getSubClient(location.SubIndex()).GetPendingEtxsFromSub(context.Background(), hash, location)
+			sl.recordSubResult(location.SubIndex(), err)
 			if err != nil {
 				return types.PendingEtxs{}, err
 			} else {
@@ -797,6 +2342,9 @@ func (sl *Slice) GetPendingEtxsFromSub(hash common.Hash, location common.Locatio
 
 // SubRelayPendingHeader takes a pending header from the sender (ie dominant), updates the phCache with a composited header and relays result to subordinates
 func (sl *Slice) SubRelayPendingHeader(pendingHeader types.PendingHeader, newEntropy *big.Int, location common.Location, subReorg bool, order int) {
+	if sl.isClosed() {
+		return
+	}
 	nodeCtx := common.NodeLocation.Context()
 	var err error
 
@@ -809,12 +2357,13 @@ func (sl *Slice) SubRelayPendingHeader(pendingHeader types.PendingHeader, newEnt
 			}
 		}
 
-		for _, i := range sl.randomRelayArray() {
-			if sl.subClients[i] != nil {
-				if ph, exists := sl.readPhCache(pendingHeader.Termini().SubTerminiAtIndex(common.NodeLocation.Region())); exists {
-					sl.subClients[i].SubRelayPendingHeader(context.Background(), ph, newEntropy, location, subReorg, order)
-				}
-			}
+		sl.phCacheMu.RLock()
+		ph, exists := sl.readPhCache(pendingHeader.Termini().SubTerminiAtIndex(common.NodeLocation.Region()))
+		sl.phCacheMu.RUnlock()
+		if exists {
+			sl.relayToSubs(func(ctx context.Context, i int) {
+				sl.relayPendingHeaderToSub(ctx, i, ph, newEntropy, location, subReorg, order)
+			})
 		}
 	} else {
 		// This check prevents a double send to the miner.
@@ -832,15 +2381,75 @@ func (sl *Slice) SubRelayPendingHeader(pendingHeader types.PendingHeader, newEnt
 		}
 
 		if !bytes.Equal(location, common.NodeLocation) {
-			bestPh, exists := sl.readPhCache(sl.bestPhKey)
+			bestPh, exists := sl.readBestPh()
 			if exists {
-				bestPh.Header().SetLocation(common.NodeLocation)
-				sl.miner.worker.pendingHeaderFeed.Send(bestPh.Header())
+				sl.sendPendingHeaderToMiner(bestPh)
 			}
 		}
 	}
 }
 
+// SubRelayPendingHeaderDiff rebuilds the pending header relayed by the dom by
+// applying the diff on the local pending header at the same terminus, and then
+// handles it like a full relay. If there is no local pending header to apply
+// the diff on, ErrPendingHeaderNotInCache is returned so that the dom can fall
+// back to relaying the full pending header.
+func (sl *Slice) SubRelayPendingHeaderDiff(diff types.PendingHeaderDiff, termini types.Termini, newEntropy *big.Int, location common.Location, subReorg bool, order int) error {
+	if sl.isClosed() {
+		return ErrSliceClosed
+	}
+	if !termini.IsValid() {
+		return errors.New("invalid termini in pending header diff")
+	}
+	pendingHeader, err := sl.applyPendingHeaderDiff(diff, termini)
+	if err != nil {
+		return err
+	}
+	sl.SubRelayPendingHeader(pendingHeader, newEntropy, location, subReorg, order)
+	return nil
+}
+
+// applyPendingHeaderDiff rebuilds the pending header relayed by the dom from the
+// diff and the local pending header at the sub terminus of this slice.
+func (sl *Slice) applyPendingHeaderDiff(diff types.PendingHeaderDiff, termini types.Termini) (types.PendingHeader, error) {
+	terminiIndex := common.NodeLocation.Zone()
+	if common.NodeLocation.Context() == common.REGION_CTX {
+		terminiIndex = common.NodeLocation.Region()
+	}
+	sl.phCacheMu.RLock()
+	localPendingHeader, exists := sl.readPhCache(termini.SubTerminiAtIndex(terminiIndex))
+	sl.phCacheMu.RUnlock()
+	if !exists {
+		return types.PendingHeader{}, ErrPendingHeaderNotInCache
+	}
+	header, err := diff.Apply(localPendingHeader.Header())
+	if err != nil {
+		return types.PendingHeader{}, err
+	}
+	return types.NewPendingHeader(header, termini), nil
+}
+
+// relayPendingHeaderToSub relays the pending header to the subordinate at the
+// given index. If diff relays are enabled only the fields of the dom contexts
+// are sent, and the full pending header is only sent if the sub rejects the diff.
+func (sl *Slice) relayPendingHeaderToSub(ctx context.Context, index int, pendingHeader types.PendingHeader, newEntropy *big.Int, location common.Location, subReorg bool, order int) {
+	sl.sendPendingHeaderToLocation(append(common.Location(common.CopyBytes(common.NodeLocation)), byte(index)), pendingHeader.Header())
+	if sl.currentSettings().relayPendingHeaderDiffs {
+		nodeCtx := common.NodeLocation.Context()
+		contexts := make([]int, 0, nodeCtx+1)
+		for i := common.PRIME_CTX; i <= nodeCtx; i++ {
+			contexts = append(contexts, i)
+		}
+		diff := types.NewPendingHeaderDiff(pendingHeader.Header(), contexts)
+		err := sl.getSubClient(index).SubRelayPendingHeaderDiff(ctx, diff, pendingHeader.Termini(), newEntropy, location, subReorg, order)
+		if err == nil {
+			return
+		}
+		log.Debug("Pending header diff relay failed, relaying full pending header", "index", index, "err", err)
+	}
+	sl.getSubClient(index).SubRelayPendingHeader(ctx, pendingHeader, newEntropy, location, subReorg, order)
+}
+
 // computePendingHeader takes in an localPendingHeaderWithTermini and updates the pending header on the same terminus if the number is greater
 func (sl *Slice) computePendingHeader(localPendingHeaderWithTermini types.PendingHeader, domPendingHeader *types.Header, domOrigin bool) types.PendingHeader {
 	nodeCtx := common.NodeLocation.Context()
@@ -875,9 +2484,10 @@ func (sl *Slice) updatePhCacheFromDom(pendingHeader types.PendingHeader, termini
 	localPendingHeader, exists := sl.readPhCache(hash)
 
 	if exists {
-		combinedPendingHeader := types.CopyHeader(localPendingHeader.Header())
-		for _, i := range indices {
-			combinedPendingHeader = sl.combinePendingHeader(pendingHeader.Header(), combinedPendingHeader, i, false)
+		combinedPendingHeader := sl.combinePendingHeaderAt(pendingHeader.Header(), localPendingHeader.Header(), indices...)
+		if err := checkPendingHeaderBaseFee(combinedPendingHeader); err != nil {
+			log.Warn("Combined pending header is invalid", "terminus", hash, "err", err)
+			return err
 		}
 
 		localTermini := localPendingHeader.Termini()
@@ -895,7 +2505,7 @@ func (sl *Slice) updatePhCacheFromDom(pendingHeader types.PendingHeader, termini
 		if nodeCtx == common.ZONE_CTX && exists && sl.bestPhKey != localPendingHeader.Termini().DomTerminus() && !sl.poem(newEntropy, bestPh.Header().ParentEntropy()) {
 			log.Warn("Subrelay Rejected", "local dom terminus", localPendingHeader.Termini().DomTerminus(), "Number", combinedPendingHeader.NumberArray(), "best ph key", sl.bestPhKey, "number", bestPh.Header().NumberArray(), "newentropy", newEntropy)
 			sl.updatePhCache(types.NewPendingHeader(combinedPendingHeader, localTermini), false, nil, sl.poem(newEntropy, localPendingHeader.Header().ParentEntropy()), location)
-			go sl.domClient.UpdateDom(context.Background(), localPendingHeader.Termini().DomTerminus(), bestPh, common.NodeLocation)
+			sl.updateDomInBackground(localPendingHeader.Termini().DomTerminus(), bestPh, common.NodeLocation)
 			return nil
 		}
 		// Pick the head
@@ -913,7 +2523,7 @@ func (sl *Slice) updatePhCacheFromDom(pendingHeader types.PendingHeader, termini
 						log.Error("Error setting current state", "err", err, "Hash", block.Hash())
 						return nil
 					}
-					newPendingHeader, err := sl.generateSlicePendingHeader(block, localPendingHeader.Termini(), combinedPendingHeader, true, true, false)
+					newPendingHeader, err := sl.generateSlicePendingHeader(context.Background(), block, localPendingHeader.Termini(), combinedPendingHeader, true, true, false)
 					if err != nil {
 						log.Error("Error generating slice pending header", "err", err)
 						return err
@@ -1004,13 +2614,40 @@ func (sl *Slice) updatePhCache(pendingHeaderWithTermini types.PendingHeader, inS
 	}
 }
 
+// checkGenesis makes sure that the genesis the slice is started with is the
+// genesis already committed to the database, both the one given by the chain
+// config and the one built from the genesis spec. A node started with the
+// config of another network on an existing datadir is refused instead of
+// running on a mismatched chain.
+func (sl *Slice) checkGenesis(genesis *Genesis) error {
+	stored := rawdb.ReadCanonicalHash(sl.sliceDb, 0)
+	if stored == (common.Hash{}) {
+		return nil
+	}
+	if configured := sl.Config().GenesisHash; configured != stored {
+		return &GenesisMismatchError{Stored: stored, New: configured}
+	}
+	if genesis != nil {
+		if specified := genesis.ToBlock(nil).Hash(); specified != stored {
+			return &GenesisMismatchError{Stored: stored, New: specified}
+		}
+	}
+	return nil
+}
+
 // init checks if the headerchain is empty and if it's empty appends the Knot
 // otherwise loads the last stored state of the chain.
 func (sl *Slice) init(genesis *Genesis) error {
+	atomic.StoreInt32(&sl.initializing, 1)
+	defer atomic.StoreInt32(&sl.initializing, 0)
+
 	// Even though the genesis block cannot have any ETXs, we still need an empty
 	// pending ETX entry for that block hash, so that the state processor can build
 	// on it
 	genesisHash := sl.Config().GenesisHash
+	if err := sl.checkGenesis(genesis); err != nil {
+		return err
+	}
 	genesisHeader := sl.hc.GetHeader(genesisHash, 0)
 	if genesisHeader == nil {
 		return errors.New("failed to get genesis header")
@@ -1020,17 +2657,15 @@ func (sl *Slice) init(genesis *Genesis) error {
 	badHashes := rawdb.ReadBadHashesList(sl.sliceDb)
 	sl.AddToBadHashesList(badHashes)
 
-	// If the headerchain is empty start from genesis
+	// If the headerchain is empty start from genesis. This is run again on every
+	// start until a block is appended on top of the genesis, including after a
+	// crash in the middle of it, so every step has to be idempotent.
 	if sl.hc.Empty() {
 		// Initialize slice state for genesis knot
-		genesisTermini := types.EmptyTermini()
-		for i := 0; i < len(genesisTermini.SubTermini()); i++ {
-			genesisTermini.SetSubTerminiAtIndex(genesisHash, i)
-		}
-		for i := 0; i < len(genesisTermini.DomTermini()); i++ {
-			genesisTermini.SetDomTerminiAtIndex(genesisHash, i)
+		genesisTermini := makeGenesisTermini(genesisHash)
+		if err := genesisTermini.Validate(); err != nil {
+			return err
 		}
-
 		rawdb.WriteTermini(sl.sliceDb, genesisHash, genesisTermini)
 		rawdb.WriteManifest(sl.sliceDb, genesisHash, types.BlockManifest{genesisHash})
 
@@ -1041,7 +2676,7 @@ func (sl *Slice) init(genesis *Genesis) error {
 		// Create empty pending ETX entry for genesis block -- genesis may not emit ETXs
 		emptyPendingEtxs := types.Transactions{}
 		err := sl.hc.AddPendingEtxs(types.PendingEtxs{genesisHeader, emptyPendingEtxs})
-		if err != nil {
+		if err != nil && !errors.Is(err, ErrPendingEtxAlreadyKnown) {
 			return err
 		}
 		err = sl.AddPendingEtxsRollup(types.PendingEtxsRollup{genesisHeader, []common.Hash{}})
@@ -1049,13 +2684,13 @@ func (sl *Slice) init(genesis *Genesis) error {
 			return err
 		}
 		err = sl.hc.AddBloom(types.Bloom{}, genesisHeader.Hash())
-		if err != nil {
+		if err != nil && !errors.Is(err, ErrBloomAlreadyKnown) {
 			return err
 		}
 		rawdb.WriteEtxSet(sl.sliceDb, genesisHash, 0, types.NewEtxSet())
 
 		if common.NodeLocation.Context() == common.PRIME_CTX {
-			go sl.NewGenesisPendingHeader(nil)
+			go sl.NewGenesisPendingHeader(sl.backgroundCtx, nil)
 		}
 	} else { // load the phCache and slice current pending header hash
 		if err := sl.loadLastState(); err != nil {
@@ -1065,11 +2700,38 @@ func (sl *Slice) init(genesis *Genesis) error {
 	return nil
 }
 
-// constructLocalBlock takes a header and construct the Block locally by getting the body
+// ConstructLocalBlock takes a header and construct the Block locally by getting the body
 // from the candidate body db. This method is used when peers give the block as a placeholder
 // for the body.
 func (sl *Slice) ConstructLocalBlock(header *types.Header) (*types.Block, error) {
-	pendingBlockBody := rawdb.ReadBody(sl.sliceDb, header.Hash(), header.NumberU64())
+	block, err := sl.constructLocalBlock(header)
+	if err != nil {
+		recordConstructBlockFailure(err)
+	}
+	return block, err
+}
+
+// recordConstructBlockFailure counts a failure to construct a local block
+// under the reason it failed for
+func recordConstructBlockFailure(err error) {
+	switch {
+	case errors.Is(err, ErrBodyNotFound):
+		constructBodyMissingCounter.Inc(1)
+	case errors.Is(err, ErrBadSubManifest):
+		constructSubManifestMismatchCounter.Inc(1)
+	case errors.Is(err, ErrTxRootMismatch):
+		constructTxRootMismatchCounter.Inc(1)
+	case errors.Is(err, ErrEtxRootMismatch):
+		constructEtxRootMismatchCounter.Inc(1)
+	case errors.Is(err, ErrUncleRootMismatch):
+		constructUncleRootMismatchCounter.Inc(1)
+	default:
+		constructOtherFailureCounter.Inc(1)
+	}
+}
+
+func (sl *Slice) constructLocalBlock(header *types.Header) (*types.Block, error) {
+	pendingBlockBody := sl.hc.GetBodyByNumber(header.Hash(), header.NumberU64())
 	if pendingBlockBody == nil {
 		return nil, ErrBodyNotFound
 	}
@@ -1143,12 +2805,7 @@ func (sl *Slice) ConstructLocalMinedBlock(header *types.Header) (*types.Block, e
 func (sl *Slice) combinePendingHeader(header *types.Header, slPendingHeader *types.Header, index int, inSlice bool) *types.Header {
 	// copying the slPendingHeader and updating the copy to remove any shared memory access issues
 	combinedPendingHeader := types.CopyHeader(slPendingHeader)
-
-	combinedPendingHeader.SetParentHash(header.ParentHash(index), index)
-	combinedPendingHeader.SetNumber(header.Number(index), index)
-	combinedPendingHeader.SetManifestHash(header.ManifestHash(index), index)
-	combinedPendingHeader.SetParentEntropy(header.ParentEntropy(index), index)
-	combinedPendingHeader.SetParentDeltaS(header.ParentDeltaS(index), index)
+	setPendingHeaderAt(combinedPendingHeader, header, index)
 
 	if inSlice {
 		combinedPendingHeader.SetEtxRollupHash(header.EtxRollupHash())
@@ -1168,12 +2825,48 @@ func (sl *Slice) combinePendingHeader(header *types.Header, slPendingHeader *typ
 	return combinedPendingHeader
 }
 
-// NewGenesisPendingHeader creates a pending header on the genesis block
-func (sl *Slice) NewGenesisPendingHeader(domPendingHeader *types.Header) {
+// combinePendingHeaderAt updates the pending header at each of the given
+// indices with the values from the given header. It gives the same result as
+// combining the indices one after the other, but copies slPendingHeader once.
+func (sl *Slice) combinePendingHeaderAt(header *types.Header, slPendingHeader *types.Header, indices ...int) *types.Header {
+	combinedPendingHeader := types.CopyHeader(slPendingHeader)
+	for _, index := range indices {
+		setPendingHeaderAt(combinedPendingHeader, header, index)
+	}
+	return combinedPendingHeader
+}
+
+// setPendingHeaderAt overwrites the fields of the pending header at the given
+// index with the values from the given header.
+func setPendingHeaderAt(pendingHeader *types.Header, header *types.Header, index int) {
+	pendingHeader.SetParentHash(header.ParentHash(index), index)
+	pendingHeader.SetNumber(header.Number(index), index)
+	pendingHeader.SetManifestHash(header.ManifestHash(index), index)
+	pendingHeader.SetParentEntropy(header.ParentEntropy(index), index)
+	pendingHeader.SetParentDeltaS(header.ParentDeltaS(index), index)
+}
+
+// checkPendingHeaderBaseFee makes sure that a combined pending header carries a
+// base fee, since the miner fee calculations cannot work without it
+func checkPendingHeaderBaseFee(header *types.Header) error {
+	if header == nil || header.BaseFee() == nil {
+		return ErrPendingHeaderNilBaseFee
+	}
+	return nil
+}
+
+// NewGenesisPendingHeader creates a pending header on the genesis block and
+// hands it down to the subs, each of which is bounded by the hierarchy request
+// timeout.
+func (sl *Slice) NewGenesisPendingHeader(ctx context.Context, domPendingHeader *types.Header) {
 	nodeCtx := common.NodeLocation.Context()
 	genesisHash := sl.config.GenesisHash
+	if !sl.hasMinerWorker() {
+		log.Warn("No miner worker running, cannot create the genesis pending header")
+		return
+	}
 	// Upate the local pending header
-	localPendingHeader, err := sl.miner.worker.GeneratePendingHeader(sl.hc.GetBlockByHash(genesisHash), false)
+	localPendingHeader, err := sl.miner.worker.GeneratePendingHeader(ctx, sl.hc.GetBlockByHash(genesisHash), false)
 	if err != nil {
 		return
 	}
@@ -1186,25 +2879,23 @@ func (sl *Slice) NewGenesisPendingHeader(domPendingHeader *types.Header) {
 	}
 
 	if nodeCtx != common.ZONE_CTX {
-		for _, client := range sl.subClients {
+		for _, client := range sl.getSubClients() {
 			if client != nil {
-				client.NewGenesisPendingHeader(context.Background(), domPendingHeader)
+				subCtx, cancel := sl.hierarchyRequestContext(ctx)
+				client.NewGenesisPendingHeader(subCtx, domPendingHeader)
+				cancel()
 				if err != nil {
 					return
 				}
 			}
 		}
 	}
-	genesisTermini := types.EmptyTermini()
-	for i := 0; i < len(genesisTermini.SubTermini()); i++ {
-		genesisTermini.SetSubTerminiAtIndex(genesisHash, i)
-	}
-	for i := 0; i < len(genesisTermini.DomTermini()); i++ {
-		genesisTermini.SetDomTerminiAtIndex(genesisHash, i)
-	}
+	genesisTermini := makeGenesisTermini(genesisHash)
 	if sl.hc.Empty() {
 		domPendingHeader.SetTime(uint64(time.Now().Unix()))
-		sl.phCache.Add(sl.config.GenesisHash, types.NewPendingHeader(domPendingHeader, genesisTermini))
+		sl.phCacheMu.Lock()
+		sl.writePhCache(sl.config.GenesisHash, types.NewPendingHeader(domPendingHeader, genesisTermini))
+		sl.phCacheMu.Unlock()
 	}
 }
 
@@ -1216,31 +2907,389 @@ func (sl *Slice) SubscribeMissingBlockEvent(ch chan<- types.BlockRequest) event.
 	return sl.scope.Track(sl.missingBlockFeed.Subscribe(ch))
 }
 
+// SubscribePendingEtxsEvent registers a subscription of PendingEtxsEvent.
+func (sl *Slice) SubscribePendingEtxsEvent(ch chan<- PendingEtxsEvent) event.Subscription {
+	return sl.scope.Track(sl.pendingEtxsEventFeed.Subscribe(ch))
+}
+
+// SubscribeChainHeadEvent registers a subscription of ChainHeadEvent.
+func (sl *Slice) SubscribeChainHeadEvent(ch chan<- ChainHeadEvent) event.Subscription {
+	return sl.hc.SubscribeChainHeadEvent(ch)
+}
+
+// SubscribeChainSideEvent registers a subscription of ChainSideEvent, sent for
+// the blocks which are appended without becoming the head.
+func (sl *Slice) SubscribeChainSideEvent(ch chan<- ChainSideEvent) event.Subscription {
+	return sl.hc.SubscribeChainSideEvent(ch)
+}
+
+// SubscribeHeadStalledEvent registers a subscription of HeadStalledEvent.
+func (sl *Slice) SubscribeHeadStalledEvent(ch chan<- HeadStalledEvent) event.Subscription {
+	return sl.scope.Track(sl.headStalledFeed.Subscribe(ch))
+}
+
+// SubscribeTxConfirmation notifies the given channel once the transaction is
+// included in a canonical block with at least confirmations canonical blocks on
+// top of it, counted like Confirmations, so zero notifies on inclusion. If the
+// transaction is reorged out of the canonical chain after having been
+// confirmed, a dropped notification is sent and the transaction is watched
+// again. The subscription ends when it is unsubscribed or the slice is stopped.
+func (sl *Slice) SubscribeTxConfirmation(txHash common.Hash, confirmations int, ch chan<- TxConfirmation) (event.Subscription, error) {
+	if sl.isClosed() {
+		return nil, ErrSliceClosed
+	}
+	if confirmations < 0 {
+		return nil, ErrInvalidConfirmations
+	}
+	heads := make(chan ChainHeadEvent, chainHeadChanSize)
+	headSub := sl.hc.SubscribeChainHeadEvent(heads)
+
+	return sl.scope.Track(event.NewSubscription(func(quit <-chan struct{}) error {
+		defer headSub.Unsubscribe()
+
+		var confirmed *TxConfirmation
+		notify := func() bool {
+			next, changed := sl.checkTxConfirmation(txHash, uint64(confirmations), confirmed)
+			if !changed {
+				return true
+			}
+			select {
+			case ch <- *next:
+			case <-quit:
+				return false
+			}
+			if next.Dropped {
+				confirmed = nil
+			} else {
+				confirmed = next
+			}
+			return true
+		}
+		if !notify() {
+			return nil
+		}
+		for {
+			select {
+			case <-heads:
+				if !notify() {
+					return nil
+				}
+			case err := <-headSub.Err():
+				return err
+			case <-quit:
+				return nil
+			}
+		}
+	})), nil
+}
+
+// checkTxConfirmation looks up the transaction on the canonical chain and
+// returns the notification to send if its confirmation status has changed
+// since the last notification.
+func (sl *Slice) checkTxConfirmation(txHash common.Hash, confirmations uint64, confirmed *TxConfirmation) (*TxConfirmation, bool) {
+	tx, blockHash, blockNumber, _ := rawdb.ReadTransaction(sl.sliceDb, txHash)
+	depth := -1
+	if tx != nil {
+		depth, _ = sl.Confirmations(blockHash)
+	}
+	canonical := depth >= 0
+	if confirmed != nil {
+		// Already notified, only report if the confirming block left the canonical chain
+		if canonical && blockHash == confirmed.BlockHash {
+			return nil, false
+		}
+		return &TxConfirmation{TxHash: txHash, BlockHash: confirmed.BlockHash, BlockNumber: confirmed.BlockNumber, Dropped: true}, true
+	}
+	if !canonical {
+		return nil, false
+	}
+	if uint64(depth) < confirmations {
+		return nil, false
+	}
+	return &TxConfirmation{TxHash: txHash, BlockHash: blockHash, BlockNumber: blockNumber, Confirmations: uint64(depth)}, true
+}
+
+// dialClient dials the go-quai client at the given url, and gives up with an
+// error if the connection is not established within the timeout
+func dialClient(url string, timeout time.Duration) (*quaiclient.Client, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	client, err := quaiclient.DialContext(ctx, url)
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("dial %s timed out after %v: %w", url, timeout, err)
+	}
+	return client, err
+}
+
 // MakeDomClient creates the quaiclient for the given domurl
-func makeDomClient(domurl string) *quaiclient.Client {
+func makeDomClient(domurl string, timeout time.Duration) (*quaiclient.Client, error) {
 	if domurl == "" {
-		log.Fatal("dom client url is empty")
+		return nil, errors.New("dom client url is empty")
+	}
+	domClient, err := dialClient(domurl, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to the dominant go-quai client: %w", err)
+	}
+	return domClient, nil
+}
+
+// redialClients reconnects the dom and sub clients which were never reached or
+// whose last request failed. The clients which are working are left alone, so
+// that the requests in flight on them are not cut off. A client which cannot
+// be reconnected is kept as is, so that a failed redial does not take down a
+// connection which may still recover on its own.
+func (sl *Slice) redialClients() {
+	nodeCtx := common.NodeLocation.Context()
+	if nodeCtx != common.PRIME_CTX && sl.domClientUrl != "" && !sl.domReachable() {
+		if err := sl.dialDomClient(); err != nil {
+			log.Warn("Failed to redial the dominant go-quai client", "err", err)
+		}
+	}
+	if nodeCtx != common.ZONE_CTX {
+		for i, suburl := range sl.subClientUrls {
+			if suburl == "" || i >= len(sl.subUnreachable) || (sl.getSubClient(i) != nil && atomic.LoadInt32(&sl.subUnreachable[i]) == 0) {
+				continue
+			}
+			if err := sl.dialSubClient(i); err != nil {
+				log.Warn("Failed to redial the subordinate go-quai client", "index", i, "err", err)
+			}
+		}
+	}
+}
+
+// dialDomClient dials the dom and replaces the dom client on success
+func (sl *Slice) dialDomClient() error {
+	domClient, err := dialClient(sl.domClientUrl, sl.dialTimeout)
+	if err != nil {
+		return err
+	}
+	if oldClient := sl.setDomClient(domClient); oldClient != nil {
+		oldClient.Close()
+	}
+	return nil
+}
+
+// getDomClient returns the dom client, nil until the dom is first reached. It
+// is never reset to nil once set.
+func (sl *Slice) getDomClient() *quaiclient.Client {
+	sl.clientsMu.RLock()
+	defer sl.clientsMu.RUnlock()
+	return sl.domClient
+}
+
+// setDomClient replaces the dom client and returns the previous one
+func (sl *Slice) setDomClient(client *quaiclient.Client) *quaiclient.Client {
+	sl.clientsMu.Lock()
+	defer sl.clientsMu.Unlock()
+	oldClient := sl.domClient
+	sl.domClient = client
+	return oldClient
+}
+
+// getSubClient returns the client of the sub at the given index, nil until the
+// sub is first reached. It is never reset to nil once set.
+func (sl *Slice) getSubClient(index int) *quaiclient.Client {
+	sl.clientsMu.RLock()
+	defer sl.clientsMu.RUnlock()
+	if index < 0 || index >= len(sl.subClients) {
+		return nil
+	}
+	return sl.subClients[index]
+}
+
+// getSubClients returns a copy of the sub clients
+func (sl *Slice) getSubClients() []*quaiclient.Client {
+	sl.clientsMu.RLock()
+	defer sl.clientsMu.RUnlock()
+	return append([]*quaiclient.Client(nil), sl.subClients...)
+}
+
+// setSubClient replaces the client of the sub at the given index and returns
+// the previous one
+func (sl *Slice) setSubClient(index int, client *quaiclient.Client) *quaiclient.Client {
+	sl.clientsMu.Lock()
+	defer sl.clientsMu.Unlock()
+	oldClient := sl.subClients[index]
+	sl.subClients[index] = client
+	return oldClient
+}
+
+// dialSubClient dials the sub at the given index and replaces its client on
+// success
+func (sl *Slice) dialSubClient(index int) error {
+	subClient, err := dialClient(sl.subClientUrls[index], sl.dialTimeout)
+	if err != nil {
+		return err
+	}
+	if oldClient := sl.setSubClient(index, subClient); oldClient != nil {
+		oldClient.Close()
+	}
+	return nil
+}
+
+// isConnectionError reports whether the error returned by a dom or sub client
+// is caused by the connection rather than by the remote node
+func isConnectionError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	// A dropped websocket connection surfaces as a close error of the websocket
+	msg := err.Error()
+	return strings.Contains(msg, "connection refused") || strings.Contains(msg, "connection reset") || strings.HasPrefix(msg, "websocket: close ")
+}
+
+// reconnect redials with an exponential backoff in the background until dial
+// succeeds or the slice is stopped. The flag makes sure a single reconnection
+// runs per client, requests made in the meantime fail fast on the dead client
+// instead of waiting on the reconnection.
+func (sl *Slice) reconnect(flag *int32, name string, dial func() error) {
+	if !atomic.CompareAndSwapInt32(flag, 0, 1) {
+		return
+	}
+	go func() {
+		defer atomic.StoreInt32(flag, 0)
+		backoff := sl.reconnectBackoff
+		for attempt := 1; ; attempt++ {
+			select {
+			case <-time.After(backoff):
+			case <-sl.quit:
+				return
+			}
+			err := dial()
+			if err == nil {
+				log.Info("Reconnected to the "+name+" go-quai client", "attempts", attempt)
+				return
+			}
+			log.Debug("Failed to reconnect to the "+name+" go-quai client", "attempt", attempt, "err", err)
+			backoff *= 2
+			if backoff > sl.reconnectMaxBackoff {
+				backoff = sl.reconnectMaxBackoff
+			}
+		}
+	}()
+}
+
+// recordSubResult keeps track of whether the last request to the sub at the
+// given index failed, and starts a reconnection to it if the request failed
+// because of the connection
+func (sl *Slice) recordSubResult(index int, err error) {
+	if index < 0 || index >= len(sl.subUnreachable) {
+		return
+	}
+	if err == nil {
+		atomic.StoreInt32(&sl.subUnreachable[index], 0)
+		return
+	}
+	atomic.StoreInt32(&sl.subUnreachable[index], 1)
+	if isConnectionError(err) && index < len(sl.subReconnecting) && index < len(sl.subClientUrls) && sl.subClientUrls[index] != "" {
+		sl.reconnect(&sl.subReconnecting[index], "subordinate", func() error { return sl.dialSubClient(index) })
 	}
-	domClient, err := quaiclient.Dial(domurl)
+}
+
+// regeneratePendingHeader generates a new pending header on the current head
+// and sends it to the miner. Only a zone running the state processor has a
+// miner to deliver to, the other contexts regenerate on their next append.
+func (sl *Slice) regeneratePendingHeader() {
+	if common.NodeLocation.Context() != common.ZONE_CTX || !sl.ProcessingState() || !sl.hasMinerWorker() {
+		return
+	}
+	block := sl.hc.GetBlockByHash(sl.hc.CurrentHeader().Hash())
+	if block == nil {
+		return
+	}
+	localPendingHeader, err := sl.miner.worker.GeneratePendingHeader(context.Background(), block, true)
 	if err != nil {
-		log.Fatal("Error connecting to the dominant go-quai client", "err", err)
+		log.Warn("Failed to regenerate the pending header", "err", err)
+		return
+	}
+	sl.phCacheMu.Lock()
+	sl.updatePhCache(types.PendingHeader{}, true, localPendingHeader, true, common.NodeLocation)
+	sl.phCacheMu.Unlock()
+	if bestPh, exists := sl.readBestPh(); exists {
+		sl.sendPendingHeaderToMiner(bestPh)
+	}
+}
+
+// ReorgTo forces the head of the slice onto the appended block with the given
+// hash, to move a node off a bad branch without a resync. The state and the
+// canonical chain are moved to the block, the best pending header key is set
+// to its dom terminus, and after the chain head event is sent the pending
+// header is regenerated for the miner. Only a zone, which holds the state, can
+// be forced. Unknown blocks, blocks without termini, bad blocks and reorgs
+// deeper than the maximum reorg depth are rejected.
+func (sl *Slice) ReorgTo(hash common.Hash) error {
+	if nodeCtx := common.NodeLocation.Context(); nodeCtx != common.ZONE_CTX {
+		return fmt.Errorf("%w: cannot force the head in context %d", ErrInvalidContext, nodeCtx)
+	}
+
+	sl.appendMu.Lock()
+	defer sl.appendMu.Unlock()
+
+	block := sl.hc.GetBlockByHash(hash)
+	if block == nil {
+		return ErrBlockNotFound
 	}
-	return domClient
+	termini := sl.hc.GetTerminiByHash(hash)
+	if termini == nil {
+		return ErrTerminiNotFound
+	}
+	if sl.IsBlockHashABadHash(hash) {
+		return ErrBadBlockHash
+	}
+	if err := sl.checkReorgDepth(block.Header()); err != nil {
+		return err
+	}
+
+	log.Warn("Forcing the head", "from", sl.hc.CurrentHeader().Hash(), "to", hash, "number", block.Header().NumberArray())
+	if err := sl.hc.SetCurrentState(block.Header()); err != nil {
+		return err
+	}
+	if err := sl.hc.SetCurrentHeader(block.Header()); err != nil {
+		return err
+	}
+	sl.phCacheMu.Lock()
+	sl.WriteBestPhKey(termini.DomTerminus())
+	sl.phCacheMu.Unlock()
+	reorgCounter.Inc(1)
+
+	sl.hc.chainHeadFeed.Send(ChainHeadEvent{Block: block})
+	sl.regeneratePendingHeader()
+	return nil
+}
+
+// recoverStalledHead notifies the head stalled subscribers and attempts to
+// get the head moving again by reconnecting to the hierarchy and regenerating
+// the pending header.
+func (sl *Slice) recoverStalledHead(stalledFor time.Duration) {
+	head := sl.hc.CurrentHeader()
+	log.Warn("Head stalled, attempting recovery", "hash", head.Hash(), "number", head.NumberArray(), "stalled for", common.PrettyDuration(stalledFor))
+	sl.headStalledFeed.Send(HeadStalledEvent{Head: head, StalledFor: stalledFor})
+	sl.redialClients()
+	sl.regeneratePendingHeader()
 }
 
 // MakeSubClients creates the quaiclient for the given suburls
-func makeSubClients(suburls []string) []*quaiclient.Client {
+func makeSubClients(suburls []string, timeout time.Duration) ([]*quaiclient.Client, []error) {
 	subClients := make([]*quaiclient.Client, 3)
+	errs := make([]error, 3)
 	for i, suburl := range suburls {
+		if i >= len(subClients) {
+			break
+		}
 		if suburl != "" {
-			subClient, err := quaiclient.Dial(suburl)
+			subClient, err := dialClient(suburl, timeout)
 			if err != nil {
-				log.Fatal("Error connecting to the subordinate go-quai client for index", "index", i, " err ", err)
+				errs[i] = fmt.Errorf("error connecting to the subordinate go-quai client for index %d: %w", i, err)
+				continue
 			}
 			subClients[i] = subClient
 		}
 	}
-	return subClients
+	return subClients, errs
 }
 
 // loadLastState loads the phCache and the slice pending header hash from the db.
@@ -1258,25 +3307,186 @@ func (sl *Slice) loadLastState() error {
 }
 
 // Stop stores the phCache and the sl.pendingHeader hash value to the db.
-func (sl *Slice) Stop() {
+// The appends in flight are cancelled and waited for first, so that none of
+// them writes after the state is persisted. Every shutdown step is run even if
+// an earlier one fails, and the failures are returned together.
+func (sl *Slice) Stop() error {
+	if !atomic.CompareAndSwapInt32(&sl.closed, 0, 1) {
+		return nil
+	}
 	nodeCtx := common.NodeLocation.Context()
 
-	var badHashes []common.Hash
-	for hash := range sl.badHashesCache {
-		badHashes = append(badHashes, hash)
+	sl.beginShutdown()
+	sl.cancelInFlightAppends()
+	sl.appendWg.Wait()
+	sl.backgroundCancel()
+	sl.relayWg.Wait()
+
+	var errs []error
+	if err := sl.flushAppendBatch(); err != nil {
+		log.Error("Failed to commit the accumulated append batch on stop", "err", err)
+		errs = append(errs, fmt.Errorf("append batch: %w", err))
+	}
+	if err := sl.persistState(); err != nil {
+		if sl.currentSettings().retryPersistOnStop {
+			log.Warn("Failed to persist slice state on stop, retrying", "err", err)
+			err = sl.persistState()
+		}
+		if err != nil {
+			log.Error("Failed to persist slice state on stop, the pending header state will be rebuilt on restart", "err", err)
+			errs = append(errs, fmt.Errorf("slice state: %w", err))
+		}
 	}
-	rawdb.WriteBadHashesList(sl.sliceDb, badHashes)
-	sl.miner.worker.StorePendingBlockBody()
 
 	sl.scope.Close()
 	close(sl.quit)
+	sl.wg.Wait()
 
 	sl.hc.Stop()
 	if nodeCtx == common.ZONE_CTX && sl.ProcessingState() {
-		sl.asyncPhSub.Unsubscribe()
+		if sl.asyncPhSub != nil {
+			sl.asyncPhSub.Unsubscribe()
+		}
 		sl.txPool.Stop()
 	}
 	sl.miner.Stop()
+	return errors.Join(errs...)
+}
+
+// StopContext stops the slice gracefully. New appends are rejected with
+// ErrShuttingDown, and the appends in flight are waited for until ctx is done
+// before the slice is stopped and its state persisted. If ctx is done first,
+// the appends still in flight are cancelled, the slice is stopped anyway and
+// the context error is returned along with any error of Stop.
+func (sl *Slice) StopContext(ctx context.Context) error {
+	return errors.Join(sl.drainAppends(ctx), sl.Stop())
+}
+
+// drainAppends begins the graceful shutdown, after which new appends are
+// rejected, and waits for the appends in flight until ctx is done. The appends
+// still in flight then are cancelled and waited for.
+func (sl *Slice) drainAppends(ctx context.Context) error {
+	sl.beginShutdown()
+
+	drained := make(chan struct{})
+	go func() {
+		sl.appendWg.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+	}
+	log.Warn("Cancelling the appends still in flight", "err", ctx.Err())
+	sl.cancelInFlightAppends()
+	<-drained
+	return fmt.Errorf("draining appends: %w", ctx.Err())
+}
+
+// beginShutdown rejects the appends started from now on. No append is added to
+// appendWg afterwards, so it can be waited for.
+func (sl *Slice) beginShutdown() {
+	sl.shutdownMu.Lock()
+	sl.shuttingDown = true
+	sl.shutdownMu.Unlock()
+}
+
+// beginInFlightAppend registers an append with the in-flight appends, and
+// returns false if the shutdown has begun. The returned context is cancelled
+// if the shutdown cannot wait for the append, and the returned function must
+// be called once the append is over.
+func (sl *Slice) beginInFlightAppend(ctx context.Context) (context.Context, func(), bool) {
+	sl.shutdownMu.Lock()
+	defer sl.shutdownMu.Unlock()
+	if sl.shuttingDown {
+		return ctx, nil, false
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	if sl.appendCancels == nil {
+		sl.appendCancels = make(map[uint64]context.CancelFunc)
+	}
+	seq := sl.appendSeq
+	sl.appendSeq++
+	sl.appendCancels[seq] = cancel
+	sl.appendWg.Add(1)
+
+	return ctx, func() {
+		sl.shutdownMu.Lock()
+		delete(sl.appendCancels, seq)
+		sl.shutdownMu.Unlock()
+		cancel()
+		sl.appendWg.Done()
+	}, true
+}
+
+// cancelInFlightAppends cancels the contexts of the appends in flight
+func (sl *Slice) cancelInFlightAppends() {
+	sl.shutdownMu.Lock()
+	defer sl.shutdownMu.Unlock()
+	for _, cancel := range sl.appendCancels {
+		cancel()
+	}
+}
+
+// persistState writes the state which is only kept in memory while the slice is
+// running, i.e. the bad hashes, the best pending header and the pending block
+// bodies of the worker, to the db in a single batch.
+func (sl *Slice) persistState() error {
+	batch := sl.sliceDb.NewBatch()
+
+	var badHashes []common.Hash
+	for hash := range sl.badHashesCache {
+		badHashes = append(badHashes, hash)
+	}
+	rawdb.WriteBadHashesList(batch, badHashes)
+
+	sl.phCacheMu.RLock()
+	rawdb.WriteBestPhKey(batch, sl.bestPhKey)
+	if bestPh, exists := sl.readPhCache(sl.bestPhKey); exists {
+		rawdb.WritePendingHeader(batch, sl.bestPhKey, bestPh)
+	}
+	sl.phCacheMu.RUnlock()
+	sl.miner.worker.StorePendingBlockBody(batch)
+
+	return batch.Write()
+}
+
+// Healthy reports whether the slice is in a healthy state along with the
+// reasons for any failed health check. The checks only inspect in memory state
+// so that it is cheap to be polled by a health endpoint.
+func (sl *Slice) Healthy() (bool, []string) {
+	nodeCtx := common.NodeLocation.Context()
+	var reasons []string
+
+	if nodeCtx != common.PRIME_CTX {
+		if sl.getDomClient() == nil {
+			reasons = append(reasons, "dom disconnected")
+		} else if atomic.LoadInt32(&sl.domUnreachable) == 1 {
+			reasons = append(reasons, "dom unreachable")
+		}
+	}
+
+	currentHeader := sl.hc.CurrentHeader()
+	if currentHeader == nil {
+		reasons = append(reasons, "current head missing")
+	} else if currentHeader.Hash() != sl.config.GenesisHash {
+		headTime := time.Unix(int64(currentHeader.Time()), 0)
+		if headStallTimeout := sl.currentSettings().headStallTimeout; time.Since(headTime) > headStallTimeout {
+			reasons = append(reasons, fmt.Sprintf("head stalled >%v", headStallTimeout))
+		}
+	}
+
+	if _, exists := sl.readBestPh(); !exists {
+		reasons = append(reasons, "phCache head missing")
+	}
+
+	return len(reasons) == 0, reasons
+}
+
+// isClosed returns true once the slice has been stopped
+func (sl *Slice) isClosed() bool {
+	return atomic.LoadInt32(&sl.closed) == 1
 }
 
 func (sl *Slice) Config() *params.ChainConfig { return sl.config }
@@ -1287,28 +3497,61 @@ func (sl *Slice) HeaderChain() *HeaderChain { return sl.hc }
 
 func (sl *Slice) TxPool() *TxPool { return sl.txPool }
 
+// ResetTxPool re-validates the tx pool against the current head. The pool
+// follows the chain head events, this is needed after the head is moved
+// without one.
+func (sl *Slice) ResetTxPool() error {
+	if sl.txPool == nil {
+		return ErrTxPoolNotRunning
+	}
+	return sl.txPool.ResetHead(sl.hc.CurrentHeader())
+}
+
 func (sl *Slice) Miner() *Miner { return sl.miner }
 
 func (sl *Slice) CurrentInfo(header *types.Header) bool {
+	if !sl.hasMinerWorker() {
+		return false
+	}
 	return sl.miner.worker.CurrentInfo(header)
 }
 
 func (sl *Slice) WriteBlock(block *types.Block) {
+	if sl.isClosed() {
+		return
+	}
 	sl.hc.WriteBlock(block)
 }
 
 func (sl *Slice) AddPendingEtxs(pEtxs types.PendingEtxs) error {
+	if sl.isClosed() {
+		return ErrSliceClosed
+	}
 	nodeCtx := common.NodeLocation.Context()
-	if err := sl.hc.AddPendingEtxs(pEtxs); err == nil || err.Error() != ErrPendingEtxAlreadyKnown.Error() {
+	err := sl.hc.AddPendingEtxs(pEtxs)
+	if IsAppendError(err, ErrPendingEtxNotValid) {
+		// Never relay pending etxs which were rejected
+		return err
+	}
+	if err == nil || !IsAppendError(err, ErrPendingEtxAlreadyKnown) {
+		// Notify the subscribers only once the new set has been written
+		if err == nil {
+			sl.pendingEtxsEventFeed.Send(PendingEtxsEvent{Header: pEtxs.Header.Hash(), Count: len(pEtxs.Etxs)})
+		}
 		// Only in the region case we have to send the pendingEtxs to dom from the AddPendingEtxs
 		if nodeCtx == common.REGION_CTX {
 			// Also the first time when adding the pending etx broadcast it to the peers
 			sl.pendingEtxsFeed.Send(pEtxs)
-			if sl.domClient != nil {
-				sl.domClient.SendPendingEtxsToDom(context.Background(), pEtxs)
+			if sl.getDomClient() != nil {
+				ctx, cancel := context.WithTimeout(context.Background(), c_pEtxQueueTimeout)
+				if err := sl.queuePendingEtxsToDom(ctx, pEtxs); err != nil {
+					log.Warn("Failed to queue the pending etxs for the dom, the dom has to request them", "hash", pEtxs.Header.Hash(), "err", err)
+				}
+				cancel()
 			}
 		}
-	} else if err.Error() == ErrPendingEtxAlreadyKnown.Error() {
+	} else if IsAppendError(err, ErrPendingEtxAlreadyKnown) {
+		pEtxDuplicateCounter.Inc(1)
 		return nil
 	} else {
 		return err
@@ -1317,6 +3560,9 @@ func (sl *Slice) AddPendingEtxs(pEtxs types.PendingEtxs) error {
 }
 
 func (sl *Slice) AddPendingEtxsRollup(pEtxsRollup types.PendingEtxsRollup) error {
+	if sl.isClosed() {
+		return ErrSliceClosed
+	}
 	if !pEtxsRollup.IsValid(trie.NewStackTrie(nil)) {
 		log.Info("PendingEtxRollup is invalid")
 		return ErrPendingEtxRollupNotValid
@@ -1336,8 +3582,8 @@ func (sl *Slice) AddPendingEtxsRollup(pEtxsRollup types.PendingEtxsRollup) error
 			sl.pendingEtxsRollupFeed.Send(pEtxsRollup)
 			// Only in the region case, send the pending etx rollup to the dom
 		} else if nodeCtx == common.REGION_CTX {
-			if sl.domClient != nil {
-				sl.domClient.SendPendingEtxsRollupToDom(context.Background(), pEtxsRollup)
+			if sl.getDomClient() != nil {
+				sl.recordDomResult(sl.getDomClient().SendPendingEtxsRollupToDom(context.Background(), pEtxsRollup))
 			}
 		}
 	}
@@ -1375,13 +3621,17 @@ func (sl *Slice) SetHeadBackToRecoveryState(pendingHeader *types.Header, hash co
 	nodeCtx := common.NodeLocation.Context()
 	if nodeCtx == common.PRIME_CTX {
 		localPendingHeaderWithTermini := sl.ComputeRecoveryPendingHeader(hash)
-		sl.phCache.Add(hash, localPendingHeaderWithTermini)
+		sl.phCacheMu.Lock()
+		sl.writePhCache(hash, localPendingHeaderWithTermini)
+		sl.phCacheMu.Unlock()
 		sl.GenerateRecoveryPendingHeader(localPendingHeaderWithTermini.Header(), localPendingHeaderWithTermini.Termini())
 	} else {
 		localPendingHeaderWithTermini := sl.ComputeRecoveryPendingHeader(hash)
 		localPendingHeaderWithTermini.SetHeader(sl.combinePendingHeader(localPendingHeaderWithTermini.Header(), pendingHeader, nodeCtx, true))
 		localPendingHeaderWithTermini.Header().SetLocation(common.NodeLocation)
-		sl.phCache.Add(hash, localPendingHeaderWithTermini)
+		sl.phCacheMu.Lock()
+		sl.writePhCache(hash, localPendingHeaderWithTermini)
+		sl.phCacheMu.Unlock()
 		return localPendingHeaderWithTermini
 	}
 	return types.PendingHeader{}
@@ -1405,6 +3655,7 @@ func (sl *Slice) cleanCacheAndDatabaseTillBlock(hash common.Hash) {
 	sl.hc.numberCache.Purge()
 	sl.hc.pendingEtxsRollup.Purge()
 	sl.hc.pendingEtxs.Purge()
+	sl.hc.subRollupCache.Purge()
 	rawdb.DeleteAllHeadsHashes(sl.sliceDb)
 	// bodydb caches
 	sl.hc.bc.blockCache.Purge()
@@ -1429,6 +3680,7 @@ func (sl *Slice) cleanCacheAndDatabaseTillBlock(hash common.Hash) {
 			}
 			rawdb.DeletePendingEtxs(sl.sliceDb, header.Hash())
 			rawdb.DeletePendingEtxsRollup(sl.sliceDb, header.Hash())
+			rawdb.DeleteEtxRollup(sl.sliceDb, header.Hash())
 		}
 		// delete the trie node for a given root of the header
 		rawdb.DeleteTrieNode(sl.sliceDb, header.Root())
@@ -1450,21 +3702,27 @@ func (sl *Slice) cleanCacheAndDatabaseTillBlock(hash common.Hash) {
 	if nodeCtx == common.ZONE_CTX && sl.ProcessingState() {
 		sl.hc.bc.processor.snaps, _ = snapshot.New(sl.sliceDb, sl.hc.bc.processor.stateCache.TrieDB(), sl.hc.bc.processor.cacheConfig.SnapshotLimit, currentHeader.Root(), true, true)
 	}
+	// The head was moved without a chain head event
+	if sl.txPool != nil {
+		if err := sl.ResetTxPool(); err != nil {
+			log.Warn("Failed to reset the tx pool", "err", err)
+		}
+	}
 }
 
 func (sl *Slice) GenerateRecoveryPendingHeader(pendingHeader *types.Header, checkPointHashes types.Termini) error {
 	nodeCtx := common.NodeLocation.Context()
 	if nodeCtx == common.PRIME_CTX {
 		for i := 0; i < common.NumRegionsInPrime; i++ {
-			if sl.subClients[i] != nil {
-				sl.subClients[i].GenerateRecoveryPendingHeader(context.Background(), pendingHeader, checkPointHashes)
+			if sl.getSubClient(i) != nil {
+				sl.getSubClient(i).GenerateRecoveryPendingHeader(context.Background(), pendingHeader, checkPointHashes)
 			}
 		}
 	} else if nodeCtx == common.REGION_CTX {
 		newPendingHeader := sl.SetHeadBackToRecoveryState(pendingHeader, checkPointHashes.SubTerminiAtIndex(common.NodeLocation.Region()))
 		for i := 0; i < common.NumZonesInRegion; i++ {
-			if sl.subClients[i] != nil {
-				sl.subClients[i].GenerateRecoveryPendingHeader(context.Background(), newPendingHeader.Header(), newPendingHeader.Termini())
+			if sl.getSubClient(i) != nil {
+				sl.getSubClient(i).GenerateRecoveryPendingHeader(context.Background(), newPendingHeader.Header(), newPendingHeader.Termini())
 			}
 		}
 	} else {
@@ -1478,7 +3736,7 @@ func (sl *Slice) GenerateRecoveryPendingHeader(pendingHeader *types.Header, chec
 // termini
 func (sl *Slice) ComputeRecoveryPendingHeader(hash common.Hash) types.PendingHeader {
 	block := sl.hc.GetBlockByHash(hash)
-	pendingHeader, err := sl.miner.worker.GeneratePendingHeader(block, false)
+	pendingHeader, err := sl.miner.worker.GeneratePendingHeader(context.Background(), block, false)
 	if err != nil {
 		log.Error("Error generating pending header during the checkpoint recovery process")
 		return types.PendingHeader{}
@@ -0,0 +1,2932 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dominant-strategies/go-quai/common"
+	"github.com/dominant-strategies/go-quai/consensus"
+	"github.com/dominant-strategies/go-quai/core/rawdb"
+	"github.com/dominant-strategies/go-quai/core/state"
+	"github.com/dominant-strategies/go-quai/core/types"
+	"github.com/dominant-strategies/go-quai/core/vm"
+	"github.com/dominant-strategies/go-quai/ethdb"
+	"github.com/dominant-strategies/go-quai/log"
+	"github.com/dominant-strategies/go-quai/params"
+	"github.com/dominant-strategies/go-quai/quaiclient"
+	"github.com/dominant-strategies/go-quai/rpc"
+	"github.com/dominant-strategies/go-quai/trie"
+	expireLru "github.com/hnlq715/golang-lru"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+)
+
+// testEngine is a consensus engine accepting every header. The order of a
+// block is the node context unless set otherwise, and the total entropy of a
+// block is its parent entropy plus its difficulty, so that the tests choose
+// which block is heavier through the difficulty.
+type testEngine struct {
+	mu       sync.Mutex
+	orders   map[common.Hash]int
+	sealErrs map[common.Hash]error
+	seals    int           // Number of seals verified
+	sealCost time.Duration // Time taken to compute the proof of work of a header whose pow values are not loaded
+}
+
+func newTestEngine() *testEngine {
+	return &testEngine{orders: make(map[common.Hash]int), sealErrs: make(map[common.Hash]error)}
+}
+
+// setOrder sets the order CalcOrder returns for the header
+func (e *testEngine) setOrder(header *types.Header, order int) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.orders[header.Hash()] = order
+}
+
+// setSealErr makes the seal of the header invalid
+func (e *testEngine) setSealErr(header *types.Header, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.sealErrs[header.Hash()] = err
+}
+
+// setSealCost sets the time taken to compute the proof of work of a header
+func (e *testEngine) setSealCost(cost time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.sealCost = cost
+}
+
+func (e *testEngine) sealsVerified() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.seals
+}
+
+func (e *testEngine) Author(header *types.Header) (common.Address, error) {
+	return header.Coinbase(), nil
+}
+
+func (e *testEngine) IntrinsicLogS(powHash common.Hash) *big.Int { return big.NewInt(0) }
+
+func (e *testEngine) CalcOrder(header *types.Header) (*big.Int, int, error) {
+	if header.NumberU64() == 0 {
+		return big.NewInt(0), common.PRIME_CTX, nil
+	}
+	if _, err := e.VerifySeal(header); err != nil {
+		return big.NewInt(0), -1, err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if order, ok := e.orders[header.Hash()]; ok {
+		return header.Difficulty(), order, nil
+	}
+	return header.Difficulty(), common.NodeLocation.Context(), nil
+}
+
+func (e *testEngine) TotalLogS(header *types.Header) *big.Int {
+	return new(big.Int).Add(header.ParentEntropy(common.NodeLocation.Context()), header.Difficulty())
+}
+
+func (e *testEngine) TotalLogPhS(header *types.Header) *big.Int {
+	return header.ParentEntropy(common.NodeLocation.Context())
+}
+
+func (e *testEngine) DeltaLogS(header *types.Header) *big.Int { return big.NewInt(0) }
+
+func (e *testEngine) ComputePowLight(header *types.Header) (common.Hash, common.Hash) {
+	return common.Hash{}, header.Hash()
+}
+
+func (e *testEngine) VerifyHeader(chain consensus.ChainHeaderReader, header *types.Header) error {
+	return nil
+}
+
+func (e *testEngine) VerifyHeaders(chain consensus.ChainHeaderReader, headers []*types.Header) (chan<- struct{}, <-chan error) {
+	abort, results := make(chan struct{}), make(chan error, len(headers))
+	for range headers {
+		results <- nil
+	}
+	return abort, results
+}
+
+func (e *testEngine) VerifyUncles(chain consensus.ChainReader, block *types.Block) error { return nil }
+
+func (e *testEngine) Prepare(chain consensus.ChainHeaderReader, header *types.Header, parent *types.Header) error {
+	return nil
+}
+
+func (e *testEngine) Finalize(chain consensus.ChainHeaderReader, header *types.Header, state *state.StateDB, txs []*types.Transaction, uncles []*types.Header) {
+}
+
+func (e *testEngine) FinalizeAndAssemble(chain consensus.ChainHeaderReader, header *types.Header, state *state.StateDB, txs []*types.Transaction, uncles []*types.Header, etxs []*types.Transaction, manifest types.BlockManifest, receipts []*types.Receipt) (*types.Block, error) {
+	return types.NewBlock(header, txs, uncles, etxs, manifest, receipts, trie.NewStackTrie(nil)), nil
+}
+
+func (e *testEngine) Hashrate() float64 { return 0 }
+
+func (e *testEngine) Seal(header *types.Header, results chan<- *types.Header, stop <-chan struct{}) error {
+	return nil
+}
+
+func (e *testEngine) CalcDifficulty(chain consensus.ChainHeaderReader, parent *types.Header) *big.Int {
+	return big.NewInt(1)
+}
+
+func (e *testEngine) IsDomCoincident(chain consensus.ChainHeaderReader, header *types.Header) bool {
+	_, order, err := e.CalcOrder(header)
+	return err == nil && order < common.NodeLocation.Context()
+}
+
+// VerifySeal computes the proof of work of the header, unless its pow values
+// are already loaded, the way the engine does
+func (e *testEngine) VerifySeal(header *types.Header) (common.Hash, error) {
+	e.mu.Lock()
+	e.seals++
+	err, cost := e.sealErrs[header.Hash()], e.sealCost
+	e.mu.Unlock()
+	if cost > 0 && header.PowHash.Load() == nil {
+		time.Sleep(cost)
+		header.PowDigest.Store(header.MixHash())
+		header.PowHash.Store(header.Hash())
+	}
+	return header.Hash(), err
+}
+
+func (e *testEngine) APIs(chain consensus.ChainHeaderReader) []rpc.API { return nil }
+
+func (e *testEngine) Close() error { return nil }
+
+// errTestWrite is returned by the batches of a testDb while its writes fail
+var errTestWrite = errors.New("test batch write failure")
+
+// testDb is a memory database whose batch writes can be made to fail, and
+// which counts its reads
+type testDb struct {
+	ethdb.Database
+	failWrites int32
+	reads      int64
+}
+
+func (db *testDb) Get(key []byte) ([]byte, error) {
+	atomic.AddInt64(&db.reads, 1)
+	return db.Database.Get(key)
+}
+
+func (db *testDb) NewBatch() ethdb.Batch {
+	return &testBatch{Batch: db.Database.NewBatch(), db: db}
+}
+
+type testBatch struct {
+	ethdb.Batch
+	db *testDb
+}
+
+func (b *testBatch) Write() error {
+	if atomic.LoadInt32(&b.db.failWrites) == 1 {
+		return errTestWrite
+	}
+	return b.Batch.Write()
+}
+
+// testChain is a slice started from a fresh genesis on the test engine
+type testChain struct {
+	sl      *Slice
+	db      *testDb
+	engine  *testEngine
+	genesis *types.Block
+	dom     *testDomAPI // Dom of a chain started with newTestSubChain
+}
+
+// newTestChain starts a slice at the node location, which the caller sets.
+// Outside of prime the dom client is dialed at domUrl in the background, the
+// tests wait for it with waitForDomClient or set their own.
+func newTestChain(t testing.TB, config *Config, domUrl string, subUrls []string) *testChain {
+	t.Helper()
+	db := &testDb{Database: rawdb.NewMemoryDatabase()}
+	chainConfig := *params.TestChainConfig
+	chainConfig.Location = common.NodeLocation
+	genesis := &Genesis{Config: &chainConfig, Difficulty: big.NewInt(1), GasLimit: params.GenesisGasLimit}
+	genesisBlock := genesis.MustCommit(db)
+	chainConfig.GenesisHash = genesisBlock.Hash()
+
+	if config == nil {
+		config = &Config{}
+	}
+	if config.Etherbase.Equal(common.ZeroAddr) {
+		config.Etherbase = common.HexToAddress("0x0000000000000000000000000000000000000001")
+	}
+	// The default extra data reads the VERSION file of the working directory
+	if len(config.ExtraData) == 0 {
+		config.ExtraData = []byte("test")
+	}
+	// The tests append the parents first, waiting on them only slows down the
+	// tests of unknown parents
+	if config.ParentGraceWindow == 0 {
+		config.ParentGraceWindow = -1
+	}
+	engine := newTestEngine()
+	sl, err := NewSlice(db, config, &TxPoolConfig{}, nil, func(*types.Header) bool { return false }, &chainConfig, nil, domUrl, subUrls, engine, &CacheConfig{}, vm.Config{}, genesis)
+	if err != nil {
+		t.Fatalf("failed to create the slice: %v", err)
+	}
+	t.Cleanup(func() { sl.Stop() })
+
+	// Prime creates the genesis pending header on start and hands it down, the
+	// dom of the other contexts is played here
+	if common.NodeLocation.Context() != common.PRIME_CTX {
+		sl.NewGenesisPendingHeader(context.Background(), types.EmptyHeader())
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		sl.phCacheMu.Lock()
+		_, exists := sl.readPhCache(genesisBlock.Hash())
+		sl.phCacheMu.Unlock()
+		if exists {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("genesis pending header not created")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return &testChain{sl: sl, db: db, engine: engine, genesis: genesisBlock}
+}
+
+// waitForDomClient waits for the background dial of the dom client
+func (tc *testChain) waitForDomClient(t testing.TB) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for tc.sl.getDomClient() == nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("dom client not dialed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// newBlock returns a block without transactions on top of parent, with the given
+// difficulty. The fork byte tells apart the blocks of competing forks.
+func (tc *testChain) newBlock(parent *types.Header, difficulty int64, fork byte) *types.Block {
+	nodeCtx := common.NodeLocation.Context()
+	header := types.EmptyHeader()
+	header.SetParentHash(parent.Hash(), nodeCtx)
+	header.SetNumber(new(big.Int).SetUint64(parent.NumberU64()+1), nodeCtx)
+	// The blocks of a region are mined in its first zone
+	location := common.NodeLocation
+	if nodeCtx == common.REGION_CTX {
+		location = common.Location{byte(common.NodeLocation.Region()), 0}
+	}
+	header.SetLocation(location)
+	header.SetTime(parent.Time() + 1)
+	header.SetDifficulty(big.NewInt(difficulty))
+	header.SetGasLimit(parent.GasLimit())
+	// The sub contexts of the block carry at least the entropy of this one
+	for ctx := nodeCtx; ctx < common.HierarchyDepth; ctx++ {
+		header.SetParentEntropy(tc.engine.TotalLogS(parent), ctx)
+	}
+	header.SetExtra([]byte{fork})
+	// The manifest of the parent is computed the way the miner does, so that
+	// blocks can be built ahead of the append of their parent
+	if nodeCtx != common.PRIME_CTX {
+		header.SetManifestHash(tc.sl.miner.worker.ComputeManifestHash(parent), nodeCtx)
+	}
+	// Every block of the sub is coincident with this chain, so the sub manifest
+	// only holds the parent
+	var subManifest types.BlockManifest
+	if nodeCtx != common.ZONE_CTX {
+		subManifest = types.BlockManifest{parent.Hash()}
+		header.SetManifestHash(types.DeriveSha(subManifest, trie.NewStackTrie(nil)), nodeCtx+1)
+	}
+	return types.NewBlock(header, nil, nil, nil, subManifest, nil, trie.NewStackTrie(nil))
+}
+
+// appendBlock appends the block the way a block received from the network is
+// appended, with the block written ahead of the append
+func (tc *testChain) appendBlock(ctx context.Context, block *types.Block) (types.Transactions, bool, bool, error) {
+	tc.sl.WriteBlock(block)
+	return tc.sl.Append(ctx, block.Header(), types.EmptyHeader(), common.Hash{}, false, nil)
+}
+
+// appendChain appends n blocks of difficulty 1 on top of parent and returns
+// their headers
+func (tc *testChain) appendChain(t testing.TB, parent *types.Header, n int, fork byte) []*types.Header {
+	t.Helper()
+	headers := make([]*types.Header, 0, n)
+	for i := 0; i < n; i++ {
+		block := tc.newBlock(parent, 1, fork)
+		if _, _, _, err := tc.appendBlock(context.Background(), block); err != nil {
+			t.Fatalf("failed to append block %d: %v", i, err)
+		}
+		parent = block.Header()
+		headers = append(headers, parent)
+	}
+	return headers
+}
+
+// newTestDom serves the dom api of a zone over http and returns its url
+func newTestDom(t testing.TB, api interface{}) string {
+	t.Helper()
+	server := rpc.NewServer()
+	if err := server.RegisterName("quai", api); err != nil {
+		t.Fatalf("failed to register the dom api: %v", err)
+	}
+	dom := httptest.NewServer(server)
+	t.Cleanup(func() {
+		dom.Close()
+		server.Stop()
+	})
+	return dom.URL
+}
+
+// setTestLocation sets the node location for the rest of the test. It is
+// restored once the slices started afterwards are stopped, so that their
+// relays to the subs never see the location of the next test.
+func setTestLocation(t testing.TB, location common.Location) {
+	t.Helper()
+	restore := common.NodeLocation
+	t.Cleanup(func() { common.NodeLocation = restore })
+	common.NodeLocation = location
+}
+
+// newTestSubChain starts a zone or a region on a dom answering the pending etx
+// sends, and waits for its dom client. The caller sets the node location.
+func newTestSubChain(t testing.TB, config *Config, subUrls []string) *testChain {
+	t.Helper()
+	api := &testDomAPI{received: make(chan common.Hash, 1024)}
+	tc := newTestChain(t, config, newTestDom(t, api), subUrls)
+	tc.dom = api
+	tc.waitForDomClient(t)
+	return tc
+}
+
+// drainDom discards the pending etxs received by the dom of the chain until the
+// returned function is called, so that a long run of appends never fills it
+func (tc *testChain) drainDom() (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-tc.dom.received:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// newTestCore returns a core running the append queue of the chain
+func newTestCore(tc *testChain) *Core {
+	appendQueue, _ := expireLru.New(c_maxAppendQueue)
+	processingCache, _ := expireLru.NewWithExpire(c_processingCache, time.Minute)
+	c := &Core{sl: tc.sl, engine: tc.engine, appendQueue: appendQueue, processingCache: processingCache, normalListBackoff: 1, quit: make(chan struct{})}
+	return c
+}
+
+// toggleDom serves the dom api over http, answering every request with an
+// http error while it is down
+type toggleDom struct {
+	down    int32
+	handler http.Handler
+}
+
+func (d *toggleDom) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if atomic.LoadInt32(&d.down) == 1 {
+		http.Error(w, "dom down", http.StatusServiceUnavailable)
+		return
+	}
+	d.handler.ServeHTTP(w, r)
+}
+
+func TestInsertChainDoesNotWaitForDom(t *testing.T) {
+	defer func(location common.Location) { common.NodeLocation = location }(common.NodeLocation)
+	common.NodeLocation = common.Location{0, 0}
+
+	api := &testDomAPI{received: make(chan common.Hash, 16)}
+	server := rpc.NewServer()
+	defer server.Stop()
+	if err := server.RegisterName("quai", api); err != nil {
+		t.Fatalf("failed to register the dom api: %v", err)
+	}
+	dom := &toggleDom{down: 1, handler: server}
+	httpDom := httptest.NewServer(dom)
+	defer httpDom.Close()
+
+	tc := newTestChain(t, nil, httpDom.URL, nil)
+	tc.waitForDomClient(t)
+	c := newTestCore(tc)
+
+	block := tc.newBlock(tc.genesis.Header(), 1, 0)
+	rawdb.WriteBody(tc.sl.sliceDb, block.Hash(), block.NumberU64(), block.Body())
+	start := time.Now()
+	if n, err := c.InsertChain(types.Blocks{block}); err != nil || n != 1 {
+		t.Fatalf("insert failed: have %d, %v, want 1, nil", n, err)
+	}
+	// The send is left to the retries of the send loop
+	if elapsed := time.Since(start); elapsed >= c_pEtxSendBackoff {
+		t.Fatalf("insert waited on the dom: took %v", elapsed)
+	}
+	if have := tc.sl.hc.CurrentHeader().Hash(); have != block.Hash() {
+		t.Fatalf("head mismatch: have %x, want %x", have, block.Hash())
+	}
+
+	atomic.StoreInt32(&dom.down, 0)
+	select {
+	case have := <-api.received:
+		if have != block.Hash() {
+			t.Fatalf("pending etxs mismatch: have %x, want %x", have, block.Hash())
+		}
+	case <-time.After(8 * c_pEtxSendBackoff):
+		t.Fatalf("pending etxs not sent once the dom came back")
+	}
+}
+
+// flakyDom serves the dom api over http, failing the given number of requests
+// with an http error before serving them
+type flakyDom struct {
+	failures int32
+	requests int32
+	handler  http.Handler
+}
+
+func (d *flakyDom) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	atomic.AddInt32(&d.requests, 1)
+	if atomic.AddInt32(&d.failures, -1) >= 0 {
+		http.Error(w, "dom unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	d.handler.ServeHTTP(w, r)
+}
+
+func TestSendPendingEtxsToDomRetries(t *testing.T) {
+	defer func(location common.Location) { common.NodeLocation = location }(common.NodeLocation)
+	common.NodeLocation = common.Location{0, 0}
+
+	api := &testDomAPI{received: make(chan common.Hash, 1)}
+	server := rpc.NewServer()
+	defer server.Stop()
+	if err := server.RegisterName("quai", api); err != nil {
+		t.Fatalf("failed to register the dom api: %v", err)
+	}
+	dom := &flakyDom{failures: 2, handler: server}
+	httpDom := httptest.NewServer(dom)
+	defer httpDom.Close()
+
+	sl, _ := newTestSlice()
+	sl.quit = make(chan struct{})
+	sl.pEtxSendCh = make(chan pEtxSend, c_pEtxSendQueueSize)
+	sl.wg.Add(1)
+	go sl.pEtxSendLoop()
+	defer func() {
+		close(sl.quit)
+		sl.wg.Wait()
+	}()
+	client, err := dialClient(httpDom.URL, time.Second)
+	if err != nil {
+		t.Fatalf("failed to dial the dom: %v", err)
+	}
+	sl.setDomClient(client)
+
+	// The dom fails twice, the third attempt delivers the pending etxs
+	pEtxs := types.PendingEtxs{Header: newTestHeader(nil, 1, 0)}
+	if err := sl.SendPendingEtxsToDom(context.Background(), pEtxs); err != nil {
+		t.Fatalf("failed to send the pending etxs: %v", err)
+	}
+	if requests := atomic.LoadInt32(&dom.requests); requests != 3 {
+		t.Errorf("request count mismatch: have %d, want 3", requests)
+	}
+	select {
+	case have := <-api.received:
+		if have != pEtxs.Header.Hash() {
+			t.Fatalf("pending etxs mismatch: have %x, want %x", have, pEtxs.Header.Hash())
+		}
+	default:
+		t.Fatalf("pending etxs not delivered to the dom")
+	}
+
+	// A dom failing every attempt gives up with a definitive error
+	atomic.StoreInt32(&dom.failures, c_pEtxSendAttempts)
+	if err := sl.SendPendingEtxsToDom(context.Background(), pEtxs); !errors.Is(err, ErrPendingEtxsNotSent) {
+		t.Fatalf("error mismatch: have %v, want %v", err, ErrPendingEtxsNotSent)
+	}
+
+	// The retries stop once the caller gives up
+	atomic.StoreInt32(&dom.failures, c_pEtxSendAttempts)
+	atomic.StoreInt32(&dom.requests, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = sl.SendPendingEtxsToDom(ctx, pEtxs)
+	if !errors.Is(err, ErrPendingEtxsNotSent) || !strings.Contains(err.Error(), context.Canceled.Error()) {
+		t.Fatalf("error mismatch: have %v, want %v with %v", err, ErrPendingEtxsNotSent, context.Canceled)
+	}
+	if requests := atomic.LoadInt32(&dom.requests); requests > 1 {
+		t.Errorf("sends retried after the caller gave up: %d requests", requests)
+	}
+}
+
+// blockingSubAPI serves a quai_append which blocks until the caller gives up
+type blockingSubAPI struct {
+	started chan struct{}
+}
+
+func (api *blockingSubAPI) Append(ctx context.Context, raw json.RawMessage) (map[string]interface{}, error) {
+	api.started <- struct{}{}
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestAppendContextCancelledDuringSubAppend(t *testing.T) {
+	defer func(location common.Location) { common.NodeLocation = location }(common.NodeLocation)
+	common.NodeLocation = common.Location{0}
+
+	api := &blockingSubAPI{started: make(chan struct{}, 1)}
+	tc := newTestSubChain(t, nil, []string{newTestDom(t, api)})
+	head := tc.sl.hc.CurrentHeader()
+
+	block := tc.newBlock(tc.genesis.Header(), 1, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-api.started
+		cancel()
+	}()
+	_, _, _, err := tc.appendBlock(ctx, block)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("error mismatch: have %v, want %v", err, context.Canceled)
+	}
+	// Nothing of the block is committed
+	if have := tc.sl.hc.CurrentHeader().Hash(); have != head.Hash() {
+		t.Errorf("head moved: have %x, want %x", have, head.Hash())
+	}
+	if termini := rawdb.ReadTermini(tc.sl.sliceDb, block.Hash()); termini != nil {
+		t.Errorf("termini written for the cancelled block")
+	}
+	if hash := rawdb.ReadCanonicalHash(tc.sl.sliceDb, block.NumberU64()); hash != (common.Hash{}) {
+		t.Errorf("canonical hash written for the cancelled block: %x", hash)
+	}
+	tc.sl.phCacheMu.RLock()
+	_, exists := tc.sl.readPhCache(block.Hash())
+	tc.sl.phCacheMu.RUnlock()
+	if exists {
+		t.Errorf("pending header written for the cancelled block")
+	}
+}
+
+func TestAppendTimeoutOnStalledSub(t *testing.T) {
+	defer func(location common.Location) { common.NodeLocation = location }(common.NodeLocation)
+	common.NodeLocation = common.Location{0}
+
+	api := &blockingSubAPI{started: make(chan struct{}, 1)}
+	tc := newTestSubChain(t, &Config{AppendTimeout: 50 * time.Millisecond}, []string{newTestDom(t, api)})
+	head := tc.sl.hc.CurrentHeader()
+
+	block := tc.newBlock(tc.genesis.Header(), 1, 0)
+	_, _, _, err := tc.appendBlock(context.Background(), block)
+	if !errors.Is(err, ErrAppendTimeout) {
+		t.Fatalf("error mismatch: have %v, want %v", err, ErrAppendTimeout)
+	}
+	select {
+	case <-api.started:
+	default:
+		t.Fatalf("sub append not called")
+	}
+	if have := tc.sl.hc.CurrentHeader().Hash(); have != head.Hash() {
+		t.Errorf("head moved: have %x, want %x", have, head.Hash())
+	}
+	if termini := rawdb.ReadTermini(tc.sl.sliceDb, block.Hash()); termini != nil {
+		t.Errorf("termini written for the timed out block")
+	}
+}
+
+// testSubAPI serves a quai_append which takes every block as the new head
+type testSubAPI struct{}
+
+func (api *testSubAPI) Append(ctx context.Context, raw json.RawMessage) (map[string]interface{}, error) {
+	return map[string]interface{}{"pendingEtxs": types.Transactions{}, "subReorg": true, "setHead": true}, nil
+}
+
+func TestAppendRollsBackOnFailedHeadWrite(t *testing.T) {
+	defer func(location common.Location) { common.NodeLocation = location }(common.NodeLocation)
+
+	tests := []struct {
+		name     string
+		location common.Location
+		subUrls  func(t *testing.T) []string
+	}{
+		{"zone", common.Location{0, 0}, func(*testing.T) []string { return nil }},
+		{"region", common.Location{0}, func(t *testing.T) []string { return []string{newTestDom(t, &testSubAPI{})} }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			common.NodeLocation = tt.location
+			tc := newTestSubChain(t, nil, tt.subUrls(t))
+			head := tc.appendChain(t, tc.genesis.Header(), 1, 0)[0]
+
+			// The block is coincident with the region, so a zone writes its
+			// inbound etxs and a region writes its etx rollup
+			block := tc.newBlock(head, 1, 0)
+			tc.engine.setOrder(block.Header(), common.REGION_CTX)
+			atomic.StoreInt32(&tc.db.failWrites, 1)
+			_, _, _, err := tc.appendBlock(context.Background(), block)
+			atomic.StoreInt32(&tc.db.failWrites, 0)
+			if !errors.Is(err, errTestWrite) {
+				t.Fatalf("error mismatch: have %v, want %v", err, errTestWrite)
+			}
+
+			if have := tc.sl.hc.CurrentHeader().Hash(); have != head.Hash() {
+				t.Errorf("head moved: have %x, want %x", have, head.Hash())
+			}
+			if hash := rawdb.ReadHeadBlockHash(tc.db); hash != head.Hash() {
+				t.Errorf("head marker written: have %x, want %x", hash, head.Hash())
+			}
+			if hash := rawdb.ReadCanonicalHash(tc.db, block.NumberU64()); hash != (common.Hash{}) {
+				t.Errorf("canonical hash written: %x", hash)
+			}
+			if termini := rawdb.ReadTermini(tc.db, block.Hash()); termini != nil {
+				t.Errorf("termini written")
+			}
+			if etxs := rawdb.ReadInboundEtxs(tc.db, block.Hash()); etxs != nil {
+				t.Errorf("inbound etxs written")
+			}
+			if rollup := rawdb.ReadEtxRollup(tc.db, block.Hash()); rollup != nil {
+				t.Errorf("etx rollup written")
+			}
+
+			// The same block is appended once the writes go through again
+			if _, _, setHead, err := tc.appendBlock(context.Background(), block); err != nil || !setHead {
+				t.Fatalf("append after the failure: have %v, %v, want true, nil", setHead, err)
+			}
+			if tt.location.Context() == common.ZONE_CTX && rawdb.ReadInboundEtxs(tc.db, block.Hash()) == nil {
+				t.Errorf("inbound etxs not written on the retry")
+			}
+			if tt.location.Context() == common.REGION_CTX && rawdb.ReadEtxRollup(tc.db, block.Hash()) == nil {
+				t.Errorf("etx rollup not written on the retry")
+			}
+		})
+	}
+}
+
+func TestCheckReorgDepth(t *testing.T) {
+	defer func(location common.Location) { common.NodeLocation = location }(common.NodeLocation)
+	common.NodeLocation = common.Location{0, 0}
+
+	tc := newTestSubChain(t, nil, nil)
+	chain := append([]*types.Header{tc.genesis.Header()}, tc.appendChain(t, tc.genesis.Header(), 4, 0)...)
+
+	tests := []struct {
+		name  string
+		limit uint64
+		fork  int // Number of the block the header forks off
+		err   error
+	}{
+		{"extends the head", 2, 4, nil},
+		{"below the limit", 2, 3, nil},
+		{"at the limit", 2, 2, nil},
+		{"over the limit", 2, 1, ErrReorgTooDeep},
+		{"from genesis over the limit", 2, 0, ErrReorgTooDeep},
+		{"no limit", 0, 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tc.sl.UpdateConfig(ConfigPatch{MaxReorgDepth: &tt.limit}); err != nil {
+				t.Fatalf("failed to set the reorg limit: %v", err)
+			}
+			header := tc.newBlock(chain[tt.fork], 1, 1).Header()
+			if err := tc.sl.checkReorgDepth(header); !errors.Is(err, tt.err) {
+				t.Fatalf("error mismatch: have %v, want %v", err, tt.err)
+			}
+		})
+	}
+
+	// A block over the limit is refused by append before anything is written
+	limit := uint64(2)
+	if err := tc.sl.UpdateConfig(ConfigPatch{MaxReorgDepth: &limit}); err != nil {
+		t.Fatalf("failed to set the reorg limit: %v", err)
+	}
+	block := tc.newBlock(chain[1], 1, 1)
+	if _, _, _, err := tc.appendBlock(context.Background(), block); !errors.Is(err, ErrReorgTooDeep) {
+		t.Fatalf("error mismatch: have %v, want %v", err, ErrReorgTooDeep)
+	}
+	if termini := rawdb.ReadTermini(tc.db, block.Hash()); termini != nil {
+		t.Errorf("termini written for the refused block")
+	}
+}
+
+// dbCheckForkChoice records whether the candidate was already committed to the
+// db when the head was decided
+type dbCheckForkChoice struct {
+	ForkChoice
+	db                ethdb.Database
+	called, committed bool
+}
+
+func (f *dbCheckForkChoice) ShouldReorg(current, candidate *types.Header, currentTd, candidateTd *big.Int) (bool, error) {
+	f.called = true
+	f.committed = rawdb.ReadTermini(f.db, candidate.Hash()) != nil || rawdb.ReadCanonicalHash(f.db, candidate.NumberU64()) == candidate.Hash()
+	return f.ForkChoice.ShouldReorg(current, candidate, currentTd, candidateTd)
+}
+
+func TestAppendBatchDeferredCommit(t *testing.T) {
+	defer func(location common.Location) { common.NodeLocation = location }(common.NodeLocation)
+	common.NodeLocation = common.Location{0, 0}
+
+	tc := newTestSubChain(t, &Config{AppendBatchBlocks: 3}, nil)
+	head := tc.appendChain(t, tc.genesis.Header(), 1, 0)[0]
+
+	// appendSide appends a block lighter than the head, which is only committed
+	// along with the batch
+	fork := byte(0)
+	appendSide := func() *types.Block {
+		fork++
+		block := tc.newBlock(tc.genesis.Header(), 0, fork)
+		if _, _, setHead, err := tc.appendBlock(context.Background(), block); err != nil || setHead {
+			t.Fatalf("side block append: have %v, %v, want false, nil", setHead, err)
+		}
+		return block
+	}
+	committed := func(blocks ...*types.Block) bool {
+		for _, block := range blocks {
+			if rawdb.ReadTermini(tc.db, block.Hash()) == nil {
+				return false
+			}
+		}
+		return true
+	}
+	pending := func(blocks ...*types.Block) {
+		t.Helper()
+		for _, block := range blocks {
+			if rawdb.ReadTermini(tc.db, block.Hash()) != nil {
+				t.Fatalf("block %x committed ahead of the batch", block.Hash())
+			}
+			// The accumulated blocks are known to the slice all the same
+			if tc.sl.hc.GetTerminiByHash(block.Hash()) == nil {
+				t.Fatalf("termini of block %x not staged", block.Hash())
+			}
+		}
+	}
+
+	// The batch is committed once it holds the configured number of blocks
+	side1, side2 := appendSide(), appendSide()
+	pending(side1, side2)
+	side3 := appendSide()
+	if !committed(side1, side2, side3) {
+		t.Fatalf("full batch not committed")
+	}
+
+	// A block moving the head commits the accumulated ones with it, and nothing
+	// of it is written before the head is decided
+	side4 := appendSide()
+	pending(side4)
+	next := tc.newBlock(head, 1, 0)
+	forkChoice := &dbCheckForkChoice{ForkChoice: tc.sl.currentForkChoice(), db: tc.db}
+	tc.sl.SetForkChoice(forkChoice)
+	if _, _, setHead, err := tc.appendBlock(context.Background(), next); err != nil || !setHead {
+		t.Fatalf("head append: have %v, %v, want true, nil", setHead, err)
+	}
+	if !forkChoice.called || forkChoice.committed {
+		t.Fatalf("head decided after the commit: called %v, committed %v", forkChoice.called, forkChoice.committed)
+	}
+	if !committed(side4, next) {
+		t.Fatalf("batch not committed along with the head")
+	}
+	if hash := rawdb.ReadHeadBlockHash(tc.db); hash != next.Hash() {
+		t.Fatalf("head marker mismatch: have %x, want %x", hash, next.Hash())
+	}
+
+	// Stopping the slice commits what is left
+	side5 := appendSide()
+	pending(side5)
+	if err := tc.sl.Stop(); err != nil {
+		t.Fatalf("failed to stop the slice: %v", err)
+	}
+	if !committed(side5) {
+		t.Fatalf("batch not committed on stop")
+	}
+}
+
+// concurrencyForkChoice runs the default fork choice and records the largest
+// number of heads decided at the same time
+type concurrencyForkChoice struct {
+	ForkChoice
+	active, max int32
+}
+
+func (f *concurrencyForkChoice) ShouldReorg(current, candidate *types.Header, currentTd, candidateTd *big.Int) (bool, error) {
+	active := atomic.AddInt32(&f.active, 1)
+	defer atomic.AddInt32(&f.active, -1)
+	for {
+		highest := atomic.LoadInt32(&f.max)
+		if active <= highest || atomic.CompareAndSwapInt32(&f.max, highest, active) {
+			break
+		}
+	}
+	// Leave the other appends time to catch up
+	time.Sleep(time.Millisecond)
+	return f.ForkChoice.ShouldReorg(current, candidate, currentTd, candidateTd)
+}
+
+func TestConcurrentAppendsAreSerialized(t *testing.T) {
+	defer func(location common.Location) { common.NodeLocation = location }(common.NodeLocation)
+	common.NodeLocation = common.Location{0, 0}
+
+	tc := newTestSubChain(t, nil, nil)
+	forkChoice := &concurrencyForkChoice{ForkChoice: tc.sl.currentForkChoice()}
+	tc.sl.SetForkChoice(forkChoice)
+
+	// Sibling blocks of growing difficulty, each of them appended twice
+	const siblings = 8
+	blocks := make([]*types.Block, siblings)
+	for i := range blocks {
+		blocks[i] = tc.newBlock(tc.genesis.Header(), int64(i+1), byte(i))
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*siblings)
+	for i := 0; i < 2*siblings; i++ {
+		block := blocks[i%siblings]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, _, err := tc.appendBlock(context.Background(), block); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("concurrent appends deadlocked")
+	}
+	close(errs)
+	for err := range errs {
+		t.Errorf("append failed: %v", err)
+	}
+
+	if have := atomic.LoadInt32(&forkChoice.max); have != 1 {
+		t.Errorf("heads decided concurrently: %d at once", have)
+	}
+	// The heaviest block is the head, whatever order the appends ran in
+	heaviest := blocks[siblings-1]
+	if have := tc.sl.hc.CurrentHeader().Hash(); have != heaviest.Hash() {
+		t.Fatalf("head mismatch: have %x, want %x", have, heaviest.Hash())
+	}
+	if hash := rawdb.ReadHeadBlockHash(tc.db); hash != heaviest.Hash() {
+		t.Errorf("head marker mismatch: have %x, want %x", hash, heaviest.Hash())
+	}
+	if hash := rawdb.ReadCanonicalHash(tc.db, 1); hash != heaviest.Hash() {
+		t.Errorf("canonical hash mismatch: have %x, want %x", hash, heaviest.Hash())
+	}
+	for i, block := range blocks {
+		if rawdb.ReadTermini(tc.db, block.Hash()) == nil {
+			t.Errorf("termini of block %d not written", i)
+		}
+	}
+	tc.sl.phCacheMu.RLock()
+	bestPh, exists := tc.sl.readPhCache(tc.sl.bestPhKey)
+	tc.sl.phCacheMu.RUnlock()
+	if !exists {
+		t.Fatalf("best pending header missing")
+	}
+	if have := bestPh.Header().ParentHash(); have != heaviest.Hash() {
+		t.Errorf("best pending header parent mismatch: have %x, want %x", have, heaviest.Hash())
+	}
+}
+
+func TestStopContextJoinsErrors(t *testing.T) {
+	defer func(location common.Location) { common.NodeLocation = location }(common.NodeLocation)
+	common.NodeLocation = common.Location{0, 0}
+
+	tests := []struct {
+		name       string
+		failWrites bool // The state persisted by Stop fails to be written
+		inFlight   bool // An append is still in flight when ctx is done
+		err        error
+		notErr     error
+	}{
+		{"stop fails, drain succeeds", true, false, errTestWrite, context.Canceled},
+		{"drain fails, stop succeeds", false, true, context.Canceled, errTestWrite},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newTestSubChain(t, nil, nil)
+			if tt.inFlight {
+				appendCtx, endAppend, ok := tc.sl.beginInFlightAppend(context.Background())
+				if !ok {
+					t.Fatalf("failed to begin an append")
+				}
+				// The append returns once the shutdown cancels it
+				go func() {
+					<-appendCtx.Done()
+					endAppend()
+				}()
+			}
+			if tt.failWrites {
+				atomic.StoreInt32(&tc.db.failWrites, 1)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			if tt.inFlight {
+				cancel()
+			} else {
+				defer cancel()
+			}
+
+			err := tc.sl.StopContext(ctx)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error mismatch: have %v, want %v", err, tt.err)
+			}
+			if errors.Is(err, tt.notErr) {
+				t.Fatalf("error of the successful step returned: %v", err)
+			}
+			// The shutdown is completed either way
+			if !tc.sl.isClosed() {
+				t.Errorf("slice not closed")
+			}
+			select {
+			case <-tc.sl.quit:
+			default:
+				t.Errorf("quit channel not closed")
+			}
+			select {
+			case <-tc.sl.miner.exitCh:
+			default:
+				t.Errorf("miner not stopped")
+			}
+			block := tc.newBlock(tc.genesis.Header(), 1, 0)
+			if _, _, _, err := tc.appendBlock(context.Background(), block); !errors.Is(err, ErrSliceClosed) {
+				t.Errorf("append after stop: have %v, want %v", err, ErrSliceClosed)
+			}
+		})
+	}
+}
+
+func TestCoreStopAfterStopContext(t *testing.T) {
+	defer func(location common.Location) { common.NodeLocation = location }(common.NodeLocation)
+	common.NodeLocation = common.Location{0, 0}
+
+	tc := newTestSubChain(t, nil, nil)
+	c := newTestCore(tc)
+	if err := c.StopContext(context.Background()); err != nil {
+		t.Fatalf("failed to stop the core: %v", err)
+	}
+	// A second stop, e.g. by the node after a graceful shutdown, is a no-op
+	if err := c.Stop(); err != nil {
+		t.Fatalf("second stop failed: %v", err)
+	}
+	if err := c.StopContext(context.Background()); err != nil {
+		t.Fatalf("second graceful stop failed: %v", err)
+	}
+}
+
+func TestStopWaitsForInFlightAppends(t *testing.T) {
+	defer func(location common.Location) { common.NodeLocation = location }(common.NodeLocation)
+	common.NodeLocation = common.Location{0, 0}
+
+	tc := newTestSubChain(t, nil, nil)
+	appendCtx, endAppend, ok := tc.sl.beginInFlightAppend(context.Background())
+	if !ok {
+		t.Fatalf("failed to begin an append")
+	}
+	// The append takes a moment to return once it is cancelled
+	var finished int32
+	go func() {
+		<-appendCtx.Done()
+		time.Sleep(20 * time.Millisecond)
+		atomic.StoreInt32(&finished, 1)
+		endAppend()
+	}()
+	if err := tc.sl.Stop(); err != nil {
+		t.Fatalf("failed to stop the slice: %v", err)
+	}
+	if atomic.LoadInt32(&finished) != 1 {
+		t.Fatalf("slice stopped before the append in flight returned")
+	}
+	if _, _, ok := tc.sl.beginInFlightAppend(context.Background()); ok {
+		t.Fatalf("append accepted after stop")
+	}
+}
+
+func TestAppendRejectsMismatchingRollup(t *testing.T) {
+	defer func(location common.Location) { common.NodeLocation = location }(common.NodeLocation)
+	common.NodeLocation = common.Location{0}
+
+	api := &blockingSubAPI{started: make(chan struct{}, 1)}
+	tc := newTestSubChain(t, nil, []string{newTestDom(t, api)})
+	head := tc.sl.hc.CurrentHeader()
+
+	// The rollup collected from the sub manifest is empty
+	block := tc.newBlock(tc.genesis.Header(), 1, 0)
+	header := block.Header()
+	header.SetEtxRollupHash(common.Hash{1})
+	block = types.NewBlockWithHeader(header).WithBody(nil, nil, nil, block.SubManifest())
+
+	_, _, _, err := tc.appendBlock(context.Background(), block)
+	if !errors.Is(err, ErrRollupHashMismatch) {
+		t.Fatalf("error mismatch: have %v, want %v", err, ErrRollupHashMismatch)
+	}
+	select {
+	case <-api.started:
+		t.Fatalf("block with a mismatching rollup sent to the sub")
+	default:
+	}
+	if rollup := rawdb.ReadEtxRollup(tc.db, block.Hash()); rollup != nil {
+		t.Errorf("etx rollup written")
+	}
+	if etxSet := rawdb.ReadEtxSet(tc.db, block.Hash(), block.NumberU64()); etxSet != nil {
+		t.Errorf("etx set written")
+	}
+	if _, exists := tc.sl.hc.subRollupCache.Get(block.Hash()); exists {
+		t.Errorf("mismatching rollup cached")
+	}
+	if termini := rawdb.ReadTermini(tc.db, block.Hash()); termini != nil {
+		t.Errorf("termini written")
+	}
+	if have := tc.sl.hc.CurrentHeader().Hash(); have != head.Hash() {
+		t.Errorf("head moved: have %x, want %x", have, head.Hash())
+	}
+}
+
+func TestAppendRejectsOversizedManifest(t *testing.T) {
+	setTestLocation(t, common.Location{0})
+
+	api := &blockingSubAPI{started: make(chan struct{}, 1)}
+	tc := newTestSubChain(t, &Config{MaxManifestSize: 2}, []string{newTestDom(t, api)})
+	head := tc.sl.hc.CurrentHeader()
+
+	// The sub manifest holds unknown blocks past the parent, collecting their
+	// rollup would have to fetch their pending etxs
+	block := tc.newBlock(tc.genesis.Header(), 1, 0)
+	manifest := types.BlockManifest{tc.genesis.Hash(), {1}, {2}}
+	header := block.Header()
+	header.SetManifestHash(types.DeriveSha(manifest, trie.NewStackTrie(nil)), common.ZONE_CTX)
+	block = types.NewBlockWithHeader(header).WithBody(nil, nil, nil, manifest)
+
+	_, _, _, err := tc.appendBlock(context.Background(), block)
+	if !errors.Is(err, ErrManifestTooLarge) {
+		t.Fatalf("error mismatch: have %v, want %v", err, ErrManifestTooLarge)
+	}
+	select {
+	case <-api.started:
+		t.Fatalf("block with an oversized manifest sent to the sub")
+	default:
+	}
+	if _, exists := tc.sl.hc.subRollupCache.Get(block.Hash()); exists {
+		t.Errorf("rollup of the oversized manifest collected")
+	}
+	if _, exists := tc.sl.inboundEtxsCache.Get(block.Hash()); exists {
+		t.Errorf("inbound etxs of the oversized manifest collected")
+	}
+	if termini := rawdb.ReadTermini(tc.db, block.Hash()); termini != nil {
+		t.Errorf("termini written")
+	}
+	if have := tc.sl.hc.CurrentHeader().Hash(); have != head.Hash() {
+		t.Errorf("head moved: have %x, want %x", have, head.Hash())
+	}
+}
+
+func TestVerifyTerminiUnknownTerminus(t *testing.T) {
+	setTestLocation(t, common.Location{0, 0})
+	hooks := log.Log.ReplaceHooks(make(logrus.LevelHooks))
+	defer log.Log.ReplaceHooks(hooks)
+	logs := logtest.NewLocal(log.Log.Logger)
+
+	tc := newTestSubChain(t, &Config{VerifyTermini: true}, nil)
+	parent := tc.appendChain(t, tc.genesis.Header(), 1, 0)[0]
+
+	// The coincident terminus of the parent points at an unknown hash
+	unknown := common.Hash{1}
+	termini := types.CopyTermini(*tc.sl.hc.GetTerminiByHash(parent.Hash()))
+	termini.SetDomTerminiAtIndex(unknown, common.NodeLocation.DomIndex())
+	rawdb.WriteTermini(tc.db, parent.Hash(), termini)
+
+	// warned reports whether the append of the block warned about the unknown
+	// terminus it inherits
+	warned := func(block *types.Block) bool {
+		logs.Reset()
+		if _, _, _, err := tc.appendBlock(context.Background(), block); err != nil {
+			t.Fatalf("append failed: %v", err)
+		}
+		if have := tc.sl.hc.GetTerminiByHash(block.Hash()).DomTerminus(); have != unknown {
+			t.Fatalf("terminus not inherited: have %x, want %x", have, unknown)
+		}
+		for _, entry := range logs.AllEntries() {
+			if entry.Level == logrus.WarnLevel && strings.Contains(entry.Message, "PCRC inherited terminus references an unknown header") {
+				return true
+			}
+		}
+		return false
+	}
+	if !warned(tc.newBlock(parent, 1, 0)) {
+		t.Errorf("unknown inherited terminus not reported")
+	}
+
+	// The check is skipped once disabled
+	disabled := false
+	if err := tc.sl.UpdateConfig(ConfigPatch{VerifyTermini: &disabled}); err != nil {
+		t.Fatal(err)
+	}
+	if warned(tc.newBlock(parent, 1, 1)) {
+		t.Errorf("unknown inherited terminus reported with the check disabled")
+	}
+}
+
+func TestInitPartiallyInitialized(t *testing.T) {
+	defer func(location common.Location) { common.NodeLocation = location }(common.NodeLocation)
+	common.NodeLocation = common.Location{0, 0}
+
+	tc := newTestSubChain(t, nil, nil)
+	genesisHash := tc.genesis.Hash()
+	// The previous run stopped after adding the pending etxs and the bloom of
+	// the genesis, which are already known to the headerchain
+	rawdb.DeleteEtxSet(tc.db, genesisHash, 0)
+	if _, err := tc.sl.hc.GetPendingEtxs(genesisHash); err != nil {
+		t.Fatalf("genesis pending etxs missing before the rerun: %v", err)
+	}
+
+	if err := tc.sl.init(nil); err != nil {
+		t.Fatalf("init rerun failed: %v", err)
+	}
+	if etxSet := rawdb.ReadEtxSet(tc.db, genesisHash, 0); etxSet == nil {
+		t.Errorf("genesis etx set not written by the rerun")
+	}
+	if rawdb.ReadPendingEtxs(tc.db, genesisHash) == nil {
+		t.Errorf("genesis pending etxs missing")
+	}
+	if rawdb.ReadBloom(tc.db, genesisHash) == nil {
+		t.Errorf("genesis bloom missing")
+	}
+	if rawdb.ReadTermini(tc.db, genesisHash) == nil {
+		t.Errorf("genesis termini missing")
+	}
+	if have := tc.sl.hc.CurrentHeader().Hash(); have != genesisHash {
+		t.Errorf("head mismatch: have %x, want %x", have, genesisHash)
+	}
+	// The chain is usable after the rerun
+	tc.appendChain(t, tc.genesis.Header(), 1, 0)
+}
+
+// putHookDb is a memory database running a hook on every put
+type putHookDb struct {
+	ethdb.Database
+	onPut func(key []byte)
+}
+
+func (db *putHookDb) Put(key []byte, value []byte) error {
+	db.onPut(key)
+	return db.Database.Put(key, value)
+}
+
+func TestAppendDuringInit(t *testing.T) {
+	setTestLocation(t, common.Location{0, 0})
+
+	tc := newTestSubChain(t, nil, nil)
+	block := tc.newBlock(tc.genesis.Header(), 1, 0)
+
+	// The append is fired from within the knot initialization, on the first
+	// write of the genesis data by the rerun
+	var (
+		fired     bool
+		appendErr error
+	)
+	genesisHash := tc.genesis.Hash()
+	tc.sl.sliceDb = &putHookDb{Database: tc.db, onPut: func(key []byte) {
+		if fired || !bytes.HasSuffix(key, genesisHash.Bytes()) {
+			return
+		}
+		fired = true
+		_, _, _, appendErr = tc.appendBlock(context.Background(), block)
+	}}
+	if err := tc.sl.init(nil); err != nil {
+		t.Fatalf("init rerun failed: %v", err)
+	}
+	if !fired {
+		t.Fatalf("no append fired during init")
+	}
+	if appendErr != ErrInitializing {
+		t.Fatalf("append during init: have %v, want %v", appendErr, ErrInitializing)
+	}
+	if have := tc.sl.hc.CurrentHeader().Hash(); have != tc.genesis.Hash() {
+		t.Fatalf("head moved during init: have %x, want %x", have, tc.genesis.Hash())
+	}
+
+	// Once initialized the same block is accepted
+	if _, _, _, err := tc.appendBlock(context.Background(), block); err != nil {
+		t.Fatalf("append after init failed: %v", err)
+	}
+	if have := tc.sl.hc.CurrentHeader().Hash(); have != block.Hash() {
+		t.Fatalf("head mismatch: have %x, want %x", have, block.Hash())
+	}
+}
+
+func TestAppendContiguous(t *testing.T) {
+	defer func(location common.Location) { common.NodeLocation = location }(common.NodeLocation)
+	common.NodeLocation = common.Location{0, 0}
+
+	errSeal := errors.New("invalid seal")
+	tests := []struct {
+		name    string
+		prepare func(t *testing.T, tc *testChain, blocks []*types.Block) []*types.Header
+		appends int    // Number of headers appended, and of pending etxs returned
+		err     error  // Error of the range append
+		index   string // Index of the failing header in the error
+		head    int    // Number of blocks appended on the head after the range
+	}{
+		{"empty range", func(t *testing.T, tc *testChain, blocks []*types.Block) []*types.Header {
+			return nil
+		}, 0, nil, "", 0},
+		{"out of order", func(t *testing.T, tc *testChain, blocks []*types.Block) []*types.Header {
+			return []*types.Header{blocks[1].Header(), blocks[0].Header(), blocks[2].Header()}
+		}, 0, ErrNonContiguousHeaders, "header 1", 0},
+		{"gap", func(t *testing.T, tc *testChain, blocks []*types.Block) []*types.Header {
+			return []*types.Header{blocks[0].Header(), blocks[2].Header()}
+		}, 0, ErrNonContiguousHeaders, "header 1", 0},
+		{"middle block fails", func(t *testing.T, tc *testChain, blocks []*types.Block) []*types.Header {
+			tc.engine.setSealErr(blocks[1].Header(), errSeal)
+			return []*types.Header{blocks[0].Header(), blocks[1].Header(), blocks[2].Header()}
+		}, 1, errSeal, "header 1", 1},
+		{"already known", func(t *testing.T, tc *testChain, blocks []*types.Block) []*types.Header {
+			headers := []*types.Header{blocks[0].Header(), blocks[1].Header(), blocks[2].Header()}
+			if _, err := tc.sl.AppendContiguous(context.Background(), headers); err != nil {
+				t.Fatalf("failed to append the range ahead: %v", err)
+			}
+			return headers
+		}, 3, nil, "", 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newTestSubChain(t, nil, nil)
+			blocks := make([]*types.Block, 3)
+			parent := tc.genesis.Header()
+			for i := range blocks {
+				blocks[i] = tc.newBlock(parent, 1, 0)
+				tc.sl.WriteBlock(blocks[i])
+				parent = blocks[i].Header()
+			}
+			headers := tt.prepare(t, tc, blocks)
+
+			pendingEtxs, err := tc.sl.AppendContiguous(context.Background(), headers)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error mismatch: have %v, want %v", err, tt.err)
+			}
+			if err != nil && !strings.Contains(err.Error(), tt.index) {
+				t.Errorf("error does not name %s: %v", tt.index, err)
+			}
+			if len(pendingEtxs) != tt.appends {
+				t.Errorf("pending etxs mismatch: have %d, want %d", len(pendingEtxs), tt.appends)
+			}
+			want := tc.genesis.Hash()
+			if tt.head > 0 {
+				want = blocks[tt.head-1].Hash()
+			}
+			if have := tc.sl.hc.CurrentHeader().Hash(); have != want {
+				t.Errorf("head mismatch: have %x, want %x", have, want)
+			}
+			// Only the blocks on the head are appended
+			for i, block := range blocks {
+				if appended := rawdb.ReadTermini(tc.db, block.Hash()) != nil; appended != (i < tt.head) {
+					t.Errorf("block %d appended: have %v, want %v", i, appended, i < tt.head)
+				}
+			}
+		})
+	}
+}
+
+func TestAppendOrGetKnownBlock(t *testing.T) {
+	defer func(location common.Location) { common.NodeLocation = location }(common.NodeLocation)
+	common.NodeLocation = common.Location{0, 0}
+
+	tc := newTestSubChain(t, nil, nil)
+	block := tc.newBlock(tc.genesis.Header(), 1, 0)
+	tc.sl.WriteBlock(block)
+	if _, _, _, err := tc.sl.AppendOrGet(context.Background(), block.Header(), types.EmptyHeader(), common.Hash{}, false, nil); err != nil {
+		t.Fatalf("first append failed: %v", err)
+	}
+
+	heads := make(chan ChainHeadEvent, 1)
+	headSub := tc.sl.hc.chainHeadFeed.Subscribe(heads)
+	defer headSub.Unsubscribe()
+	sides := make(chan ChainSideEvent, 1)
+	sideSub := tc.sl.hc.chainSideFeed.Subscribe(sides)
+	defer sideSub.Unsubscribe()
+	tc.sl.phCacheMu.RLock()
+	phKeys, bestPhKey := tc.sl.phCache.Keys(), tc.sl.bestPhKey
+	tc.sl.phCacheMu.RUnlock()
+
+	pendingEtxs, subReorg, setHead, err := tc.sl.AppendOrGet(context.Background(), block.Header(), types.EmptyHeader(), common.Hash{}, false, nil)
+	if !errors.Is(err, ErrKnownBlock) {
+		t.Fatalf("error mismatch: have %v, want %v", err, ErrKnownBlock)
+	}
+	if subReorg || setHead {
+		t.Errorf("known block reported as a new head: subReorg %v, setHead %v", subReorg, setHead)
+	}
+	if len(pendingEtxs) != 0 {
+		t.Errorf("stored pending etxs mismatch: have %v, want none", pendingEtxs)
+	}
+	// Appending the known block again through Append has no side effects either
+	if _, _, _, err := tc.appendBlock(context.Background(), block); err != nil {
+		t.Fatalf("re-append failed: %v", err)
+	}
+
+	select {
+	case ev := <-heads:
+		t.Errorf("head event sent for the known block %x", ev.Block.Hash())
+	case ev := <-sides:
+		t.Errorf("side event sent for the known block %x", ev.Block.Hash())
+	case <-time.After(50 * time.Millisecond):
+	}
+	tc.sl.phCacheMu.RLock()
+	defer tc.sl.phCacheMu.RUnlock()
+	if have := tc.sl.phCache.Keys(); !reflect.DeepEqual(have, phKeys) {
+		t.Errorf("phCache written: have %v, want %v", have, phKeys)
+	}
+	if tc.sl.bestPhKey != bestPhKey {
+		t.Errorf("best pending header moved: have %x, want %x", tc.sl.bestPhKey, bestPhKey)
+	}
+	if have := tc.sl.hc.CurrentHeader().Hash(); have != block.Hash() {
+		t.Errorf("head mismatch: have %x, want %x", have, block.Hash())
+	}
+}
+
+func TestReorgTo(t *testing.T) {
+	defer func(location common.Location) { common.NodeLocation = location }(common.NodeLocation)
+	common.NodeLocation = common.Location{0, 0}
+
+	tests := []struct {
+		name   string
+		target func(t *testing.T, tc *testChain, chain []*types.Header) []*types.Header // Canonical chain of the target
+	}{
+		{"rewind on the canonical chain", func(t *testing.T, tc *testChain, chain []*types.Header) []*types.Header {
+			return chain[:1]
+		}},
+		{"onto a lighter side chain", func(t *testing.T, tc *testChain, chain []*types.Header) []*types.Header {
+			side := make([]*types.Header, 0, 2)
+			parent := tc.genesis.Header()
+			for i := 0; i < 2; i++ {
+				block := tc.newBlock(parent, 0, 1)
+				if _, _, setHead, err := tc.appendBlock(context.Background(), block); err != nil || setHead {
+					t.Fatalf("side block append: have %v, %v, want false, nil", setHead, err)
+				}
+				parent = block.Header()
+				side = append(side, parent)
+			}
+			return side
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := newTestSubChain(t, nil, nil)
+			chain := tc.appendChain(t, tc.genesis.Header(), 3, 0)
+			target := tt.target(t, tc, chain)
+			head := target[len(target)-1]
+
+			if err := tc.sl.ReorgTo(head.Hash()); err != nil {
+				t.Fatalf("reorg failed: %v", err)
+			}
+			if have := tc.sl.hc.CurrentHeader().Hash(); have != head.Hash() {
+				t.Errorf("head mismatch: have %x, want %x", have, head.Hash())
+			}
+			if hash := rawdb.ReadHeadBlockHash(tc.db); hash != head.Hash() {
+				t.Errorf("head marker mismatch: have %x, want %x", hash, head.Hash())
+			}
+			for _, header := range target {
+				if hash := rawdb.ReadCanonicalHash(tc.db, header.NumberU64()); hash != header.Hash() {
+					t.Errorf("canonical hash %d mismatch: have %x, want %x", header.NumberU64(), hash, header.Hash())
+				}
+			}
+			for number := head.NumberU64() + 1; number <= chain[len(chain)-1].NumberU64(); number++ {
+				if hash := rawdb.ReadCanonicalHash(tc.db, number); hash != (common.Hash{}) {
+					t.Errorf("canonical hash %d left above the head: %x", number, hash)
+				}
+			}
+			termini := tc.sl.hc.GetTerminiByHash(head.Hash())
+			tc.sl.phCacheMu.RLock()
+			bestPhKey := tc.sl.bestPhKey
+			tc.sl.phCacheMu.RUnlock()
+			if bestPhKey != termini.DomTerminus() {
+				t.Errorf("best pending header key mismatch: have %x, want %x", bestPhKey, termini.DomTerminus())
+			}
+		})
+	}
+}
+
+// recordingForkChoice runs the default fork choice and records the entropy of
+// the last candidate it was asked about
+type recordingForkChoice struct {
+	ForkChoice
+	candidateTd *big.Int
+}
+
+func (f *recordingForkChoice) ShouldReorg(current, candidate *types.Header, currentTd, candidateTd *big.Int) (bool, error) {
+	f.candidateTd = candidateTd
+	return f.ForkChoice.ShouldReorg(current, candidate, currentTd, candidateTd)
+}
+
+func TestAppendWithResult(t *testing.T) {
+	defer func(location common.Location) { common.NodeLocation = location }(common.NodeLocation)
+	common.NodeLocation = common.Location{0, 0}
+
+	tc := newTestSubChain(t, nil, nil)
+	forkChoice := &recordingForkChoice{ForkChoice: tc.sl.currentForkChoice()}
+	tc.sl.SetForkChoice(forkChoice)
+	head := tc.newBlock(tc.genesis.Header(), 2, 0)
+	side := tc.newBlock(tc.genesis.Header(), 1, 1)
+
+	for _, tt := range []struct {
+		block   *types.Block
+		setHead bool
+	}{{head, true}, {side, false}} {
+		tc.sl.WriteBlock(tt.block)
+		result, err := tc.sl.AppendWithResult(context.Background(), tt.block.Header(), types.EmptyHeader(), common.Hash{}, false, nil)
+		if err != nil {
+			t.Fatalf("append failed: %v", err)
+		}
+		if result.Block == nil || result.Block.Hash() != tt.block.Hash() {
+			t.Fatalf("block mismatch: have %v, want %x", result.Block, tt.block.Hash())
+		}
+		if result.Entropy.Cmp(forkChoice.candidateTd) != 0 {
+			t.Errorf("entropy mismatch: have %v, fork choice weighed %v", result.Entropy, forkChoice.candidateTd)
+		}
+		if want := tc.engine.TotalLogS(tt.block.Header()); result.Entropy.Cmp(want) != 0 {
+			t.Errorf("entropy mismatch: have %v, want %v", result.Entropy, want)
+		}
+		if result.SetHead != tt.setHead {
+			t.Errorf("set head mismatch: have %v, want %v", result.SetHead, tt.setHead)
+		}
+		// The head is the one committed
+		if have := tc.sl.hc.CurrentHeader().Hash(); have != head.Hash() {
+			t.Errorf("head mismatch: have %x, want %x", have, head.Hash())
+		}
+		if hash := rawdb.ReadHeadBlockHash(tc.db); hash != head.Hash() {
+			t.Errorf("head marker mismatch: have %x, want %x", hash, head.Hash())
+		}
+		if rawdb.ReadTermini(tc.db, tt.block.Hash()) == nil {
+			t.Errorf("termini of the block not committed")
+		}
+	}
+}
+
+func TestAppendBlockParity(t *testing.T) {
+	defer func(location common.Location) { common.NodeLocation = location }(common.NodeLocation)
+	common.NodeLocation = common.Location{0, 0}
+
+	// The same blocks are appended to two chains started from the same genesis,
+	// with the body read back from the db on one and held by the caller on the
+	// other
+	fromDb, fromCaller := newTestSubChain(t, nil, nil), newTestSubChain(t, nil, nil)
+	if fromDb.genesis.Hash() != fromCaller.genesis.Hash() {
+		t.Fatalf("genesis mismatch")
+	}
+	parent := fromDb.genesis.Header()
+	blocks := []*types.Block{
+		fromDb.newBlock(parent, 1, 0),
+		fromDb.newBlock(parent, 2, 1), // Heavier sibling, reorgs the first
+		fromDb.newBlock(parent, 1, 2), // Lighter sibling, a side block
+	}
+	for i, block := range blocks {
+		etxs, subReorg, setHead, err := fromDb.appendBlock(context.Background(), block)
+		if err != nil {
+			t.Fatalf("block %d: append failed: %v", i, err)
+		}
+		// Only the header is known ahead of the append of the held block
+		rawdb.WriteHeader(fromCaller.db, block.Header())
+		callerEtxs, callerSubReorg, callerSetHead, err := fromCaller.sl.AppendBlock(context.Background(), block, types.EmptyHeader(), common.Hash{}, false, nil)
+		if err != nil {
+			t.Fatalf("block %d: append of the held block failed: %v", i, err)
+		}
+		if len(callerEtxs) != len(etxs) || callerSubReorg != subReorg || callerSetHead != setHead {
+			t.Errorf("block %d: result mismatch: have %d etxs, %v, %v, want %d etxs, %v, %v", i, len(callerEtxs), callerSubReorg, callerSetHead, len(etxs), subReorg, setHead)
+		}
+		if have, want := fromCaller.sl.hc.CurrentHeader().Hash(), fromDb.sl.hc.CurrentHeader().Hash(); have != want {
+			t.Errorf("block %d: head mismatch: have %x, want %x", i, have, want)
+		}
+		if have, want := rawdb.ReadTermini(fromCaller.db, block.Hash()), rawdb.ReadTermini(fromDb.db, block.Hash()); !reflect.DeepEqual(have, want) {
+			t.Errorf("block %d: termini mismatch: have %v, want %v", i, have, want)
+		}
+		if rawdb.ReadBody(fromCaller.db, block.Hash(), block.NumberU64()) == nil {
+			t.Errorf("block %d: body of the held block not written", i)
+		}
+		fromDb.sl.phCacheMu.RLock()
+		fromCaller.sl.phCacheMu.RLock()
+		if fromCaller.sl.bestPhKey != fromDb.sl.bestPhKey {
+			t.Errorf("block %d: best pending header key mismatch: have %x, want %x", i, fromCaller.sl.bestPhKey, fromDb.sl.bestPhKey)
+		}
+		fromCaller.sl.phCacheMu.RUnlock()
+		fromDb.sl.phCacheMu.RUnlock()
+	}
+
+	// A held body which does not match its header is refused
+	block := fromDb.newBlock(blocks[1].Header(), 1, 0)
+	rawdb.WriteHeader(fromCaller.db, block.Header())
+	bad := block.WithBody(types.Transactions{newTestTx(0)}, nil, nil, nil)
+	if _, _, _, err := fromCaller.sl.AppendBlock(context.Background(), bad, types.EmptyHeader(), common.Hash{}, false, nil); !errors.Is(err, ErrTxRootMismatch) {
+		t.Fatalf("error mismatch: have %v, want %v", err, ErrTxRootMismatch)
+	}
+}
+
+func TestServiceBlocksDropsAfterMaxRetries(t *testing.T) {
+	defer func(location common.Location) { common.NodeLocation = location }(common.NodeLocation)
+	common.NodeLocation = common.Location{0, 0}
+
+	tc := newTestSubChain(t, nil, nil)
+	c := newTestCore(tc)
+
+	// The parent of the block is never written, so every attempt fails
+	parent := tc.newBlock(tc.genesis.Header(), 1, 0)
+	block := tc.newBlock(parent.Header(), 1, 0)
+	tc.sl.WriteBlock(block)
+	entry := []types.HashAndNumber{{Hash: block.Hash(), Number: block.NumberU64()}}
+	// service attempts the block, skipping the backoff of the previous attempt
+	service := func() {
+		if value, exists := c.appendQueue.Peek(block.Hash()); exists {
+			counter := value.(blockNumberAndRetryCounter)
+			counter.nextRetry = time.Time{}
+			c.appendQueue.Add(block.Hash(), counter)
+		}
+		c.serviceBlocks(entry)
+	}
+
+	for _, maxRetries := range []uint64{3, 5} {
+		if err := tc.sl.UpdateConfig(ConfigPatch{AppendQueueMaxRetries: &maxRetries}); err != nil {
+			t.Fatalf("failed to set the max retries: %v", err)
+		}
+		c.appendQueue.Add(block.Hash(), blockNumberAndRetryCounter{number: block.NumberU64()})
+		for retry := uint64(1); retry <= maxRetries; retry++ {
+			service()
+			value, exists := c.appendQueue.Peek(block.Hash())
+			if !exists {
+				t.Fatalf("max retries %d: block dropped after %d retries", maxRetries, retry)
+			}
+			if have := value.(blockNumberAndRetryCounter).retry; have != retry {
+				t.Fatalf("max retries %d: retry counter mismatch: have %d, want %d", maxRetries, have, retry)
+			}
+		}
+		service()
+		if c.appendQueue.Contains(block.Hash()) {
+			t.Fatalf("max retries %d: block kept past the max retries", maxRetries)
+		}
+	}
+}
+
+// blockingForkChoice holds every head decision until it is released
+type blockingForkChoice struct {
+	ForkChoice
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (f *blockingForkChoice) ShouldReorg(current, candidate *types.Header, currentTd, candidateTd *big.Int) (bool, error) {
+	f.entered <- struct{}{}
+	<-f.release
+	return f.ForkChoice.ShouldReorg(current, candidate, currentTd, candidateTd)
+}
+
+func TestProcAppendQueueSingleFlight(t *testing.T) {
+	defer func(location common.Location) { common.NodeLocation = location }(common.NodeLocation)
+	common.NodeLocation = common.Location{0, 0}
+
+	tc := newTestSubChain(t, nil, nil)
+	c := newTestCore(tc)
+	forkChoice := &blockingForkChoice{ForkChoice: tc.sl.currentForkChoice(), entered: make(chan struct{}, 2), release: make(chan struct{})}
+	tc.sl.SetForkChoice(forkChoice)
+
+	block := tc.newBlock(tc.genesis.Header(), 1, 0)
+	tc.sl.WriteBlock(block)
+	c.appendQueue.Add(block.Hash(), blockNumberAndRetryCounter{number: block.NumberU64()})
+
+	first := make(chan struct{})
+	go func() {
+		c.procAppendQueue()
+		close(first)
+	}()
+	select {
+	case <-forkChoice.entered:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("queued block not appended")
+	}
+
+	// The runs started while the first one is in flight return at once,
+	// without attempting the queued block again
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.procAppendQueue()
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		close(forkChoice.release)
+		t.Fatalf("concurrent runs waited on the run in flight")
+	}
+	value, _ := c.appendQueue.Peek(block.Hash())
+	if retry := value.(blockNumberAndRetryCounter).retry; retry != 1 {
+		t.Errorf("retry counter mismatch: have %d, want 1", retry)
+	}
+
+	close(forkChoice.release)
+	<-first
+	select {
+	case <-forkChoice.entered:
+		t.Fatalf("queued block attempted by a concurrent run")
+	default:
+	}
+	if have := tc.sl.hc.CurrentHeader().Hash(); have != block.Hash() {
+		t.Fatalf("head mismatch: have %x, want %x", have, block.Hash())
+	}
+	// Once the run is over the next one goes ahead
+	if atomic.LoadInt32(&c.appendQueueProcessing) != 0 {
+		t.Fatalf("append queue still marked as processing")
+	}
+	// Wait for the pending etxs of the block to be sent before the node
+	// location is restored
+	select {
+	case <-tc.dom.received:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("pending etxs not sent to the dom")
+	}
+}
+
+func TestRedialClientsOnlyFailed(t *testing.T) {
+	defer func(location common.Location) { common.NodeLocation = location }(common.NodeLocation)
+	common.NodeLocation = common.Location{0}
+
+	url := newTestDom(t, &testDomAPI{received: make(chan common.Hash, 1)})
+	dial := func() *quaiclient.Client {
+		client, err := dialClient(url, time.Second)
+		if err != nil {
+			t.Fatalf("failed to dial: %v", err)
+		}
+		return client
+	}
+	sl, _ := newTestSlice()
+	sl.dialTimeout = time.Second
+	sl.domClientUrl = url
+	sl.subClientUrls = []string{url, url}
+	domClient, healthySub, failedSub := dial(), dial(), dial()
+	sl.domClient = domClient
+	sl.subClients = []*quaiclient.Client{healthySub, failedSub, nil}
+
+	// Only the last request to the second sub failed
+	sl.recordSubResult(0, nil)
+	sl.recordSubResult(1, errors.New("sub not synced"))
+	sl.recordDomResult(nil)
+	sl.redialClients()
+
+	if sl.getDomClient() != domClient {
+		t.Errorf("working dom client redialed")
+	}
+	if sl.getSubClient(0) != healthySub {
+		t.Errorf("working sub client redialed")
+	}
+	if client := sl.getSubClient(1); client == failedSub || client == nil {
+		t.Errorf("failed sub client not redialed")
+	}
+
+	// A failed dom is redialed as well
+	sl.recordDomResult(errors.New("dom not synced"))
+	sl.redialClients()
+	if sl.getDomClient() == domClient {
+		t.Errorf("failed dom client not redialed")
+	}
+}
+
+func TestDialClientTimeout(t *testing.T) {
+	// The black hole accepts connections but never answers the handshake
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer listener.Close()
+	go func() {
+		var conns []net.Conn
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				for _, conn := range conns {
+					conn.Close()
+				}
+				return
+			}
+			conns = append(conns, conn)
+		}
+	}()
+
+	timeout := 100 * time.Millisecond
+	start := time.Now()
+	client, err := dialClient("ws://"+listener.Addr().String(), timeout)
+	elapsed := time.Since(start)
+	if err == nil {
+		client.Close()
+		t.Fatalf("dial of a black hole succeeded")
+	}
+	if elapsed > 10*timeout {
+		t.Fatalf("dial took %v, want about %v", elapsed, timeout)
+	}
+	if !strings.Contains(err.Error(), "timed out after") {
+		t.Fatalf("error mismatch: have %v, want a dial timeout", err)
+	}
+}
+
+// restartableDom serves the dom api over websocket on a fixed address, and can
+// be taken down and brought back up on the same address
+type restartableDom struct {
+	t      *testing.T
+	api    interface{}
+	addr   string
+	server *rpc.Server
+	http   *http.Server
+}
+
+func newRestartableDom(t *testing.T, api interface{}) *restartableDom {
+	t.Helper()
+	d := &restartableDom{t: t, api: api, addr: "127.0.0.1:0"}
+	d.start()
+	t.Cleanup(d.stop)
+	return d
+}
+
+func (d *restartableDom) url() string { return "ws://" + d.addr }
+
+// start serves the api on the address, dropping the connections of the
+// previous run
+func (d *restartableDom) start() {
+	d.t.Helper()
+	listener, err := net.Listen("tcp", d.addr)
+	if err != nil {
+		d.t.Fatalf("failed to listen: %v", err)
+	}
+	d.addr = listener.Addr().String()
+	d.server = rpc.NewServer()
+	if err := d.server.RegisterName("quai", d.api); err != nil {
+		d.t.Fatalf("failed to register the api: %v", err)
+	}
+	d.http = &http.Server{Handler: d.server.WebsocketHandler([]string{"*"})}
+	go d.http.Serve(listener)
+}
+
+// stop stops accepting connections and closes the open ones
+func (d *restartableDom) stop() {
+	if d.http == nil {
+		return
+	}
+	d.http.Close()
+	d.server.Stop()
+	d.http = nil
+}
+
+func TestReconnectAfterDrop(t *testing.T) {
+	setTestLocation(t, common.Location{0})
+
+	dom := newRestartableDom(t, &testDomAPI{received: make(chan common.Hash, 16)})
+	sub := newRestartableDom(t, &testDomAPI{received: make(chan common.Hash, 16)})
+
+	sl, _ := newTestSlice()
+	sl.quit = make(chan struct{})
+	defer close(sl.quit)
+	sl.dialTimeout = 100 * time.Millisecond
+	sl.reconnectBackoff = 20 * time.Millisecond
+	sl.reconnectMaxBackoff = 40 * time.Millisecond
+	sl.domClientUrl = dom.url()
+	sl.subClientUrls = []string{sub.url()}
+	sl.subClients = make([]*quaiclient.Client, 1)
+	if err := sl.dialDomClient(); err != nil {
+		t.Fatal(err)
+	}
+	if err := sl.dialSubClient(0); err != nil {
+		t.Fatal(err)
+	}
+	domClient, subClient := sl.getDomClient(), sl.getSubClient(0)
+
+	// requestDom and requestSub make a request to the client and record its
+	// result the way the slice does
+	pEtxs := types.PendingEtxs{Header: newTestHeader(nil, 1, 0)}
+	requestDom := func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		err := sl.getDomClient().SendPendingEtxsToDom(ctx, pEtxs)
+		sl.recordDomResult(err)
+		return err
+	}
+	requestSub := func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		err := sl.getSubClient(0).SendPendingEtxsToDom(ctx, pEtxs)
+		sl.recordSubResult(0, err)
+		return err
+	}
+	if err := requestDom(); err != nil {
+		t.Fatalf("dom request failed: %v", err)
+	}
+	if err := requestSub(); err != nil {
+		t.Fatalf("sub request failed: %v", err)
+	}
+
+	// Both clients drop, the failed requests mark them unreachable and start a
+	// reconnection which keeps failing while they are down
+	dom.stop()
+	sub.stop()
+	if err := requestDom(); !isConnectionError(err) {
+		t.Fatalf("dom request on a dropped connection: have %v, want a connection error", err)
+	}
+	if err := requestSub(); !isConnectionError(err) {
+		t.Fatalf("sub request on a dropped connection: have %v, want a connection error", err)
+	}
+	if sl.domReachable() || atomic.LoadInt32(&sl.subUnreachable[0]) != 1 {
+		t.Fatalf("dropped clients not marked unreachable")
+	}
+	if atomic.LoadInt32(&sl.domReconnecting) != 1 || atomic.LoadInt32(&sl.subReconnecting[0]) != 1 {
+		t.Fatalf("no reconnection started")
+	}
+	time.Sleep(5 * sl.reconnectMaxBackoff)
+	if sl.getDomClient() != domClient || sl.getSubClient(0) != subClient {
+		t.Fatalf("clients replaced while down")
+	}
+
+	// Once they accept connections again the reconnection replaces the clients
+	// after its backoff, and the requests recover
+	dom.start()
+	sub.start()
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&sl.domReconnecting) == 1 || atomic.LoadInt32(&sl.subReconnecting[0]) == 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("not reconnected")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if sl.getDomClient() == domClient || sl.getSubClient(0) == subClient {
+		t.Fatalf("clients not replaced by the reconnection")
+	}
+	if err := requestDom(); err != nil {
+		t.Fatalf("dom request after the reconnection failed: %v", err)
+	}
+	if err := requestSub(); err != nil {
+		t.Fatalf("sub request after the reconnection failed: %v", err)
+	}
+	if !sl.domReachable() || atomic.LoadInt32(&sl.subUnreachable[0]) != 0 {
+		t.Fatalf("recovered clients still marked unreachable")
+	}
+}
+
+// slowSub is a sub whose pending header relays block until released
+type slowSub struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (api *slowSub) SubRelayPendingHeader(ctx context.Context, raw json.RawMessage) error {
+	api.started <- struct{}{}
+	select {
+	case <-api.release:
+	case <-ctx.Done():
+	}
+	return nil
+}
+
+func TestRelayToSlowSub(t *testing.T) {
+	defer func(location common.Location) { common.NodeLocation = location }(common.NodeLocation)
+	common.NodeLocation = common.Location{0}
+
+	api := &slowSub{started: make(chan struct{}, 1), release: make(chan struct{})}
+	client, err := dialClient(newTestDom(t, api), time.Second)
+	if err != nil {
+		t.Fatalf("failed to dial the sub: %v", err)
+	}
+	sl, _ := newTestSlice()
+	sl.subClients = []*quaiclient.Client{client, nil, nil}
+
+	ph := types.NewPendingHeader(newTestHeader(nil, 1, 0), types.EmptyTermini())
+	done := make(chan struct{})
+	go func() {
+		sl.phCacheMu.Lock()
+		defer sl.phCacheMu.Unlock()
+		sl.relayPh(nil, ph, false, common.Location{0, 0}, true)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("relay waited for the slow sub")
+	}
+	select {
+	case <-api.started:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("pending header not relayed to the sub")
+	}
+	// The sub is still busy with the relay, the pending header cache is free
+	locked := make(chan struct{})
+	go func() {
+		sl.phCacheMu.Lock()
+		sl.phCacheMu.Unlock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("pending header cache held during the relay to the slow sub")
+	}
+	close(api.release)
+	sl.relayWg.Wait()
+}
+
+func TestAppendWritesEtxRollup(t *testing.T) {
+	setTestLocation(t, common.Location{0})
+
+	tc := newTestSubChain(t, nil, []string{newTestDom(t, &testSubAPI{})})
+
+	// The pending etxs of the genesis, which the sub manifest of the block
+	// references, carry etxs to another zone
+	etxs := make(types.Transactions, 2)
+	for i := range etxs {
+		to := common.BytesToAddress([]byte{0x0a, byte(i)})
+		etxs[i] = types.NewTx(&types.ExternalTx{ChainID: big.NewInt(1), Nonce: uint64(i), GasTipCap: big.NewInt(0), GasFeeCap: big.NewInt(0), Gas: 21000, To: &to, Value: big.NewInt(0)})
+	}
+	tc.sl.hc.pendingEtxs.Add(tc.genesis.Hash(), types.PendingEtxs{Header: tc.genesis.Header(), Etxs: etxs})
+	block := tc.newBlock(tc.genesis.Header(), 1, 0)
+	header := block.Header()
+	header.SetEtxRollupHash(types.EtxRollupHash(etxs, trie.NewStackTrie(nil)))
+	block = types.NewBlockWithHeader(header).WithBody(nil, nil, nil, block.SubManifest())
+
+	// The first attempt collects the rollup and fails, the collected rollup is
+	// evicted before the block is appended again
+	atomic.StoreInt32(&tc.db.failWrites, 1)
+	_, _, _, err := tc.appendBlock(context.Background(), block)
+	atomic.StoreInt32(&tc.db.failWrites, 0)
+	if !errors.Is(err, errTestWrite) {
+		t.Fatalf("error mismatch: have %v, want %v", err, errTestWrite)
+	}
+	tc.sl.hc.subRollupCache.Purge()
+	if _, _, _, err := tc.appendBlock(context.Background(), block); err != nil {
+		t.Fatalf("failed to append the block: %v", err)
+	}
+
+	rollup, err := tc.sl.GetRollup(block.Hash())
+	if err != nil {
+		t.Fatalf("failed to retrieve the rollup: %v", err)
+	}
+	if len(rollup) != len(etxs) {
+		t.Fatalf("rollup length mismatch: have %d, want %d", len(rollup), len(etxs))
+	}
+	for i, etx := range rollup {
+		if etx.Hash() != etxs[i].Hash() {
+			t.Errorf("etx %d mismatch: have %x, want %x", i, etx.Hash(), etxs[i].Hash())
+		}
+	}
+}
+
+func TestGetBodyByNumberCache(t *testing.T) {
+	setTestLocation(t, common.Location{0, 0})
+
+	tc := newTestSubChain(t, nil, nil)
+	block := tc.newBlock(tc.genesis.Header(), 1, 0)
+	written := block.WithBody(types.Transactions{newTestTx(0)}, nil, nil, nil)
+	tc.sl.WriteBlock(written)
+
+	// The written body is served from the cache, and matches the database
+	checkBody := func(want *types.Transaction) {
+		t.Helper()
+		for name, body := range map[string]*types.Body{
+			"cached":   tc.sl.hc.GetBodyByNumber(block.Hash(), block.NumberU64()),
+			"database": rawdb.ReadBody(tc.db, block.Hash(), block.NumberU64()),
+		} {
+			if body == nil || len(body.Transactions) != 1 {
+				t.Fatalf("%s body mismatch: have %v, want one transaction", name, body)
+			}
+			if have := body.Transactions[0].Hash(); have != want.Hash() {
+				t.Errorf("%s body transaction mismatch: have %x, want %x", name, have, want.Hash())
+			}
+		}
+	}
+	if _, ok := tc.sl.hc.bc.bodyCache.Peek(block.Hash()); !ok {
+		t.Fatalf("written body not cached")
+	}
+	checkBody(written.Transactions()[0])
+
+	// A miss reads the database and caches the body
+	tc.sl.hc.bc.bodyCache.Purge()
+	checkBody(written.Transactions()[0])
+	if _, ok := tc.sl.hc.bc.bodyCache.Peek(block.Hash()); !ok {
+		t.Errorf("body read from the database not cached")
+	}
+
+	// A re-write of the same hash overwrites the cached body and its encoding
+	if rlp := tc.sl.hc.GetBodyRLP(block.Hash()); len(rlp) == 0 {
+		t.Fatalf("body encoding not found")
+	}
+	rewritten := block.WithBody(types.Transactions{newTestTx(1)}, nil, nil, nil)
+	tc.sl.WriteBlock(rewritten)
+	checkBody(rewritten.Transactions()[0])
+	if have, want := tc.sl.hc.GetBodyRLP(block.Hash()), rawdb.ReadBodyRLP(tc.db, block.Hash(), block.NumberU64()); !bytes.Equal(have, want) {
+		t.Errorf("body encoding mismatch: have %x, want %x", have, want)
+	}
+}
+
+func TestCheckTerminusChain(t *testing.T) {
+	setTestLocation(t, common.Location{0, 0})
+
+	tc := newTestSubChain(t, nil, nil)
+	// Four blocks on top of the genesis, written without being appended so
+	// that their termini are set here
+	headers := make([]*types.Header, 4)
+	parent := tc.genesis.Header()
+	for i := range headers {
+		headers[i] = tc.newBlock(parent, 1, 0).Header()
+		rawdb.WriteHeader(tc.db, headers[i])
+		parent = headers[i]
+	}
+	header := tc.newBlock(parent, 1, 0).Header()
+	setDomTerminus := func(hash common.Hash, domTerminus common.Hash) {
+		termini := types.EmptyTermini()
+		termini.SetDomTerminiAtIndex(domTerminus, common.NodeLocation.DomIndex())
+		rawdb.WriteTermini(tc.db, hash, termini)
+	}
+	// Each block is the dom terminus of the next one
+	setLegitTermini := func() {
+		setDomTerminus(headers[0].Hash(), tc.genesis.Hash())
+		for i := 1; i < len(headers); i++ {
+			setDomTerminus(headers[i].Hash(), headers[i-1].Hash())
+		}
+	}
+
+	tests := []struct {
+		name     string
+		depth    int
+		prepare  func()
+		terminus common.Hash
+		err      error
+	}{
+		{"back to the genesis", c_cyclicCheckDepth, func() {}, headers[2].Hash(), nil},
+		{"unknown terminus", c_cyclicCheckDepth, func() {}, common.Hash{0xaa}, nil},
+		{"references the header", c_cyclicCheckDepth, func() {}, header.Hash(), ErrCyclicReference},
+		{"references itself", c_cyclicCheckDepth, func() {
+			setDomTerminus(headers[1].Hash(), headers[2].Hash())
+		}, headers[2].Hash(), ErrCyclicReference},
+		// The walk from the fourth block goes through the second one back to
+		// the fourth, through the dom terminus of the first block
+		{"multi-hop cycle", c_cyclicCheckDepth, func() {
+			setDomTerminus(headers[0].Hash(), headers[3].Hash())
+		}, headers[3].Hash(), ErrCyclicReference},
+		{"cycle past the depth", 2, func() {
+			setDomTerminus(headers[0].Hash(), headers[3].Hash())
+		}, headers[3].Hash(), nil},
+		{"walk disabled", -1, func() {}, header.Hash(), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setLegitTermini()
+			tt.prepare()
+			tc.sl.cyclicCheckDepth = tt.depth
+			if err := tc.sl.checkTerminusChain(header, tt.terminus); !errors.Is(err, tt.err) {
+				t.Errorf("error mismatch: have %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestPreverifySeals(t *testing.T) {
+	setTestLocation(t, common.Location{0, 0})
+
+	errSeal := errors.New("invalid seal")
+	tc := newTestSubChain(t, nil, nil)
+	valid := tc.newBlock(tc.genesis.Header(), 1, 0)
+	invalid := tc.newBlock(valid.Header(), 1, 0)
+	forged := tc.newBlock(valid.Header(), 1, 1)
+	tc.engine.setSealErr(invalid.Header(), errSeal)
+
+	headers := []*types.Header{valid.Header(), invalid.Header(), forged.Header()}
+	seals := tc.engine.sealsVerified()
+	tc.sl.PreverifySeals(headers)
+	if have := tc.engine.sealsVerified() - seals; have != len(headers) {
+		t.Fatalf("verified seals mismatch: have %d, want %d", have, len(headers))
+	}
+	// The results are memoized
+	tc.sl.PreverifySeals(headers)
+	if have := tc.engine.sealsVerified() - seals; have != len(headers) {
+		t.Errorf("seals verified again: have %d, want %d", have, len(headers))
+	}
+
+	if _, _, _, err := tc.appendBlock(context.Background(), valid); err != nil {
+		t.Fatalf("failed to append the valid block: %v", err)
+	}
+	// The invalid seal is rejected from the memoized result, before the engine
+	// is asked again
+	seals = tc.engine.sealsVerified()
+	if _, _, _, err := tc.appendBlock(context.Background(), invalid); !errors.Is(err, errSeal) {
+		t.Errorf("error mismatch for the invalid seal: have %v, want %v", err, errSeal)
+	}
+	if have := tc.engine.sealsVerified(); have != seals {
+		t.Errorf("invalid seal verified again: have %d, want %d", have, seals)
+	}
+	// A header with the hash of a verified one but another mix hash does not
+	// reuse the result, its seal is verified by the engine and rejected
+	tc.engine.setSealErr(forged.Header(), errSeal)
+	header := forged.Header()
+	header.SetMixHash(common.Hash{0xaa})
+	if header.Hash() != forged.Hash() {
+		t.Fatalf("mix hash covered by the block hash")
+	}
+	if _, _, _, err := tc.appendBlock(context.Background(), forged.WithSeal(header)); !errors.Is(err, errSeal) {
+		t.Errorf("error mismatch for the forged mix hash: have %v, want %v", err, errSeal)
+	}
+	if have := tc.sl.hc.CurrentHeader().Hash(); have != valid.Hash() {
+		t.Errorf("head mismatch: have %x, want %x", have, valid.Hash())
+	}
+}
+
+// newTestPendingEtxs returns the pending etxs of a block emitting n etxs, with
+// the etx hash of the header committing to them
+func newTestPendingEtxs(seed byte, n int) types.PendingEtxs {
+	etxs := make(types.Transactions, n)
+	for i := range etxs {
+		to := common.BytesToAddress([]byte{0x0a, byte(i)})
+		etxs[i] = types.NewTx(&types.ExternalTx{ChainID: big.NewInt(1), Nonce: uint64(i), GasTipCap: big.NewInt(0), GasFeeCap: big.NewInt(0), Gas: 21000, To: &to, Value: big.NewInt(0)})
+	}
+	header := types.EmptyHeader()
+	header.SetExtra([]byte{seed})
+	header.SetEtxHash(types.DeriveSha(etxs, trie.NewStackTrie(nil)))
+	return types.PendingEtxs{Header: header, Etxs: etxs}
+}
+
+func TestSubscribePendingEtxsEvent(t *testing.T) {
+	setTestLocation(t, common.Location{0, 0})
+
+	tc := newTestSubChain(t, nil, nil)
+	events := make(chan PendingEtxsEvent, 4)
+	sub := tc.sl.SubscribePendingEtxsEvent(events)
+	defer sub.Unsubscribe()
+
+	pEtxs := newTestPendingEtxs(1, 2)
+	if err := tc.sl.AddPendingEtxs(pEtxs); err != nil {
+		t.Fatalf("failed to add the pending etxs: %v", err)
+	}
+	select {
+	case ev := <-events:
+		if ev.Header != pEtxs.Header.Hash() || ev.Count != len(pEtxs.Etxs) {
+			t.Errorf("event mismatch: have %x with %d etxs, want %x with %d etxs", ev.Header, ev.Count, pEtxs.Header.Hash(), len(pEtxs.Etxs))
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("no event for the new pending etxs")
+	}
+	// The set is written by the time the event is sent
+	if rawdb.ReadPendingEtxs(tc.db, pEtxs.Header.Hash()) == nil {
+		t.Errorf("pending etxs not written")
+	}
+
+	// A duplicate and a rejected set are not notified
+	if err := tc.sl.AddPendingEtxs(pEtxs); err != nil {
+		t.Fatalf("failed to add the duplicate pending etxs: %v", err)
+	}
+	invalid := newTestPendingEtxs(2, 2)
+	invalid.Etxs = invalid.Etxs[:1]
+	if err := tc.sl.AddPendingEtxs(invalid); !errors.Is(err, ErrPendingEtxNotValid) {
+		t.Fatalf("error mismatch for the invalid pending etxs: have %v, want %v", err, ErrPendingEtxNotValid)
+	}
+	select {
+	case ev := <-events:
+		t.Errorf("unexpected event for %x", ev.Header)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestBlockOrderCached(t *testing.T) {
+	setTestLocation(t, common.Location{0, 0})
+
+	tc := newTestSubChain(t, nil, nil)
+	worker := tc.sl.miner.worker
+
+	// The order is calculated once and cached on the block
+	block := tc.newBlock(tc.genesis.Header(), 1, 0)
+	seals := tc.engine.sealsVerified()
+	for i := 0; i < 2; i++ {
+		order, err := worker.blockOrder(block)
+		if err != nil {
+			t.Fatalf("failed to get the order: %v", err)
+		}
+		if order != common.ZONE_CTX {
+			t.Errorf("order mismatch: have %d, want %d", order, common.ZONE_CTX)
+		}
+	}
+	if have := tc.engine.sealsVerified() - seals; have != 1 {
+		t.Errorf("order calculations mismatch: have %d, want 1", have)
+	}
+	if order, ok := block.GetOrder(); !ok || order != common.ZONE_CTX {
+		t.Errorf("cached order mismatch: have %d (%v), want %d", order, ok, common.ZONE_CTX)
+	}
+
+	// An order cached on the block wins over the engine
+	cached := tc.newBlock(tc.genesis.Header(), 1, 1)
+	cached.SetOrder(common.REGION_CTX)
+	seals = tc.engine.sealsVerified()
+	if order, err := worker.blockOrder(cached); err != nil || order != common.REGION_CTX {
+		t.Errorf("order mismatch: have %d (%v), want %d", order, err, common.REGION_CTX)
+	}
+	if have := tc.engine.sealsVerified(); have != seals {
+		t.Errorf("cached order calculated again")
+	}
+
+	// The append calculates the order of the block, and the manifest of the
+	// block its coincidence, the pending header generation reuses the order
+	seals = tc.engine.sealsVerified()
+	if _, _, _, err := tc.appendBlock(context.Background(), block); err != nil {
+		t.Fatalf("failed to append the block: %v", err)
+	}
+	if have := tc.engine.sealsVerified() - seals; have != 2 {
+		t.Errorf("order calculations during the append mismatch: have %d, want 2", have)
+	}
+}
+
+func TestAddPendingEtxsRejectsMismatchingEtxs(t *testing.T) {
+	setTestLocation(t, common.Location{0})
+
+	tc := newTestSubChain(t, nil, []string{newTestDom(t, &testSubAPI{})})
+	relayed := make(chan types.PendingEtxs, 8)
+	sub := tc.sl.pendingEtxsFeed.Subscribe(relayed)
+	defer sub.Unsubscribe()
+
+	tampered := newTestPendingEtxs(2, 2)
+	to := common.BytesToAddress([]byte{0x0b})
+	tampered.Etxs[1] = types.NewTx(&types.ExternalTx{ChainID: big.NewInt(1), Nonce: 1, GasTipCap: big.NewInt(0), GasFeeCap: big.NewInt(0), Gas: 21000, To: &to, Value: big.NewInt(1)})
+	missing := newTestPendingEtxs(3, 2)
+	missing.Etxs = missing.Etxs[:1]
+	extra := newTestPendingEtxs(4, 2)
+	extra.Etxs = append(extra.Etxs, tampered.Etxs[1])
+	noEtxs := newTestPendingEtxs(5, 2)
+	noEtxs.Etxs = nil
+
+	tests := []struct {
+		name  string
+		pEtxs types.PendingEtxs
+		err   error
+	}{
+		{"matching", newTestPendingEtxs(1, 2), nil},
+		{"matching empty", newTestPendingEtxs(6, 0), nil},
+		{"tampered etx", tampered, ErrPendingEtxNotValid},
+		{"missing etx", missing, ErrPendingEtxNotValid},
+		{"extra etx", extra, ErrPendingEtxNotValid},
+		{"no etxs", noEtxs, ErrPendingEtxNotValid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hash := tt.pEtxs.Header.Hash()
+			if err := tc.sl.AddPendingEtxs(tt.pEtxs); !errors.Is(err, tt.err) {
+				t.Fatalf("error mismatch: have %v, want %v", err, tt.err)
+			}
+			written := rawdb.ReadPendingEtxs(tc.db, hash) != nil
+			if written != (tt.err == nil) {
+				t.Errorf("pending etxs written: have %v, want %v", written, tt.err == nil)
+			}
+			if cached := tc.sl.hc.pendingEtxs.Contains(hash); cached != (tt.err == nil) {
+				t.Errorf("pending etxs cached: have %v, want %v", cached, tt.err == nil)
+			}
+			// Only the matching sets are relayed to the peers and the dom
+			wait := 50 * time.Millisecond
+			if tt.err == nil {
+				wait = time.Second
+			}
+			select {
+			case pEtxs := <-relayed:
+				if tt.err != nil || pEtxs.Header.Hash() != hash {
+					t.Errorf("pending etxs %x relayed to the peers", pEtxs.Header.Hash())
+				}
+			case <-time.After(wait):
+				if tt.err == nil {
+					t.Errorf("pending etxs not relayed to the peers")
+				}
+			}
+			select {
+			case have := <-tc.dom.received:
+				if tt.err != nil || have != hash {
+					t.Errorf("pending etxs %x sent to the dom", have)
+				}
+			case <-time.After(wait):
+				if tt.err == nil {
+					t.Errorf("pending etxs not sent to the dom")
+				}
+			}
+		})
+	}
+}
+
+func TestAppendQueuePersistence(t *testing.T) {
+	setTestLocation(t, common.Location{0, 0})
+
+	tc := newTestSubChain(t, nil, nil)
+	if hashes := rawdb.ReadAppendQueue(tc.db); hashes != nil {
+		t.Fatalf("append queue stored on a fresh database: %v", hashes)
+	}
+	headers := tc.appendChain(t, tc.genesis.Header(), c_appendQueueRemoveThreshold+2, 0)
+	head := headers[len(headers)-1]
+
+	// Blocks written but not appended yet, on top of the head, on a recent
+	// fork and on a fork too far behind the head
+	next := tc.newBlock(head, 1, 0)
+	recent := tc.newBlock(headers[len(headers)-2], 1, 1)
+	old := tc.newBlock(tc.genesis.Header(), 1, 2)
+	for _, block := range []*types.Block{next, recent, old} {
+		tc.sl.WriteBlock(block)
+	}
+	rawdb.WriteAppendQueue(tc.db, []common.Hash{next.Hash(), head.Hash(), {0xaa}, recent.Hash(), old.Hash()})
+
+	// Only the blocks still waiting to be appended are loaded, and the stored
+	// queue is deleted
+	c := newTestCore(tc)
+	c.loadAppendQueue()
+	want := map[common.Hash]bool{next.Hash(): true, recent.Hash(): true}
+	checkQueue := func(hashes []common.Hash) {
+		t.Helper()
+		if len(hashes) != len(want) {
+			t.Fatalf("queue length mismatch: have %d, want %d", len(hashes), len(want))
+		}
+		for _, hash := range hashes {
+			if !want[hash] {
+				t.Errorf("unexpected block %x in the queue", hash)
+			}
+		}
+	}
+	keys := c.appendQueue.Keys()
+	loaded := make([]common.Hash, len(keys))
+	for i, key := range keys {
+		loaded[i] = key.(common.Hash)
+	}
+	checkQueue(loaded)
+	if hashes := rawdb.ReadAppendQueue(tc.db); hashes != nil {
+		t.Errorf("stored append queue not deleted: %v", hashes)
+	}
+
+	// The stored queue round trips through the database
+	c.storeAppendQueue()
+	checkQueue(rawdb.ReadAppendQueue(tc.db))
+	restarted := newTestCore(tc)
+	restarted.loadAppendQueue()
+	if have := restarted.appendQueue.Len(); have != len(want) {
+		t.Errorf("reloaded queue length mismatch: have %d, want %d", have, len(want))
+	}
+}
+
+func TestCheckFutureTime(t *testing.T) {
+	tests := []struct {
+		name          string
+		timestamp     uint64
+		now           int64
+		maxFutureTime uint64
+		err           error
+	}{
+		{"zero timestamp", 0, 100, 15, ErrImplausibleTimestamp},
+		{"zero timestamp without bound", 0, 100, math.MaxUint64, ErrImplausibleTimestamp},
+		{"past", 1, 100, 15, nil},
+		{"now", 100, 100, 15, nil},
+		{"at the bound", 115, 100, 15, nil},
+		{"past the bound", 116, 100, 15, consensus.ErrFutureBlock},
+		{"no future time allowed", 101, 100, 0, consensus.ErrFutureBlock},
+		{"max timestamp", math.MaxUint64, 100, 15, consensus.ErrFutureBlock},
+		{"max timestamp at a max now", math.MaxUint64, math.MaxInt64, 15, consensus.ErrFutureBlock},
+		{"max timestamp without bound", math.MaxUint64, 100, math.MaxUint64, nil},
+		{"max future time", 200, 100, math.MaxUint64, nil},
+		{"pre epoch clock", 15, -100, 15, nil},
+		{"past the bound of a pre epoch clock", 16, -100, 15, consensus.ErrFutureBlock},
+	}
+	for _, tt := range tests {
+		if err := checkFutureTime(tt.timestamp, tt.now, tt.maxFutureTime); !errors.Is(err, tt.err) {
+			t.Errorf("%s: error mismatch: have %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestForEachCanonicalBlock(t *testing.T) {
+	setTestLocation(t, common.Location{0, 0})
+
+	tc := newTestSubChain(t, nil, nil)
+	headers := tc.appendChain(t, tc.genesis.Header(), 4, 0)
+	// A block on a side chain is never visited
+	side := tc.newBlock(headers[0], 1, 1)
+	if _, _, _, err := tc.appendBlock(context.Background(), side); err != nil {
+		t.Fatalf("failed to append the side block: %v", err)
+	}
+
+	errStop := errors.New("stop")
+	tests := []struct {
+		name    string
+		from    uint64
+		to      uint64
+		stopAt  uint64 // Number of the block on which fn fails, zero for none
+		visited []int  // Indices in headers of the blocks visited
+		err     error
+	}{
+		{"full range", 1, 4, 0, []int{0, 1, 2, 3}, nil},
+		{"single block", 2, 2, 0, []int{1}, nil},
+		{"empty range", 3, 2, 0, nil, nil},
+		{"fn error", 1, 4, 2, []int{0, 1}, errStop},
+		{"past the head", 3, 6, 0, []int{2, 3}, ErrCanonicalHashNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var visited []common.Hash
+			err := tc.sl.ForEachCanonicalBlock(tt.from, tt.to, func(block *types.Block) error {
+				visited = append(visited, block.Hash())
+				if block.NumberU64() == tt.stopAt {
+					return errStop
+				}
+				return nil
+			})
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error mismatch: have %v, want %v", err, tt.err)
+			}
+			if len(visited) != len(tt.visited) {
+				t.Fatalf("visited blocks mismatch: have %d, want %d", len(visited), len(tt.visited))
+			}
+			for i, index := range tt.visited {
+				if visited[i] != headers[index].Hash() {
+					t.Errorf("block %d mismatch: have %x, want %x", i, visited[i], headers[index].Hash())
+				}
+			}
+		})
+	}
+}
+
+func TestSuggestGasPrice(t *testing.T) {
+	setTestLocation(t, common.Location{0, 0})
+
+	tc := newTestSubChain(t, nil, nil)
+	// setBaseFee sets a known base fee in the best pending header
+	setBaseFee := func(baseFee int64) {
+		t.Helper()
+		ph, exists := tc.sl.readBestPh()
+		if !exists {
+			t.Fatalf("best pending header not found")
+		}
+		header := types.CopyHeader(ph.Header())
+		header.SetBaseFee(big.NewInt(baseFee))
+		tc.sl.phCacheMu.Lock()
+		tc.sl.writePhCache(tc.sl.bestPhKey, types.NewPendingHeader(header, ph.Termini()))
+		tc.sl.phCacheMu.Unlock()
+	}
+	checkPrice := func(want *big.Int) {
+		t.Helper()
+		price, err := tc.sl.SuggestGasPrice(common.ZONE_CTX)
+		if err != nil {
+			t.Fatalf("failed to suggest a gas price: %v", err)
+		}
+		if price.Cmp(want) != 0 {
+			t.Errorf("gas price mismatch: have %v, want %v", price, want)
+		}
+	}
+
+	// Without transactions the default tip is suggested
+	setBaseFee(1000)
+	checkPrice(big.NewInt(1000 + c_defaultGasTip))
+
+	// The lowest tips of the recent blocks are 3, 9 and 6, a block without
+	// transactions is ignored
+	parent := tc.genesis.Header()
+	for i, tips := range [][]int64{{5, 3}, {9}, nil, {6, 7}} {
+		txs := make(types.Transactions, len(tips))
+		for j, tip := range tips {
+			txs[j] = types.NewTx(&types.InternalTx{ChainID: big.NewInt(1), Nonce: uint64(i*10 + j), GasTipCap: big.NewInt(tip), GasFeeCap: big.NewInt(100), Gas: 21000, Value: big.NewInt(0), V: big.NewInt(0), R: big.NewInt(0), S: big.NewInt(0)})
+		}
+		block := types.NewBlock(tc.newBlock(parent, 1, 0).Header(), txs, nil, nil, nil, nil, trie.NewStackTrie(nil))
+		if _, _, _, err := tc.appendBlock(context.Background(), block); err != nil {
+			t.Fatalf("failed to append block %d: %v", i, err)
+		}
+		parent = block.Header()
+	}
+	setBaseFee(1000)
+	checkPrice(big.NewInt(1006))
+
+	for _, ctx := range []int{-1, common.PRIME_CTX, common.REGION_CTX, common.HierarchyDepth} {
+		if _, err := tc.sl.SuggestGasPrice(ctx); !errors.Is(err, ErrInvalidContext) {
+			t.Errorf("context %d: error mismatch: have %v, want %v", ctx, err, ErrInvalidContext)
+		}
+	}
+}
+
+func TestStalePendingHeaders(t *testing.T) {
+	setTestLocation(t, common.Location{0, 0})
+
+	tc := newTestSubChain(t, nil, nil)
+	orphan := tc.newBlock(tc.genesis.Header(), 1, 0)
+	if _, _, _, err := tc.appendBlock(context.Background(), orphan); err != nil {
+		t.Fatalf("failed to append the block: %v", err)
+	}
+	if stale := tc.sl.StalePendingHeaders(); len(stale) != 0 {
+		t.Fatalf("stale pending headers on the canonical chain: %x", stale)
+	}
+	onOrphan, exists := tc.sl.readBestPh()
+	if !exists || onOrphan.Header().ParentHash() != orphan.Hash() {
+		t.Fatalf("pending header not built on the block")
+	}
+
+	// A heavier fork reorgs the orphan out. The entry of the terminus is moved
+	// to the fork, the pending header built on the orphan is left behind under
+	// a terminus which is no longer updated.
+	fork := tc.newBlock(tc.genesis.Header(), 2, 1)
+	if _, _, _, err := tc.appendBlock(context.Background(), fork); err != nil {
+		t.Fatalf("failed to append the fork: %v", err)
+	}
+	if head := tc.sl.hc.CurrentHeader().Hash(); head != fork.Hash() {
+		t.Fatalf("head mismatch: have %x, want %x", head, fork.Hash())
+	}
+	onFork, _ := tc.sl.readBestPh()
+	ahead := types.CopyHeader(onFork.Header())
+	ahead.SetNumber(big.NewInt(int64(fork.NumberU64()) + 2))
+	leftBehind, canonical, future := common.Hash{0xaa}, common.Hash{0xbb}, common.Hash{0xcc}
+	tc.sl.phCacheMu.Lock()
+	tc.sl.writePhCache(leftBehind, onOrphan)
+	tc.sl.writePhCache(canonical, onFork)
+	tc.sl.writePhCache(future, types.NewPendingHeader(ahead, onFork.Termini()))
+	keys := tc.sl.phCache.Keys()
+	tc.sl.phCacheMu.Unlock()
+
+	stale := tc.sl.StalePendingHeaders()
+	want := []common.Hash{leftBehind, future}
+	if !reflect.DeepEqual(stale, want) {
+		t.Errorf("stale pending headers mismatch: have %x, want %x", stale, want)
+	}
+	// The phCache is left untouched
+	tc.sl.phCacheMu.RLock()
+	defer tc.sl.phCacheMu.RUnlock()
+	if have := tc.sl.phCache.Keys(); !reflect.DeepEqual(have, keys) {
+		t.Errorf("phCache modified: have %x, want %x", have, keys)
+	}
+}
+
+func TestSubscribeChainHeadAndSideEvents(t *testing.T) {
+	setTestLocation(t, common.Location{0, 0})
+
+	tc := newTestSubChain(t, nil, nil)
+	heads := make(chan ChainHeadEvent, 4)
+	headSub := tc.sl.SubscribeChainHeadEvent(heads)
+	defer headSub.Unsubscribe()
+	sides := make(chan ChainSideEvent, 4)
+	sideSub := tc.sl.SubscribeChainSideEvent(sides)
+	defer sideSub.Unsubscribe()
+
+	// expectEvent waits for the event of the block on one feed, and makes sure
+	// that the other feed stays quiet
+	expectEvent := func(hash common.Hash, head bool) {
+		t.Helper()
+		select {
+		case ev := <-heads:
+			if !head || ev.Block.Hash() != hash {
+				t.Errorf("unexpected head event for %x", ev.Block.Hash())
+			}
+		case ev := <-sides:
+			if head || ev.Block.Hash() != hash {
+				t.Errorf("unexpected side event for %x", ev.Block.Hash())
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no event for %x", hash)
+		}
+		select {
+		case ev := <-heads:
+			t.Errorf("unexpected head event for %x", ev.Block.Hash())
+		case ev := <-sides:
+			t.Errorf("unexpected side event for %x", ev.Block.Hash())
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	head := tc.newBlock(tc.genesis.Header(), 2, 0)
+	if _, _, _, err := tc.appendBlock(context.Background(), head); err != nil {
+		t.Fatalf("failed to append the head: %v", err)
+	}
+	expectEvent(head.Hash(), true)
+
+	// A lighter block is appended on the side
+	side := tc.newBlock(tc.genesis.Header(), 1, 1)
+	if _, _, _, err := tc.appendBlock(context.Background(), side); err != nil {
+		t.Fatalf("failed to append the side block: %v", err)
+	}
+	expectEvent(side.Hash(), false)
+	if have := tc.sl.hc.CurrentHeader().Hash(); have != head.Hash() {
+		t.Errorf("head mismatch: have %x, want %x", have, head.Hash())
+	}
+
+	// No event is sent once unsubscribed
+	headSub.Unsubscribe()
+	sideSub.Unsubscribe()
+	next := tc.newBlock(head.Header(), 1, 0)
+	if _, _, _, err := tc.appendBlock(context.Background(), next); err != nil {
+		t.Fatalf("failed to append the next head: %v", err)
+	}
+	select {
+	case ev := <-heads:
+		t.Errorf("head event after unsubscribing for %x", ev.Block.Hash())
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestBlockEtxs(t *testing.T) {
+	setTestLocation(t, common.Location{0, 0})
+
+	tc := newTestSubChain(t, nil, nil)
+	empty := tc.newBlock(tc.genesis.Header(), 1, 0)
+	if _, _, _, err := tc.appendBlock(context.Background(), empty); err != nil {
+		t.Fatalf("failed to append the block without etxs: %v", err)
+	}
+	etxs := newTestPendingEtxs(1, 2).Etxs
+	emitting := types.NewBlock(tc.newBlock(empty.Header(), 1, 0).Header(), nil, nil, etxs, nil, nil, trie.NewStackTrie(nil))
+	if _, _, _, err := tc.appendBlock(context.Background(), emitting); err != nil {
+		t.Fatalf("failed to append the block with etxs: %v", err)
+	}
+
+	have, err := tc.sl.BlockEtxs(emitting.Hash())
+	if err != nil {
+		t.Fatalf("failed to get the etxs: %v", err)
+	}
+	if len(have) != len(etxs) {
+		t.Fatalf("etxs length mismatch: have %d, want %d", len(have), len(etxs))
+	}
+	for i, etx := range have {
+		if etx.Hash() != etxs[i].Hash() {
+			t.Errorf("etx %d mismatch: have %x, want %x", i, etx.Hash(), etxs[i].Hash())
+		}
+	}
+	if have, err := tc.sl.BlockEtxs(empty.Hash()); err != nil || len(have) != 0 {
+		t.Errorf("etxs of the block without etxs mismatch: have %d (%v), want none", len(have), err)
+	}
+	if _, err := tc.sl.BlockEtxs(common.Hash{0xaa}); !errors.Is(err, ErrBlockNotFound) {
+		t.Errorf("error mismatch for an unknown block: have %v, want %v", err, ErrBlockNotFound)
+	}
+
+	// A body whose etxs do not match the etx hash of the header is refused
+	tc.sl.WriteBlock(emitting.WithBody(nil, nil, etxs[:1], nil))
+	if _, err := tc.sl.BlockEtxs(emitting.Hash()); err == nil {
+		t.Errorf("etxs not matching the header returned")
+	}
+}
+
+func TestMiningStrategyEqualEntropy(t *testing.T) {
+	setTestLocation(t, common.Location{0, 0})
+
+	tests := []struct {
+		policy     EqualTdPolicy
+		lowerWins  bool
+		higherWins bool
+	}{
+		{EqualTdPreferLowestHash, true, false},
+		{EqualTdPreferFirstSeen, false, false},
+	}
+	for _, test := range tests {
+		policy := test.policy
+		tc := newTestSubChain(t, &Config{EqualTdPolicy: policy}, nil)
+		low := tc.newBlock(tc.genesis.Header(), 1, 0)
+		high := tc.newBlock(tc.genesis.Header(), 1, 1)
+		if bytes.Compare(low.Hash().Bytes(), high.Hash().Bytes()) > 0 {
+			low, high = high, low
+		}
+		for _, block := range []*types.Block{low, high} {
+			if _, _, _, err := tc.appendBlock(context.Background(), block); err != nil {
+				t.Fatalf("policy %d: failed to append the block: %v", policy, err)
+			}
+		}
+		// Both pending headers carry the same entropy, only their parents differ
+		lowPh := types.NewPendingHeader(tc.newBlock(low.Header(), 1, 0).Header(), types.EmptyTermini())
+		highPh := types.NewPendingHeader(tc.newBlock(high.Header(), 1, 0).Header(), types.EmptyTermini())
+
+		if have := tc.sl.miningStrategy(highPh, lowPh); have != test.lowerWins {
+			t.Errorf("policy %d: switch to the lower parent: have %v, want %v", policy, have, test.lowerWins)
+		}
+		if have := tc.sl.miningStrategy(lowPh, highPh); have != test.higherWins {
+			t.Errorf("policy %d: switch to the higher parent: have %v, want %v", policy, have, test.higherWins)
+		}
+	}
+}
+
+func TestAppendCoincidentUnreachableDom(t *testing.T) {
+	setTestLocation(t, common.Location{0, 0})
+
+	api := &testDomAPI{received: make(chan common.Hash, 16)}
+	server := rpc.NewServer()
+	defer server.Stop()
+	if err := server.RegisterName("quai", api); err != nil {
+		t.Fatalf("failed to register the dom api: %v", err)
+	}
+	dom := &toggleDom{down: 1, handler: server}
+	httpDom := httptest.NewServer(dom)
+	defer httpDom.Close()
+
+	tc := newTestChain(t, &Config{DomRequiredForCoincident: true}, httpDom.URL, nil)
+	tc.waitForDomClient(t)
+	// The last request to the dom failed
+	atomic.StoreInt32(&tc.sl.domUnreachable, 1)
+
+	block := tc.newBlock(tc.genesis.Header(), 1, 0)
+	tc.engine.setOrder(block.Header(), common.REGION_CTX)
+
+	// The coincident block is refused while the dom is unreachable
+	if _, _, _, err := tc.appendBlock(context.Background(), block); err != ErrDomClientNotUp {
+		t.Fatalf("strict append: have %v, want %v", err, ErrDomClientNotUp)
+	}
+	if tc.sl.hc.GetHeaderByHash(block.Hash()) != nil {
+		t.Fatalf("refused block was appended")
+	}
+	if have := tc.sl.hc.CurrentHeader().Hash(); have != tc.genesis.Hash() {
+		t.Fatalf("head moved: have %x, want %x", have, tc.genesis.Hash())
+	}
+
+	// Without the flag the block is appended optimistically, leaving its pending
+	// etxs to the retries of the send loop
+	disabled := false
+	if err := tc.sl.UpdateConfig(ConfigPatch{DomRequiredForCoincident: &disabled}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := tc.appendBlock(context.Background(), block); err != nil {
+		t.Fatalf("optimistic append failed: %v", err)
+	}
+	if have := tc.sl.hc.CurrentHeader().Hash(); have != block.Hash() {
+		t.Fatalf("head mismatch: have %x, want %x", have, block.Hash())
+	}
+}
+
+func TestHeadAndPending(t *testing.T) {
+	setTestLocation(t, common.Location{0, 0})
+	tc := newTestSubChain(t, nil, nil)
+	nodeCtx := common.NodeLocation.Context()
+
+	// checkSnapshot checks that the termini and the pending header are those of
+	// the head
+	checkSnapshot := func(head *types.Header, termini types.Termini, pending types.PendingHeader) error {
+		if want := tc.sl.hc.GetTerminiByHash(head.Hash()); want == nil || want.DomTerminus() != termini.DomTerminus() {
+			return fmt.Errorf("termini of another head: have %x", termini.DomTerminus())
+		}
+		if pending.Header().ParentHash(nodeCtx) != head.Hash() {
+			return fmt.Errorf("pending header of another head: have parent %x, want %x", pending.Header().ParentHash(nodeCtx), head.Hash())
+		}
+		return nil
+	}
+
+	head, termini, pending, err := tc.sl.HeadAndPending()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if head.Hash() != tc.genesis.Hash() {
+		t.Fatalf("head mismatch: have %x, want %x", head.Hash(), tc.genesis.Hash())
+	}
+	if err := checkSnapshot(head, termini, pending); err != nil {
+		t.Fatal(err)
+	}
+
+	// The snapshots taken while blocks are appended are never torn
+	done := make(chan error, 1)
+	go func() {
+		parent := tc.genesis.Header()
+		for i := 0; i < 20; i++ {
+			block := tc.newBlock(parent, 1, 0)
+			if _, _, _, err := tc.appendBlock(context.Background(), block); err != nil {
+				done <- fmt.Errorf("failed to append block %d: %v", i, err)
+				return
+			}
+			parent = block.Header()
+		}
+		done <- nil
+	}()
+	snapshots := 0
+	for appending := true; appending; snapshots++ {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+			appending = false
+		default:
+		}
+		head, termini, pending, err := tc.sl.HeadAndPending()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := checkSnapshot(head, termini, pending); err != nil {
+			t.Fatalf("snapshot %d at block %d: %v", snapshots, head.NumberU64(), err)
+		}
+	}
+	if head := tc.sl.hc.CurrentHeader(); head.NumberU64() != 20 {
+		t.Fatalf("head number mismatch: have %d, want 20", head.NumberU64())
+	}
+}
+
+func BenchmarkConstructLocalBlock(b *testing.B) {
+	setTestLocation(b, common.Location{0, 0})
+
+	// The body of the block holds transactions, so it is not empty
+	tc := newTestSubChain(b, nil, nil)
+	txs := make(types.Transactions, 64)
+	for i := range txs {
+		txs[i] = newTestTx(uint64(i))
+	}
+	header := tc.newBlock(tc.genesis.Header(), 1, 0).Header()
+	header.SetTxHash(types.DeriveSha(txs, trie.NewStackTrie(nil)))
+	tc.sl.WriteBlock(types.NewBlockWithHeader(header).WithBody(txs, nil, nil, nil))
+
+	for _, bench := range []struct {
+		name   string
+		cached bool
+	}{{"cached", true}, {"uncached", false}} {
+		b.Run(bench.name, func(b *testing.B) {
+			atomic.StoreInt64(&tc.db.reads, 0)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if !bench.cached {
+					tc.sl.hc.bc.bodyCache.Purge()
+				}
+				if _, err := tc.sl.ConstructLocalBlock(header); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(atomic.LoadInt64(&tc.db.reads))/float64(b.N), "reads/op")
+		})
+	}
+}
+
+func BenchmarkAppendEmptyBody(b *testing.B) {
+	setTestLocation(b, common.Location{0, 0})
+
+	tc := newTestSubChain(b, nil, nil)
+	defer tc.drainDom()()
+
+	// The blocks of a zone carry no sub manifest, without transactions their
+	// body is empty
+	blocks := make([]*types.Block, b.N)
+	parent := tc.genesis.Header()
+	for i := range blocks {
+		blocks[i] = tc.newBlock(parent, 1, 0)
+		if !blocks[i].EmptyBody() {
+			b.Fatalf("block %d has a body", i)
+		}
+		parent = blocks[i].Header()
+	}
+	b.ResetTimer()
+	for _, block := range blocks {
+		if _, _, _, err := tc.appendBlock(context.Background(), block); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkSyncSeals(b *testing.B) {
+	setTestLocation(b, common.Location{0, 0})
+
+	for _, bench := range []struct {
+		name        string
+		preverified bool
+	}{{"inline", false}, {"preverified", true}} {
+		b.Run(bench.name, func(b *testing.B) {
+			tc := newTestSubChain(b, &Config{SealVerifyWorkers: 8}, nil)
+			defer tc.drainDom()()
+
+			// The blocks are synced in batches, whose seals are verified ahead of
+			// their appends or by the appends themselves. The proof of work is
+			// only given a cost once the blocks are built, since building a block
+			// computes the order of its parent.
+			blocks := make([]*types.Block, b.N)
+			parent := tc.genesis.Header()
+			for i := range blocks {
+				blocks[i] = tc.newBlock(parent, 1, 0)
+				parent = blocks[i].Header()
+			}
+			tc.engine.setSealCost(time.Millisecond)
+			const batchSize = 64
+			b.ResetTimer()
+			for start := 0; start < len(blocks); start += batchSize {
+				batch := blocks[start:]
+				if len(batch) > batchSize {
+					batch = batch[:batchSize]
+				}
+				if bench.preverified {
+					headers := make([]*types.Header, len(batch))
+					for i, block := range batch {
+						headers[i] = block.Header()
+					}
+					tc.sl.PreverifySeals(headers)
+				}
+				for _, block := range batch {
+					if _, _, _, err := tc.appendBlock(context.Background(), block); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
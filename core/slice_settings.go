@@ -0,0 +1,154 @@
+package core
+
+import (
+	"context"
+	"math/big"
+	"time"
+
+	"github.com/dominant-strategies/go-quai/common"
+	"github.com/dominant-strategies/go-quai/log"
+)
+
+// sliceSettings holds the slice config values which can be changed while the
+// slice is running. They are always read through currentSettings.
+type sliceSettings struct {
+	equalTdPolicy             EqualTdPolicy // Policy used by hlcr to resolve heads with equal total entropy
+	domRequiredForCoincident  bool          // Refuse to append coincident blocks while the dom is unreachable
+	relayPendingHeaderDiffs   bool          // Relay only the dom context fields of pending headers to the subordinates
+	verifyTermini             bool          // Warn when pcrc inherits a terminus which does not reference a known header
+	retryPersistOnStop        bool          // Retry persisting the in memory state once if it fails on stop
+	pEtxRetryThreshold        uint64        // Number of pEtxNotFound return on a dom block before asking the sub
+	appendTimeout             time.Duration // Timeout of each hierarchical request made during an append, zero disables it
+	maxReorgDepth             uint64        // Maximum number of canonical blocks a new head may reorganize, zero disables it
+	headStallTimeout          time.Duration // Time without head advance after which the slice is reported unhealthy and a recovery is attempted
+	phGCInterval              time.Duration // Time between two collections of the phCache
+	maxFutureTime             uint64        // Max time into the future (in seconds) a block is accepted in the append queue
+	appendQueueMaxRetries     uint64        // Number of times a block is retried before it is dropped from the append queue regardless of its number
+	appendQueueRetryThreshold uint64        // Number of times a block is retried before it is dropped from the append queue once it is behind the head
+}
+
+// ConfigPatch describes a change to the slice config. Only the non nil fields
+// are applied. ChainID and GenesisHash cannot be changed on a running slice and
+// a patch setting them is rejected.
+type ConfigPatch struct {
+	EqualTdPolicy             *EqualTdPolicy
+	DomRequiredForCoincident  *bool
+	RelayPendingHeaderDiffs   *bool
+	VerifyTermini             *bool
+	RetryPersistOnStop        *bool
+	PEtxRetryThreshold        *uint64
+	AppendTimeout             *time.Duration
+	MaxReorgDepth             *uint64
+	HeadStallTimeout          *time.Duration
+	PendingHeaderGCInterval   *time.Duration
+	MaxFutureTime             *uint64
+	AppendQueueMaxRetries     *uint64
+	AppendQueueRetryThreshold *uint64
+
+	// Immutable
+	ChainID     *big.Int
+	GenesisHash *common.Hash
+}
+
+// currentSettings returns a copy of the runtime settings of the slice
+func (sl *Slice) currentSettings() sliceSettings {
+	sl.settingsMu.RLock()
+	defer sl.settingsMu.RUnlock()
+	return sl.settings
+}
+
+// hierarchyRequestContext derives the context of a request to the dom or a sub
+// made during an append, bounded by the append timeout if one is configured.
+func (sl *Slice) hierarchyRequestContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if timeout := sl.currentSettings().appendTimeout; timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+	return context.WithCancel(ctx)
+}
+
+// UpdateConfig applies the given patch to the runtime settings of the slice.
+// The patch is applied atomically, either all of its fields are applied or
+// none of them are.
+func (sl *Slice) UpdateConfig(patch ConfigPatch) error {
+	if patch.ChainID != nil || patch.GenesisHash != nil {
+		return ErrImmutableConfig
+	}
+	if patch.EqualTdPolicy != nil && *patch.EqualTdPolicy > EqualTdPreferLocal {
+		return ErrInvalidConfigPatch
+	}
+	if patch.PEtxRetryThreshold != nil && *patch.PEtxRetryThreshold == 0 {
+		return ErrInvalidConfigPatch
+	}
+	if patch.AppendTimeout != nil && *patch.AppendTimeout < 0 {
+		return ErrInvalidConfigPatch
+	}
+	if patch.HeadStallTimeout != nil && *patch.HeadStallTimeout <= 0 {
+		return ErrInvalidConfigPatch
+	}
+	if patch.PendingHeaderGCInterval != nil && *patch.PendingHeaderGCInterval <= 0 {
+		return ErrInvalidConfigPatch
+	}
+	if patch.MaxFutureTime != nil && *patch.MaxFutureTime == 0 {
+		return ErrInvalidConfigPatch
+	}
+	if patch.AppendQueueMaxRetries != nil && *patch.AppendQueueMaxRetries == 0 {
+		return ErrInvalidConfigPatch
+	}
+	if patch.AppendQueueRetryThreshold != nil && *patch.AppendQueueRetryThreshold == 0 {
+		return ErrInvalidConfigPatch
+	}
+
+	sl.settingsMu.Lock()
+	defer sl.settingsMu.Unlock()
+
+	if patch.EqualTdPolicy != nil {
+		sl.settings.equalTdPolicy = *patch.EqualTdPolicy
+	}
+	if patch.DomRequiredForCoincident != nil {
+		sl.settings.domRequiredForCoincident = *patch.DomRequiredForCoincident
+	}
+	if patch.RelayPendingHeaderDiffs != nil {
+		sl.settings.relayPendingHeaderDiffs = *patch.RelayPendingHeaderDiffs
+	}
+	if patch.VerifyTermini != nil {
+		sl.settings.verifyTermini = *patch.VerifyTermini
+	}
+	if patch.RetryPersistOnStop != nil {
+		sl.settings.retryPersistOnStop = *patch.RetryPersistOnStop
+	}
+	if patch.PEtxRetryThreshold != nil {
+		sl.settings.pEtxRetryThreshold = *patch.PEtxRetryThreshold
+	}
+	if patch.AppendTimeout != nil {
+		sl.settings.appendTimeout = *patch.AppendTimeout
+	}
+	if patch.MaxReorgDepth != nil {
+		sl.settings.maxReorgDepth = *patch.MaxReorgDepth
+	}
+	if patch.HeadStallTimeout != nil {
+		sl.settings.headStallTimeout = *patch.HeadStallTimeout
+	}
+	if patch.PendingHeaderGCInterval != nil && *patch.PendingHeaderGCInterval != sl.settings.phGCInterval {
+		sl.settings.phGCInterval = *patch.PendingHeaderGCInterval
+		// Wake up the gc loop so that its ticker is reset to the new interval
+		select {
+		case sl.phGCIntervalCh <- struct{}{}:
+		default:
+		}
+	}
+	if patch.MaxFutureTime != nil {
+		sl.settings.maxFutureTime = *patch.MaxFutureTime
+	}
+	if patch.AppendQueueMaxRetries != nil {
+		sl.settings.appendQueueMaxRetries = *patch.AppendQueueMaxRetries
+	}
+	if patch.AppendQueueRetryThreshold != nil {
+		sl.settings.appendQueueRetryThreshold = *patch.AppendQueueRetryThreshold
+	}
+	log.Info("Updated slice config", "equalTdPolicy", sl.settings.equalTdPolicy, "domRequiredForCoincident", sl.settings.domRequiredForCoincident,
+		"relayPendingHeaderDiffs", sl.settings.relayPendingHeaderDiffs, "verifyTermini", sl.settings.verifyTermini,
+		"retryPersistOnStop", sl.settings.retryPersistOnStop, "pEtxRetryThreshold", sl.settings.pEtxRetryThreshold, "appendTimeout", sl.settings.appendTimeout,
+		"maxReorgDepth", sl.settings.maxReorgDepth, "headStallTimeout", sl.settings.headStallTimeout, "phGCInterval", sl.settings.phGCInterval,
+		"maxFutureTime", sl.settings.maxFutureTime, "appendQueueMaxRetries", sl.settings.appendQueueMaxRetries, "appendQueueRetryThreshold", sl.settings.appendQueueRetryThreshold)
+	return nil
+}
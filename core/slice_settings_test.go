@@ -0,0 +1,157 @@
+package core
+
+import (
+	"errors"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/dominant-strategies/go-quai/common"
+	"github.com/dominant-strategies/go-quai/core/types"
+	lru "github.com/hashicorp/golang-lru"
+)
+
+// testSettings returns the settings of a slice started with the defaults
+func testSettings() sliceSettings {
+	return sliceSettings{
+		equalTdPolicy:             EqualTdPreferLowestHash,
+		pEtxRetryThreshold:        c_pEtxRetryThreshold,
+		headStallTimeout:          c_headStalledThreshold,
+		phGCInterval:              pendingHeaderGCTime * time.Minute,
+		maxFutureTime:             c_maxFutureTime,
+		appendQueueMaxRetries:     c_appendQueueMaxRetries,
+		appendQueueRetryThreshold: c_zoneRetryThreshold,
+	}
+}
+
+func TestUpdateConfig(t *testing.T) {
+	policy := func(p EqualTdPolicy) *EqualTdPolicy { return &p }
+	duration := func(d time.Duration) *time.Duration { return &d }
+	number := func(n uint64) *uint64 { return &n }
+	flag := func(b bool) *bool { return &b }
+
+	tests := []struct {
+		name  string
+		patch ConfigPatch
+		err   error
+		apply func(s *sliceSettings)
+	}{
+		{"empty patch", ConfigPatch{}, nil, func(s *sliceSettings) {}},
+		{"all mutable fields", ConfigPatch{
+			EqualTdPolicy:             policy(EqualTdPreferLocal),
+			DomRequiredForCoincident:  flag(true),
+			RelayPendingHeaderDiffs:   flag(true),
+			VerifyTermini:             flag(true),
+			RetryPersistOnStop:        flag(true),
+			PEtxRetryThreshold:        number(7),
+			AppendTimeout:             duration(time.Second),
+			MaxReorgDepth:             number(64),
+			HeadStallTimeout:          duration(time.Minute),
+			PendingHeaderGCInterval:   duration(time.Second),
+			MaxFutureTime:             number(5),
+			AppendQueueMaxRetries:     number(10),
+			AppendQueueRetryThreshold: number(4),
+		}, nil, func(s *sliceSettings) {
+			s.equalTdPolicy = EqualTdPreferLocal
+			s.domRequiredForCoincident = true
+			s.relayPendingHeaderDiffs = true
+			s.verifyTermini = true
+			s.retryPersistOnStop = true
+			s.pEtxRetryThreshold = 7
+			s.appendTimeout = time.Second
+			s.maxReorgDepth = 64
+			s.headStallTimeout = time.Minute
+			s.phGCInterval = time.Second
+			s.maxFutureTime = 5
+			s.appendQueueMaxRetries = 10
+			s.appendQueueRetryThreshold = 4
+		}},
+		{"zero append timeout disables it", ConfigPatch{AppendTimeout: duration(0)}, nil, func(s *sliceSettings) { s.appendTimeout = 0 }},
+		{"chain id", ConfigPatch{ChainID: big.NewInt(1)}, ErrImmutableConfig, nil},
+		{"genesis hash", ConfigPatch{GenesisHash: &common.Hash{}}, ErrImmutableConfig, nil},
+		{"immutable with valid fields", ConfigPatch{MaxReorgDepth: number(1), ChainID: big.NewInt(1)}, ErrImmutableConfig, nil},
+		{"unknown policy", ConfigPatch{EqualTdPolicy: policy(EqualTdPreferLocal + 1)}, ErrInvalidConfigPatch, nil},
+		{"zero pEtx retry threshold", ConfigPatch{PEtxRetryThreshold: number(0)}, ErrInvalidConfigPatch, nil},
+		{"negative append timeout", ConfigPatch{AppendTimeout: duration(-time.Second)}, ErrInvalidConfigPatch, nil},
+		{"zero head stall timeout", ConfigPatch{HeadStallTimeout: duration(0)}, ErrInvalidConfigPatch, nil},
+		{"zero gc interval", ConfigPatch{PendingHeaderGCInterval: duration(0)}, ErrInvalidConfigPatch, nil},
+		{"negative gc interval", ConfigPatch{PendingHeaderGCInterval: duration(-time.Second)}, ErrInvalidConfigPatch, nil},
+		{"zero max future time", ConfigPatch{MaxFutureTime: number(0)}, ErrInvalidConfigPatch, nil},
+		{"zero append queue max retries", ConfigPatch{AppendQueueMaxRetries: number(0)}, ErrInvalidConfigPatch, nil},
+		{"zero append queue retry threshold", ConfigPatch{AppendQueueRetryThreshold: number(0)}, ErrInvalidConfigPatch, nil},
+		// A single invalid field rejects the valid ones along with it
+		{"valid fields with an invalid one", ConfigPatch{
+			MaxReorgDepth:           number(1),
+			VerifyTermini:           flag(true),
+			PendingHeaderGCInterval: duration(time.Second),
+			MaxFutureTime:           number(0),
+		}, ErrInvalidConfigPatch, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sl, _ := newTestSlice()
+			sl.settings = testSettings()
+
+			err := sl.UpdateConfig(tt.patch)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error mismatch: have %v, want %v", err, tt.err)
+			}
+			want := testSettings()
+			if tt.apply != nil {
+				tt.apply(&want)
+			}
+			if have := sl.currentSettings(); !reflect.DeepEqual(have, want) {
+				t.Errorf("settings mismatch: have %+v, want %+v", have, want)
+			}
+		})
+	}
+}
+
+func TestGcPendingHeadersLoopIntervalUpdate(t *testing.T) {
+	sl, _ := newTestSlice()
+	sl.settings = testSettings()
+	sl.settings.phGCInterval = time.Hour
+	sl.phGCIntervalCh = make(chan struct{}, 1)
+	sl.quit = make(chan struct{})
+	sl.phCache, _ = lru.New(c_phCacheSize)
+	sl.phGCWindow = 10
+
+	head := newTestHeader(nil, 1000, 0)
+	stale := newTestHeader(nil, 1, 0)
+	sl.phCache.Add(head.Hash(), types.NewPendingHeader(head, types.EmptyTermini()))
+	sl.phCache.Add(stale.Hash(), types.NewPendingHeader(stale, types.EmptyTermini()))
+	sl.bestPhKey = head.Hash()
+	sl.hc.currentHeader.Store(head)
+
+	done := make(chan struct{})
+	sl.wg.Add(1)
+	go func() {
+		sl.gcPendingHeadersLoop()
+		close(done)
+	}()
+	defer func() {
+		close(sl.quit)
+		<-done
+	}()
+
+	// Nothing is collected on the initial hourly interval
+	time.Sleep(50 * time.Millisecond)
+	if !sl.phCache.Contains(stale.Hash()) {
+		t.Fatalf("stale entry collected before the interval update")
+	}
+	interval := 10 * time.Millisecond
+	if err := sl.UpdateConfig(ConfigPatch{PendingHeaderGCInterval: &interval}); err != nil {
+		t.Fatalf("failed to update the gc interval: %v", err)
+	}
+	deadline := time.Now().Add(time.Second)
+	for sl.phCache.Contains(stale.Hash()) {
+		if time.Now().After(deadline) {
+			t.Fatalf("stale entry not collected after the interval update")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if !sl.phCache.Contains(head.Hash()) {
+		t.Errorf("head entry collected")
+	}
+}
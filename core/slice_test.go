@@ -0,0 +1,1682 @@
+// Copyright 2014 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dominant-strategies/go-quai/common"
+	"github.com/dominant-strategies/go-quai/core/rawdb"
+	"github.com/dominant-strategies/go-quai/core/state"
+	"github.com/dominant-strategies/go-quai/core/types"
+	"github.com/dominant-strategies/go-quai/crypto"
+	"github.com/dominant-strategies/go-quai/ethdb"
+	"github.com/dominant-strategies/go-quai/event"
+	"github.com/dominant-strategies/go-quai/params"
+	"github.com/dominant-strategies/go-quai/quaiclient"
+	"github.com/dominant-strategies/go-quai/rpc"
+	"github.com/dominant-strategies/go-quai/trie"
+	lru "github.com/hashicorp/golang-lru"
+	expireLru "github.com/hnlq715/golang-lru"
+)
+
+// newTestSlice returns a slice backed by a memory db, with only the fields
+// needed by the accessors under test set.
+func newTestSlice() (*Slice, ethdb.Database) {
+	db := rawdb.NewMemoryDatabase()
+	hc := &HeaderChain{headerDb: db}
+	hc.headerCache, _ = lru.New(headerCacheLimit)
+	hc.numberCache, _ = lru.New(numberCacheLimit)
+	sl := &Slice{sliceDb: db, hc: hc}
+	sl.backgroundCtx, sl.backgroundCancel = context.WithCancel(context.Background())
+	return sl, db
+}
+
+// newTestHeader returns a header at the given number on top of parent. The
+// fork byte tells apart the headers of competing forks at the same number.
+func newTestHeader(parent *types.Header, number uint64, fork byte) *types.Header {
+	header := types.EmptyHeader()
+	if parent != nil {
+		header.SetParentHash(parent.Hash())
+	}
+	header.SetNumber(new(big.Int).SetUint64(number))
+	header.SetExtra([]byte{fork})
+	return header
+}
+
+// newTestTx returns a distinct transaction for each nonce
+func newTestTx(nonce uint64) *types.Transaction {
+	return types.NewTx(&types.InternalTx{
+		ChainID:   big.NewInt(1),
+		Nonce:     nonce,
+		GasTipCap: big.NewInt(0),
+		GasFeeCap: big.NewInt(0),
+		Gas:       21000,
+		Value:     big.NewInt(0),
+		V:         big.NewInt(0),
+		R:         big.NewInt(0),
+		S:         big.NewInt(0),
+	})
+}
+
+// writeCanonicalBlock writes the header as the canonical block at its number,
+// with the given transactions in its body and indexed.
+func writeCanonicalBlock(db ethdb.Database, header *types.Header, txs ...*types.Transaction) {
+	rawdb.WriteHeader(db, header)
+	rawdb.WriteTermini(db, header.Hash(), types.EmptyTermini())
+	rawdb.WriteBody(db, header.Hash(), header.NumberU64(), &types.Body{Transactions: txs})
+	rawdb.WriteCanonicalHash(db, header.Hash(), header.NumberU64())
+	hashes := make([]common.Hash, len(txs))
+	for i, tx := range txs {
+		hashes[i] = tx.Hash()
+	}
+	rawdb.WriteTxLookupEntries(db, header.NumberU64(), hashes)
+}
+
+// setTestHead moves the head of the slice to the header and sends the chain
+// head event for it.
+func setTestHead(sl *Slice, header *types.Header) {
+	sl.hc.currentHeader.Store(header)
+	sl.hc.chainHeadFeed.Send(ChainHeadEvent{Block: types.NewBlockWithHeader(header)})
+}
+
+func expectConfirmation(t *testing.T, ch <-chan TxConfirmation) TxConfirmation {
+	t.Helper()
+	select {
+	case confirmation := <-ch:
+		return confirmation
+	case <-time.After(time.Second):
+		t.Fatal("no confirmation received")
+	}
+	return TxConfirmation{}
+}
+
+func expectNoConfirmation(t *testing.T, ch <-chan TxConfirmation) {
+	t.Helper()
+	select {
+	case confirmation := <-ch:
+		t.Fatalf("unexpected confirmation: %+v", confirmation)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestSubscribeTxConfirmationDepth(t *testing.T) {
+	sl, db := newTestSlice()
+	tx := newTestTx(0)
+
+	// The transaction is included in block 2, with the head at block 3 it has
+	// one confirmation
+	headers := []*types.Header{newTestHeader(nil, 0, 0)}
+	for i := uint64(1); i <= 5; i++ {
+		headers = append(headers, newTestHeader(headers[i-1], i, 0))
+	}
+	for i, header := range headers[:4] {
+		if i == 2 {
+			writeCanonicalBlock(db, header, tx)
+		} else {
+			writeCanonicalBlock(db, header)
+		}
+	}
+	sl.hc.currentHeader.Store(headers[3])
+
+	ch := make(chan TxConfirmation, 1)
+	sub, err := sl.SubscribeTxConfirmation(tx.Hash(), 2, ch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Unsubscribe()
+	expectNoConfirmation(t, ch)
+
+	writeCanonicalBlock(db, headers[4])
+	setTestHead(sl, headers[4])
+	confirmation := expectConfirmation(t, ch)
+	if confirmation.Dropped || confirmation.BlockHash != headers[2].Hash() || confirmation.BlockNumber != 2 || confirmation.Confirmations != 2 {
+		t.Fatalf("wrong confirmation: %+v", confirmation)
+	}
+
+	// Deeper heads do not notify again
+	writeCanonicalBlock(db, headers[5])
+	setTestHead(sl, headers[5])
+	expectNoConfirmation(t, ch)
+
+	// The notified depth matches Confirmations at the time of the notification
+	if depth, err := sl.Confirmations(headers[2].Hash()); err != nil || depth != 3 {
+		t.Fatalf("confirmations: have %d, %v, want 3", depth, err)
+	}
+	if _, err := sl.SubscribeTxConfirmation(tx.Hash(), -1, ch); err != ErrInvalidConfirmations {
+		t.Fatalf("negative confirmations: have %v, want %v", err, ErrInvalidConfirmations)
+	}
+}
+
+func TestSubscribeTxConfirmationReorg(t *testing.T) {
+	sl, db := newTestSlice()
+	tx := newTestTx(0)
+
+	genesis := newTestHeader(nil, 0, 0)
+	block1 := newTestHeader(genesis, 1, 0)
+	block2 := newTestHeader(block1, 2, 0)
+	writeCanonicalBlock(db, genesis)
+	writeCanonicalBlock(db, block1)
+	writeCanonicalBlock(db, block2, tx)
+	sl.hc.currentHeader.Store(block2)
+
+	ch := make(chan TxConfirmation, 1)
+	sub, err := sl.SubscribeTxConfirmation(tx.Hash(), 0, ch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Unsubscribe()
+	if confirmation := expectConfirmation(t, ch); confirmation.Dropped || confirmation.BlockHash != block2.Hash() || confirmation.Confirmations != 0 {
+		t.Fatalf("wrong confirmation: %+v", confirmation)
+	}
+
+	// A fork without the transaction replaces block 2
+	fork2 := newTestHeader(block1, 2, 1)
+	fork3 := newTestHeader(fork2, 3, 1)
+	writeCanonicalBlock(db, fork2)
+	writeCanonicalBlock(db, fork3)
+	setTestHead(sl, fork3)
+	if confirmation := expectConfirmation(t, ch); !confirmation.Dropped || confirmation.BlockHash != block2.Hash() {
+		t.Fatalf("wrong dropped notification: %+v", confirmation)
+	}
+
+	// The transaction is included again on the fork and notified again
+	fork4 := newTestHeader(fork3, 4, 1)
+	writeCanonicalBlock(db, fork4, tx)
+	setTestHead(sl, fork4)
+	if confirmation := expectConfirmation(t, ch); confirmation.Dropped || confirmation.BlockHash != fork4.Hash() || confirmation.Confirmations != 0 {
+		t.Fatalf("wrong confirmation after reorg: %+v", confirmation)
+	}
+
+	// No notification is sent once unsubscribed
+	sub.Unsubscribe()
+	fork5 := newTestHeader(fork4, 5, 1)
+	writeCanonicalBlock(db, fork5)
+	setTestHead(sl, fork5)
+	expectNoConfirmation(t, ch)
+}
+
+func TestConfirmations(t *testing.T) {
+	sl, db := newTestSlice()
+
+	headers := []*types.Header{newTestHeader(nil, 0, 0)}
+	for i := uint64(1); i <= 5; i++ {
+		headers = append(headers, newTestHeader(headers[i-1], i, 0))
+	}
+	for _, header := range headers {
+		writeCanonicalBlock(db, header)
+	}
+	sl.hc.currentHeader.Store(headers[5])
+
+	// A side block at the height of block 3 is known but not canonical
+	side := newTestHeader(headers[2], 3, 1)
+	rawdb.WriteHeader(db, side)
+	rawdb.WriteTermini(db, side.Hash(), types.EmptyTermini())
+
+	tests := []struct {
+		name  string
+		hash  common.Hash
+		depth int
+		err   error
+	}{
+		{"head", headers[5].Hash(), 0, nil},
+		{"deep", headers[1].Hash(), 4, nil},
+		{"genesis", headers[0].Hash(), 5, nil},
+		{"side", side.Hash(), -1, ErrNotCanonical},
+		{"unknown", common.HexToHash("0x01"), -1, ErrBlockNotFound},
+	}
+	for _, tt := range tests {
+		depth, err := sl.Confirmations(tt.hash)
+		if depth != tt.depth || err != tt.err {
+			t.Errorf("%s: have %d, %v, want %d, %v", tt.name, depth, err, tt.depth, tt.err)
+		}
+	}
+}
+
+func TestCanonicalHash(t *testing.T) {
+	sl, db := newTestSlice()
+
+	headers := []*types.Header{newTestHeader(nil, 0, 0)}
+	for i := uint64(1); i <= 4; i++ {
+		headers = append(headers, newTestHeader(headers[i-1], i, 0))
+	}
+	// Block 2 is missing from the canonical hash index
+	for i, header := range headers {
+		if i != 2 {
+			writeCanonicalBlock(db, header)
+		}
+	}
+	sl.hc.currentHeader.Store(headers[4])
+
+	tests := []struct {
+		number uint64
+		hash   common.Hash
+		err    error
+	}{
+		{0, headers[0].Hash(), nil},
+		{3, headers[3].Hash(), nil},
+		{4, headers[4].Hash(), nil},
+		{2, common.Hash{}, ErrCanonicalHashNotFound},
+		{5, common.Hash{}, ErrCanonicalHashNotFound},
+	}
+	for _, tt := range tests {
+		hash, err := sl.CanonicalHash(tt.number)
+		if hash != tt.hash || err != tt.err {
+			t.Errorf("number %d: have %x, %v, want %x, %v", tt.number, hash, err, tt.hash, tt.err)
+		}
+	}
+
+	// A canonical hash left above the head by a rewind is not returned
+	sl.hc.currentHeader.Store(headers[3])
+	if hash, err := sl.CanonicalHash(4); err != ErrCanonicalHashNotFound {
+		t.Errorf("number above the head: have %x, %v, want %v", hash, err, ErrCanonicalHashNotFound)
+	}
+}
+
+func TestPrunePendingDataRetentionPeriod(t *testing.T) {
+	sl, db := newTestSlice()
+	sl.hc.headerCache, _ = lru.New(headerCacheLimit)
+	sl.hc.pendingEtxs, _ = lru.New(c_maxPendingEtxBatchesPrime)
+	sl.hc.subRollupCache, _ = lru.New(c_subRollupCacheSize)
+	sl.phCache, _ = lru.New(c_phCacheSize)
+	sl.retentionPeriod = 100 * time.Second
+
+	// Irregular block times, with the head at 200s only the blocks older than
+	// 100s, i.e. the blocks up to number 4, are pruned
+	times := []uint64{0, 10, 15, 40, 99, 100, 130, 131, 200}
+	headers := make([]*types.Header, len(times))
+	for i, ts := range times {
+		var parent *types.Header
+		if i > 0 {
+			parent = headers[i-1]
+		}
+		headers[i] = newTestHeader(parent, uint64(i), 0)
+		headers[i].SetTime(ts)
+		rawdb.WriteHeader(db, headers[i])
+		rawdb.WriteTermini(db, headers[i].Hash(), types.EmptyTermini())
+		rawdb.WriteCanonicalHash(db, headers[i].Hash(), uint64(i))
+		rawdb.WritePendingEtxs(db, types.PendingEtxs{Header: headers[i], Etxs: types.Transactions{}})
+		rawdb.WritePendingHeader(db, headers[i].Hash(), types.NewPendingHeader(headers[i], types.EmptyTermini()))
+	}
+	sl.hc.currentHeader.Store(headers[len(headers)-1])
+
+	// The pending header of the terminus at block 3 has since moved above the
+	// retention window, it is kept even though it was first indexed below it
+	rawdb.WritePendingHeader(db, headers[3].Hash(), types.NewPendingHeader(headers[7], types.EmptyTermini()))
+
+	// Only the cached rollups collected from the pruned pending etxs are
+	// evicted, they are cached by the hash of the dom block
+	prunedDom, keptDom := common.Hash{2}, common.Hash{6}
+	sl.hc.subRollupCache.Add(prunedDom, cachedSubRollup{sources: []common.Hash{headers[6].Hash(), headers[2].Hash()}})
+	sl.hc.subRollupCache.Add(keptDom, cachedSubRollup{sources: []common.Hash{headers[1].Hash(), headers[6].Hash()}})
+
+	// The pending etxs still referenced by a queued block are kept
+	keep := map[common.Hash]struct{}{headers[1].Hash(): {}}
+	prunedEtxs, prunedPhs := sl.PrunePendingData(keep)
+	if prunedEtxs != 4 || prunedPhs != 4 {
+		t.Fatalf("pruned counts mismatch: have %d etxs and %d headers, want 4 and 4", prunedEtxs, prunedPhs)
+	}
+	if sl.hc.subRollupCache.Contains(prunedDom) || !sl.hc.subRollupCache.Contains(keptDom) {
+		t.Errorf("wrong sub rollups evicted")
+	}
+	for i, header := range headers {
+		pruned := i < 5
+		if have := rawdb.ReadPendingHeader(db, header.Hash()) == nil; have != (pruned && i != 3) {
+			t.Errorf("block %d: pending header pruned %v, want %v", i, have, pruned && i != 3)
+		}
+		if have := rawdb.ReadPendingEtxs(db, header.Hash()) == nil; have != (pruned && i != 1) {
+			t.Errorf("block %d: pending etxs pruned %v, want %v", i, have, pruned && i != 1)
+		}
+	}
+
+	// A retention period longer than the chain prunes nothing
+	sl.retentionPeriod = time.Hour
+	if prunedEtxs, prunedPhs := sl.PrunePendingData(nil); prunedEtxs != 0 || prunedPhs != 0 {
+		t.Fatalf("pruned %d etxs and %d headers within the retention period", prunedEtxs, prunedPhs)
+	}
+}
+
+func TestCollectSubRollupCache(t *testing.T) {
+	defer func(location common.Location) { common.NodeLocation = location }(common.NodeLocation)
+	common.NodeLocation = common.Location{0}
+
+	sl, db := newTestSlice()
+	sl.hc.pendingEtxs, _ = lru.New(c_maxPendingEtxBatchesPrime)
+	sl.hc.subRollupCache, _ = lru.New(c_subRollupCacheSize)
+	fetched := 0
+	sl.hc.fetchPEtx = func(blockHash common.Hash, hash common.Hash, location common.Location) (types.PendingEtxs, error) {
+		fetched++
+		return types.PendingEtxs{}, ErrPendingEtxNotFound
+	}
+
+	// A region block whose manifest references the pending etxs of one zone block
+	zoneHeader := newTestHeader(nil, 1, 0)
+	etxs := types.Transactions{newTestTx(0), newTestTx(1)}
+	rawdb.WritePendingEtxs(db, types.PendingEtxs{Header: zoneHeader, Etxs: etxs})
+	header := newTestHeader(nil, 1, 1)
+	header.SetEtxRollupHash(types.EtxRollupHash(etxs, trie.NewStackTrie(nil)))
+	block := types.NewBlockWithHeader(header).WithBody(nil, nil, nil, types.BlockManifest{zoneHeader.Hash()})
+
+	// Miss, the rollup is collected from the pending etxs and cached
+	rollup, err := sl.hc.CollectSubRollup(block)
+	if err != nil {
+		t.Fatalf("failed to collect the sub rollup: %v", err)
+	}
+	if len(rollup) != len(etxs) {
+		t.Fatalf("rollup length mismatch: have %d, want %d", len(rollup), len(etxs))
+	}
+	if !sl.hc.subRollupCache.Contains(block.Hash()) {
+		t.Fatalf("sub rollup not cached by the block hash")
+	}
+
+	// Hit, the rollup is served from the cache without the pending etxs
+	rawdb.DeletePendingEtxs(db, zoneHeader.Hash())
+	if rollup, err = sl.hc.CollectSubRollup(block); err != nil || len(rollup) != len(etxs) {
+		t.Fatalf("cached sub rollup not served: have %d etxs, err %v", len(rollup), err)
+	}
+	if fetched != 0 {
+		t.Fatalf("pending etxs fetched on a cache hit")
+	}
+
+	// Bust, removing the pending etxs evicts the rollup collected from them
+	if evicted := sl.hc.invalidateSubRollups(map[common.Hash]struct{}{zoneHeader.Hash(): {}}); evicted != 1 {
+		t.Fatalf("evicted %d rollups, want 1", evicted)
+	}
+	if _, err := sl.hc.CollectSubRollup(block); !errors.Is(err, ErrPendingEtxNotFound) {
+		t.Fatalf("error mismatch: have %v, want %v", err, ErrPendingEtxNotFound)
+	}
+	if fetched != 1 {
+		t.Fatalf("missing pending etxs fetched %d times, want 1", fetched)
+	}
+}
+
+func TestGetPendingHeaderByTerminus(t *testing.T) {
+	sl, _ := newTestSlice()
+	sl.phCache, _ = lru.New(2)
+
+	headers := make([]*types.Header, 3)
+	for i := range headers {
+		headers[i] = newTestHeader(nil, uint64(i+1), 0)
+	}
+	sl.phCacheMu.Lock()
+	sl.writePhCache(headers[0].Hash(), types.NewPendingHeader(headers[0], types.EmptyTermini()))
+	sl.writePhCache(headers[1].Hash(), types.NewPendingHeader(headers[1], types.EmptyTermini()))
+	sl.phCacheMu.Unlock()
+
+	ph, err := sl.GetPendingHeaderByTerminus(headers[0].Hash())
+	if err != nil {
+		t.Fatalf("failed to read the cached pending header: %v", err)
+	}
+	if ph.Header().Hash() != headers[0].Hash() {
+		t.Fatalf("pending header mismatch: have %x, want %x", ph.Header().Hash(), headers[0].Hash())
+	}
+
+	// The read did not make the first entry the most recent one, it is evicted
+	sl.phCacheMu.Lock()
+	sl.writePhCache(headers[2].Hash(), types.NewPendingHeader(headers[2], types.EmptyTermini()))
+	sl.phCacheMu.Unlock()
+	if sl.phCache.Contains(headers[0].Hash()) {
+		t.Fatalf("read pending header not evicted first")
+	}
+
+	// The evicted entry is read from disk without being loaded back
+	ph, err = sl.GetPendingHeaderByTerminus(headers[0].Hash())
+	if err != nil {
+		t.Fatalf("failed to read the evicted pending header: %v", err)
+	}
+	if ph.Header().Hash() != headers[0].Hash() {
+		t.Fatalf("pending header mismatch: have %x, want %x", ph.Header().Hash(), headers[0].Hash())
+	}
+	if sl.phCache.Contains(headers[0].Hash()) || !sl.phCache.Contains(headers[1].Hash()) {
+		t.Errorf("phCache changed by the read")
+	}
+
+	if _, err := sl.GetPendingHeaderByTerminus(common.Hash{1}); !errors.Is(err, ErrPendingHeaderNotInCache) {
+		t.Errorf("error mismatch: have %v, want %v", err, ErrPendingHeaderNotInCache)
+	}
+}
+
+func TestMinerWorkDiff(t *testing.T) {
+	setTestLocation(t, common.Location{0, 0})
+	sl, _ := newTestSlice()
+	sl.phCache, _ = lru.New(c_phCacheSize)
+
+	if _, err := sl.MinerWorkDiff(); err != ErrPendingHeaderNotInCache {
+		t.Fatalf("no best pending header: have %v, want %v", err, ErrPendingHeaderNotInCache)
+	}
+
+	// The best pending header builds on block 5 under the terminus of block 4
+	parent := newTestHeader(nil, 5, 0)
+	terminus := newTestHeader(nil, 4, 0)
+	termini := types.EmptyTermini()
+	termini.SetDomTerminiAtIndex(terminus.Hash(), common.NodeLocation.DomIndex())
+	best := types.NewPendingHeader(newTestHeader(parent, 6, 0), termini)
+	sl.phCacheMu.Lock()
+	sl.writePhCache(terminus.Hash(), best)
+	sl.WriteBestPhKey(terminus.Hash())
+	sl.phCacheMu.Unlock()
+
+	if _, err := sl.MinerWorkDiff(); err != ErrNoMinerWork {
+		t.Fatalf("no miner work: have %v, want %v", err, ErrNoMinerWork)
+	}
+
+	// The miners work on the best pending header
+	sl.minerPh.Store(best)
+	diff, err := sl.MinerWorkDiff()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff.Diverged() || diff.Selected.Hash() != best.Header().Hash() || diff.Delivered.Hash() != best.Header().Hash() {
+		t.Fatalf("unexpected divergence: %+v", diff)
+	}
+
+	// The delivery of the best pending header failed, the miners are still on
+	// the pending header of the previous block under another terminus
+	oldParent := newTestHeader(nil, 4, 1)
+	oldTerminus := newTestHeader(nil, 3, 0)
+	oldTermini := types.EmptyTermini()
+	oldTermini.SetDomTerminiAtIndex(oldTerminus.Hash(), common.NodeLocation.DomIndex())
+	stale := types.NewPendingHeader(newTestHeader(oldParent, 5, 0), oldTermini)
+	sl.minerPh.Store(stale)
+	diff, err = sl.MinerWorkDiff()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !diff.Diverged() || !diff.Number || !diff.Parent || !diff.Terminus {
+		t.Fatalf("divergence not reported: %+v", diff)
+	}
+	if diff.Selected.Hash() != best.Header().Hash() || diff.Delivered.Hash() != stale.Header().Hash() {
+		t.Fatalf("wrong headers: selected %x, delivered %x", diff.Selected.Hash(), diff.Delivered.Hash())
+	}
+
+	// Only the parent differs when the miners work on a sibling block
+	sibling := types.NewPendingHeader(newTestHeader(newTestHeader(nil, 5, 1), 6, 0), termini)
+	sl.minerPh.Store(sibling)
+	diff, err = sl.MinerWorkDiff()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !diff.Diverged() || diff.Number || !diff.Parent || diff.Terminus {
+		t.Fatalf("wrong divergence: %+v", diff)
+	}
+}
+
+func TestSubscribePendingHeaderForLocation(t *testing.T) {
+	setTestLocation(t, common.Location{0})
+	sl, _ := newTestSlice()
+	sl.locationPhSubs = make(map[*locationPhSub]struct{})
+	defer sl.scope.Close()
+
+	zone0, zone1 := common.Location{0, 0}, common.Location{0, 1}
+	ch0 := make(chan *types.Header, 1)
+	ch1 := make(chan *types.Header, 1)
+	sub0, err := sl.SubscribePendingHeaderForLocation(zone0, ch0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub0.Unsubscribe()
+	sub1, err := sl.SubscribePendingHeaderForLocation(zone1, ch1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub1.Unsubscribe()
+
+	// The slow subscriber of zone 0 never reads its channel
+	slow := make(chan *types.Header)
+	slowSub, err := sl.SubscribePendingHeaderForLocation(zone0, slow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer slowSub.Unsubscribe()
+
+	if _, err := sl.SubscribePendingHeaderForLocation(common.Location{1, 0}, ch0); err != ErrLocationNotRelayed {
+		t.Fatalf("location of another region: have %v, want %v", err, ErrLocationNotRelayed)
+	}
+
+	// Every header is routed to the subscribers of its location only. More
+	// headers are sent than the slow subscriber buffers, the others keep
+	// receiving them once it is full.
+	for i := 0; i < 2*c_locationPhChanSize; i++ {
+		sl.sendPendingHeaderToLocation(zone0, newTestHeader(nil, uint64(i), 0))
+		sl.sendPendingHeaderToLocation(zone1, newTestHeader(nil, uint64(i), 1))
+		for _, sub := range []struct {
+			ch   chan *types.Header
+			fork byte
+		}{{ch0, 0}, {ch1, 1}} {
+			select {
+			case header := <-sub.ch:
+				if header.NumberU64() != uint64(i) || header.Extra()[0] != sub.fork {
+					t.Fatalf("header %d of fork %d mismatch: have number %d, fork %d", i, sub.fork, header.NumberU64(), header.Extra()[0])
+				}
+			case <-time.After(time.Second):
+				t.Fatalf("header %d of fork %d not delivered", i, sub.fork)
+			}
+		}
+	}
+	select {
+	case header := <-ch0:
+		t.Fatalf("unexpected header: %d", header.NumberU64())
+	case header := <-ch1:
+		t.Fatalf("unexpected header: %d", header.NumberU64())
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestPendingHeadersByCoinbase(t *testing.T) {
+	setTestLocation(t, common.Location{0, 0})
+	sl, _ := newTestSlice()
+	sl.phCache, _ = lru.New(c_phCacheSize)
+
+	coinbase0 := common.HexToAddress("0x0000000000000000000000000000000000000001")
+	coinbase1 := common.HexToAddress("0x0000000000000000000000000000000000000002")
+	want := map[common.Address]map[common.Hash]bool{coinbase0: {}, coinbase1: {}}
+	sl.phCacheMu.Lock()
+	for i := 0; i < 5; i++ {
+		coinbase := coinbase0
+		if i%2 == 1 {
+			coinbase = coinbase1
+		}
+		header := newTestHeader(nil, uint64(i+1), 0)
+		header.SetCoinbase(coinbase)
+		sl.writePhCache(common.Hash{byte(i + 1)}, types.NewPendingHeader(header, types.EmptyTermini()))
+		want[coinbase][header.Hash()] = true
+	}
+	sl.phCacheMu.Unlock()
+
+	for coinbase, hashes := range want {
+		pendingHeaders := sl.PendingHeadersByCoinbase(coinbase)
+		if len(pendingHeaders) != len(hashes) {
+			t.Fatalf("coinbase %x: have %d pending headers, want %d", coinbase, len(pendingHeaders), len(hashes))
+		}
+		for _, ph := range pendingHeaders {
+			if !hashes[ph.Header().Hash()] {
+				t.Errorf("coinbase %x: unexpected pending header %x", coinbase, ph.Header().Hash())
+			}
+		}
+	}
+	if pendingHeaders := sl.PendingHeadersByCoinbase(common.HexToAddress("0x0000000000000000000000000000000000000003")); len(pendingHeaders) != 0 {
+		t.Errorf("unknown coinbase: have %d pending headers, want 0", len(pendingHeaders))
+	}
+
+	// The returned pending headers are copies of the cached ones
+	pendingHeaders := sl.PendingHeadersByCoinbase(coinbase0)
+	pendingHeaders[0].Header().SetCoinbase(coinbase1)
+	if have := len(sl.PendingHeadersByCoinbase(coinbase0)); have != len(want[coinbase0]) {
+		t.Errorf("cached pending header modified through the result: have %d pending headers, want %d", have, len(want[coinbase0]))
+	}
+}
+
+func TestGcPendingHeadersKeepsHead(t *testing.T) {
+	sl, _ := newTestSlice()
+	sl.phCache, _ = lru.New(c_phCacheSize)
+	sl.phGCWindow = 500
+
+	// The best pending header lags far behind the head, which is not advancing
+	entries := make(map[uint64]common.Hash)
+	for _, number := range []uint64{100, 200, 600, 990} {
+		header := newTestHeader(nil, number, 0)
+		entries[number] = header.Hash()
+		sl.phCache.Add(header.Hash(), types.NewPendingHeader(header, types.EmptyTermini()))
+	}
+	sl.bestPhKey = entries[100]
+	sl.hc.currentHeader.Store(newTestHeader(nil, 1000, 0))
+
+	if removed := sl.gcPendingHeaders(); removed != 1 {
+		t.Fatalf("removed %d entries, want 1", removed)
+	}
+	for number, hash := range entries {
+		kept := number == 100 || number >= 500
+		if have := sl.phCache.Contains(hash); have != kept {
+			t.Errorf("entry at %d: kept %v, want %v", number, have, kept)
+		}
+	}
+}
+
+func TestHealthyReasons(t *testing.T) {
+	defer func(location common.Location) { common.NodeLocation = location }(common.NodeLocation)
+
+	genesis := newTestHeader(nil, 0, 0)
+	tests := []struct {
+		name     string
+		location common.Location
+		setup    func(sl *Slice)
+		reasons  []string
+	}{
+		{"healthy prime", common.Location{}, func(sl *Slice) {}, nil},
+		{"healthy zone", common.Location{0, 0}, func(sl *Slice) { sl.domClient = &quaiclient.Client{} }, nil},
+		{"dom disconnected", common.Location{0, 0}, func(sl *Slice) {}, []string{"dom disconnected"}},
+		{"dom unreachable", common.Location{0, 0}, func(sl *Slice) {
+			sl.domClient = &quaiclient.Client{}
+			sl.domUnreachable = 1
+		}, []string{"dom unreachable"}},
+		{"head stalled", common.Location{}, func(sl *Slice) {
+			head := newTestHeader(genesis, 1, 0)
+			head.SetTime(uint64(time.Now().Add(-2 * time.Minute).Unix()))
+			sl.hc.currentHeader.Store(head)
+		}, []string{"head stalled >1m0s"}},
+		{"stalled at genesis", common.Location{}, func(sl *Slice) {
+			sl.hc.currentHeader.Store(genesis)
+		}, nil},
+		{"current head missing", common.Location{}, func(sl *Slice) {
+			sl.hc.currentHeader.Store((*types.Header)(nil))
+		}, []string{"current head missing"}},
+		{"phCache head missing", common.Location{}, func(sl *Slice) { sl.phCache.Purge() }, []string{"phCache head missing"}},
+		{"several failures", common.Location{0, 0}, func(sl *Slice) { sl.phCache.Purge() }, []string{"dom disconnected", "phCache head missing"}},
+	}
+	for _, test := range tests {
+		common.NodeLocation = test.location
+
+		sl, _ := newTestSlice()
+		sl.config = &params.ChainConfig{GenesisHash: genesis.Hash()}
+		sl.settings.headStallTimeout = time.Minute
+		sl.phCache, _ = lru.New(c_phCacheSize)
+		head := newTestHeader(genesis, 1, 0)
+		head.SetTime(uint64(time.Now().Unix()))
+		sl.hc.currentHeader.Store(head)
+		sl.bestPhKey = head.Hash()
+		sl.phCache.Add(head.Hash(), types.NewPendingHeader(head, types.EmptyTermini()))
+		test.setup(sl)
+
+		healthy, reasons := sl.Healthy()
+		if healthy != (len(test.reasons) == 0) || !reflect.DeepEqual(reasons, test.reasons) {
+			t.Errorf("%s: have healthy %v with reasons %v, want reasons %v", test.name, healthy, reasons, test.reasons)
+		}
+	}
+}
+
+func TestPhCacheEvictionKeepsHead(t *testing.T) {
+	sl, db := newTestSlice()
+	sl.phCache, _ = lru.New(2)
+
+	termini := make([]common.Hash, 4)
+	for i := range termini {
+		header := newTestHeader(nil, uint64(i+1), 0)
+		termini[i] = header.Hash()
+		if i == 0 {
+			sl.bestPhKey = termini[i]
+		}
+		sl.writePhCache(termini[i], types.NewPendingHeader(header, types.EmptyTermini()))
+	}
+	// The head entry was written first, yet survives the adds of a full cache
+	if !sl.phCache.Contains(termini[0]) {
+		t.Fatalf("best pending header evicted")
+	}
+	if sl.phCache.Len() != 2 {
+		t.Fatalf("phCache size mismatch: have %d, want 2", sl.phCache.Len())
+	}
+	// The evicted entries are still readable, loaded back from the db
+	for i, terminus := range termini {
+		ph, exists := sl.readPhCache(terminus)
+		if !exists || ph.Header().NumberU64() != uint64(i+1) {
+			t.Fatalf("pending header %d not readable after eviction", i)
+		}
+		if rawdb.ReadPendingHeader(db, terminus) == nil {
+			t.Fatalf("pending header %d not written to the db", i)
+		}
+		if !sl.phCache.Contains(termini[0]) {
+			t.Fatalf("best pending header evicted by the read of entry %d", i)
+		}
+	}
+}
+
+func TestSubRelayPendingHeaderConcurrentReads(t *testing.T) {
+	defer func(location common.Location) { common.NodeLocation = location }(common.NodeLocation)
+	common.NodeLocation = common.Location{0}
+
+	sl, _ := newTestSlice()
+	// A single entry cache makes every read fall back to the db, which adds
+	// the entry back to the cache next to the best one
+	sl.phCache, _ = lru.New(1)
+
+	heads := make([]common.Hash, 2)
+	for i := range heads {
+		header := newTestHeader(nil, uint64(i+1), 0)
+		heads[i] = header.Hash()
+		sl.writePhCache(heads[i], types.NewPendingHeader(header, types.EmptyTermini()))
+	}
+	sl.bestPhKey = heads[0]
+	// The relays alternate between two sub termini, so that each of them
+	// misses the cache
+	relays := make([]types.PendingHeader, 2)
+	diffs := make([]types.PendingHeaderDiff, 2)
+	for i := range relays {
+		sub := newTestHeader(nil, 10, byte(i+1))
+		termini := types.EmptyTermini()
+		termini.SetSubTerminiAtIndex(sub.Hash(), common.NodeLocation.Region())
+		sl.writePhCache(sub.Hash(), types.NewPendingHeader(sub, termini))
+		relays[i] = types.NewPendingHeader(newTestHeader(nil, 11, byte(i+1)), termini)
+		diffs[i] = types.NewPendingHeaderDiff(relays[i].Header(), []int{common.PRIME_CTX})
+	}
+
+	const rounds = 200
+	var wg sync.WaitGroup
+	run := func(f func(i int)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				f(i)
+			}
+		}()
+	}
+	// The relays come from a sub of this region, so only the phCache is read
+	location := common.Location{0, 1}
+	run(func(int) { sl.GetPendingHeader() })
+	run(func(i int) { sl.SubRelayPendingHeader(relays[i%2], big.NewInt(1), location, true, common.PRIME_CTX) })
+	run(func(i int) {
+		if err := sl.SubRelayPendingHeaderDiff(diffs[i%2], relays[i%2].Termini(), big.NewInt(1), location, true, common.PRIME_CTX); err != nil {
+			t.Errorf("diff relay failed: %v", err)
+		}
+	})
+	run(func(i int) {
+		sl.phCacheMu.Lock()
+		sl.WriteBestPhKey(heads[i%2])
+		sl.phCacheMu.Unlock()
+	})
+	wg.Wait()
+}
+
+// newTestPendingHeader returns a header with distinct values in every context,
+// derived from seed, along with distinct values in the fields of the slice.
+func newTestPendingHeader(seed byte) *types.Header {
+	header := types.EmptyHeader()
+	for ctx := 0; ctx < common.HierarchyDepth; ctx++ {
+		value := int64(seed)*10 + int64(ctx)
+		header.SetParentHash(common.Hash{seed, byte(ctx), 1}, ctx)
+		header.SetNumber(big.NewInt(value), ctx)
+		header.SetManifestHash(common.Hash{seed, byte(ctx), 2}, ctx)
+		header.SetParentEntropy(big.NewInt(value+1), ctx)
+		header.SetParentDeltaS(big.NewInt(value+2), ctx)
+	}
+	header.SetCoinbase(common.BytesToAddress([]byte{seed}))
+	header.SetRoot(common.Hash{seed, 3})
+	header.SetTxHash(common.Hash{seed, 4})
+	header.SetDifficulty(big.NewInt(int64(seed) + 100))
+	header.SetGasLimit(uint64(seed) + 1000)
+	header.SetBaseFee(big.NewInt(int64(seed) + 5))
+	header.SetExtra([]byte{seed})
+	return header
+}
+
+func TestApplyPendingHeaderDiff(t *testing.T) {
+	defer func(location common.Location) { common.NodeLocation = location }(common.NodeLocation)
+
+	tests := []struct {
+		name     string
+		location common.Location
+		contexts []int
+	}{
+		{"from prime", common.Location{0}, []int{common.PRIME_CTX}},
+		{"from region", common.Location{0, 0}, []int{common.PRIME_CTX, common.REGION_CTX}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			common.NodeLocation = tt.location
+			terminiIndex := tt.location.Zone()
+			if tt.location.Context() == common.REGION_CTX {
+				terminiIndex = tt.location.Region()
+			}
+
+			sl, _ := newTestSlice()
+			sl.phCache, _ = lru.New(c_phCacheSize)
+			local := newTestPendingHeader(1)
+			terminus := common.Hash{0xaa}
+			sl.writePhCache(terminus, types.NewPendingHeader(local, types.EmptyTermini()))
+
+			full := newTestPendingHeader(2)
+			termini := types.EmptyTermini()
+			termini.SetSubTerminiAtIndex(terminus, terminiIndex)
+			have, err := sl.applyPendingHeaderDiff(types.NewPendingHeaderDiff(full, tt.contexts), termini)
+			if err != nil {
+				t.Fatalf("failed to apply the diff: %v", err)
+			}
+			want := local
+			for _, ctx := range tt.contexts {
+				want = sl.combinePendingHeader(full, want, ctx, false)
+			}
+
+			for ctx := 0; ctx < common.HierarchyDepth; ctx++ {
+				if have.Header().ParentHash(ctx) != want.ParentHash(ctx) {
+					t.Errorf("parent hash mismatch at context %d: have %x, want %x", ctx, have.Header().ParentHash(ctx), want.ParentHash(ctx))
+				}
+				if have.Header().Number(ctx).Cmp(want.Number(ctx)) != 0 {
+					t.Errorf("number mismatch at context %d: have %v, want %v", ctx, have.Header().Number(ctx), want.Number(ctx))
+				}
+				if have.Header().ManifestHash(ctx) != want.ManifestHash(ctx) {
+					t.Errorf("manifest hash mismatch at context %d: have %x, want %x", ctx, have.Header().ManifestHash(ctx), want.ManifestHash(ctx))
+				}
+				if have.Header().ParentEntropy(ctx).Cmp(want.ParentEntropy(ctx)) != 0 {
+					t.Errorf("parent entropy mismatch at context %d: have %v, want %v", ctx, have.Header().ParentEntropy(ctx), want.ParentEntropy(ctx))
+				}
+				if have.Header().ParentDeltaS(ctx).Cmp(want.ParentDeltaS(ctx)) != 0 {
+					t.Errorf("parent deltaS mismatch at context %d: have %v, want %v", ctx, have.Header().ParentDeltaS(ctx), want.ParentDeltaS(ctx))
+				}
+			}
+			// The fields of the slice are the local ones
+			if have.Header().Coinbase() != want.Coinbase() {
+				t.Errorf("coinbase mismatch: have %x, want %x", have.Header().Coinbase(), want.Coinbase())
+			}
+			if have.Header().Root() != want.Root() {
+				t.Errorf("root mismatch: have %x, want %x", have.Header().Root(), want.Root())
+			}
+			if have.Header().TxHash() != want.TxHash() {
+				t.Errorf("tx hash mismatch: have %x, want %x", have.Header().TxHash(), want.TxHash())
+			}
+			if have.Header().Difficulty().Cmp(want.Difficulty()) != 0 {
+				t.Errorf("difficulty mismatch: have %v, want %v", have.Header().Difficulty(), want.Difficulty())
+			}
+			if have.Header().GasLimit() != want.GasLimit() {
+				t.Errorf("gas limit mismatch: have %d, want %d", have.Header().GasLimit(), want.GasLimit())
+			}
+			if have.Header().BaseFee().Cmp(want.BaseFee()) != 0 {
+				t.Errorf("base fee mismatch: have %v, want %v", have.Header().BaseFee(), want.BaseFee())
+			}
+			if !bytes.Equal(have.Header().Extra(), want.Extra()) {
+				t.Errorf("extra mismatch: have %x, want %x", have.Header().Extra(), want.Extra())
+			}
+			if have.Header().Hash() != want.Hash() {
+				t.Errorf("hash mismatch: have %x, want %x", have.Header().Hash(), want.Hash())
+			}
+			if have.Termini().SubTerminiAtIndex(terminiIndex) != terminus {
+				t.Errorf("termini not taken from the relay")
+			}
+			// The cached pending header is left untouched
+			if cached, _ := sl.readPhCache(terminus); cached.Header().Hash() != local.Hash() {
+				t.Errorf("cached pending header modified by the diff")
+			}
+		})
+	}
+}
+
+func TestCombinePendingHeaderAt(t *testing.T) {
+	defer func(location common.Location) { common.NodeLocation = location }(common.NodeLocation)
+	common.NodeLocation = common.Location{0, 0}
+
+	sl, _ := newTestSlice()
+	tests := [][]int{
+		nil,
+		{common.PRIME_CTX},
+		{common.REGION_CTX},
+		{common.PRIME_CTX, common.REGION_CTX},
+		{common.PRIME_CTX, common.REGION_CTX, common.ZONE_CTX},
+	}
+	for _, indices := range tests {
+		local := newTestPendingHeader(1)
+		localHash := local.Hash()
+		header := newTestPendingHeader(2)
+
+		have := sl.combinePendingHeaderAt(header, local, indices...)
+		want := types.CopyHeader(local)
+		for _, index := range indices {
+			want = sl.combinePendingHeader(header, want, index, false)
+		}
+		for ctx := 0; ctx < common.HierarchyDepth; ctx++ {
+			if have.ParentHash(ctx) != want.ParentHash(ctx) {
+				t.Errorf("indices %v: parent hash mismatch at context %d: have %x, want %x", indices, ctx, have.ParentHash(ctx), want.ParentHash(ctx))
+			}
+			if have.Number(ctx).Cmp(want.Number(ctx)) != 0 {
+				t.Errorf("indices %v: number mismatch at context %d: have %v, want %v", indices, ctx, have.Number(ctx), want.Number(ctx))
+			}
+			if have.ManifestHash(ctx) != want.ManifestHash(ctx) {
+				t.Errorf("indices %v: manifest hash mismatch at context %d: have %x, want %x", indices, ctx, have.ManifestHash(ctx), want.ManifestHash(ctx))
+			}
+			if have.ParentEntropy(ctx).Cmp(want.ParentEntropy(ctx)) != 0 {
+				t.Errorf("indices %v: parent entropy mismatch at context %d: have %v, want %v", indices, ctx, have.ParentEntropy(ctx), want.ParentEntropy(ctx))
+			}
+			if have.ParentDeltaS(ctx).Cmp(want.ParentDeltaS(ctx)) != 0 {
+				t.Errorf("indices %v: parent deltaS mismatch at context %d: have %v, want %v", indices, ctx, have.ParentDeltaS(ctx), want.ParentDeltaS(ctx))
+			}
+		}
+		if have.Hash() != want.Hash() {
+			t.Errorf("indices %v: hash mismatch: have %x, want %x", indices, have.Hash(), want.Hash())
+		}
+		// The combined header is a copy, the local pending header is untouched
+		if have == local || local.Hash() != localHash {
+			t.Errorf("indices %v: local pending header modified", indices)
+		}
+	}
+}
+
+func TestCheckPendingHeaderBaseFee(t *testing.T) {
+	defer func(location common.Location) { common.NodeLocation = location }(common.NodeLocation)
+	common.NodeLocation = common.Location{0, 0}
+
+	sl, _ := newTestSlice()
+	tests := []struct {
+		name   string
+		header *types.Header
+		err    error
+	}{
+		{"nil header", nil, ErrPendingHeaderNilBaseFee},
+		{"nil base fee", new(types.Header), ErrPendingHeaderNilBaseFee},
+		{"zero base fee", types.EmptyHeader(), nil},
+		{"combined header", sl.combinePendingHeaderAt(newTestPendingHeader(2), newTestPendingHeader(1), common.PRIME_CTX, common.REGION_CTX), nil},
+	}
+	for _, tt := range tests {
+		if err := checkPendingHeaderBaseFee(tt.header); !errors.Is(err, tt.err) {
+			t.Errorf("%s: error mismatch: have %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestSubRelayPendingHeaderDiffNotInCache(t *testing.T) {
+	defer func(location common.Location) { common.NodeLocation = location }(common.NodeLocation)
+	common.NodeLocation = common.Location{0, 0}
+
+	sl, _ := newTestSlice()
+	sl.phCache, _ = lru.New(c_phCacheSize)
+	sl.writePhCache(common.Hash{0xaa}, types.NewPendingHeader(newTestPendingHeader(1), types.EmptyTermini()))
+
+	termini := types.EmptyTermini()
+	termini.SetSubTerminiAtIndex(common.Hash{0xbb}, common.NodeLocation.Zone())
+	diff := types.NewPendingHeaderDiff(newTestPendingHeader(2), []int{common.PRIME_CTX, common.REGION_CTX})
+	err := sl.SubRelayPendingHeaderDiff(diff, termini, big.NewInt(1), common.Location{0}, true, common.REGION_CTX)
+	if !errors.Is(err, ErrPendingHeaderNotInCache) {
+		t.Fatalf("error mismatch: have %v, want %v", err, ErrPendingHeaderNotInCache)
+	}
+}
+
+func TestNoMinerWorkerGuards(t *testing.T) {
+	sl, _ := newTestSlice()
+	header := newTestHeader(nil, 1, 0)
+
+	if _, err := sl.generateSlicePendingHeader(context.Background(), types.NewBlockWithHeader(header), types.EmptyTermini(), header, false, true, false); !errors.Is(err, ErrNoMinerWorker) {
+		t.Fatalf("generateSlicePendingHeader error mismatch: have %v, want %v", err, ErrNoMinerWorker)
+	}
+	if sl.CurrentInfo(header) {
+		t.Fatalf("CurrentInfo reported true without a miner worker")
+	}
+}
+
+func TestReorgToRejectsInvalidTargets(t *testing.T) {
+	defer func(location common.Location) { common.NodeLocation = location }(common.NodeLocation)
+
+	sl, _ := newTestSlice()
+	sl.hc.numberCache, _ = lru.New(numberCacheLimit)
+	unknown := newTestHeader(nil, 1, 0).Hash()
+
+	common.NodeLocation = common.Location{}
+	if err := sl.ReorgTo(unknown); !errors.Is(err, ErrInvalidContext) {
+		t.Fatalf("prime error mismatch: have %v, want %v", err, ErrInvalidContext)
+	}
+	common.NodeLocation = common.Location{0, 0}
+	if err := sl.ReorgTo(unknown); !errors.Is(err, ErrBlockNotFound) {
+		t.Fatalf("unknown block error mismatch: have %v, want %v", err, ErrBlockNotFound)
+	}
+}
+
+func TestDrainAppendsWaitsForInFlight(t *testing.T) {
+	sl, _ := newTestSlice()
+	appendCtx, endAppend, ok := sl.beginInFlightAppend(context.Background())
+	if !ok {
+		t.Fatalf("append rejected before the shutdown")
+	}
+	// The append in flight returns once it is cancelled
+	cancelled := make(chan struct{})
+	go func() {
+		<-appendCtx.Done()
+		close(cancelled)
+		endAppend()
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := sl.drainAppends(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("drain error mismatch: have %v, want %v", err, context.DeadlineExceeded)
+	}
+	select {
+	case <-cancelled:
+	default:
+		t.Fatalf("append in flight not cancelled")
+	}
+	if _, _, ok := sl.beginInFlightAppend(context.Background()); ok {
+		t.Fatalf("append accepted after the shutdown began")
+	}
+	if err := sl.drainAppends(context.Background()); err != nil {
+		t.Fatalf("drain failed once the appends finished: %v", err)
+	}
+}
+
+func TestDrainAppendsLetsFinishingAppendsRun(t *testing.T) {
+	sl, _ := newTestSlice()
+	appendCtx, endAppend, ok := sl.beginInFlightAppend(context.Background())
+	if !ok {
+		t.Fatalf("append rejected before the shutdown")
+	}
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		endAppend()
+	}()
+	if err := sl.drainAppends(context.Background()); err != nil {
+		t.Fatalf("drain failed: %v", err)
+	}
+	if appendCtx.Err() != context.Canceled {
+		t.Fatalf("append context not released: %v", appendCtx.Err())
+	}
+	if len(sl.appendCancels) != 0 {
+		t.Fatalf("finished append still registered")
+	}
+}
+
+func TestQueuePendingEtxsToDomFull(t *testing.T) {
+	sl, _ := newTestSlice()
+	sl.quit = make(chan struct{})
+	sl.pEtxSendCh = make(chan pEtxSend, 1)
+
+	// Without the send loop running, the second set finds the queue full
+	if err := sl.queuePendingEtxsToDom(context.Background(), types.PendingEtxs{Header: newTestHeader(nil, 1, 0)}); err != nil {
+		t.Fatalf("failed to queue the pending etxs: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := sl.queuePendingEtxsToDom(ctx, types.PendingEtxs{Header: newTestHeader(nil, 2, 0)}); !errors.Is(err, ErrPendingEtxsNotSent) {
+		t.Fatalf("error mismatch: have %v, want %v", err, ErrPendingEtxsNotSent)
+	}
+	if len(sl.pEtxSendCh) != 1 {
+		t.Fatalf("queue length mismatch: have %d, want 1", len(sl.pEtxSendCh))
+	}
+}
+
+func TestCacheCountsFollowAddsAndRemovals(t *testing.T) {
+	sl, _ := newTestSlice()
+	sl.hc.pendingEtxs, _ = lru.New(c_maxPendingEtxBatchesPrime)
+	appendQueue, _ := expireLru.New(c_maxAppendQueue)
+	c := &Core{sl: sl, appendQueue: appendQueue}
+
+	hashes := make([]common.Hash, 3)
+	for i := range hashes {
+		hashes[i] = newTestHeader(nil, uint64(i+1), 0).Hash()
+		sl.hc.pendingEtxs.Add(hashes[i], types.PendingEtxs{})
+		c.appendQueue.Add(hashes[i], blockNumberAndRetryCounter{})
+		if have := c.PendingEtxsCount(); have != i+1 {
+			t.Fatalf("pending etxs count mismatch after add %d: have %d, want %d", i, have, i+1)
+		}
+		if have := c.FutureHeaderCount(); have != i+1 {
+			t.Fatalf("future header count mismatch after add %d: have %d, want %d", i, have, i+1)
+		}
+	}
+	for i, hash := range hashes {
+		sl.hc.pendingEtxs.Remove(hash)
+		c.appendQueue.Remove(hash)
+		want := len(hashes) - i - 1
+		if have := c.PendingEtxsCount(); have != want {
+			t.Fatalf("pending etxs count mismatch after removal %d: have %d, want %d", i, have, want)
+		}
+		if have := c.FutureHeaderCount(); have != want {
+			t.Fatalf("future header count mismatch after removal %d: have %d, want %d", i, have, want)
+		}
+	}
+}
+
+// testAppendAPI serves quai_append from the slice, the way the node does, so
+// that a sub client can be wired back to the slice itself.
+type testAppendAPI struct {
+	sl *Slice
+}
+
+func (api *testAppendAPI) Append(ctx context.Context, raw json.RawMessage) (map[string]interface{}, error) {
+	var body struct {
+		Header           *types.Header      `json:"header"`
+		DomPendingHeader *types.Header      `json:"domPendingHeader"`
+		DomTerminus      common.Hash        `json:"domTerminus"`
+		DomOrigin        bool               `json:"domOrigin"`
+		NewInboundEtxs   types.Transactions `json:"newInboundEtxs"`
+	}
+	if err := json.Unmarshal(raw, &body); err != nil {
+		return nil, err
+	}
+	pendingEtxs, subReorg, setHead, err := api.sl.Append(ctx, body.Header, body.DomPendingHeader, body.DomTerminus, body.DomOrigin, body.NewInboundEtxs)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"pendingEtxs": pendingEtxs, "subReorg": subReorg, "setHead": setHead}, nil
+}
+
+func TestAppendReentryThroughSelfSubClient(t *testing.T) {
+	sl, _ := newTestSlice()
+	sl.config = &params.ChainConfig{}
+	sl.appending = make(map[common.Hash]int)
+	sl.subClients = make([]*quaiclient.Client, common.NumZonesInRegion)
+
+	server := rpc.NewServer()
+	defer server.Stop()
+	if err := server.RegisterName("quai", &testAppendAPI{sl: sl}); err != nil {
+		t.Fatalf("failed to register the append api: %v", err)
+	}
+	sl.setSubClient(0, quaiclient.NewClient(rpc.DialInProc(server)))
+
+	header := newTestHeader(nil, 1, 0)
+	header.SetLocation(common.Location{0})
+	// The slice hands the block to its sub, which is the slice itself
+	sl.beginAppend(header.Hash())
+	_, _, _, err := sl.getSubClient(0).Append(context.Background(), header, nil, types.EmptyHeader(), common.Hash{}, true, nil)
+	sl.endAppend(header.Hash())
+	if !IsAppendError(err, ErrAppendReentry) {
+		t.Fatalf("re-entered append error mismatch: have %v, want %v", err, ErrAppendReentry)
+	}
+	if sl.isAppending(header.Hash()) {
+		t.Fatalf("block still marked as being appended by the sub")
+	}
+}
+
+func TestFutureHeadersListing(t *testing.T) {
+	sl, db := newTestSlice()
+	sl.hc.numberCache, _ = lru.New(numberCacheLimit)
+	sl.hc.headerCache, _ = lru.New(headerCacheLimit)
+	appendQueue, _ := expireLru.New(c_maxAppendQueue)
+	c := &Core{sl: sl, appendQueue: appendQueue}
+
+	// Parent appended, parent only stored as a candidate, parent unknown
+	appended := newTestHeader(nil, 1, 0)
+	rawdb.WriteHeader(db, appended)
+	rawdb.WriteTermini(db, appended.Hash(), types.EmptyTermini())
+	candidate := newTestHeader(nil, 1, 1)
+	rawdb.WriteHeader(db, candidate)
+	unknown := newTestHeader(nil, 1, 2)
+
+	waiting := []*types.Header{
+		newTestHeader(appended, 2, 0),
+		newTestHeader(candidate, 2, 1),
+		newTestHeader(unknown, 2, 2),
+	}
+	for _, header := range waiting {
+		rawdb.WriteHeader(db, header)
+		c.appendQueue.Add(header.Hash(), blockNumberAndRetryCounter{number: header.NumberU64(), retry: 1})
+	}
+	missing := newTestHeader(nil, 3, 0)
+	c.appendQueue.Add(missing.Hash(), blockNumberAndRetryCounter{number: 3})
+
+	want := map[common.Hash]FutureHeaderInfo{
+		waiting[0].Hash(): {Hash: waiting[0].Hash(), Number: 2, ParentHash: appended.Hash(), Retries: 1, Reason: "parent appended, waiting on block data"},
+		waiting[1].Hash(): {Hash: waiting[1].Hash(), Number: 2, ParentHash: candidate.Hash(), Retries: 1, Reason: "parent not yet appended"},
+		waiting[2].Hash(): {Hash: waiting[2].Hash(), Number: 2, ParentHash: unknown.Hash(), Retries: 1, Reason: "parent not yet arrived"},
+		missing.Hash():    {Hash: missing.Hash(), Number: 3, Reason: "block not found in the database"},
+	}
+	infos := c.FutureHeaders()
+	if len(infos) != len(want) {
+		t.Fatalf("future header count mismatch: have %d, want %d", len(infos), len(want))
+	}
+	for _, info := range infos {
+		if !reflect.DeepEqual(info, want[info.Hash]) {
+			t.Errorf("future header %x mismatch: have %+v, want %+v", info.Hash, info, want[info.Hash])
+		}
+	}
+}
+
+func TestReadZoneBestPhMissing(t *testing.T) {
+	defer func(location common.Location) { common.NodeLocation = location }(common.NodeLocation)
+	common.NodeLocation = common.Location{0, 0}
+
+	sl, db := newTestSlice()
+	sl.config = &params.ChainConfig{GenesisHash: newTestHeader(nil, 0, 0).Hash()}
+	sl.phCache, _ = lru.New(c_phCacheSize)
+	sl.bestPhKey = newTestHeader(nil, 5, 0).Hash()
+
+	// A zone does not compute a pending header before its best ph is read
+	block := types.NewBlockWithHeader(newTestHeader(nil, 1, 0))
+	bestPh := sl.readZoneBestPh(block, types.PendingHeader{})
+	if !reflect.DeepEqual(bestPh, types.EmptyPendingHeader()) {
+		t.Fatalf("best ph mismatch: have %v, want the empty pending header", bestPh)
+	}
+	if sl.bestPhKey != sl.config.GenesisHash || rawdb.ReadBestPhKey(db) != sl.config.GenesisHash {
+		t.Fatalf("best ph key not reset to the genesis: have %x", sl.bestPhKey)
+	}
+	if !sl.phCache.Contains(block.Hash()) {
+		t.Fatalf("pending header not cached under the block hash")
+	}
+
+	// An existing best ph is returned as is
+	head := types.NewPendingHeader(newTestHeader(nil, 2, 0), types.EmptyTermini())
+	sl.writePhCache(sl.bestPhKey, head)
+	if bestPh := sl.readZoneBestPh(block, types.PendingHeader{}); bestPh.Header().Hash() != head.Header().Hash() {
+		t.Fatalf("best ph mismatch: have %x, want %x", bestPh.Header().Hash(), head.Header().Hash())
+	}
+}
+
+// testDomAPI records the pending etxs sent to it over quai_sendPendingEtxsToDom
+type testDomAPI struct {
+	received chan common.Hash
+}
+
+func (api *testDomAPI) SendPendingEtxsToDom(ctx context.Context, raw json.RawMessage) error {
+	var body struct {
+		Header *types.Header `json:"header"`
+	}
+	if err := json.Unmarshal(raw, &body); err != nil {
+		return err
+	}
+	api.received <- body.Header.Hash()
+	return nil
+}
+
+func TestPEtxSendLoopRetryDoesNotBlockQueue(t *testing.T) {
+	sl, _ := newTestSlice()
+	sl.quit = make(chan struct{})
+	sl.pEtxSendCh = make(chan pEtxSend, c_pEtxSendQueueSize)
+	sl.wg.Add(1)
+	go sl.pEtxSendLoop()
+	defer func() {
+		close(sl.quit)
+		sl.wg.Wait()
+	}()
+
+	// Without a dom client the first set fails and is retried after the backoff
+	first := types.PendingEtxs{Header: newTestHeader(nil, 1, 0)}
+	if err := sl.queuePendingEtxsToDom(context.Background(), first); err != nil {
+		t.Fatalf("failed to queue the pending etxs: %v", err)
+	}
+	time.Sleep(c_pEtxSendBackoff / 5)
+
+	api := &testDomAPI{received: make(chan common.Hash, 2)}
+	server := rpc.NewServer()
+	defer server.Stop()
+	if err := server.RegisterName("quai", api); err != nil {
+		t.Fatalf("failed to register the dom api: %v", err)
+	}
+	sl.setDomClient(quaiclient.NewClient(rpc.DialInProc(server)))
+
+	// The second set is sent while the first one waits for its retry
+	second := types.PendingEtxs{Header: newTestHeader(nil, 2, 0)}
+	if err := sl.queuePendingEtxsToDom(context.Background(), second); err != nil {
+		t.Fatalf("failed to queue the pending etxs: %v", err)
+	}
+	for i, want := range []common.Hash{second.Header.Hash(), first.Header.Hash()} {
+		select {
+		case have := <-api.received:
+			if have != want {
+				t.Fatalf("pending etxs %d mismatch: have %x, want %x", i, have, want)
+			}
+		case <-time.After(4 * c_pEtxSendBackoff):
+			t.Fatalf("pending etxs %d not sent to the dom", i)
+		}
+	}
+}
+
+func TestPEtxSendLoopBoundsRetries(t *testing.T) {
+	sl, _ := newTestSlice()
+	sl.quit = make(chan struct{})
+	sl.pEtxSendCh = make(chan pEtxSend, c_pEtxSendQueueSize)
+	sl.wg.Add(1)
+	go sl.pEtxSendLoop()
+
+	// Without a dom client every send fails and is held back for its retry
+	results := make([]chan error, c_pEtxSendQueueSize+1)
+	for i := range results {
+		results[i] = make(chan error, 1)
+		send := pEtxSend{ctx: context.Background(), pEtxs: types.PendingEtxs{Header: newTestHeader(nil, uint64(i), 0)}, attempt: 1, backoff: time.Hour, result: results[i]}
+		if err := sl.enqueuePendingEtxs(context.Background(), send); err != nil {
+			t.Fatalf("failed to queue the pending etxs %d: %v", i, err)
+		}
+	}
+	// Once c_pEtxSendQueueSize sends are held back, the next one is given up on
+	select {
+	case err := <-results[c_pEtxSendQueueSize]:
+		if !errors.Is(err, ErrPendingEtxsNotSent) {
+			t.Fatalf("error mismatch: have %v, want %v", err, ErrPendingEtxsNotSent)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("send beyond the held back limit not given up on")
+	}
+	// The held back sends are reported as not sent when the loop stops
+	close(sl.quit)
+	sl.wg.Wait()
+	for i, result := range results[:c_pEtxSendQueueSize] {
+		select {
+		case err := <-result:
+			if !errors.Is(err, ErrPendingEtxsNotSent) {
+				t.Errorf("held back send %d error mismatch: have %v, want %v", i, err, ErrPendingEtxsNotSent)
+			}
+		default:
+			t.Errorf("held back send %d not reported", i)
+		}
+	}
+}
+
+func TestCheckHeadStalledRecovers(t *testing.T) {
+	defer func(location common.Location) { common.NodeLocation = location }(common.NodeLocation)
+	common.NodeLocation = common.Location{0, 0}
+
+	// The dom is served over http, so that a redial of the dom client succeeds
+	server := rpc.NewServer()
+	defer server.Stop()
+	dom := httptest.NewServer(server)
+	defer dom.Close()
+
+	genesis := newTestHeader(nil, 0, 0)
+	sl, _ := newTestSlice()
+	sl.config = &params.ChainConfig{GenesisHash: genesis.Hash()}
+	sl.hc.bc = &BodyDb{}
+	sl.hc.currentHeader.Store(newTestHeader(genesis, 1, 0))
+	sl.domClientUrl = dom.URL
+	sl.dialTimeout = time.Second
+	appendQueue, _ := expireLru.New(c_maxAppendQueue)
+	c := &Core{sl: sl, appendQueue: appendQueue, normalListBackoff: 1, quit: make(chan struct{})}
+
+	timeout := 50 * time.Millisecond
+	if err := sl.UpdateConfig(ConfigPatch{HeadStallTimeout: &timeout}); err != nil {
+		t.Fatalf("failed to update the head stall timeout: %v", err)
+	}
+	stalls := make(chan HeadStalledEvent, 1)
+	sub := c.SubscribeHeadStalledEvent(stalls)
+	defer sub.Unsubscribe()
+	done := make(chan struct{})
+	go func() {
+		c.checkHeadStalled()
+		close(done)
+	}()
+	// Wait for the watchdog to exit before the node location is restored
+	defer func() {
+		close(c.quit)
+		<-done
+	}()
+
+	select {
+	case event := <-stalls:
+		if event.Head.Hash() != c.CurrentHeader().Hash() || event.StalledFor < timeout {
+			t.Fatalf("head stalled event mismatch: have %x stalled for %v", event.Head.Hash(), event.StalledFor)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("no recovery attempted for the stalled head")
+	}
+	// The event is sent before the clients are redialed
+	deadline := time.Now().Add(time.Second)
+	for sl.getDomClient() == nil {
+		if time.Now().After(deadline) {
+			t.Fatalf("dom client not redialed by the recovery")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCheckGenesis(t *testing.T) {
+	chainConfig := *params.TestChainConfig
+	genesis := &Genesis{Config: &chainConfig, Difficulty: big.NewInt(1), GasLimit: params.GenesisGasLimit}
+	other := &Genesis{Config: &chainConfig, Difficulty: big.NewInt(2), GasLimit: params.GenesisGasLimit}
+	stored := genesis.ToBlock(nil).Hash()
+
+	tests := []struct {
+		name       string
+		existing   bool // The genesis is already committed to the datadir
+		configured common.Hash
+		spec       *Genesis
+		mismatch   common.Hash // The new hash reported by the error, if any
+	}{
+		{"fresh datadir", false, other.ToBlock(nil).Hash(), other, common.Hash{}},
+		{"matching genesis", true, stored, genesis, common.Hash{}},
+		{"matching config without spec", true, stored, nil, common.Hash{}},
+		{"config hash mismatch", true, other.ToBlock(nil).Hash(), genesis, other.ToBlock(nil).Hash()},
+		{"genesis spec mismatch", true, stored, other, other.ToBlock(nil).Hash()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sl, db := newTestSlice()
+			if tt.existing {
+				genesis.MustCommit(db)
+			}
+			config := chainConfig
+			config.GenesisHash = tt.configured
+			sl.config = &config
+
+			err := sl.checkGenesis(tt.spec)
+			if tt.mismatch == (common.Hash{}) {
+				if err != nil {
+					t.Fatalf("genesis refused: %v", err)
+				}
+				return
+			}
+			var mismatchErr *GenesisMismatchError
+			if !errors.As(err, &mismatchErr) {
+				t.Fatalf("error mismatch: have %v, want a genesis mismatch", err)
+			}
+			if mismatchErr.Stored != stored || mismatchErr.New != tt.mismatch {
+				t.Errorf("hashes mismatch: have stored %x new %x, want stored %x new %x", mismatchErr.Stored, mismatchErr.New, stored, tt.mismatch)
+			}
+		})
+	}
+}
+
+// testPoolChain is the chain of a tx pool, with the states of the blocks set
+// by their root
+type testPoolChain struct {
+	mu       sync.Mutex
+	head     *types.Block
+	blocks   map[common.Hash]*types.Block
+	states   map[common.Hash]*state.StateDB
+	headFeed event.Feed
+}
+
+func (c *testPoolChain) addBlock(block *types.Block) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.blocks[block.Hash()] = block
+}
+
+func (c *testPoolChain) CurrentBlock() *types.Block {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.head
+}
+
+func (c *testPoolChain) GetBlock(hash common.Hash, number uint64) *types.Block {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.blocks[hash]
+}
+
+func (c *testPoolChain) StateAt(root common.Hash) (*state.StateDB, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	statedb, ok := c.states[root]
+	if !ok {
+		return nil, errors.New("unknown root")
+	}
+	return statedb.Copy(), nil
+}
+
+func (c *testPoolChain) SubscribeChainHeadEvent(ch chan<- ChainHeadEvent) event.Subscription {
+	return c.headFeed.Subscribe(ch)
+}
+
+func TestResetTxPool(t *testing.T) {
+	defer func(location common.Location) { common.NodeLocation = location }(common.NodeLocation)
+	common.NodeLocation = common.Location{0, 0}
+
+	sl, _ := newTestSlice()
+	if err := sl.ResetTxPool(); !errors.Is(err, ErrTxPoolNotRunning) {
+		t.Fatalf("error mismatch without a pool: have %v, want %v", err, ErrTxPoolNotRunning)
+	}
+
+	// The sender has to be an address of the zone
+	key, _ := crypto.GenerateKey()
+	for !common.NodeLocation.ContainsAddress(crypto.PubkeyToAddress(key.PublicKey)) {
+		key, _ = crypto.GenerateKey()
+	}
+	sender, err := crypto.PubkeyToAddress(key.PublicKey).InternalAddress()
+	if err != nil {
+		t.Fatalf("sender not internal: %v", err)
+	}
+	// The sender has sent no transaction at the first root, and one at the
+	// second root
+	chain := &testPoolChain{blocks: make(map[common.Hash]*types.Block), states: make(map[common.Hash]*state.StateDB)}
+	stateDb := state.NewDatabase(rawdb.NewMemoryDatabase())
+	for nonce, root := range []common.Hash{{0x01}, {0x02}} {
+		statedb, _ := state.New(common.Hash{}, stateDb, nil)
+		statedb.SetBalance(sender, big.NewInt(params.Ether))
+		statedb.SetNonce(sender, uint64(nonce))
+		chain.states[root] = statedb
+	}
+	newBlock := func(parent *types.Header, fork byte, root common.Hash, txs types.Transactions) *types.Block {
+		header := newTestHeader(parent, 0, fork)
+		if parent != nil {
+			header.SetNumber(new(big.Int).Add(parent.Number(), common.Big1))
+		}
+		header.SetRoot(root)
+		header.SetGasLimit(params.GenesisGasLimit)
+		header.SetBaseFee(big.NewInt(1))
+		block := types.NewBlockWithHeader(header).WithBody(txs, nil, nil, nil)
+		chain.addBlock(block)
+		return block
+	}
+	genesis := newBlock(nil, 0, common.Hash{0x01}, nil)
+	chain.head = genesis
+
+	chainConfig := *params.TestChainConfig
+	chainConfig.Location = common.NodeLocation
+	pool := NewTxPool(TxPoolConfig{NoLocals: true}, &chainConfig, chain)
+	defer pool.Stop()
+	sl.txPool = pool
+	to := common.BytesToAddress([]byte{0x01})
+	tx, err := types.SignNewTx(key, pool.signer, &types.InternalTx{ChainID: chainConfig.ChainID, Nonce: 0, GasTipCap: big.NewInt(10), GasFeeCap: big.NewInt(10), Gas: 21000, To: &to, Value: big.NewInt(1)})
+	if err != nil {
+		t.Fatalf("failed to sign the transaction: %v", err)
+	}
+	if err := pool.AddLocal(tx); err != nil {
+		t.Fatalf("failed to add the transaction: %v", err)
+	}
+	if pending, _ := pool.Stats(); pending != 1 {
+		t.Fatalf("pending mismatch: have %d, want 1", pending)
+	}
+
+	// The head moves without a chain head event to a block including the
+	// transaction, which the reset drops
+	included := newBlock(genesis.Header(), 1, common.Hash{0x02}, types.Transactions{tx})
+	sl.hc.currentHeader.Store(included.Header())
+	if err := sl.ResetTxPool(); err != nil {
+		t.Fatalf("failed to reset the pool: %v", err)
+	}
+	if pool.Has(tx.Hash()) {
+		t.Errorf("included transaction kept in the pool")
+	}
+
+	// The head moves to a sibling without the transaction, which the reset
+	// reinjects
+	sibling := newBlock(genesis.Header(), 2, common.Hash{0x01}, nil)
+	sl.hc.currentHeader.Store(sibling.Header())
+	if err := sl.ResetTxPool(); err != nil {
+		t.Fatalf("failed to reset the pool: %v", err)
+	}
+	if !pool.Has(tx.Hash()) {
+		t.Errorf("transaction of the dropped block not reinjected")
+	}
+
+	// A head without a known state is refused
+	sl.hc.currentHeader.Store(newBlock(sibling.Header(), 0, common.Hash{0x03}, nil).Header())
+	if err := sl.ResetTxPool(); err == nil {
+		t.Errorf("reset to an unknown state accepted")
+	}
+}
+
+func TestCheckRollupAvailability(t *testing.T) {
+	defer func(location common.Location) { common.NodeLocation = location }(common.NodeLocation)
+
+	sl, db := newTestSlice()
+	sl.hc.pendingEtxs, _ = lru.New(16)
+	sl.hc.pendingEtxsRollup, _ = lru.New(16)
+
+	// The pending etxs of a are cached, those of b are only in the database
+	cached, stored := newTestPendingEtxs(1, 1), newTestPendingEtxs(2, 1)
+	sl.hc.pendingEtxs.Add(cached.Header.Hash(), cached)
+	rawdb.WritePendingEtxs(db, stored)
+	a, b := cached.Header.Hash(), stored.Header.Hash()
+	c, d := common.Hash{0x0c}, common.Hash{0x0d}
+
+	// Same for the rollups in prime
+	cachedRollup := types.PendingEtxsRollup{Header: newTestHeader(nil, 1, 1), Manifest: types.BlockManifest{a, c}}
+	storedRollup := types.PendingEtxsRollup{Header: newTestHeader(nil, 1, 2), Manifest: types.BlockManifest{b}}
+	sl.hc.pendingEtxsRollup.Add(cachedRollup.Header.Hash(), cachedRollup)
+	rawdb.WritePendingEtxsRollup(db, storedRollup)
+	missingRollup := common.Hash{0x0e}
+
+	tests := []struct {
+		name     string
+		location common.Location
+		manifest types.BlockManifest
+		missing  []common.Hash
+	}{
+		{"zone", common.Location{0, 0}, types.BlockManifest{c, d}, []common.Hash{}},
+		{"region all available", common.Location{0}, types.BlockManifest{a, b}, []common.Hash{}},
+		{"region some missing", common.Location{0}, types.BlockManifest{a, c, b, d}, []common.Hash{c, d}},
+		{"region empty manifest", common.Location{0}, types.BlockManifest{}, []common.Hash{}},
+		{"prime all available", common.Location{}, types.BlockManifest{storedRollup.Header.Hash()}, []common.Hash{}},
+		{"prime some missing", common.Location{}, types.BlockManifest{cachedRollup.Header.Hash(), storedRollup.Header.Hash(), missingRollup}, []common.Hash{c, missingRollup}},
+	}
+	for _, tt := range tests {
+		common.NodeLocation = tt.location
+		missing, err := sl.CheckRollupAvailability(tt.manifest)
+		if err != nil {
+			t.Fatalf("%s: failed to check the rollup: %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(missing, tt.missing) {
+			t.Errorf("%s: missing mismatch: have %x, want %x", tt.name, missing, tt.missing)
+		}
+	}
+
+	atomic.StoreInt32(&sl.closed, 1)
+	if _, err := sl.CheckRollupAvailability(types.BlockManifest{a}); !errors.Is(err, ErrSliceClosed) {
+		t.Errorf("error mismatch on a closed slice: have %v, want %v", err, ErrSliceClosed)
+	}
+}
+
+func TestGetManifestCache(t *testing.T) {
+	sl, db := newTestSlice()
+	sl.manifestCache, _ = lru.New(16)
+
+	hash := common.Hash{0x01}
+	want := types.BlockManifest{{0x0a}, {0x0b}}
+	rawdb.WriteManifest(db, hash, want)
+	manifest, err := sl.GetManifest(hash)
+	if err != nil {
+		t.Fatalf("failed to get the manifest: %v", err)
+	}
+	if !reflect.DeepEqual(manifest, want) {
+		t.Fatalf("manifest mismatch: have %x, want %x", manifest, want)
+	}
+	if !sl.manifestCache.Contains(hash) {
+		t.Fatalf("manifest not cached")
+	}
+
+	// A hit is served from the cache without reading the database, and the
+	// cached manifest is not shared with the callers
+	rawdb.DeleteManifest(db, hash)
+	manifest[0] = common.Hash{0xff}
+	for i := 0; i < 2; i++ {
+		cached, err := sl.GetManifest(hash)
+		if err != nil {
+			t.Fatalf("failed to get the cached manifest: %v", err)
+		}
+		if !reflect.DeepEqual(cached, want) {
+			t.Errorf("cached manifest mismatch: have %x, want %x", cached, want)
+		}
+		cached[1] = common.Hash{0xff}
+	}
+
+	if _, err := sl.GetManifest(common.Hash{0x02}); err == nil {
+		t.Errorf("unknown manifest returned")
+	}
+	if sl.manifestCache.Contains(common.Hash{0x02}) {
+		t.Errorf("unknown manifest cached")
+	}
+}
@@ -245,6 +245,9 @@ type TxPool struct {
 	pendingNonces *txNoncer      // Pending state tracking virtual nonces
 	currentMaxGas uint64         // Current gas limit for transaction caps
 
+	headMu sync.Mutex
+	head   *types.Header // Head of the last requested reset
+
 	locals  *accountSet // Set of local transaction to exempt from eviction rules
 	journal *txJournal  // Journal of local transaction to back up to disk
 
@@ -315,7 +318,8 @@ func NewTxPool(config TxPoolConfig, chainconfig *params.ChainConfig, chain block
 		pool.locals.add(addr)
 	}
 	pool.priced = newTxPricedList(pool.all)
-	pool.reset(nil, chain.CurrentBlock().Header())
+	pool.head = chain.CurrentBlock().Header()
+	pool.reset(nil, pool.head)
 
 	// Start the reorg loop early so it can handle requests generated during journal loading.
 	pool.wg.Add(1)
@@ -352,8 +356,6 @@ func (pool *TxPool) loop() {
 		report  = time.NewTicker(statsReportInterval)
 		evict   = time.NewTicker(evictionInterval)
 		journal = time.NewTicker(pool.config.Rejournal)
-		// Track the previous head headers for transaction reorgs
-		head = pool.chain.CurrentBlock()
 	)
 	defer report.Stop()
 	defer evict.Stop()
@@ -364,8 +366,7 @@ func (pool *TxPool) loop() {
 		// Handle ChainHeadEvent
 		case ev := <-pool.chainHeadCh:
 			if ev.Block != nil {
-				pool.requestReset(head.Header(), ev.Block.Header())
-				head = ev.Block
+				pool.requestReset(pool.swapHead(ev.Block.Header()), ev.Block.Header())
 			}
 
 		// System shutdown.
@@ -1108,6 +1109,30 @@ func (pool *TxPool) removeTx(hash common.Hash, outofbound bool) {
 	}
 }
 
+// ResetHead re-validates the pool against the given head, dropping the
+// transactions which became invalid and reinjecting those of the blocks which
+// are no longer on the chain of the head. It returns once the reset is applied.
+func (pool *TxPool) ResetHead(newHead *types.Header) error {
+	if newHead == nil {
+		return errors.New("nil head")
+	}
+	if _, err := pool.chain.StateAt(newHead.Root()); err != nil {
+		return err
+	}
+	<-pool.requestReset(pool.swapHead(newHead), newHead)
+	return nil
+}
+
+// swapHead records the head of a new reset and returns the previous one, which
+// the reset starts from.
+func (pool *TxPool) swapHead(newHead *types.Header) *types.Header {
+	pool.headMu.Lock()
+	defer pool.headMu.Unlock()
+	oldHead := pool.head
+	pool.head = newHead
+	return oldHead
+}
+
 // requestReset requests a pool reset to the new head block.
 // The returned channel is closed when the reset has occurred.
 func (pool *TxPool) requestReset(oldHead *types.Header, newHead *types.Header) chan struct{} {
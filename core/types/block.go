@@ -20,6 +20,7 @@ package types
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"math/big"
@@ -686,6 +687,8 @@ type Block struct {
 	// caches
 	size       atomic.Value
 	appendTime atomic.Value
+	emptyBody  atomic.Value
+	order      atomic.Value
 
 	// These fields are used by package eth to track
 	// inter-peer block relay.
@@ -889,6 +892,18 @@ func (b *Block) Size() common.StorageSize {
 	return common.StorageSize(c)
 }
 
+// EmptyBody returns true if the block carries no transactions, uncles, external
+// transactions or sub manifest. The result is computed on the first call and
+// cached thereafter, since the body of a block is never modified in place.
+func (b *Block) EmptyBody() bool {
+	if empty := b.emptyBody.Load(); empty != nil {
+		return empty.(bool)
+	}
+	empty := len(b.transactions) == 0 && len(b.uncles) == 0 && len(b.extTransactions) == 0 && len(b.subManifest) == 0
+	b.emptyBody.Store(empty)
+	return empty
+}
+
 // SanityCheck can be used to prevent that unbounded fields are
 // stuffed with junk data to add processing overhead
 func (b *Block) SanityCheck() error {
@@ -960,6 +975,21 @@ func (b *Block) SetAppendTime(appendTime time.Duration) {
 	b.appendTime.Store(appendTime)
 }
 
+// GetOrder returns the order of the block within the hierarchy if it has been
+// set, so that the coincidence of a block is only calculated once per append.
+func (b *Block) GetOrder() (int, bool) {
+	if order := b.order.Load(); order != nil {
+		if val, ok := order.(int); ok {
+			return val, true
+		}
+	}
+	return -1, false
+}
+
+func (b *Block) SetOrder(order int) {
+	b.order.Store(order)
+}
+
 type Blocks []*Block
 
 // PendingHeader stores the header and termini value associated with the header.
@@ -1004,6 +1034,63 @@ func CopyPendingHeader(ph *PendingHeader) *PendingHeader {
 	return &cpy
 }
 
+// PendingHeaderDiff carries only the per context fields of a pending header
+// that a dom relays to its subordinates. The subordinate applies the diff on
+// top of its own pending header at the same terminus.
+type PendingHeaderDiff struct {
+	Contexts      []int          `json:"contexts"`
+	ParentHash    []common.Hash  `json:"parentHash"`
+	Number        []*hexutil.Big `json:"number"`
+	ManifestHash  []common.Hash  `json:"manifestHash"`
+	ParentEntropy []*hexutil.Big `json:"parentEntropy"`
+	ParentDeltaS  []*hexutil.Big `json:"parentDeltaS"`
+}
+
+// NewPendingHeaderDiff collects the fields of the given contexts from header
+func NewPendingHeaderDiff(header *Header, contexts []int) PendingHeaderDiff {
+	diff := PendingHeaderDiff{
+		Contexts:      make([]int, len(contexts)),
+		ParentHash:    make([]common.Hash, len(contexts)),
+		Number:        make([]*hexutil.Big, len(contexts)),
+		ManifestHash:  make([]common.Hash, len(contexts)),
+		ParentEntropy: make([]*hexutil.Big, len(contexts)),
+		ParentDeltaS:  make([]*hexutil.Big, len(contexts)),
+	}
+	for i, ctx := range contexts {
+		diff.Contexts[i] = ctx
+		diff.ParentHash[i] = header.ParentHash(ctx)
+		diff.Number[i] = (*hexutil.Big)(header.Number(ctx))
+		diff.ManifestHash[i] = header.ManifestHash(ctx)
+		diff.ParentEntropy[i] = (*hexutil.Big)(header.ParentEntropy(ctx))
+		diff.ParentDeltaS[i] = (*hexutil.Big)(header.ParentDeltaS(ctx))
+	}
+	return diff
+}
+
+// Apply returns a copy of base with the fields of the diff contexts replaced
+func (d PendingHeaderDiff) Apply(base *Header) (*Header, error) {
+	if len(d.ParentHash) != len(d.Contexts) || len(d.Number) != len(d.Contexts) ||
+		len(d.ManifestHash) != len(d.Contexts) || len(d.ParentEntropy) != len(d.Contexts) ||
+		len(d.ParentDeltaS) != len(d.Contexts) {
+		return nil, errors.New("pending header diff fields do not match its contexts")
+	}
+	header := CopyHeader(base)
+	for i, ctx := range d.Contexts {
+		if ctx < 0 || ctx >= common.HierarchyDepth {
+			return nil, fmt.Errorf("pending header diff has invalid context %d", ctx)
+		}
+		if d.Number[i] == nil || d.ParentEntropy[i] == nil || d.ParentDeltaS[i] == nil {
+			return nil, fmt.Errorf("pending header diff is missing fields for context %d", ctx)
+		}
+		header.SetParentHash(d.ParentHash[i], ctx)
+		header.SetNumber((*big.Int)(d.Number[i]), ctx)
+		header.SetManifestHash(d.ManifestHash[i], ctx)
+		header.SetParentEntropy((*big.Int)(d.ParentEntropy[i]), ctx)
+		header.SetParentDeltaS((*big.Int)(d.ParentDeltaS[i]), ctx)
+	}
+	return header, nil
+}
+
 // "external" pending header encoding. used for rlp
 type extPendingHeader struct {
 	Header  *Header
@@ -1036,6 +1123,14 @@ func (p PendingHeader) EncodeRLP(w io.Writer) error {
 	})
 }
 
+var (
+	// ErrNilTermini is returned when validating termini which do not exist
+	ErrNilTermini = errors.New("termini are nil")
+
+	// ErrInvalidTermini is returned when the termini do not hold a terminus for every chain of the hierarchy
+	ErrInvalidTermini = errors.New("invalid termini")
+)
+
 // Termini stores the dom terminus (i.e the previous dom block) and
 // subTermini(i.e the dom blocks that have occured in the subordinate chains)
 type Termini struct {
@@ -1061,6 +1156,15 @@ func CopyTermini(termini Termini) Termini {
 	return newTermini
 }
 
+// NewTermini returns the termini with the given dom and sub termini, indexed
+// by the location of the dom and sub chains respectively
+func NewTermini(domTermini [common.HierarchyDepth]common.Hash, subTermini [common.HierarchyDepth]common.Hash) Termini {
+	termini := EmptyTermini()
+	copy(termini.domTermini, domTermini[:])
+	copy(termini.subTermini, subTermini[:])
+	return termini
+}
+
 func EmptyTermini() Termini {
 	termini := Termini{}
 	termini.subTermini = make([]common.Hash, common.HierarchyDepth)
@@ -1111,18 +1215,22 @@ func (t *Termini) SetSubTerminiAtIndex(val common.Hash, index int) {
 }
 
 func (t *Termini) IsValid() bool {
+	return t.Validate() == nil
+}
+
+// Validate returns an error describing why the termini are malformed, or nil
+// if they hold a dom and a sub terminus for every chain of the hierarchy.
+func (t *Termini) Validate() error {
 	if t == nil {
-		return false
+		return ErrNilTermini
 	}
-	if len(t.subTermini) != common.NumZonesInRegion {
-		return false
+	if len(t.domTermini) != common.HierarchyDepth {
+		return fmt.Errorf("%w: have %d dom termini, want %d", ErrInvalidTermini, len(t.domTermini), common.HierarchyDepth)
 	}
-
-	if len(t.domTermini) != common.NumZonesInRegion {
-		return false
+	if len(t.subTermini) != common.HierarchyDepth {
+		return fmt.Errorf("%w: have %d sub termini, want %d", ErrInvalidTermini, len(t.subTermini), common.HierarchyDepth)
 	}
-
-	return true
+	return nil
 }
 
 // "external termini" pending header encoding. used for rlp
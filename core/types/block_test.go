@@ -0,0 +1,193 @@
+// Copyright 2014 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package types_test
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/dominant-strategies/go-quai/common"
+	"github.com/dominant-strategies/go-quai/common/hexutil"
+	"github.com/dominant-strategies/go-quai/core/types"
+	"github.com/dominant-strategies/go-quai/rlp"
+)
+
+// newDiffTestHeader returns a header with distinct values in every context
+func newDiffTestHeader(seed byte) *types.Header {
+	header := types.EmptyHeader()
+	for ctx := 0; ctx < common.HierarchyDepth; ctx++ {
+		value := int64(seed)*10 + int64(ctx)
+		header.SetParentHash(common.Hash{seed, byte(ctx), 1}, ctx)
+		header.SetNumber(big.NewInt(value), ctx)
+		header.SetManifestHash(common.Hash{seed, byte(ctx), 2}, ctx)
+		header.SetParentEntropy(big.NewInt(value+1), ctx)
+		header.SetParentDeltaS(big.NewInt(value+2), ctx)
+	}
+	header.SetCoinbase(common.BytesToAddress([]byte{seed}))
+	header.SetExtra([]byte{seed})
+	return header
+}
+
+func TestPendingHeaderDiffApply(t *testing.T) {
+	base, full := newDiffTestHeader(1), newDiffTestHeader(2)
+	baseHash := base.Hash()
+
+	for _, contexts := range [][]int{
+		{},
+		{common.PRIME_CTX},
+		{common.PRIME_CTX, common.REGION_CTX},
+		{common.PRIME_CTX, common.REGION_CTX, common.ZONE_CTX},
+	} {
+		diff := types.NewPendingHeaderDiff(full, contexts)
+		header, err := diff.Apply(base)
+		if err != nil {
+			t.Fatalf("contexts %v: failed to apply the diff: %v", contexts, err)
+		}
+		for ctx := 0; ctx < common.HierarchyDepth; ctx++ {
+			want := base
+			if ctx < len(contexts) {
+				want = full
+			}
+			if header.ParentHash(ctx) != want.ParentHash(ctx) ||
+				header.Number(ctx).Cmp(want.Number(ctx)) != 0 ||
+				header.ManifestHash(ctx) != want.ManifestHash(ctx) ||
+				header.ParentEntropy(ctx).Cmp(want.ParentEntropy(ctx)) != 0 ||
+				header.ParentDeltaS(ctx).Cmp(want.ParentDeltaS(ctx)) != 0 {
+				t.Errorf("contexts %v: fields mismatch at context %d", contexts, ctx)
+			}
+		}
+		if header.Coinbase() != base.Coinbase() {
+			t.Errorf("contexts %v: coinbase taken from the diff", contexts)
+		}
+		if base.Hash() != baseHash {
+			t.Fatalf("contexts %v: base header modified", contexts)
+		}
+	}
+	// Applying all the contexts of a header on top of itself is a no-op
+	header, err := types.NewPendingHeaderDiff(base, []int{common.PRIME_CTX, common.REGION_CTX, common.ZONE_CTX}).Apply(base)
+	if err != nil {
+		t.Fatalf("failed to apply the diff: %v", err)
+	}
+	if header.Hash() != baseHash {
+		t.Errorf("hash mismatch: have %x, want %x", header.Hash(), baseHash)
+	}
+}
+
+func TestPendingHeaderDiffApplyInvalid(t *testing.T) {
+	full := newDiffTestHeader(2)
+	contexts := []int{common.PRIME_CTX, common.REGION_CTX}
+
+	tests := []struct {
+		name   string
+		modify func(d *types.PendingHeaderDiff)
+	}{
+		{"short parent hashes", func(d *types.PendingHeaderDiff) { d.ParentHash = d.ParentHash[:1] }},
+		{"short numbers", func(d *types.PendingHeaderDiff) { d.Number = d.Number[:1] }},
+		{"short manifest hashes", func(d *types.PendingHeaderDiff) { d.ManifestHash = d.ManifestHash[:1] }},
+		{"short parent entropies", func(d *types.PendingHeaderDiff) { d.ParentEntropy = d.ParentEntropy[:1] }},
+		{"short parent deltaS", func(d *types.PendingHeaderDiff) { d.ParentDeltaS = d.ParentDeltaS[:1] }},
+		{"extra context", func(d *types.PendingHeaderDiff) { d.Contexts = append(d.Contexts, common.ZONE_CTX) }},
+		{"negative context", func(d *types.PendingHeaderDiff) { d.Contexts[1] = -1 }},
+		{"context out of range", func(d *types.PendingHeaderDiff) { d.Contexts[1] = common.HierarchyDepth }},
+		{"nil number", func(d *types.PendingHeaderDiff) { d.Number[1] = nil }},
+		{"nil parent entropy", func(d *types.PendingHeaderDiff) { d.ParentEntropy[0] = nil }},
+		{"nil parent deltaS", func(d *types.PendingHeaderDiff) { d.ParentDeltaS[1] = (*hexutil.Big)(nil) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diff := types.NewPendingHeaderDiff(full, contexts)
+			tt.modify(&diff)
+			base := newDiffTestHeader(1)
+			if header, err := diff.Apply(base); err == nil {
+				t.Fatalf("malformed diff applied: %x", header.Hash())
+			}
+		})
+	}
+}
+
+func TestNewTermini(t *testing.T) {
+	var domTermini, subTermini [common.HierarchyDepth]common.Hash
+	for i := 0; i < common.HierarchyDepth; i++ {
+		domTermini[i] = common.Hash{byte(i + 1)}
+		subTermini[i] = common.Hash{byte(i + 1), 1}
+	}
+	termini := types.NewTermini(domTermini, subTermini)
+	if err := termini.Validate(); err != nil {
+		t.Fatalf("constructed termini invalid: %v", err)
+	}
+	for i := 0; i < common.HierarchyDepth; i++ {
+		if have := termini.DomTerminiAtIndex(i); have != domTermini[i] {
+			t.Errorf("dom terminus %d mismatch: have %x, want %x", i, have, domTermini[i])
+		}
+		if have := termini.SubTerminiAtIndex(i); have != subTermini[i] {
+			t.Errorf("sub terminus %d mismatch: have %x, want %x", i, have, subTermini[i])
+		}
+	}
+
+	// The termini do not share the arrays they were built from
+	domTermini[0] = common.Hash{}
+	if termini.DomTerminiAtIndex(0) == (common.Hash{}) {
+		t.Errorf("termini modified through the dom termini array")
+	}
+	empty := types.EmptyTermini()
+	if err := empty.Validate(); err != nil {
+		t.Errorf("empty termini invalid: %v", err)
+	}
+}
+
+func TestTerminiValidateMalformed(t *testing.T) {
+	if err := (*types.Termini)(nil).Validate(); !errors.Is(err, types.ErrNilTermini) {
+		t.Errorf("nil termini: have %v, want %v", err, types.ErrNilTermini)
+	}
+	if err := (&types.Termini{}).Validate(); !errors.Is(err, types.ErrInvalidTermini) {
+		t.Errorf("zero termini: have %v, want %v", err, types.ErrInvalidTermini)
+	}
+
+	// Malformed termini are read from their encoding
+	full := make([]common.Hash, common.HierarchyDepth)
+	tests := []struct {
+		name       string
+		domTermini []common.Hash
+		subTermini []common.Hash
+	}{
+		{"no dom termini", nil, full},
+		{"no sub termini", full, nil},
+		{"short dom termini", full[:common.HierarchyDepth-1], full},
+		{"short sub termini", full, full[:1]},
+		{"long dom termini", append(full[:common.HierarchyDepth:common.HierarchyDepth], common.Hash{}), full},
+		{"long sub termini", full, append(full[:common.HierarchyDepth:common.HierarchyDepth], common.Hash{})},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			enc, err := rlp.EncodeToBytes(struct {
+				DomTermini []common.Hash
+				SubTermini []common.Hash
+			}{tt.domTermini, tt.subTermini})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var termini types.Termini
+			if err := rlp.DecodeBytes(enc, &termini); err != nil {
+				t.Fatal(err)
+			}
+			if err := termini.Validate(); !errors.Is(err, types.ErrInvalidTermini) {
+				t.Fatalf("have %v, want %v", err, types.ErrInvalidTermini)
+			}
+		})
+	}
+}
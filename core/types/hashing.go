@@ -111,3 +111,46 @@ func DeriveSha(list DerivableList, hasher TrieHasher) common.Hash {
 	}
 	return hasher.Hash()
 }
+
+// EncodeEtxRollup returns the canonical encoding of an ETX rollup. It is the RLP
+// list whose i-th element is the consensus encoding of the i-th ETX, which is
+// also the value stored under the key rlp(i) in the trie the rollup hash is
+// derived from. Other implementations must reproduce this encoding exactly to
+// verify rollup hashes.
+func EncodeEtxRollup(etxRollup Transactions) []byte {
+	valueBuf := encodeBufferPool.Get().(*bytes.Buffer)
+	defer encodeBufferPool.Put(valueBuf)
+
+	values := make([][]byte, etxRollup.Len())
+	for i := range values {
+		values[i] = encodeForDerive(etxRollup, i, valueBuf)
+	}
+	// Encoding a list of byte slices cannot fail
+	data, _ := rlp.EncodeToBytes(values)
+	return data
+}
+
+// EtxRollupHashFromEncoding computes the rollup hash of an ETX rollup encoded
+// with EncodeEtxRollup, i.e. the root of the trie holding the i-th element of
+// the encoded list under the key rlp(i).
+func EtxRollupHashFromEncoding(encoding []byte, hasher TrieHasher) (common.Hash, error) {
+	var values [][]byte
+	if err := rlp.DecodeBytes(encoding, &values); err != nil {
+		return common.Hash{}, err
+	}
+	return DeriveSha(encodedList(values), hasher), nil
+}
+
+// encodedList is a DerivableList of already encoded values.
+type encodedList [][]byte
+
+func (l encodedList) Len() int { return len(l) }
+
+func (l encodedList) EncodeIndex(i int, w *bytes.Buffer) { w.Write(l[i]) }
+
+// EtxRollupHash computes the hash committed to in the EtxRollupHash field of
+// a header, the root derived by DeriveSha from the ETXs of the rollup. It is
+// the hash EtxRollupHashFromEncoding computes from the canonical encoding.
+func EtxRollupHash(etxRollup Transactions, hasher TrieHasher) common.Hash {
+	return DeriveSha(etxRollup, hasher)
+}
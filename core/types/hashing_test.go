@@ -0,0 +1,124 @@
+// Copyright 2014 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package types_test
+
+import (
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"testing"
+
+	"github.com/dominant-strategies/go-quai/common"
+	"github.com/dominant-strategies/go-quai/common/hexutil"
+	"github.com/dominant-strategies/go-quai/core/types"
+	"github.com/dominant-strategies/go-quai/trie"
+)
+
+var updateGolden = flag.Bool("update", false, "update the golden files in testdata")
+
+// etxRollupGolden pins the encoding and the hash of an ETX rollup, so that
+// other implementations can check theirs against it.
+type etxRollupGolden struct {
+	Name     string        `json:"name"`
+	Encoding hexutil.Bytes `json:"encoding"`
+	Hash     common.Hash   `json:"hash"`
+}
+
+// testEtxRollup returns a deterministic rollup of n ETXs.
+func testEtxRollup(n int) types.Transactions {
+	etxs := make(types.Transactions, n)
+	for i := range etxs {
+		to := common.BytesToAddress([]byte{0x0a, byte(i)})
+		etxs[i] = types.NewTx(&types.ExternalTx{
+			ChainID:   big.NewInt(9000),
+			Nonce:     uint64(i),
+			GasTipCap: big.NewInt(1),
+			GasFeeCap: big.NewInt(int64(i + 2)),
+			Gas:       21000,
+			To:        &to,
+			Value:     big.NewInt(int64(i) * 1000),
+			Data:      []byte{byte(i)},
+			Sender:    common.BytesToAddress([]byte{0x0b, byte(i)}),
+		})
+	}
+	return etxs
+}
+
+func TestEtxRollupGolden(t *testing.T) {
+	inputs := []struct {
+		name string
+		etxs types.Transactions
+	}{
+		{"empty", types.Transactions{}},
+		{"single", testEtxRollup(1)},
+		{"three", testEtxRollup(3)},
+		// Crosses the index 0x7f after which the index 0 is inserted
+		{"index boundary", testEtxRollup(130)},
+	}
+	have := make([]etxRollupGolden, len(inputs))
+	for i, input := range inputs {
+		encoding := types.EncodeEtxRollup(input.etxs)
+		hash := types.EtxRollupHash(input.etxs, trie.NewStackTrie(nil))
+		// Other implementations hash the canonical encoding, it must give the
+		// same hash as the ETXs themselves
+		if fromEncoding, err := types.EtxRollupHashFromEncoding(encoding, trie.NewStackTrie(nil)); err != nil || fromEncoding != hash {
+			t.Errorf("%s: rollup hash from encoding mismatch: have %x, %v, want %x", input.name, fromEncoding, err, hash)
+		}
+		have[i] = etxRollupGolden{Name: input.name, Encoding: encoding, Hash: hash}
+	}
+
+	path := filepath.Join("testdata", "etx_rollup.json")
+	if *updateGolden {
+		data, err := json.MarshalIndent(have, "", "  ")
+		if err != nil {
+			t.Fatalf("failed to encode the golden file: %v", err)
+		}
+		if err := ioutil.WriteFile(path, append(data, '\n'), 0644); err != nil {
+			t.Fatalf("failed to write the golden file: %v", err)
+		}
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read the golden file: %v", err)
+	}
+	var want []etxRollupGolden
+	if err := json.Unmarshal(data, &want); err != nil {
+		t.Fatalf("failed to decode the golden file: %v", err)
+	}
+	if len(have) != len(want) {
+		t.Fatalf("golden vector count mismatch: have %d, want %d", len(have), len(want))
+	}
+	for i := range want {
+		if have[i].Name != want[i].Name {
+			t.Fatalf("golden vector %d name mismatch: have %s, want %s", i, have[i].Name, want[i].Name)
+		}
+		if hexutil.Encode(have[i].Encoding) != hexutil.Encode(want[i].Encoding) {
+			t.Errorf("%s: encoding mismatch: have %x, want %x", want[i].Name, have[i].Encoding, want[i].Encoding)
+		}
+		if have[i].Hash != want[i].Hash {
+			t.Errorf("%s: hash mismatch: have %x, want %x", want[i].Name, have[i].Hash, want[i].Hash)
+		}
+	}
+}
+
+func TestEtxRollupHashFromEncodingInvalid(t *testing.T) {
+	if _, err := types.EtxRollupHashFromEncoding([]byte{0xc2, 0x01}, trie.NewStackTrie(nil)); err == nil {
+		t.Fatalf("malformed encoding accepted")
+	}
+}
@@ -1,6 +1,7 @@
 package core
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"math/big"
@@ -165,6 +166,34 @@ type Config struct {
 	GasPrice   *big.Int       // Minimum gas price for mining a transaction
 	Recommit   time.Duration  // The time interval for miner to re-create mining work.
 	Noverify   bool           // Disable remote mining solution verification(only useful in ethash).
+
+	EqualTdPolicy             EqualTdPolicy `toml:",omitempty"` // Policy used to choose between heads of equal total entropy
+	DomRequiredForCoincident  bool          `toml:",omitempty"` // Refuse to append coincident blocks while the dom is unreachable
+	RelayPendingHeaderDiffs   bool          `toml:",omitempty"` // Relay only the changed per context fields of pending headers to subordinates
+	VerifyTermini             bool          `toml:",omitempty"` // Debug check that inherited termini reference known headers
+	RetryPersistOnStop        bool          `toml:",omitempty"` // Retry persisting the slice state once if it fails on stop
+	AppendTimeout             time.Duration `toml:",omitempty"` // Timeout of each hierarchical request made during an append, zero disables it
+	MaxReorgDepth             uint64        `toml:",omitempty"` // Maximum number of canonical blocks a new head may reorganize, zero disables it
+	AppendBatchBlocks         int           `toml:",omitempty"` // Number of appends committed to the db together, an append moving the head commits the accumulated ones
+	AppendBatchBytes          int           `toml:",omitempty"` // Size in bytes of the accumulated appends after which they are committed
+	HeadStallTimeout          time.Duration `toml:",omitempty"` // Time without head advance after which a recovery is attempted, defaults to c_headStalledThreshold
+	MaxAppendQueue            int           `toml:",omitempty"` // Maximum number of future headers held in the append queue, defaults to c_maxAppendQueue
+	MaxFutureTime             uint64        `toml:",omitempty"` // Max time into the future (in seconds) a block is accepted in the append queue, defaults to c_maxFutureTime
+	AppendQueueMaxRetries     uint64        `toml:",omitempty"` // Number of times a block is retried before it is dropped from the append queue, defaults to c_appendQueueMaxRetries
+	AppendQueueRetryThreshold uint64        `toml:",omitempty"` // Number of times a block behind the head is retried before it is dropped from the append queue, defaults to the context retry threshold
+	PhCacheSize               int           `toml:",omitempty"` // Number of pending headers held in the phCache, defaults to c_phCacheSize
+	PendingHeaderGCWindow     uint64        `toml:",omitempty"` // Number of blocks behind the head after which a phCache entry is collected, defaults to c_pendingHeaderGCWindow
+	PendingHeaderGCInterval   time.Duration `toml:",omitempty"` // Time between two collections of the phCache, defaults to pendingHeaderGCTime minutes
+	ReconnectBackoff          time.Duration `toml:",omitempty"` // Delay before the first reconnection attempt to a dom or sub, defaults to c_reconnectBackoff
+	ReconnectMaxBackoff       time.Duration `toml:",omitempty"` // Maximum delay between two reconnection attempts, defaults to c_reconnectMaxBackoff
+	ParentGraceWindow         time.Duration `toml:",omitempty"` // Time an append waits for an unknown parent, defaults to c_parentGraceWindow, negative disables it
+	DialTimeout               time.Duration `toml:",omitempty"` // Time after which dialing a dom or sub is abandoned, defaults to c_dialTimeout
+	ManifestCacheSize         int           `toml:",omitempty"` // Number of decoded manifests kept in memory, defaults to c_manifestCacheSize
+	MaxManifestSize           int           `toml:",omitempty"` // Maximum number of hashes in the sub manifest of an appended block, defaults to c_maxManifestSize
+	PendingRetention          uint64        `toml:",omitempty"` // Number of blocks below the head for which the pending etxs and pending headers are kept on disk, defaults to c_pendingRetention
+	PendingRetentionPeriod    time.Duration `toml:",omitempty"` // Period before the head for which the pending etxs and pending headers are kept on disk, overrides PendingRetention when set
+	SealVerifyWorkers         int           `toml:",omitempty"` // Number of seals verified concurrently ahead of the appends, defaults to the number of CPUs
+	CyclicCheckDepth          int           `toml:",omitempty"` // Number of dom terminus links walked back to detect a cycle, defaults to c_cyclicCheckDepth, negative disables it
 }
 
 // worker is the main object which takes care of submitting new work to consensus engine
@@ -408,8 +437,8 @@ func (w *worker) LoadPendingBlockBody() {
 	rawdb.DeleteAllPbBodyKeys(w.workerDb)
 }
 
-// StorePendingBlockBody stores the pending block body cache into the db
-func (w *worker) StorePendingBlockBody() {
+// StorePendingBlockBody stores the pending block body cache into the given db writer
+func (w *worker) StorePendingBlockBody(db ethdb.KeyValueWriter) {
 	// store the pendingBodyCache body
 	var pendingBlockBodyKeys []common.Hash
 	pendingBlockBody := w.pendingBlockBody
@@ -417,11 +446,11 @@ func (w *worker) StorePendingBlockBody() {
 		if value, exist := pendingBlockBody.Peek(key); exist {
 			pendingBlockBodyKeys = append(pendingBlockBodyKeys, key.(common.Hash))
 			if key.(common.Hash) != types.EmptyBodyHash {
-				rawdb.WritePbCacheBody(w.workerDb, key.(common.Hash), value.(*types.Body))
+				rawdb.WritePbCacheBody(db, key.(common.Hash), value.(*types.Body))
 			}
 		}
 	}
-	rawdb.WritePbBodyKeys(w.workerDb, pendingBlockBodyKeys)
+	rawdb.WritePbBodyKeys(db, pendingBlockBodyKeys)
 }
 
 // asyncStateLoop updates the state root for a block and returns the state udpate in a channel
@@ -441,7 +470,7 @@ func (w *worker) asyncStateLoop() {
 					return
 				default:
 					block := head.Block
-					header, err := w.GeneratePendingHeader(block, true)
+					header, err := w.GeneratePendingHeader(context.Background(), block, true)
 					if err != nil {
 						log.Error("Error generating pending header with state", "err", err)
 						return
@@ -460,7 +489,8 @@ func (w *worker) asyncStateLoop() {
 }
 
 // GeneratePendingBlock generates pending block given a commited block.
-func (w *worker) GeneratePendingHeader(block *types.Block, fill bool) (*types.Header, error) {
+// Generation is abandoned with ctx.Err() if ctx is cancelled before it completes.
+func (w *worker) GeneratePendingHeader(ctx context.Context, block *types.Block, fill bool) (*types.Header, error) {
 	nodeCtx := common.NodeLocation.Context()
 
 	w.interruptAsyncPhGen()
@@ -492,6 +522,9 @@ func (w *worker) GeneratePendingHeader(block *types.Block, fill bool) (*types.He
 	if err != nil {
 		return nil, err
 	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
 	if nodeCtx == common.ZONE_CTX && w.hc.ProcessingState() {
 		// Fill pending transactions from the txpool
@@ -790,7 +823,7 @@ func (w *worker) prepareWork(genParams *generateParams, block *types.Block) (*en
 
 	// Only calculate entropy if the parent is not the genesis block
 	if parent.Hash() != w.hc.config.GenesisHash {
-		_, order, err := w.engine.CalcOrder(parent.Header())
+		order, err := w.blockOrder(parent)
 		if err != nil {
 			return nil, err
 		}
@@ -885,6 +918,20 @@ func (w *worker) adjustGasLimit(interrupt *int32, env *environment, parent *type
 	env.header.SetGasLimit(CalcGasLimit(parent.Header(), w.config.GasCeil))
 }
 
+// blockOrder returns the order of the block, using the order cached on the
+// block by the slice if it has already been calculated.
+func (w *worker) blockOrder(block *types.Block) (int, error) {
+	if order, ok := block.GetOrder(); ok {
+		return order, nil
+	}
+	_, order, err := w.engine.CalcOrder(block.Header())
+	if err != nil {
+		return -1, err
+	}
+	block.SetOrder(order)
+	return order, nil
+}
+
 // ComputeManifestHash given a header computes the manifest hash for the header
 // and stores it in the database
 func (w *worker) ComputeManifestHash(header *types.Header) common.Hash {
@@ -924,7 +971,7 @@ func (w *worker) FinalizeAssemble(chain consensus.ChainHeaderReader, header *typ
 		if nodeCtx == common.ZONE_CTX {
 			// Compute and set etx rollup hash
 			var etxRollup types.Transactions
-			if w.engine.IsDomCoincident(w.hc, parent.Header()) {
+			if order, err := w.blockOrder(parent); err == nil && order < nodeCtx {
 				etxRollup = parent.ExtTransactions()
 			} else {
 				etxRollup, err = w.hc.CollectEtxRollup(parent)
@@ -933,7 +980,7 @@ func (w *worker) FinalizeAssemble(chain consensus.ChainHeaderReader, header *typ
 				}
 				etxRollup = append(etxRollup, parent.ExtTransactions()...)
 			}
-			etxRollupHash := types.DeriveSha(etxRollup, trie.NewStackTrie(nil))
+			etxRollupHash := types.EtxRollupHash(etxRollup, trie.NewStackTrie(nil))
 			block.Header().SetEtxRollupHash(etxRollupHash)
 		}
 
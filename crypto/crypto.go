@@ -21,6 +21,7 @@ import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/binary"
 	"encoding/hex"
 	"errors"
@@ -30,6 +31,8 @@ import (
 	"io/ioutil"
 	"math/big"
 	"os"
+	"runtime"
+	"sync"
 
 	"github.com/dominant-strategies/go-quai/common"
 	"github.com/dominant-strategies/go-quai/common/math"
@@ -50,7 +53,11 @@ var (
 	secp256k1halfN = new(big.Int).Div(secp256k1N, big.NewInt(2))
 )
 
-var errInvalidPubkey = errors.New("invalid secp256k1 public key")
+var (
+	errInvalidPubkey     = errors.New("invalid secp256k1 public key")
+	errInvalidSigLength  = errors.New("invalid signature length")
+	errInvalidHashLength = errors.New("invalid hash length")
+)
 
 // KeccakState wraps sha3.state. In addition to the usual hash methods, it also supports
 // Read to get a variable amount of data from the hash state. Read is faster than Sum
@@ -259,6 +266,28 @@ func GenerateKey() (*ecdsa.PrivateKey, error) {
 	return ecdsa.GenerateKey(S256(), rand.Reader)
 }
 
+// generateKeyAttempts is the number of candidate scalars GenerateKeyFromReader
+// reads before giving up.
+const generateKeyAttempts = 16
+
+// GenerateKeyFromReader generates a private key from the entropy of r, the
+// same reader content always gives the same key. Each attempt reads 32 bytes
+// as the scalar, a zero or out of range scalar is skipped and the next 32 bytes
+// are read instead.
+func GenerateKeyFromReader(r io.Reader) (*ecdsa.PrivateKey, error) {
+	d := make([]byte, 32)
+	defer zeroBytes(d)
+	for i := 0; i < generateKeyAttempts; i++ {
+		if _, err := io.ReadFull(r, d); err != nil {
+			return nil, err
+		}
+		if key, err := toECDSA(d, true); err == nil {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("no valid private key in %d attempts", generateKeyAttempts)
+}
+
 // ValidateSignatureValues verifies whether the signature values are valid with
 // the given chain rules. The v value is assumed to be either 0 or 1.
 func ValidateSignatureValues(v byte, r, s *big.Int) bool {
@@ -274,11 +303,111 @@ func ValidateSignatureValues(v byte, r, s *big.Int) bool {
 	return r.Cmp(secp256k1N) < 0 && s.Cmp(secp256k1N) < 0 && (v == 0 || v == 1)
 }
 
+// VerifySignatureBy reports whether the signature over the given hash was made
+// by the key of addr. A well formed signature made by another key returns false,
+// an error is only returned for a malformed hash or signature.
+func VerifySignatureBy(addr common.Address, hash []byte, sig []byte) (bool, error) {
+	signer, err := RecoverAddress(hash, sig)
+	if err != nil {
+		return false, err
+	}
+	return signer.Equal(addr), nil
+}
+
+// RecoverAddress returns the address of the key that created the given
+// signature over hash. The signature must be in the [R || S || V] format where
+// V is 0 or 1. A signature over another digest recovers another address, so
+// callers must compare the result with the expected signer.
+func RecoverAddress(hash, sig []byte) (common.Address, error) {
+	if len(hash) != DigestLength {
+		return common.Address{}, errInvalidHashLength
+	}
+	if len(sig) != SignatureLength {
+		return common.Address{}, errInvalidSigLength
+	}
+	pub, err := SigToPub(hash, sig)
+	if err != nil {
+		return common.Address{}, err
+	}
+	return PubkeyToAddress(*pub), nil
+}
+
+// batchVerifyChunk is the minimum number of signatures verified by each
+// goroutine of BatchVerify, smaller batches are verified on the calling one.
+const batchVerifyChunk = 16
+
+// BatchVerify reports for each index whether sigs[i] is a valid signature of
+// digests[i] by pubkeys[i], with the same rules as VerifySignature. An error
+// is only returned if the three slices do not have the same length. The
+// signatures are verified concurrently, on up to one goroutine per CPU.
+func BatchVerify(digests [][]byte, sigs [][]byte, pubkeys [][]byte) ([]bool, error) {
+	if len(digests) != len(sigs) || len(digests) != len(pubkeys) {
+		return nil, fmt.Errorf("batch length mismatch: %d digests, %d signatures, %d public keys", len(digests), len(sigs), len(pubkeys))
+	}
+	results := make([]bool, len(digests))
+	verify := func(from, to int) {
+		for i := from; i < to; i++ {
+			results[i] = VerifySignature(pubkeys[i], digests[i], sigs[i])
+		}
+	}
+	workers := runtime.NumCPU()
+	if max := (len(digests) + batchVerifyChunk - 1) / batchVerifyChunk; workers > max {
+		workers = max
+	}
+	if workers <= 1 {
+		verify(0, len(digests))
+		return results, nil
+	}
+	var (
+		wg    sync.WaitGroup
+		chunk = (len(digests) + workers - 1) / workers
+	)
+	for from := 0; from < len(digests); from += chunk {
+		to := from + chunk
+		if to > len(digests) {
+			to = len(digests)
+		}
+		wg.Add(1)
+		go func(from, to int) {
+			defer wg.Done()
+			verify(from, to)
+		}(from, to)
+	}
+	wg.Wait()
+	return results, nil
+}
+
 func PubkeyToAddress(p ecdsa.PublicKey) common.Address {
 	pubBytes := FromECDSAPub(&p)
 	return common.BytesToAddress(Keccak256(pubBytes[1:])[12:])
 }
 
+// PrivateKeysEqual reports whether the two private keys have the same scalar,
+// comparing them in constant time. Two nil keys are equal.
+func PrivateKeysEqual(a, b *ecdsa.PrivateKey) bool {
+	if a == nil || b == nil || a.D == nil || b.D == nil {
+		return a == b
+	}
+	aBytes := math.PaddedBigBytes(a.D, 32)
+	bBytes := math.PaddedBigBytes(b.D, 32)
+	defer zeroBytes(aBytes)
+	defer zeroBytes(bBytes)
+	return subtle.ConstantTimeCompare(aBytes, bBytes) == 1
+}
+
+// ZeroKey overwrites the scalar of the private key in memory, the key is
+// unusable afterwards. A nil key is ignored.
+func ZeroKey(k *ecdsa.PrivateKey) {
+	if k == nil || k.D == nil {
+		return
+	}
+	words := k.D.Bits()
+	for i := range words {
+		words[i] = 0
+	}
+	k.D.SetInt64(0)
+}
+
 func zeroBytes(bytes []byte) {
 	for i := range bytes {
 		bytes[i] = 0
@@ -131,6 +131,146 @@ func TestInvalidSign(t *testing.T) {
 	}
 }
 
+func TestRecoverAddress(t *testing.T) {
+	key, _ := HexToECDSA(testPrivHex)
+	addr := common.HexToAddress(testAddrHex)
+
+	msg := Keccak256([]byte("foo"))
+	sig, err := Sign(msg, key)
+	if err != nil {
+		t.Fatalf("Sign error: %s", err)
+	}
+	recoveredAddr, err := RecoverAddress(msg, sig)
+	if err != nil {
+		t.Fatalf("RecoverAddress error: %s", err)
+	}
+	if !addr.Equal(recoveredAddr) {
+		t.Errorf("Address mismatch: want: %x have: %x", addr, recoveredAddr)
+	}
+
+	if _, err := RecoverAddress(msg, nil); err == nil {
+		t.Errorf("no error for nil signature")
+	}
+	if _, err := RecoverAddress(msg, sig[:len(sig)-1]); err == nil {
+		t.Errorf("no error for signature without recovery id")
+	}
+	if _, err := RecoverAddress(msg, append(common.CopyBytes(sig), 1)); err == nil {
+		t.Errorf("no error for signature with extra bytes at the end")
+	}
+	if _, err := RecoverAddress(msg[:len(msg)-1], sig); err == nil {
+		t.Errorf("no error for short hash")
+	}
+	badRecoveryID := common.CopyBytes(sig)
+	badRecoveryID[RecoveryIDOffset] = 4
+	if _, err := RecoverAddress(msg, badRecoveryID); err == nil {
+		t.Errorf("no error for invalid recovery id")
+	}
+	zeroR := common.CopyBytes(sig)
+	copy(zeroR[:32], make([]byte, 32))
+	if _, err := RecoverAddress(msg, zeroR); err == nil {
+		t.Errorf("no error for zero R value")
+	}
+
+	// A signature over another digest recovers another key
+	otherMsg := Keccak256([]byte("bar"))
+	if otherAddr, err := RecoverAddress(otherMsg, sig); err == nil && addr.Equal(otherAddr) {
+		t.Errorf("signer recovered from signature over the wrong digest")
+	}
+}
+
+func TestGenerateKeyFromReader(t *testing.T) {
+	seed := Keccak256([]byte("seed"))
+	key1, err := GenerateKeyFromReader(bytes.NewReader(seed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key2, err := GenerateKeyFromReader(bytes.NewReader(seed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(FromECDSA(key1), FromECDSA(key2)) {
+		t.Errorf("keys from the same seed differ: %x and %x", FromECDSA(key1), FromECDSA(key2))
+	}
+	if !bytes.Equal(FromECDSA(key1), seed) {
+		t.Errorf("key is not the seed scalar: have %x want %x", FromECDSA(key1), seed)
+	}
+	if !PubkeyToAddress(key1.PublicKey).Equal(PubkeyToAddress(key2.PublicKey)) {
+		t.Errorf("addresses from the same seed differ")
+	}
+	other, err := GenerateKeyFromReader(bytes.NewReader(Keccak256([]byte("other seed"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if PrivateKeysEqual(key1, other) {
+		t.Errorf("keys from different seeds are equal")
+	}
+
+	// Zero and out of range scalars are skipped
+	invalid := append(make([]byte, 32), bytes.Repeat([]byte{0xff}, 32)...)
+	key3, err := GenerateKeyFromReader(bytes.NewReader(append(invalid, seed...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !PrivateKeysEqual(key1, key3) {
+		t.Errorf("invalid scalars not skipped: have %x want %x", FromECDSA(key3), FromECDSA(key1))
+	}
+	if _, err := GenerateKeyFromReader(bytes.NewReader(invalid)); err == nil {
+		t.Errorf("no error for reader without a valid scalar")
+	}
+	if _, err := GenerateKeyFromReader(bytes.NewReader(make([]byte, 1024))); err == nil {
+		t.Errorf("no error for reader of zeros")
+	}
+	if _, err := GenerateKeyFromReader(bytes.NewReader(seed[:31])); err == nil {
+		t.Errorf("no error for short reader")
+	}
+}
+
+func TestPrivateKeysEqual(t *testing.T) {
+	key, _ := HexToECDSA(testPrivHex)
+	same, _ := HexToECDSA(testPrivHex)
+	other, _ := GenerateKey()
+	if !PrivateKeysEqual(key, same) {
+		t.Errorf("equal keys reported different")
+	}
+	if !PrivateKeysEqual(key, key) {
+		t.Errorf("key reported different from itself")
+	}
+	if PrivateKeysEqual(key, other) {
+		t.Errorf("different keys reported equal")
+	}
+	if PrivateKeysEqual(key, nil) || PrivateKeysEqual(nil, key) {
+		t.Errorf("key reported equal to nil")
+	}
+	if !PrivateKeysEqual(nil, nil) {
+		t.Errorf("nil keys reported different")
+	}
+	// Scalars with leading zero bytes are compared on their padded encoding
+	small := ToECDSAUnsafe(common.LeftPadBytes([]byte{1}, 32))
+	if PrivateKeysEqual(small, key) || !PrivateKeysEqual(small, ToECDSAUnsafe(common.LeftPadBytes([]byte{1}, 32))) {
+		t.Errorf("wrong result for small scalar")
+	}
+}
+
+func TestZeroKey(t *testing.T) {
+	key, _ := HexToECDSA(testPrivHex)
+	words := key.D.Bits()
+	ZeroKey(key)
+	if key.D.Sign() != 0 {
+		t.Errorf("scalar not cleared: %x", key.D)
+	}
+	for i, word := range words {
+		if word != 0 {
+			t.Errorf("word %d of the scalar storage not cleared: %x", i, word)
+		}
+	}
+	if b := FromECDSA(key); !bytes.Equal(b, make([]byte, 32)) {
+		t.Errorf("key bytes not cleared: %x", b)
+	}
+	// Nil keys are ignored
+	ZeroKey(nil)
+	ZeroKey(&ecdsa.PrivateKey{})
+}
+
 func TestNewContractAddress(t *testing.T) {
 	key, _ := HexToECDSA(testPrivHex)
 	addr := common.HexToAddress(testAddrHex)
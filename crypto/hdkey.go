@@ -0,0 +1,179 @@
+// Copyright 2014 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package crypto
+
+import (
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/dominant-strategies/go-quai/common/math"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/text/unicode/norm"
+)
+
+// HardenedKeyStart is the index of the first hardened child key in a BIP32
+// derivation path.
+const HardenedKeyStart = 1 << 31
+
+const (
+	bip39SeedIterations = 2048
+	bip39SeedLength     = 64
+	bip39WordBits       = 11
+)
+
+var (
+	errInvalidMnemonic = errors.New("invalid mnemonic")
+	errInvalidSeed     = errors.New("invalid seed length")
+	errInvalidChildKey = errors.New("invalid child key")
+
+	bip39Words     = strings.Fields(bip39English)
+	bip39WordIndex = func() map[string]int {
+		index := make(map[string]int, len(bip39Words))
+		for i, word := range bip39Words {
+			index[word] = i
+		}
+		return index
+	}()
+)
+
+// MnemonicToSeed validates a BIP39 English mnemonic and returns the 64 byte
+// seed derived from it and the passphrase. The mnemonic must have 12, 15, 18,
+// 21 or 24 words and a valid checksum.
+func MnemonicToSeed(mnemonic, passphrase string) ([]byte, error) {
+	words := strings.Fields(norm.NFKD.String(mnemonic))
+	if err := validateMnemonic(words); err != nil {
+		return nil, err
+	}
+	password := []byte(strings.Join(words, " "))
+	salt := []byte("mnemonic" + norm.NFKD.String(passphrase))
+	return pbkdf2.Key(password, salt, bip39SeedIterations, bip39SeedLength, sha512.New), nil
+}
+
+// validateMnemonic checks the word count, the words and the checksum of a
+// mnemonic.
+func validateMnemonic(words []string) error {
+	if len(words) < 12 || len(words) > 24 || len(words)%3 != 0 {
+		return fmt.Errorf("%w: %d words", errInvalidMnemonic, len(words))
+	}
+	// Each word carries 11 bits, for every 32 bits of entropy there is one
+	// bit of checksum
+	bits := new(big.Int)
+	for _, word := range words {
+		index, ok := bip39WordIndex[word]
+		if !ok {
+			return fmt.Errorf("%w: unknown word %q", errInvalidMnemonic, word)
+		}
+		bits.Lsh(bits, bip39WordBits)
+		bits.Or(bits, big.NewInt(int64(index)))
+	}
+	checksumBits := uint(len(words) * bip39WordBits / 33)
+	entropyLen := int(checksumBits) * 4
+
+	checksum := new(big.Int).And(bits, big.NewInt(1<<checksumBits-1))
+	entropy := math.PaddedBigBytes(new(big.Int).Rsh(bits, checksumBits), entropyLen)
+	hash := sha256.Sum256(entropy)
+	if uint64(hash[0]>>(8-checksumBits)) != checksum.Uint64() {
+		return fmt.Errorf("%w: checksum mismatch", errInvalidMnemonic)
+	}
+	return nil
+}
+
+// DeriveKey derives the private key at the BIP32 path from the seed. The path
+// starts with "m" followed by the child indexes separated by "/", a hardened
+// index is marked with a trailing "'", "h" or "H", e.g. "m/44'/994'/0'/0/0".
+func DeriveKey(seed []byte, path string) (*ecdsa.PrivateKey, error) {
+	if len(seed) < 16 || len(seed) > 64 {
+		return nil, errInvalidSeed
+	}
+	indexes, err := ParseDerivationPath(path)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha512.New, []byte("Bitcoin seed"))
+	mac.Write(seed)
+	I := mac.Sum(nil)
+	key, chainCode := new(big.Int).SetBytes(I[:32]), I[32:]
+	if key.Sign() == 0 || key.Cmp(secp256k1N) >= 0 {
+		return nil, errInvalidChildKey
+	}
+	for _, index := range indexes {
+		if key, chainCode, err = deriveChild(key, chainCode, index); err != nil {
+			return nil, err
+		}
+	}
+	return ToECDSA(math.PaddedBigBytes(key, 32))
+}
+
+// deriveChild returns the private key and chain code of the child at the
+// index of the given private key.
+func deriveChild(key *big.Int, chainCode []byte, index uint32) (*big.Int, []byte, error) {
+	data := make([]byte, 0, 37)
+	if index >= HardenedKeyStart {
+		data = append(data, 0)
+		data = append(data, math.PaddedBigBytes(key, 32)...)
+	} else {
+		x, y := S256().ScalarBaseMult(math.PaddedBigBytes(key, 32))
+		data = append(data, CompressPubkey(&ecdsa.PublicKey{Curve: S256(), X: x, Y: y})...)
+	}
+	data = binary.BigEndian.AppendUint32(data, index)
+
+	mac := hmac.New(sha512.New, chainCode)
+	mac.Write(data)
+	I := mac.Sum(nil)
+	tweak := new(big.Int).SetBytes(I[:32])
+	if tweak.Cmp(secp256k1N) >= 0 {
+		return nil, nil, errInvalidChildKey
+	}
+	child := tweak.Add(tweak, key)
+	child.Mod(child, secp256k1N)
+	if child.Sign() == 0 {
+		return nil, nil, errInvalidChildKey
+	}
+	return child, I[32:], nil
+}
+
+// ParseDerivationPath converts a BIP32 path such as "m/44'/994'/0'/0/0" into
+// its child indexes, hardened indexes are offset by HardenedKeyStart.
+func ParseDerivationPath(path string) ([]uint32, error) {
+	components := strings.Split(strings.TrimSpace(path), "/")
+	if components[0] != "m" {
+		return nil, fmt.Errorf("invalid derivation path %q: must start with m", path)
+	}
+	indexes := make([]uint32, 0, len(components)-1)
+	for _, component := range components[1:] {
+		offset := uint32(0)
+		if trimmed := strings.TrimRight(component, "'hH"); len(component)-len(trimmed) == 1 {
+			component, offset = trimmed, HardenedKeyStart
+		}
+		// Only plain decimal numbers are accepted, ParseUint rejects signs
+		// and empty components
+		value, err := strconv.ParseUint(component, 10, 31)
+		if err != nil {
+			return nil, fmt.Errorf("invalid derivation path %q: invalid component %q", path, component)
+		}
+		indexes = append(indexes, uint32(value)+offset)
+	}
+	return indexes, nil
+}
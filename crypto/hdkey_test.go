@@ -0,0 +1,218 @@
+// Copyright 2014 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package crypto
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/dominant-strategies/go-quai/common"
+	"github.com/dominant-strategies/go-quai/common/hexutil"
+)
+
+func TestBIP39WordList(t *testing.T) {
+	if len(bip39Words) != 2048 {
+		t.Fatalf("wrong word count: have %d want 2048", len(bip39Words))
+	}
+	prefixes := make(map[string]bool)
+	for i, word := range bip39Words {
+		if i > 0 && bip39Words[i-1] >= word {
+			t.Errorf("word %d %q not sorted after %q", i, word, bip39Words[i-1])
+		}
+		// The first four letters identify a word of the English list
+		prefix := word
+		if len(prefix) > 4 {
+			prefix = prefix[:4]
+		}
+		if prefixes[prefix] {
+			t.Errorf("word %d %q has a duplicate prefix", i, word)
+		}
+		prefixes[prefix] = true
+	}
+}
+
+// Test vectors from the BIP39 specification, with the passphrase "TREZOR"
+func TestMnemonicToSeed(t *testing.T) {
+	tests := []struct {
+		mnemonic string
+		seed     string
+	}{
+		{
+			"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about",
+			"0xc55257c360c07c72029aebc1b53c05ed0362ada38ead3e3e9efa3708e53495531f09a6987599d18264c1e1c92f2cf141630c7a3c4ab7c81b2f001698e7463b04",
+		},
+		{
+			"legal winner thank year wave sausage worth useful legal winner thank yellow",
+			"0x2e8905819b8723fe2c1d161860e5ee1830318dbf49a83bd451cfb8440c28bd6fa457fe1296106559a3c80937a1c1069be3a3a5bd381ee6260e8d9739fce1f607",
+		},
+		{
+			"letter advice cage absurd amount doctor acoustic avoid letter advice cage above",
+			"0xd71de856f81a8acc65e6fc851a38d4d7ec216fd0796d0a6827a3ad6ed5511a30fa280f12eb2e47ed2ac03b5c462a0358d18d69fe4f985ec81778c1b370b652a8",
+		},
+		{
+			"zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo wrong",
+			"0xac27495480225222079d7be181583751e86f571027b0497b5b5d11218e0a8a13332572917f0f8e5a589620c6f15b11c61dee327651a14c34e18231052e48c069",
+		},
+		{
+			"ozone drill grab fiber curtain grace pudding thank cruise elder eight picnic",
+			"0x274ddc525802f7c828d8ef7ddbcdc5304e87ac3535913611fbbfa986d0c9e5476c91689f9c8a54fd55bd38606aa6a8595ad213d4c9c9f9aca3fb217069a41028",
+		},
+	}
+	for _, test := range tests {
+		seed, err := MnemonicToSeed(test.mnemonic, "TREZOR")
+		if err != nil {
+			t.Errorf("%q: %v", test.mnemonic, err)
+			continue
+		}
+		if want := hexutil.MustDecode(test.seed); !bytes.Equal(seed, want) {
+			t.Errorf("%q: seed mismatch: have %x want %x", test.mnemonic, seed, want)
+		}
+	}
+	// Extra whitespace is not part of the mnemonic
+	seed, err := MnemonicToSeed("  abandon abandon abandon abandon abandon abandon\tabandon abandon abandon abandon abandon  about ", "TREZOR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := hexutil.MustDecode(tests[0].seed); !bytes.Equal(seed, want) {
+		t.Errorf("seed mismatch with extra whitespace: have %x want %x", seed, want)
+	}
+}
+
+func TestMnemonicToSeedInvalid(t *testing.T) {
+	tests := []string{
+		"",
+		"abandon",
+		// 11 words
+		"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about",
+		// 13 words
+		"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about",
+		// bad checksum
+		"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon",
+		"zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo",
+		"legal winner thank year wave sausage worth useful legal winner thank year",
+		// unknown word
+		"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon quai",
+		// wordlist words are lower case
+		"Abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about",
+	}
+	for _, mnemonic := range tests {
+		if _, err := MnemonicToSeed(mnemonic, ""); err == nil {
+			t.Errorf("%q: no error for invalid mnemonic", mnemonic)
+		}
+	}
+}
+
+// Test vectors 1 and 2 from the BIP32 specification
+func TestDeriveKey(t *testing.T) {
+	var (
+		seed1 = hexutil.MustDecode("0x000102030405060708090a0b0c0d0e0f")
+		seed2 = hexutil.MustDecode("0xfffcf9f6f3f0edeae7e4e1dedbd8d5d2cfccc9c6c3c0bdbab7b4b1aeaba8a5a29f9c999693908d8a8784817e7b7875726f6c696663605d5a5754514e4b484542")
+	)
+	tests := []struct {
+		seed []byte
+		path string
+		key  string
+	}{
+		{seed1, "m", "0xe8f32e723decf4051aefac8e2c93c9c5b214313817cdb01a1494b917c8436b35"},
+		{seed1, "m/0'", "0xedb2e14f9ee77d26dd93b4ecede8d16ed408ce149b6cd80b0715a2d911a0afea"},
+		{seed1, "m/0'/1", "0x3c6cb8d0f6a264c91ea8b5030fadaa8e538b020f0a387421a12de9319dc93368"},
+		{seed1, "m/0h/1/2H", "0xcbce0d719ecf7431d88e6a89fa1483e02e35092af60c042b1df2ff59fa424dca"},
+		{seed1, "m/0'/1/2'/2", "0x0f479245fb19a38a1954c5c7c0ebab2f9bdfd96a17563ef28a6a4b1a2a764ef4"},
+		{seed1, "m/0'/1/2'/2/1000000000", "0x471b76e389e528d6de6d816857e012c5455051cad6660850e58372a6c3e6e7c8"},
+		{seed2, "m", "0x4b03d6fc340455b363f51020ad3ecca4f0850280cf436c70c727923f6db46c3e"},
+		{seed2, "m/0", "0xabe74a98f6c7eabee0428f53798f0ab8aa1bd37873999041703c742f15ac7e1e"},
+		{seed2, "m/0/2147483647'", "0x877c779ad9687164e9c2f4f0f4ff0340814392330693ce95a58fe18fd52e6e93"},
+	}
+	for _, test := range tests {
+		key, err := DeriveKey(test.seed, test.path)
+		if err != nil {
+			t.Errorf("%s: %v", test.path, err)
+			continue
+		}
+		if want := hexutil.MustDecode(test.key); !bytes.Equal(FromECDSA(key), want) {
+			t.Errorf("%s: key mismatch: have %x want %x", test.path, FromECDSA(key), want)
+		}
+	}
+}
+
+func TestDeriveKeyFromMnemonic(t *testing.T) {
+	seed, err := MnemonicToSeed("abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := DeriveKey(seed, "m/44'/60'/0'/0/0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := hexutil.MustDecode("0x1ab42cc412b618bdea3a599e3c9bae199ebf030895b039e9db1e30dafb12b727"); !bytes.Equal(FromECDSA(key), want) {
+		t.Errorf("key mismatch: have %x want %x", FromECDSA(key), want)
+	}
+	if addr := PubkeyToAddress(key.PublicKey); !addr.Equal(common.HexToAddress("0x9858EfFD232B4033E47d90003D41EC34EcaEda94")) {
+		t.Errorf("address mismatch: have %x", addr)
+	}
+}
+
+func TestParseDerivationPath(t *testing.T) {
+	tests := []struct {
+		path    string
+		indexes []uint32
+	}{
+		{"m", []uint32{}},
+		{"m/0", []uint32{0}},
+		{"m/44'/994'/0'/0/0", []uint32{HardenedKeyStart + 44, HardenedKeyStart + 994, HardenedKeyStart, 0, 0}},
+		{"m/1h/2H", []uint32{HardenedKeyStart + 1, HardenedKeyStart + 2}},
+		{"m/2147483647'", []uint32{HardenedKeyStart + 2147483647}},
+	}
+	for _, test := range tests {
+		indexes, err := ParseDerivationPath(test.path)
+		if err != nil {
+			t.Errorf("%q: %v", test.path, err)
+			continue
+		}
+		if !reflect.DeepEqual(indexes, test.indexes) {
+			t.Errorf("%q: have %v want %v", test.path, indexes, test.indexes)
+		}
+	}
+	invalid := []string{
+		"",
+		"/0",
+		"0/1",
+		"M/0",
+		"m/",
+		"m//0",
+		"m/0/",
+		"m/-1",
+		"m/+1",
+		"m/a",
+		"m/0''",
+		"m/2147483648",
+		"m/2147483648'",
+		"m/0x10",
+	}
+	for _, path := range invalid {
+		if _, err := ParseDerivationPath(path); err == nil {
+			t.Errorf("%q: no error for invalid path", path)
+		}
+	}
+	if _, err := DeriveKey(hexutil.MustDecode("0x000102030405060708090a0b0c0d0e0f"), "m/x"); err == nil {
+		t.Errorf("no error for invalid path")
+	}
+	if _, err := DeriveKey(make([]byte, 15), "m"); err == nil {
+		t.Errorf("no error for short seed")
+	}
+}
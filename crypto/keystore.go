@@ -0,0 +1,259 @@
+// Copyright 2014 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package crypto
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/dominant-strategies/go-quai/common"
+	"github.com/dominant-strategies/go-quai/common/math"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	keyVersion = 3
+
+	// StandardScryptN is the N parameter of Scrypt encryption algorithm, using 256MB
+	// memory and taking approximately 1s CPU time on a modern processor.
+	StandardScryptN = 1 << 18
+
+	// StandardScryptP is the P parameter of Scrypt encryption algorithm, using 256MB
+	// memory and taking approximately 1s CPU time on a modern processor.
+	StandardScryptP = 1
+
+	// LightScryptN is the N parameter of Scrypt encryption algorithm, using 4MB
+	// memory and taking approximately 100ms CPU time on a modern processor.
+	LightScryptN = 1 << 12
+
+	// LightScryptP is the P parameter of Scrypt encryption algorithm, using 4MB
+	// memory and taking approximately 100ms CPU time on a modern processor.
+	LightScryptP = 6
+
+	scryptR     = 8
+	scryptDKLen = 32
+
+	// The scrypt parameters of a key file are bounded before the key is derived,
+	// so that a crafted key file cannot exhaust the memory or the CPU of the node
+	// decrypting it. The memory used by scrypt is 128 * n * r bytes.
+	maxScryptN      = 1 << 20
+	maxScryptR      = 32
+	maxScryptP      = 16
+	maxScryptDKLen  = 64
+	maxScryptMemory = 1 << 30
+)
+
+var (
+	// ErrDecrypt is returned by DecryptKey when the passphrase does not match
+	// the one the key was encrypted with.
+	ErrDecrypt = errors.New("could not decrypt key with given passphrase")
+
+	errKeyVersion   = errors.New("unsupported key version")
+	errScryptParams = errors.New("scrypt parameters out of range")
+)
+
+// encryptedKeyJSON is the Web3 Secret Storage (version 3) encoding of a key.
+type encryptedKeyJSON struct {
+	Address string     `json:"address"`
+	Crypto  cryptoJSON `json:"crypto"`
+	Id      string     `json:"id"`
+	Version int        `json:"version"`
+}
+
+type cryptoJSON struct {
+	Cipher       string                 `json:"cipher"`
+	CipherText   string                 `json:"ciphertext"`
+	CipherParams cipherparamsJSON       `json:"cipherparams"`
+	KDF          string                 `json:"kdf"`
+	KDFParams    map[string]interface{} `json:"kdfparams"`
+	MAC          string                 `json:"mac"`
+}
+
+type cipherparamsJSON struct {
+	IV string `json:"iv"`
+}
+
+// EncryptKey encrypts the private key with the passphrase into the Web3 Secret
+// Storage JSON format, deriving the encryption key with the standard scrypt
+// parameters.
+func EncryptKey(key *ecdsa.PrivateKey, passphrase string) ([]byte, error) {
+	return EncryptKeyWithParams(key, passphrase, StandardScryptN, StandardScryptP)
+}
+
+// EncryptKeyWithParams encrypts the private key like EncryptKey, using the
+// given scrypt N and P parameters.
+func EncryptKeyWithParams(key *ecdsa.PrivateKey, passphrase string, scryptN, scryptP int) ([]byte, error) {
+	salt := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	derivedKey, err := scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, scryptDKLen)
+	if err != nil {
+		return nil, err
+	}
+	encryptKey := derivedKey[:16]
+	keyBytes := math.PaddedBigBytes(key.D, 32)
+	defer zeroBytes(keyBytes)
+
+	iv := make([]byte, aes.BlockSize)
+	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		return nil, err
+	}
+	cipherText, err := aesCTRXOR(encryptKey, keyBytes, iv)
+	if err != nil {
+		return nil, err
+	}
+	mac := Keccak256(derivedKey[16:32], cipherText)
+
+	id := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, id); err != nil {
+		return nil, err
+	}
+	// Random (version 4) UUID
+	id[6] = (id[6] & 0x0f) | 0x40
+	id[8] = (id[8] & 0x3f) | 0x80
+
+	return json.Marshal(encryptedKeyJSON{
+		Address: hex.EncodeToString(PubkeyToAddress(key.PublicKey).Bytes()),
+		Crypto: cryptoJSON{
+			Cipher:       "aes-128-ctr",
+			CipherText:   hex.EncodeToString(cipherText),
+			CipherParams: cipherparamsJSON{IV: hex.EncodeToString(iv)},
+			KDF:          "scrypt",
+			KDFParams: map[string]interface{}{
+				"n":     scryptN,
+				"r":     scryptR,
+				"p":     scryptP,
+				"dklen": scryptDKLen,
+				"salt":  hex.EncodeToString(salt),
+			},
+			MAC: hex.EncodeToString(mac),
+		},
+		Id:      fmt.Sprintf("%x-%x-%x-%x-%x", id[0:4], id[4:6], id[6:8], id[8:10], id[10:]),
+		Version: keyVersion,
+	})
+}
+
+// DecryptKey decrypts a key in the Web3 Secret Storage JSON format with the
+// passphrase. ErrDecrypt is returned if the passphrase is wrong.
+func DecryptKey(keyjson []byte, passphrase string) (*ecdsa.PrivateKey, error) {
+	var k encryptedKeyJSON
+	if err := json.Unmarshal(keyjson, &k); err != nil {
+		return nil, err
+	}
+	if k.Version != keyVersion {
+		return nil, errKeyVersion
+	}
+	if k.Crypto.Cipher != "aes-128-ctr" {
+		return nil, fmt.Errorf("cipher not supported: %v", k.Crypto.Cipher)
+	}
+	mac, err := hex.DecodeString(k.Crypto.MAC)
+	if err != nil {
+		return nil, err
+	}
+	iv, err := hex.DecodeString(k.Crypto.CipherParams.IV)
+	if err != nil {
+		return nil, err
+	}
+	cipherText, err := hex.DecodeString(k.Crypto.CipherText)
+	if err != nil {
+		return nil, err
+	}
+	derivedKey, err := scryptKey(k.Crypto, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(Keccak256(derivedKey[16:32], cipherText), mac) {
+		return nil, ErrDecrypt
+	}
+	plainText, err := aesCTRXOR(derivedKey[:16], cipherText, iv)
+	if err != nil {
+		return nil, err
+	}
+	defer zeroBytes(plainText)
+	key, err := ToECDSA(plainText)
+	if err != nil {
+		return nil, err
+	}
+	// The address is not covered by the mac, but a mismatch reveals a corrupted
+	// or tampered key file
+	if k.Address != "" && !PubkeyToAddress(key.PublicKey).Equal(common.HexToAddress(k.Address)) {
+		return nil, errors.New("decrypted key does not match the key address")
+	}
+	return key, nil
+}
+
+// scryptKey derives the decryption key from the passphrase and the scrypt
+// parameters of the key file.
+func scryptKey(c cryptoJSON, passphrase string) ([]byte, error) {
+	if c.KDF != "scrypt" {
+		return nil, fmt.Errorf("unsupported KDF: %s", c.KDF)
+	}
+	saltHex, ok := c.KDFParams["salt"].(string)
+	if !ok {
+		return nil, errors.New("missing scrypt salt")
+	}
+	salt, err := hex.DecodeString(saltHex)
+	if err != nil {
+		return nil, err
+	}
+	params := make(map[string]int, 4)
+	limits := []struct {
+		name string
+		max  int
+	}{{"n", maxScryptN}, {"r", maxScryptR}, {"p", maxScryptP}, {"dklen", maxScryptDKLen}}
+	for _, limit := range limits {
+		// JSON numbers are decoded as float64
+		value, ok := c.KDFParams[limit.name].(float64)
+		if !ok {
+			return nil, fmt.Errorf("missing scrypt parameter %q", limit.name)
+		}
+		if value < 1 || value > float64(limit.max) {
+			return nil, fmt.Errorf("%w: %s is %v, want 1 to %d", errScryptParams, limit.name, value, limit.max)
+		}
+		params[limit.name] = int(value)
+	}
+	if params["dklen"] < scryptDKLen {
+		return nil, fmt.Errorf("scrypt dklen too short: %d", params["dklen"])
+	}
+	if memory := 128 * params["n"] * params["r"]; memory > maxScryptMemory {
+		return nil, fmt.Errorf("%w: n %d and r %d need %d bytes, max %d", errScryptParams, params["n"], params["r"], memory, maxScryptMemory)
+	}
+	return scrypt.Key([]byte(passphrase), salt, params["n"], params["r"], params["p"], params["dklen"])
+}
+
+func aesCTRXOR(key, inText, iv []byte) ([]byte, error) {
+	aesBlock, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(iv) != aes.BlockSize {
+		return nil, errors.New("invalid cipher iv length")
+	}
+	stream := cipher.NewCTR(aesBlock, iv)
+	outText := make([]byte, len(inText))
+	stream.XORKeyStream(outText, inText)
+	return outText, nil
+}
@@ -0,0 +1,150 @@
+// Copyright 2014 The go-ethereum Authors
+// This file is part of the go-ethereum library.
+//
+// The go-ethereum library is free software: you can redistribute it and/or modify
+// it under the terms of the GNU Lesser General Public License as published by
+// the Free Software Foundation, either version 3 of the License, or
+// (at your option) any later version.
+//
+// The go-ethereum library is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+// GNU Lesser General Public License for more details.
+//
+// You should have received a copy of the GNU Lesser General Public License
+// along with the go-ethereum library. If not, see <http://www.gnu.org/licenses/>.
+
+package crypto
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/dominant-strategies/go-quai/common"
+)
+
+func TestKeyEncryptDecrypt(t *testing.T) {
+	key, _ := HexToECDSA(testPrivHex)
+	keyjson, err := EncryptKeyWithParams(key, "foo", LightScryptN, LightScryptP)
+	if err != nil {
+		t.Fatalf("encrypt error: %v", err)
+	}
+	decrypted, err := DecryptKey(keyjson, "foo")
+	if err != nil {
+		t.Fatalf("decrypt error: %v", err)
+	}
+	if !bytes.Equal(FromECDSA(decrypted), FromECDSA(key)) {
+		t.Errorf("key mismatch: want %x have %x", FromECDSA(key), FromECDSA(decrypted))
+	}
+	if addr := PubkeyToAddress(decrypted.PublicKey); !addr.Equal(common.HexToAddress(testAddrHex)) {
+		t.Errorf("address mismatch: want %s have %x", testAddrHex, addr)
+	}
+	if _, err := DecryptKey(keyjson, "bar"); err != ErrDecrypt {
+		t.Errorf("wrong passphrase: want %v have %v", ErrDecrypt, err)
+	}
+	if _, err := DecryptKey(keyjson, ""); err != ErrDecrypt {
+		t.Errorf("empty passphrase: want %v have %v", ErrDecrypt, err)
+	}
+}
+
+func TestKeyEncryptStandardParams(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping standard scrypt parameters in short mode")
+	}
+	key, _ := GenerateKey()
+	keyjson, err := EncryptKey(key, "foo")
+	if err != nil {
+		t.Fatalf("encrypt error: %v", err)
+	}
+	decrypted, err := DecryptKey(keyjson, "foo")
+	if err != nil {
+		t.Fatalf("decrypt error: %v", err)
+	}
+	if !bytes.Equal(FromECDSA(decrypted), FromECDSA(key)) {
+		t.Errorf("key mismatch: want %x have %x", FromECDSA(key), FromECDSA(decrypted))
+	}
+}
+
+func TestDecryptKeyCorrupted(t *testing.T) {
+	key, _ := HexToECDSA(testPrivHex)
+	keyjson, err := EncryptKeyWithParams(key, "foo", LightScryptN, LightScryptP)
+	if err != nil {
+		t.Fatalf("encrypt error: %v", err)
+	}
+	// corrupt applies the given change to the decoded key file and encodes it again
+	corrupt := func(change func(k *encryptedKeyJSON)) []byte {
+		var k encryptedKeyJSON
+		if err := json.Unmarshal(keyjson, &k); err != nil {
+			t.Fatal(err)
+		}
+		change(&k)
+		out, err := json.Marshal(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+	tests := []struct {
+		name    string
+		keyjson []byte
+	}{
+		{"truncated json", keyjson[:len(keyjson)/2]},
+		{"not json", []byte("foo")},
+		{"wrong version", corrupt(func(k *encryptedKeyJSON) { k.Version = 1 })},
+		{"unknown cipher", corrupt(func(k *encryptedKeyJSON) { k.Crypto.Cipher = "aes-256-gcm" })},
+		{"unknown kdf", corrupt(func(k *encryptedKeyJSON) { k.Crypto.KDF = "pbkdf3" })},
+		{"missing salt", corrupt(func(k *encryptedKeyJSON) { delete(k.Crypto.KDFParams, "salt") })},
+		{"missing n", corrupt(func(k *encryptedKeyJSON) { delete(k.Crypto.KDFParams, "n") })},
+		{"invalid mac", corrupt(func(k *encryptedKeyJSON) { k.Crypto.MAC = "zz" })},
+		{"invalid iv", corrupt(func(k *encryptedKeyJSON) { k.Crypto.CipherParams.IV = "00" })},
+		{"modified ciphertext", corrupt(func(k *encryptedKeyJSON) {
+			cipherText := common.FromHex(k.Crypto.CipherText)
+			cipherText[0] ^= 0xff
+			k.Crypto.CipherText = common.Bytes2Hex(cipherText)
+		})},
+		{"wrong address", corrupt(func(k *encryptedKeyJSON) { k.Address = "0000000000000000000000000000000000000001" })},
+	}
+	for _, test := range tests {
+		if key, err := DecryptKey(test.keyjson, "foo"); err == nil {
+			t.Errorf("%s: no error, decrypted key %x", test.name, FromECDSA(key))
+		}
+	}
+}
+
+func TestDecryptKeyScryptLimits(t *testing.T) {
+	key, _ := HexToECDSA(testPrivHex)
+	keyjson, err := EncryptKeyWithParams(key, "foo", LightScryptN, LightScryptP)
+	if err != nil {
+		t.Fatalf("encrypt error: %v", err)
+	}
+	tests := []struct {
+		name   string
+		params map[string]float64
+	}{
+		{"huge n", map[string]float64{"n": 1 << 40}},
+		{"n above the limit", map[string]float64{"n": maxScryptN * 2}},
+		{"zero r", map[string]float64{"r": 0}},
+		{"huge p", map[string]float64{"p": 1 << 30}},
+		{"negative p", map[string]float64{"p": -1}},
+		{"huge dklen", map[string]float64{"dklen": 1 << 32}},
+		{"memory above the limit", map[string]float64{"n": maxScryptN, "r": maxScryptR}},
+	}
+	for _, test := range tests {
+		var k encryptedKeyJSON
+		if err := json.Unmarshal(keyjson, &k); err != nil {
+			t.Fatal(err)
+		}
+		for name, value := range test.params {
+			k.Crypto.KDFParams[name] = value
+		}
+		out, err := json.Marshal(k)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := DecryptKey(out, "foo"); !errors.Is(err, errScryptParams) {
+			t.Errorf("%s: error mismatch: have %v, want %v", test.name, err, errScryptParams)
+		}
+	}
+}
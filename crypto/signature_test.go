@@ -19,6 +19,7 @@ package crypto
 import (
 	"bytes"
 	"crypto/ecdsa"
+	"math/big"
 	"reflect"
 	"testing"
 
@@ -85,6 +86,129 @@ func TestVerifySignatureMalleable(t *testing.T) {
 	}
 }
 
+// TestVerifySignatureHighS checks that the high S twin of a valid signature,
+// which is also a valid ECDSA signature, is rejected.
+func TestVerifySignatureHighS(t *testing.T) {
+	sig := common.CopyBytes(testsig[:len(testsig)-1])
+	r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+	if !ValidateSignatureValues(0, r, s) {
+		t.Fatal("low S signature values rejected")
+	}
+	if !VerifySignature(testpubkey, testmsg, sig) {
+		t.Fatal("low S signature rejected")
+	}
+	highS := new(big.Int).Sub(secp256k1N, s)
+	copy(sig[32:], math.PaddedBigBytes(highS, 32))
+	if ValidateSignatureValues(0, r, highS) {
+		t.Error("high S signature values accepted")
+	}
+	if VerifySignature(testpubkey, testmsg, sig) {
+		t.Error("high S signature accepted")
+	}
+	if VerifySignature(testpubkeyc, testmsg, sig) {
+		t.Error("high S signature accepted with compressed key")
+	}
+}
+
+func TestVerifySignatureBy(t *testing.T) {
+	key, _ := GenerateKey()
+	other, _ := GenerateKey()
+	addr := PubkeyToAddress(key.PublicKey)
+	msg := Keccak256([]byte("foo"))
+	sig, err := Sign(msg, key)
+	if err != nil {
+		t.Fatalf("sign error: %s", err)
+	}
+
+	if ok, err := VerifySignatureBy(addr, msg, sig); err != nil || !ok {
+		t.Errorf("signature not verified for its signer: ok %v, err %v", ok, err)
+	}
+	if ok, err := VerifySignatureBy(PubkeyToAddress(other.PublicKey), msg, sig); err != nil || ok {
+		t.Errorf("signature verified for the wrong signer: ok %v, err %v", ok, err)
+	}
+	if _, err := VerifySignatureBy(addr, msg, sig[:len(sig)-1]); err == nil {
+		t.Errorf("no error for signature without recovery id")
+	}
+	if _, err := VerifySignatureBy(addr, msg[:len(msg)-1], sig); err == nil {
+		t.Errorf("no error for short hash")
+	}
+	badRecoveryID := common.CopyBytes(sig)
+	badRecoveryID[RecoveryIDOffset] = 4
+	if _, err := VerifySignatureBy(addr, msg, badRecoveryID); err == nil {
+		t.Errorf("no error for invalid recovery id")
+	}
+}
+
+// makeBatch returns n signatures over distinct digests, made by distinct keys
+func makeBatch(tb testing.TB, n int) (digests, sigs, pubkeys [][]byte) {
+	for i := 0; i < n; i++ {
+		key, err := GenerateKey()
+		if err != nil {
+			tb.Fatal(err)
+		}
+		digest := Keccak256(big.NewInt(int64(i)).Bytes())
+		sig, err := Sign(digest, key)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		digests = append(digests, digest)
+		sigs = append(sigs, sig[:RecoveryIDOffset])
+		pubkeys = append(pubkeys, FromECDSAPub(&key.PublicKey))
+	}
+	return digests, sigs, pubkeys
+}
+
+func TestBatchVerify(t *testing.T) {
+	for _, n := range []int{0, 1, batchVerifyChunk - 1, 5*batchVerifyChunk + 3} {
+		digests, sigs, pubkeys := makeBatch(t, n)
+		// Invalidate every third signature, each in a different way
+		want := make([]bool, n)
+		for i := range want {
+			want[i] = true
+			if i%3 != 1 {
+				continue
+			}
+			want[i] = false
+			switch i % 4 {
+			case 0:
+				digests[i] = Keccak256(digests[i])
+			case 1:
+				sigs[i] = common.CopyBytes(sigs[i])
+				sigs[i][10]++
+			case 2:
+				pubkeys[i] = pubkeys[(i+1)%n]
+			case 3:
+				sigs[i] = sigs[i][:len(sigs[i])-1]
+			}
+		}
+		results, err := BatchVerify(digests, sigs, pubkeys)
+		if err != nil {
+			t.Fatalf("batch of %d: %v", n, err)
+		}
+		if !reflect.DeepEqual(results, want) {
+			t.Errorf("batch of %d: have %v want %v", n, results, want)
+		}
+		for i := range results {
+			if single := VerifySignature(pubkeys[i], digests[i], sigs[i]); single != results[i] {
+				t.Errorf("batch of %d: signature %d: batch result %v, single result %v", n, i, results[i], single)
+			}
+		}
+	}
+}
+
+func TestBatchVerifyLengthMismatch(t *testing.T) {
+	digests, sigs, pubkeys := makeBatch(t, 2)
+	if _, err := BatchVerify(digests[:1], sigs, pubkeys); err == nil {
+		t.Errorf("no error for missing digest")
+	}
+	if _, err := BatchVerify(digests, sigs[:1], pubkeys); err == nil {
+		t.Errorf("no error for missing signature")
+	}
+	if _, err := BatchVerify(digests, sigs, pubkeys[:1]); err == nil {
+		t.Errorf("no error for missing public key")
+	}
+}
+
 func TestDecompressPubkey(t *testing.T) {
 	key, err := DecompressPubkey(testpubkeyc)
 	if err != nil {
@@ -104,6 +228,53 @@ func TestDecompressPubkey(t *testing.T) {
 	}
 }
 
+func TestDecompressPubkeyInvalid(t *testing.T) {
+	// x = 5 is not the x coordinate of a point on the curve
+	offCurve := make([]byte, 33)
+	offCurve[0], offCurve[32] = 0x02, 5
+	tests := []struct {
+		name   string
+		pubkey []byte
+	}{
+		{"off curve", offCurve},
+		{"off curve odd", append([]byte{0x03}, offCurve[1:]...)},
+		{"x above field size", append([]byte{0x02}, bytes.Repeat([]byte{0xff}, 32)...)},
+		{"uncompressed prefix", append([]byte{0x04}, testpubkeyc[1:]...)},
+		{"zero prefix", append([]byte{0x00}, testpubkeyc[1:]...)},
+		{"invalid prefix", append([]byte{0x05}, testpubkeyc[1:]...)},
+		{"uncompressed key", testpubkey},
+		{"empty", []byte{}},
+	}
+	for _, test := range tests {
+		if _, err := DecompressPubkey(test.pubkey); err == nil {
+			t.Errorf("%s: no error for invalid compressed pubkey", test.name)
+		}
+	}
+}
+
+func TestCompressPubkeyAddress(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		key, err := GenerateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		compressed := CompressPubkey(&key.PublicKey)
+		if len(compressed) != 33 {
+			t.Fatalf("wrong compressed length %d", len(compressed))
+		}
+		pub, err := DecompressPubkey(compressed)
+		if err != nil {
+			t.Fatalf("iteration %d: %v", i, err)
+		}
+		if !bytes.Equal(FromECDSAPub(pub), FromECDSAPub(&key.PublicKey)) {
+			t.Fatalf("iteration %d: keys not equal", i)
+		}
+		if want, have := PubkeyToAddress(key.PublicKey), PubkeyToAddress(*pub); !want.Equal(have) {
+			t.Fatalf("iteration %d: address mismatch: want %x have %x", i, want, have)
+		}
+	}
+}
+
 func TestCompressPubkey(t *testing.T) {
 	key := &ecdsa.PublicKey{
 		Curve: S256(),
@@ -151,6 +322,28 @@ func BenchmarkVerifySignature(b *testing.B) {
 	}
 }
 
+func BenchmarkVerifySignatureLoop(b *testing.B) {
+	digests, sigs, pubkeys := makeBatch(b, 256)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := range digests {
+			if !VerifySignature(pubkeys[j], digests[j], sigs[j]) {
+				b.Fatal("verify error")
+			}
+		}
+	}
+}
+
+func BenchmarkBatchVerify(b *testing.B) {
+	digests, sigs, pubkeys := makeBatch(b, 256)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := BatchVerify(digests, sigs, pubkeys); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
 func BenchmarkDecompressPubkey(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		if _, err := DecompressPubkey(testpubkeyc); err != nil {
@@ -448,8 +448,8 @@ func (b *QuaiAPIBackend) SyncProgress() quai.SyncProgress {
 	return b.eth.Downloader().Progress()
 }
 
-func (b *QuaiAPIBackend) Append(header *types.Header, manifest types.BlockManifest, domPendingHeader *types.Header, domTerminus common.Hash, domOrigin bool, newInboundEtxs types.Transactions) (types.Transactions, bool, bool, error) {
-	return b.eth.core.Append(header, manifest, domPendingHeader, domTerminus, domOrigin, newInboundEtxs)
+func (b *QuaiAPIBackend) Append(ctx context.Context, header *types.Header, manifest types.BlockManifest, domPendingHeader *types.Header, domTerminus common.Hash, domOrigin bool, newInboundEtxs types.Transactions) (types.Transactions, bool, bool, error) {
+	return b.eth.core.Append(ctx, header, manifest, domPendingHeader, domTerminus, domOrigin, newInboundEtxs)
 }
 
 func (b *QuaiAPIBackend) DownloadBlocksInManifest(hash common.Hash, manifest types.BlockManifest, entropy *big.Int) {
@@ -476,6 +476,10 @@ func (b *QuaiAPIBackend) SubRelayPendingHeader(pendingHeader types.PendingHeader
 	b.eth.core.SubRelayPendingHeader(pendingHeader, newEntropy, location, subReorg, order)
 }
 
+func (b *QuaiAPIBackend) SubRelayPendingHeaderDiff(diff types.PendingHeaderDiff, termini types.Termini, newEntropy *big.Int, location common.Location, subReorg bool, order int) error {
+	return b.eth.core.SubRelayPendingHeaderDiff(diff, termini, newEntropy, location, subReorg, order)
+}
+
 func (b *QuaiAPIBackend) UpdateDom(oldTerminus common.Hash, pendingHeader types.PendingHeader, location common.Location) {
 	b.eth.core.UpdateDom(oldTerminus, pendingHeader, location)
 }
@@ -488,8 +492,8 @@ func (b *QuaiAPIBackend) ProcessingState() bool {
 	return b.eth.core.ProcessingState()
 }
 
-func (b *QuaiAPIBackend) NewGenesisPendingHeader(pendingHeader *types.Header) {
-	b.eth.core.NewGenesisPendigHeader(pendingHeader)
+func (b *QuaiAPIBackend) NewGenesisPendingHeader(ctx context.Context, pendingHeader *types.Header) {
+	b.eth.core.NewGenesisPendigHeader(ctx, pendingHeader)
 }
 
 func (b *QuaiAPIBackend) GetPendingHeader() (*types.Header, error) {
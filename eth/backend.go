@@ -18,6 +18,7 @@
 package eth
 
 import (
+	"context"
 	"fmt"
 	"math/big"
 	"sync"
@@ -49,6 +50,10 @@ import (
 	"github.com/dominant-strategies/go-quai/rpc"
 )
 
+// appendDrainTimeout is how long the shutdown waits for the appends in flight
+// before cancelling them.
+const appendDrainTimeout = 10 * time.Second
+
 // Config contains the configuration options of the ETH protocol.
 // Deprecated: use ethconfig.Config instead.
 type Config = ethconfig.Config
@@ -409,11 +414,17 @@ func (s *Quai) Stop() error {
 		s.bloomIndexer.Close()
 		close(s.closeBloomHandler)
 	}
-	s.core.Stop()
+	// Let the appends in flight finish before the core state is persisted
+	ctx, cancel := context.WithTimeout(context.Background(), appendDrainTimeout)
+	err := s.core.StopContext(ctx)
+	cancel()
+	if err != nil {
+		log.Error("Failed to stop the core cleanly", "err", err)
+	}
 	s.engine.Close()
 	rawdb.PopUncleanShutdownMarker(s.chainDb)
 	s.chainDb.Close()
 	s.eventMux.Stop()
 
-	return nil
+	return err
 }
@@ -633,7 +633,7 @@ func (s *PublicBlockChainQuaiAPI) Append(ctx context.Context, raw json.RawMessag
 		return nil, err
 	}
 
-	pendingEtxs, subReorg, setHead, err := s.b.Append(body.Header, body.Manifest, body.DomPendingHeader, body.DomTerminus, body.DomOrigin, body.NewInboundEtxs)
+	pendingEtxs, subReorg, setHead, err := s.b.Append(ctx, body.Header, body.Manifest, body.DomPendingHeader, body.DomTerminus, body.DomOrigin, body.NewInboundEtxs)
 	if err != nil {
 		return nil, err
 	}
@@ -680,6 +680,23 @@ func (s *PublicBlockChainQuaiAPI) SubRelayPendingHeader(ctx context.Context, raw
 	s.b.SubRelayPendingHeader(pendingHeader, subRelay.NewEntropy, subRelay.Location, subRelay.SubReorg, subRelay.Order)
 }
 
+type SubRelayDiff struct {
+	Diff       types.PendingHeaderDiff `json:"diff"`
+	Termini    types.Termini           `json:"termini"`
+	NewEntropy *big.Int
+	Location   common.Location
+	SubReorg   bool
+	Order      int
+}
+
+func (s *PublicBlockChainQuaiAPI) SubRelayPendingHeaderDiff(ctx context.Context, raw json.RawMessage) error {
+	var subRelay SubRelayDiff
+	if err := json.Unmarshal(raw, &subRelay); err != nil {
+		return err
+	}
+	return s.b.SubRelayPendingHeaderDiff(subRelay.Diff, subRelay.Termini, subRelay.NewEntropy, subRelay.Location, subRelay.SubReorg, subRelay.Order)
+}
+
 type DomUpdate struct {
 	OldTerminus common.Hash
 	Header      *types.Header `json:"header"`
@@ -716,7 +733,7 @@ func (s *PublicBlockChainQuaiAPI) NewGenesisPendingHeader(ctx context.Context, r
 	if err := json.Unmarshal(raw, &pendingHeader); err != nil {
 		return
 	}
-	s.b.NewGenesisPendingHeader(pendingHeader)
+	s.b.NewGenesisPendingHeader(ctx, pendingHeader)
 }
 
 func (s *PublicBlockChainQuaiAPI) GetPendingHeader(ctx context.Context) (map[string]interface{}, error) {
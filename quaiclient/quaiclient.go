@@ -55,6 +55,10 @@ func DialContext(ctx context.Context, rawurl string) (*Client, error) {
 		if err == nil {
 			break
 		}
+		// Give up once the context is done, retrying cannot succeed anymore
+		if ctx.Err() != nil {
+			return nil, err
+		}
 
 		attempts += 1
 		// exponential back-off implemented
@@ -67,7 +71,11 @@ func DialContext(ctx context.Context, rawurl string) (*Client, error) {
 		// should only get here if the ffmpeg record stream process dies
 		log.Warn("Attempting to connect to go-quai node. Waiting and retrying...", "attempts", attempts, "delay", delaySecs, "url", rawurl)
 
-		time.Sleep(time.Duration(delaySecs) * time.Second)
+		select {
+		case <-time.After(time.Duration(delaySecs) * time.Second):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
 	}
 
 	return NewClient(c), nil
@@ -142,6 +150,19 @@ func (ec *Client) SubRelayPendingHeader(ctx context.Context, pendingHeader types
 	ec.c.CallContext(ctx, nil, "quai_subRelayPendingHeader", data)
 }
 
+// SubRelayPendingHeaderDiff relays only the per context fields of the pending
+// header. An error is returned if the sub cannot apply the diff.
+func (ec *Client) SubRelayPendingHeaderDiff(ctx context.Context, diff types.PendingHeaderDiff, termini types.Termini, newEntropy *big.Int, location common.Location, subReorg bool, order int) error {
+	data := map[string]interface{}{"diff": diff}
+	data["NewEntropy"] = newEntropy
+	data["termini"] = termini.RPCMarshalTermini()
+	data["Location"] = location
+	data["SubReorg"] = subReorg
+	data["Order"] = order
+
+	return ec.c.CallContext(ctx, nil, "quai_subRelayPendingHeaderDiff", data)
+}
+
 func (ec *Client) UpdateDom(ctx context.Context, oldTerminus common.Hash, pendingHeader types.PendingHeader, location common.Location) {
 	data := map[string]interface{}{"header": pendingHeader.Header().RPCMarshalHeader()}
 	data["OldTerminus"] = oldTerminus